@@ -12,7 +12,7 @@ func MakeTransformer(cfg TransformConfig) (aug.Transformer, error){
 	var augments []aug.Option 
 	if cfg.IsTransformer{
 		switch cfg.TransformerName{
-		case "RandomAugment":
+		case "RandomAugment", "TrivialAugmentWide", "AutoAugment":
 				var doNormalize bool
 				for _, augOpt := range cfg.AugmentOpts{
 								if augOpt.Name == "Normalize"{
@@ -20,13 +20,7 @@ func MakeTransformer(cfg TransformConfig) (aug.Transformer, error){
 												break
 								}
 				}
-				if doNormalize{
-								fmt.Println("Using RandomAugment with Normalize")
-								return NewRandomAugment()
-				}
-
-				fmt.Println("Using RandomAugment WITHOUT Normalize")
-				return NewRandomAugment(WithNormalize(false))
+				return makePolicyTransformer(cfg.TransformerName, cfg.TransformerParams, doNormalize)
 
 		default:
 			err := fmt.Errorf("makeTransformer failed: unsupported TransformerName %q\n", cfg.TransformerName)
@@ -36,305 +30,318 @@ func MakeTransformer(cfg TransformConfig) (aug.Transformer, error){
 
 	// Compose a transformer from augment options
 	for _, augOpt := range cfg.AugmentOpts{
-		switch augOpt.Name{
-		case "RandomAutocontrast":
-			var pvalue float64 = 0.5
-			for k, v := range augOpt.Params{
-				if k == "pvalue"{
-					pvalue = v.(float64)
-					break
-				}
-			}
-			a := aug.WithRandomAutocontrast(pvalue)
+		a, err := augmentOption(augOpt)
+		if err != nil{
+			log.Fatal(err)
+		}
+		if a != nil{
 			augments = append(augments, a)
+		}
+	}
 
-		case "RandomSolarize":
-			var opts []aug.SolarizeOption
-			for k, v := range augOpt.Params{
-				switch k{
-				case "threshold":
-					threshold := v.(float64)
-					o := aug.WithSolarizeThreshold(threshold)
-					opts = append(opts, o)
-
-				case "pvalue":
-					pvalue := v.(float64)
-					o := aug.WithSolarizePvalue(pvalue)
-					opts = append(opts, o)
-				}
-			}
-			a := aug.WithRandomSolarize(opts...)
-			augments = append(augments, a)
+	return aug.Compose(augments...)
+}
 
-		case "RandomAdjustSharpness":
-			var opts []aug.SharpnessOption
-			for k, v := range augOpt.Params{
-				switch k{
-				case "factor":
-					factor := v.(float64)
-					o := aug.WithSharpnessFactor(factor)
-					opts = append(opts, o)
-
-				case "pvalue":
-					pvalue := v.(float64)
-					o := aug.WithSharpnessPvalue(pvalue)
-					opts = append(opts, o)
-				}
+// augmentOption creates aug option of an augment option config. It returns nil option if
+// the augment is skipped.
+func augmentOption(augOpt AugmentOpt) (aug.Option, error){
+	switch augOpt.Name{
+	case "RandomAutocontrast":
+		var pvalue float64 = 0.5
+		for k, v := range augOpt.Params{
+			if k == "pvalue"{
+				pvalue = v.(float64)
+				break
 			}
-			a := aug.WithRandomAdjustSharpness(opts...)
-			augments = append(augments, a)
-
-		case "RandomRotate":
-			var min, max float64
-			for k, v := range augOpt.Params{
-				switch k{
-				case "min":
-					min = v.(float64)
-				case "max":
-					max = v.(float64)
-				}
+		}
+		a := aug.WithRandomAutocontrast(pvalue)
+		return a, nil
+
+	case "RandomSolarize":
+		var opts []aug.SolarizeOption
+		for k, v := range augOpt.Params{
+			switch k{
+			case "threshold":
+				threshold := v.(float64)
+				o := aug.WithSolarizeThreshold(threshold)
+				opts = append(opts, o)
+
+			case "pvalue":
+				pvalue := v.(float64)
+				o := aug.WithSolarizePvalue(pvalue)
+				opts = append(opts, o)
 			}
-			a := aug.WithRandRotate(min, max)
-			augments = append(augments, a)
-
-		case "Rotate":
-			var angle float64
-			for k, v := range augOpt.Params{
-				if k == "angle"{
-					angle = v.(float64)
-					break
-				}
+		}
+		a := aug.WithRandomSolarize(opts...)
+		return a, nil
+
+	case "RandomAdjustSharpness":
+		var opts []aug.SharpnessOption
+		for k, v := range augOpt.Params{
+			switch k{
+			case "factor":
+				factor := v.(float64)
+				o := aug.WithSharpnessFactor(factor)
+				opts = append(opts, o)
+
+			case "pvalue":
+				pvalue := v.(float64)
+				o := aug.WithSharpnessPvalue(pvalue)
+				opts = append(opts, o)
 			}
-			a := aug.WithRotate(angle)
-			augments = append(augments, a)
-
-		case "RandomAffine":
-			var opts []aug.AffineOption
-			for n, v := range augOpt.Params{
-				switch n{
-				case "fill_value":
-					o := aug.WithAffineFillValue(sliceInterface2Float64(v.([]interface{})))
-					opts = append(opts, o)
-				case "mode":
-					o := aug.WithAffineMode(v.(string))
-					opts = append(opts, o)
-				case "scale":
-					o := aug.WithAffineScale(sliceInterface2Float64(v.([]interface{})))
-					opts = append(opts, o)
-				case "shear":
-					o := aug.WithAffineShear(sliceInterface2Float64(v.([]interface{})))
-					opts = append(opts, o)
-				case "degree":
-					o := aug.WithAffineDegree(sliceInterface2Int64(v.([]interface{})))
-					opts = append(opts, o)
-				case "translate":
-					o := aug.WithAffineTranslate(sliceInterface2Float64(v.([]interface{})))
-					opts = append(opts, o)
-				}
+		}
+		a := aug.WithRandomAdjustSharpness(opts...)
+		return a, nil
+
+	case "RandomRotate":
+		var min, max float64
+		for k, v := range augOpt.Params{
+			switch k{
+			case "min":
+				min = v.(float64)
+			case "max":
+				max = v.(float64)
 			}
-
-			a := aug.WithRandomAffine(opts...)
-			augments = append(augments, a)
-
-		// NOTE. skip this because resize is handled at DataLoader
-		case "Resize":
-			/*
-			var h, w int64
-			for k, v := range augOpt.Params{
-				switch k{
-				case "height":
-					h = v.(int64)
-				case "width":
-					w = v.(int64)
-				}
+		}
+		a := aug.WithRandRotate(min, max)
+		return a, nil
+
+	case "Rotate":
+		var angle float64
+		for k, v := range augOpt.Params{
+			if k == "angle"{
+				angle = v.(float64)
+				break
 			}
-
-			a := aug.WithResize(h, w)
-			augments = append(augments, a)
-			*/
-
-		case "ZoomOut":
-			var val float64 = 0.1 // default value
-			for k, v := range augOpt.Params{
-				if k == "value"{ // range [0, 0.5]
-					val = v.(float64)
-					break
-				}
+		}
+		a := aug.WithRotate(angle)
+		return a, nil
+
+	case "RandomAffine":
+		var opts []aug.AffineOption
+		for n, v := range augOpt.Params{
+			switch n{
+			case "fill_value":
+				o := aug.WithAffineFillValue(sliceInterface2Float64(v.([]interface{})))
+				opts = append(opts, o)
+			case "mode":
+				o := aug.WithAffineMode(v.(string))
+				opts = append(opts, o)
+			case "scale":
+				o := aug.WithAffineScale(sliceInterface2Float64(v.([]interface{})))
+				opts = append(opts, o)
+			case "shear":
+				o := aug.WithAffineShear(sliceInterface2Float64(v.([]interface{})))
+				opts = append(opts, o)
+			case "degree":
+				o := aug.WithAffineDegree(sliceInterface2Int64(v.([]interface{})))
+				opts = append(opts, o)
+			case "translate":
+				o := aug.WithAffineTranslate(sliceInterface2Float64(v.([]interface{})))
+				opts = append(opts, o)
 			}
+		}
 
-			a := aug.WithZoomOut(val)
-			augments = append(augments, a)
-
-		case "RandomPosterize":
-			var opts []aug.PosterizeOption
-			for k, v := range augOpt.Params{
-				switch k{
-				case "bits":
-					bits := v.(int)
-					o := aug.WithPosterizeBits(uint8(bits))
-					opts = append(opts, o)
-				case "pvalue":
-					o := aug.WithPosterizePvalue(v.(float64))
-					opts = append(opts, o)
-				}
+		a := aug.WithRandomAffine(opts...)
+		return a, nil
+
+	// NOTE. skip this because resize is handled at DataLoader
+	case "Resize":
+		/*
+		var h, w int64
+		for k, v := range augOpt.Params{
+			switch k{
+			case "height":
+				h = v.(int64)
+			case "width":
+				w = v.(int64)
 			}
-			a := aug.WithRandomPosterize(opts...)
-			augments = append(augments, a)
+		}
 
-		case "RandomPerspective":
-			var opts []aug.PerspectiveOption
-			for k, v := range augOpt.Params{
-				switch k{
-				case "mode":
-					o := aug.WithPerspectiveMode(v.(string))
-					opts = append(opts, o)
-				case "pvalue":
-					o := aug.WithPerspectivePvalue(v.(float64))
-					opts = append(opts, o)
-				case "value":
-					o := aug.WithPerspectiveValue(sliceInterface2Float64(v.([]interface{})))
-					opts = append(opts, o)
-				case "scale":
-					o := aug.WithPerspectiveScale(v.(float64))
-					opts = append(opts, o)
-				}
+		a := aug.WithResize(h, w)
+		augments = append(augments, a)
+		*/
+		return nil, nil
+
+	case "ZoomOut":
+		var val float64 = 0.1 // default value
+		for k, v := range augOpt.Params{
+			if k == "value"{ // range [0, 0.5]
+				val = v.(float64)
+				break
 			}
-			a := aug.WithRandomPerspective(opts...)
-			augments = append(augments, a)
+		}
 
-		case "Normalize":
-			var opts []aug.NormalizeOption
-			for k, v := range augOpt.Params{
-				switch k{
-				case "mean":
-					o := aug.WithNormalizeMean(sliceInterface2Float64(v.([]interface{})))
-					opts = append(opts, o)
-				case "stdev":
-					o := aug.WithNormalizeStd(sliceInterface2Float64(v.([]interface{})))
-					opts = append(opts, o)
-				}
+		a := aug.WithZoomOut(val)
+		return a, nil
+
+	case "RandomPosterize":
+		var opts []aug.PosterizeOption
+		for k, v := range augOpt.Params{
+			switch k{
+			case "bits":
+				bits := v.(int)
+				o := aug.WithPosterizeBits(uint8(bits))
+				opts = append(opts, o)
+			case "pvalue":
+				o := aug.WithPosterizePvalue(v.(float64))
+				opts = append(opts, o)
 			}
-			a := aug.WithNormalize(opts...)
-			augments = append(augments, a)
-
-		case "RandomInvert":
-			var pvalue float64 = 0.5
-			for k, v := range augOpt.Params{
-				if k == "pvalue"{
-					pvalue = v.(float64)
-					break
-				}
+		}
+		a := aug.WithRandomPosterize(opts...)
+		return a, nil
+
+	case "RandomPerspective":
+		var opts []aug.PerspectiveOption
+		for k, v := range augOpt.Params{
+			switch k{
+			case "mode":
+				o := aug.WithPerspectiveMode(v.(string))
+				opts = append(opts, o)
+			case "pvalue":
+				o := aug.WithPerspectivePvalue(v.(float64))
+				opts = append(opts, o)
+			case "value":
+				o := aug.WithPerspectiveValue(sliceInterface2Float64(v.([]interface{})))
+				opts = append(opts, o)
+			case "scale":
+				o := aug.WithPerspectiveScale(v.(float64))
+				opts = append(opts, o)
 			}
-			a := aug.WithRandomInvert(pvalue)
-			augments = append(augments, a)
-
-		case "RandomGrayscale":
-			var pvalue float64 = 0.5
-			for k, v := range augOpt.Params{
-				if k == "pvalue"{
-					pvalue = v.(float64)
-					break
-				}
+		}
+		a := aug.WithRandomPerspective(opts...)
+		return a, nil
+
+	case "Normalize":
+		var opts []aug.NormalizeOption
+		for k, v := range augOpt.Params{
+			switch k{
+			case "mean":
+				o := aug.WithNormalizeMean(sliceInterface2Float64(v.([]interface{})))
+				opts = append(opts, o)
+			case "stdev":
+				o := aug.WithNormalizeStd(sliceInterface2Float64(v.([]interface{})))
+				opts = append(opts, o)
 			}
-			a := aug.WithRandomGrayscale(pvalue)
-			augments = append(augments, a)
-
-		case "RandomVFlip":
-			var pvalue float64 = 0.5
-			for k, v := range augOpt.Params{
-				if k == "pvalue"{
-					pvalue = v.(float64)
-					break
-				}
+		}
+		a := aug.WithNormalize(opts...)
+		return a, nil
+
+	case "RandomInvert":
+		var pvalue float64 = 0.5
+		for k, v := range augOpt.Params{
+			if k == "pvalue"{
+				pvalue = v.(float64)
+				break
 			}
-			a := aug.WithRandomVFlip(pvalue)
-			augments = append(augments, a)
-
-		case "RandomHFlip":
-			var pvalue float64 = 0.5
-			for k, v := range augOpt.Params{
-				if k == "pvalue"{
-					pvalue = v.(float64)
-					break
-				}
+		}
+		a := aug.WithRandomInvert(pvalue)
+		return a, nil
+
+	case "RandomGrayscale":
+		var pvalue float64 = 0.5
+		for k, v := range augOpt.Params{
+			if k == "pvalue"{
+				pvalue = v.(float64)
+				break
 			}
-			a := aug.WithRandomHFlip(pvalue)
-			augments = append(augments, a)
-
-		case "RandomEqualize":
-			var pvalue float64 = 0.5
-			for k, v := range augOpt.Params{
-				if k == "pvalue"{
-					pvalue = v.(float64)
-					break
-				}
+		}
+		a := aug.WithRandomGrayscale(pvalue)
+		return a, nil
+
+	case "RandomVFlip":
+		var pvalue float64 = 0.5
+		for k, v := range augOpt.Params{
+			if k == "pvalue"{
+				pvalue = v.(float64)
+				break
 			}
-			a := aug.WithRandomEqualize(pvalue)
-			augments = append(augments, a)
-
-		case "RandomCutout":
-			var opts []aug.CutoutOption
-			for k, v := range augOpt.Params{
-				switch k{
-				case "ratio":
-					o := aug.WithCutoutRatio(sliceInterface2Float64(v.([]interface{})))
-					opts = append(opts, o)
-				case "scale":
-					o := aug.WithCutoutScale(sliceInterface2Float64(v.([]interface{})))
-					opts = append(opts, o)
-				case "value":
-					o := aug.WithCutoutValue(sliceInterface2Int64(v.([]interface{})))
-					opts = append(opts, o)
-				case "pvalue":
-					o := aug.WithCutoutPvalue(v.(float64))
-					opts = append(opts, o)
-				}
+		}
+		a := aug.WithRandomVFlip(pvalue)
+		return a, nil
+
+	case "RandomHFlip":
+		var pvalue float64 = 0.5
+		for k, v := range augOpt.Params{
+			if k == "pvalue"{
+				pvalue = v.(float64)
+				break
 			}
-			
-			a := aug.WithRandomCutout(opts...)
-			augments = append(augments, a)
+		}
+		a := aug.WithRandomHFlip(pvalue)
+		return a, nil
+
+	case "RandomEqualize":
+		var pvalue float64 = 0.5
+		for k, v := range augOpt.Params{
+			if k == "pvalue"{
+				pvalue = v.(float64)
+				break
+			}
+		}
+		a := aug.WithRandomEqualize(pvalue)
+		return a, nil
+
+	case "RandomCutout":
+		var opts []aug.CutoutOption
+		for k, v := range augOpt.Params{
+			switch k{
+			case "ratio":
+				o := aug.WithCutoutRatio(sliceInterface2Float64(v.([]interface{})))
+				opts = append(opts, o)
+			case "scale":
+				o := aug.WithCutoutScale(sliceInterface2Float64(v.([]interface{})))
+				opts = append(opts, o)
+			case "value":
+				o := aug.WithCutoutValue(sliceInterface2Int64(v.([]interface{})))
+				opts = append(opts, o)
+			case "pvalue":
+				o := aug.WithCutoutPvalue(v.(float64))
+				opts = append(opts, o)
+			}
+		}
+		
+		a := aug.WithRandomCutout(opts...)
+		return a, nil
 
-		case "CenterCrop":
+	case "CenterCrop":
 
-			var size []int64
-			for k, v := range augOpt.Params{
-				if k == "size"{
-					size = sliceInterface2Int64(v.([]interface{}))
-					break
-				}
+		var size []int64
+		for k, v := range augOpt.Params{
+			if k == "size"{
+				size = sliceInterface2Int64(v.([]interface{}))
+				break
 			}
-			a := aug.WithCenterCrop(size)
-			augments = append(augments, a)
-
-		case "ColorJitter":
-			var opts []aug.ColorOption
-			for n, v := range augOpt.Params{
-				switch n{
-				case "brightness":
-					o := aug.WithColorBrightness(sliceInterface2Float64(v.([]interface{})))
-					opts = append(opts, o)
-				case "saturation":
-					o := aug.WithColorSaturation(sliceInterface2Float64(v.([]interface{})))
-					opts = append(opts, o)
-				case "contrast":
-					o := aug.WithColorContrast(sliceInterface2Float64(v.([]interface{})))
-					opts = append(opts, o)
-				case "hue":
-					o := aug.WithColorHue(sliceInterface2Float64(v.([]interface{})))
-					opts = append(opts, o)
-				}
+		}
+		a := aug.WithCenterCrop(size)
+		return a, nil
+
+	case "ColorJitter":
+		var opts []aug.ColorOption
+		for n, v := range augOpt.Params{
+			switch n{
+			case "brightness":
+				o := aug.WithColorBrightness(sliceInterface2Float64(v.([]interface{})))
+				opts = append(opts, o)
+			case "saturation":
+				o := aug.WithColorSaturation(sliceInterface2Float64(v.([]interface{})))
+				opts = append(opts, o)
+			case "contrast":
+				o := aug.WithColorContrast(sliceInterface2Float64(v.([]interface{})))
+				opts = append(opts, o)
+			case "hue":
+				o := aug.WithColorHue(sliceInterface2Float64(v.([]interface{})))
+				opts = append(opts, o)
 			}
-			
-			a := aug.WithColorJitter(opts...)
-			augments = append(augments, a)
-
-		default:
-			err := fmt.Errorf("MakeTransformer failed: Unsupport augment option: %q\n", augOpt.Name)
-			log.Fatal(err)
 		}
-	}
+		
+		a := aug.WithColorJitter(opts...)
+		return a, nil
 
-	return aug.Compose(augments...)
+	default:
+		err := fmt.Errorf("MakeTransformer failed: Unsupport augment option: %q\n", augOpt.Name)
+		return nil, err
+	}
 }
 
 func sliceInterface2Float64(vals []interface{}) []float64{
@@ -355,3 +362,26 @@ func sliceInterface2Int64(vals []interface{}) []int64{
 
 	return retVal
 }
+
+// Normalization returns mean and standard deviation of input normalization of transform config,
+// i.e. params of its Normalize augment (default mean 0 and std 1) or ImageNet values of a
+// normalized policy transformer. It returns ok false if input is not normalized.
+func (cfg TransformConfig) Normalization() (mean, std []float64, ok bool) {
+	for _, augOpt := range cfg.AugmentOpts {
+		if augOpt.Name != "Normalize" {
+			continue
+		}
+		if cfg.IsTransformer {
+			return []float64{0.485, 0.456, 0.406}, []float64{0.229, 0.224, 0.225}, true
+		}
+		mean, std = []float64{0, 0, 0}, []float64{1, 1, 1}
+		if v, found := augOpt.Params["mean"]; found {
+			mean = sliceInterface2Float64(v.([]interface{}))
+		}
+		if v, found := augOpt.Params["stdev"]; found {
+			std = sliceInterface2Float64(v.([]interface{}))
+		}
+		return mean, std, true
+	}
+	return nil, nil, false
+}
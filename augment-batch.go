@@ -0,0 +1,358 @@
+package lab
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/ts"
+)
+
+// BatchAugment mixes samples of a collated batch with MixUp or CutMix and produces soft targets.
+//
+// Ref.
+// - MixUp: https://arxiv.org/abs/1710.09412
+// - CutMix: https://arxiv.org/abs/1905.04899
+type BatchAugment struct {
+	Mode       string // "MixUp", "CutMix" or "MixUpCutMix"
+	NumClasses int64
+	*BatchAugmentOptions
+	rng *rand.Rand
+}
+
+type BatchAugmentOptions struct {
+	MixUpAlpha     float64 // Beta distribution param of MixUp ratio
+	CutMixAlpha    float64 // Beta distribution param of CutMix ratio
+	PValue         float64 // probability of mixing a batch
+	SwitchProb     float64 // probability of CutMix instead of MixUp in "MixUpCutMix" mode
+	LabelSmoothing float64
+	StartEpoch     int    // first epoch to mix batches
+	EndEpoch       int    // epoch to stop mixing batches. 0: mixing until the end.
+	Schedule       string // probability schedule: "constant" or "linear_decay" from PValue at StartEpoch to 0 at EndEpoch
+	Seed           int64
+}
+
+type BatchAugmentOption func(*BatchAugmentOptions)
+
+func defaultBatchAugmentOptions() *BatchAugmentOptions {
+	return &BatchAugmentOptions{
+		MixUpAlpha:     0.2,
+		CutMixAlpha:    1.0,
+		PValue:         1.0,
+		SwitchProb:     0.5,
+		LabelSmoothing: 0.0,
+		StartEpoch:     0,
+		EndEpoch:       0,
+		Schedule:       "constant",
+		Seed:           0,
+	}
+}
+
+func WithMixUpAlpha(alpha float64) BatchAugmentOption {
+	return func(o *BatchAugmentOptions) {
+		o.MixUpAlpha = alpha
+	}
+}
+
+func WithCutMixAlpha(alpha float64) BatchAugmentOption {
+	return func(o *BatchAugmentOptions) {
+		o.CutMixAlpha = alpha
+	}
+}
+
+func WithBatchAugmentPValue(p float64) BatchAugmentOption {
+	return func(o *BatchAugmentOptions) {
+		o.PValue = p
+	}
+}
+
+func WithSwitchProb(p float64) BatchAugmentOption {
+	return func(o *BatchAugmentOptions) {
+		o.SwitchProb = p
+	}
+}
+
+func WithMixLabelSmoothing(v float64) BatchAugmentOption {
+	return func(o *BatchAugmentOptions) {
+		o.LabelSmoothing = v
+	}
+}
+
+func WithBatchAugmentEpochs(start, end int) BatchAugmentOption {
+	return func(o *BatchAugmentOptions) {
+		o.StartEpoch = start
+		o.EndEpoch = end
+	}
+}
+
+func WithBatchAugmentSchedule(schedule string) BatchAugmentOption {
+	return func(o *BatchAugmentOptions) {
+		o.Schedule = schedule
+	}
+}
+
+func WithBatchAugmentSeed(seed int64) BatchAugmentOption {
+	return func(o *BatchAugmentOptions) {
+		o.Seed = seed
+	}
+}
+
+// NewBatchAugment creates a BatchAugment.
+func NewBatchAugment(mode string, numClasses int64, opts ...BatchAugmentOption) (*BatchAugment, error) {
+	options := defaultBatchAugmentOptions()
+	for _, o := range opts {
+		o(options)
+	}
+
+	switch mode {
+	case "MixUp", "CutMix", "MixUpCutMix":
+	default:
+		err := fmt.Errorf("NewBatchAugment failed: unsupported mode %q", mode)
+		return nil, err
+	}
+	switch options.Schedule {
+	case "constant", "linear_decay":
+	default:
+		err := fmt.Errorf("NewBatchAugment failed: unsupported schedule %q", options.Schedule)
+		return nil, err
+	}
+	if numClasses < 2 {
+		err := fmt.Errorf("NewBatchAugment failed: number of classes must be at least 2. Got %d", numClasses)
+		return nil, err
+	}
+	if options.PValue < 0 || options.PValue > 1 || options.SwitchProb < 0 || options.SwitchProb > 1 {
+		err := fmt.Errorf("NewBatchAugment failed: probabilities must be in range [0, 1]")
+		return nil, err
+	}
+	if options.LabelSmoothing < 0 || options.LabelSmoothing >= 1 {
+		err := fmt.Errorf("NewBatchAugment failed: label smoothing must be in range [0, 1). Got %v", options.LabelSmoothing)
+		return nil, err
+	}
+
+	return &BatchAugment{
+		Mode:                mode,
+		NumClasses:          numClasses,
+		BatchAugmentOptions: options,
+		rng:                 rand.New(rand.NewSource(options.Seed)),
+	}, nil
+}
+
+// MakeBatchAugment creates a BatchAugment from config. It returns nil if no batch augment is specified.
+//
+// Params: "alpha" (both MixUp and CutMix), "mixup_alpha", "cutmix_alpha", "pvalue", "switch_prob",
+// "label_smoothing", "start_epoch", "end_epoch", "schedule" and "seed" (default to input seed).
+func MakeBatchAugment(cfg BatchAugmentConfig, numClasses int64, seed int64) (*BatchAugment, error) {
+	if cfg.Name == "" || cfg.Name == "None" {
+		return nil, nil
+	}
+
+	opts := []BatchAugmentOption{WithBatchAugmentSeed(seed)}
+	start, end := 0, 0
+	for k, v := range cfg.Params {
+		var (
+			f   float64
+			i   int
+			str string
+			ok  bool
+		)
+		switch k {
+		case "alpha", "mixup_alpha", "cutmix_alpha", "pvalue", "switch_prob", "label_smoothing":
+			f, ok = paramFloat(v)
+		case "start_epoch", "end_epoch", "seed":
+			i, ok = paramInt(v)
+		case "schedule":
+			str, ok = v.(string)
+		default:
+			err := fmt.Errorf("MakeBatchAugment failed: unsupported param %q", k)
+			return nil, err
+		}
+		if !ok {
+			err := fmt.Errorf("MakeBatchAugment failed: invalid value %v (%T) of param %q", v, v, k)
+			return nil, err
+		}
+
+		switch k {
+		case "alpha":
+			opts = append(opts, WithMixUpAlpha(f), WithCutMixAlpha(f))
+		case "mixup_alpha":
+			opts = append(opts, WithMixUpAlpha(f))
+		case "cutmix_alpha":
+			opts = append(opts, WithCutMixAlpha(f))
+		case "pvalue":
+			opts = append(opts, WithBatchAugmentPValue(f))
+		case "switch_prob":
+			opts = append(opts, WithSwitchProb(f))
+		case "label_smoothing":
+			opts = append(opts, WithMixLabelSmoothing(f))
+		case "start_epoch":
+			start = i
+		case "end_epoch":
+			end = i
+		case "schedule":
+			opts = append(opts, WithBatchAugmentSchedule(str))
+		case "seed":
+			opts = append(opts, WithBatchAugmentSeed(int64(i)))
+		}
+	}
+	opts = append(opts, WithBatchAugmentEpochs(start, end))
+
+	return NewBatchAugment(cfg.Name, numClasses, opts...)
+}
+
+// Probability returns probability of mixing a batch at an epoch.
+func (a *BatchAugment) Probability(epoch int) float64 {
+	if epoch < a.StartEpoch || (a.EndEpoch > 0 && epoch >= a.EndEpoch) {
+		return 0
+	}
+	if a.Schedule == "linear_decay" && a.EndEpoch > a.StartEpoch {
+		return a.PValue * float64(a.EndEpoch-epoch) / float64(a.EndEpoch-a.StartEpoch)
+	}
+	return a.PValue
+}
+
+// sample decides whether and how to mix the next batch. It returns mode and mixing ratio of
+// the original batch.
+func (a *BatchAugment) sample(epoch int) (string, float64, bool) {
+	if a.rng.Float64() >= a.Probability(epoch) {
+		return "", 1, false
+	}
+
+	mode := a.Mode
+	if mode == "MixUpCutMix" {
+		mode = "MixUp"
+		if a.rng.Float64() < a.SwitchProb {
+			mode = "CutMix"
+		}
+	}
+	alpha := a.MixUpAlpha
+	if mode == "CutMix" {
+		alpha = a.CutMixAlpha
+	}
+	if alpha <= 0 {
+		return "", 1, false
+	}
+
+	return mode, sampleBeta(a.rng, alpha, alpha), true
+}
+
+// Apply mixes input batch of shape [B, C, H, W] and returns mixed input and soft targets of
+// shape [B, NumClasses]. Target is either class indexes of shape [B] or soft targets.
+// Input tensors are not deleted.
+func (a *BatchAugment) Apply(input, target *ts.Tensor, epoch int) (*ts.Tensor, *ts.Tensor) {
+	soft := a.softTarget(target)
+	mode, lam, ok := a.sample(epoch)
+	if !ok {
+		return input.MustShallowClone(), soft
+	}
+
+	size := input.MustSize()
+	device := input.MustDevice()
+	perm := make([]int64, size[0])
+	for i, p := range a.rng.Perm(int(size[0])) {
+		perm[i] = int64(p)
+	}
+	permTs := ts.MustOfSlice(perm).MustTo(device, true)
+	shuffled := input.MustIndexSelect(0, permTs, false)
+
+	var mixed *ts.Tensor
+	switch mode {
+	case "CutMix":
+		h, w := size[len(size)-2], size[len(size)-1]
+		y1, x1, bh, bw := cutMixBox(a.rng, h, w, lam)
+		lam = 1 - float64(bh*bw)/float64(h*w)
+		box := make([]float32, h*w)
+		for y := y1; y < y1+bh; y++ {
+			for x := x1; x < x1+bw; x++ {
+				box[y*w+x] = 1
+			}
+		}
+		mask := ts.MustOfSlice(box).MustView([]int64{1, 1, h, w}, true).MustTotype(input.DType(), true).MustTo(device, true)
+		keep := mask.MustMulScalar(ts.FloatScalar(-1), false).MustAddScalar(ts.FloatScalar(1), true)
+		pasted := shuffled.MustMul(mask, false)
+		mixed = input.MustMul(keep, false).MustAdd(pasted, true)
+		mask.MustDrop()
+		keep.MustDrop()
+		pasted.MustDrop()
+	default:
+		mixed = input.MustMulScalar(ts.FloatScalar(lam), false).
+			MustAdd(shuffled.MustMulScalar(ts.FloatScalar(1-lam), false), true)
+	}
+	shuffled.MustDrop()
+
+	softShuffled := soft.MustIndexSelect(0, permTs, false)
+	mixedTarget := soft.MustMulScalar(ts.FloatScalar(lam), true).
+		MustAdd(softShuffled.MustMulScalar(ts.FloatScalar(1-lam), true), true)
+	permTs.MustDrop()
+
+	return mixed, mixedTarget
+}
+
+// softTarget converts class indexes to one-hot float targets with label smoothing.
+func (a *BatchAugment) softTarget(target *ts.Tensor) *ts.Tensor {
+	var soft *ts.Tensor
+	if target.DType() == gotch.Float || target.DType() == gotch.Double {
+		soft = target.MustShallowClone()
+	} else {
+		soft = target.MustView([]int64{-1}, false).
+			MustTotype(gotch.Int64, true).
+			MustOneHot(a.NumClasses, true).
+			MustTotype(gotch.Float, true)
+	}
+	if a.LabelSmoothing > 0 {
+		s := a.LabelSmoothing
+		soft = soft.MustMulScalar(ts.FloatScalar(1-s), true).
+			MustAddScalar(ts.FloatScalar(s/float64(a.NumClasses)), true)
+	}
+	return soft
+}
+
+// cutMixBox returns a random box of area ratio (1 - lam), clipped to image of size [h, w].
+func cutMixBox(rng *rand.Rand, h, w int64, lam float64) (y1, x1, bh, bw int64) {
+	ratio := math.Sqrt(1 - lam)
+	cutH, cutW := int64(float64(h)*ratio), int64(float64(w)*ratio)
+	cy, cx := rng.Int63n(h), rng.Int63n(w)
+	clip := func(v, max int64) int64 {
+		if v < 0 {
+			return 0
+		}
+		if v > max {
+			return max
+		}
+		return v
+	}
+	y1, y2 := clip(cy-cutH/2, h), clip(cy+cutH/2, h)
+	x1, x2 := clip(cx-cutW/2, w), clip(cx+cutW/2, w)
+	return y1, x1, y2 - y1, x2 - x1
+}
+
+// sampleBeta draws a sample from Beta(a, b) distribution.
+func sampleBeta(rng *rand.Rand, a, b float64) float64 {
+	x := sampleGamma(rng, a)
+	y := sampleGamma(rng, b)
+	if x+y == 0 {
+		return 0.5
+	}
+	return x / (x + y)
+}
+
+// sampleGamma draws a sample from Gamma(shape, 1) distribution with Marsaglia-Tsang method.
+func sampleGamma(rng *rand.Rand, shape float64) float64 {
+	if shape < 1 {
+		return sampleGamma(rng, shape+1) * math.Pow(rng.Float64(), 1/shape)
+	}
+	d := shape - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := rng.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := rng.Float64()
+		if u < 1-0.0331*x*x*x*x || math.Log(u) < 0.5*x*x+d*(1-v+math.Log(v)) {
+			return d * v
+		}
+	}
+}
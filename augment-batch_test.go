@@ -0,0 +1,197 @@
+package lab
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/sugarme/gotch/ts"
+)
+
+func TestSampleBeta(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, alpha := range []float64{0.2, 1.0, 4.0} {
+		var sum, sumSq float64
+		n := 20000
+		for i := 0; i < n; i++ {
+			v := sampleBeta(rng, alpha, alpha)
+			if v < 0 || v > 1 {
+				t.Fatalf("Beta sample out of range: %v\n", v)
+			}
+			sum += v
+			sumSq += v * v
+		}
+		mean := sum / float64(n)
+		variance := sumSq/float64(n) - mean*mean
+		wantVar := 1 / (4 * (2*alpha + 1)) // variance of Beta(a, a)
+		if math.Abs(mean-0.5) > 0.02 || math.Abs(variance-wantVar) > 0.02 {
+			t.Errorf("alpha=%v: want mean 0.5, variance %.3f, got %.3f, %.3f\n", alpha, wantVar, mean, variance)
+		}
+	}
+}
+
+func TestCutMixBox(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		lam := rng.Float64()
+		y1, x1, bh, bw := cutMixBox(rng, 32, 48, lam)
+		if y1 < 0 || x1 < 0 || bh < 0 || bw < 0 || y1+bh > 32 || x1+bw > 48 {
+			t.Fatalf("Box out of image: %d %d %d %d\n", y1, x1, bh, bw)
+		}
+		if area := float64(bh * bw); area > (1-lam)*32*48+1e-9 {
+			t.Fatalf("Box area %v greater than %v\n", area, (1-lam)*32*48)
+		}
+	}
+}
+
+func TestBatchAugmentSchedule(t *testing.T) {
+	cfg := BatchAugmentConfig{
+		Name: "MixUpCutMix",
+		Params: map[string]interface{}{
+			"alpha":       1.0,
+			"pvalue":      0.8,
+			"start_epoch": 2,
+			"end_epoch":   6,
+			"schedule":    "linear_decay",
+		},
+	}
+	a, err := MakeBatchAugment(cfg, 10, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.MixUpAlpha != 1.0 || a.CutMixAlpha != 1.0 {
+		t.Errorf("Want alpha 1.0, got %v, %v\n", a.MixUpAlpha, a.CutMixAlpha)
+	}
+	for epoch, want := range []float64{0, 0, 0.8, 0.6, 0.4, 0.2, 0, 0} {
+		if got := a.Probability(epoch); math.Abs(got-want) > 1e-9 {
+			t.Errorf("Epoch %d: want probability %v, got %v\n", epoch, want, got)
+		}
+	}
+
+	modes := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		mode, lam, ok := a.sample(2)
+		if ok {
+			modes[mode]++
+			if lam < 0 || lam > 1 {
+				t.Fatalf("Invalid mixing ratio %v\n", lam)
+			}
+		}
+	}
+	if modes["MixUp"] < 300 || modes["CutMix"] < 300 {
+		t.Errorf("Want about 400 batches of each mode, got %v\n", modes)
+	}
+	if _, _, ok := a.sample(0); ok {
+		t.Errorf("Want no mixing before start epoch")
+	}
+
+	if a, err := MakeBatchAugment(BatchAugmentConfig{}, 10, 42); a != nil || err != nil {
+		t.Errorf("Want nil batch augment for empty config")
+	}
+	if _, err := MakeBatchAugment(BatchAugmentConfig{Name: "MixDown"}, 10, 42); err == nil {
+		t.Errorf("Want error for unsupported mode")
+	}
+
+	// Integer values as decoded from YAML `alpha: 1`.
+	icfg := BatchAugmentConfig{Name: "MixUp", Params: map[string]interface{}{"alpha": 1, "pvalue": 1, "seed": 3.0}}
+	if a, err := MakeBatchAugment(icfg, 10, 42); err != nil || a.MixUpAlpha != 1 || a.PValue != 1 || a.Seed != 3 {
+		t.Errorf("Want integer params accepted, got %+v, %v\n", a, err)
+	}
+	icfg.Params = map[string]interface{}{"pvalue": "high"}
+	if _, err := MakeBatchAugment(icfg, 10, 42); err == nil || !strings.Contains(err.Error(), "pvalue") {
+		t.Errorf("Want error naming invalid param, got %v\n", err)
+	}
+
+	bcfg := &Config{}
+	bcfg.Loss.Name = "BCELoss"
+	bcfg.Transform.Train.BatchAugment = cfg
+	if _, err := NewBuilder(bcfg).BuildLoss(); err == nil {
+		t.Errorf("Want error for BCELoss with batch augment")
+	}
+	bcfg.Loss.Name = "CrossEntropyLoss"
+	if _, err := NewBuilder(bcfg).BuildLoss(); err != nil {
+		t.Errorf("Want CrossEntropyLoss with batch augment, got %v\n", err)
+	}
+}
+
+func TestBatchAugmentApply(t *testing.T) {
+	// Image i has all pixels of value i and class i so that mean pixel of a mixed image
+	// equals the expected class of its mixed target.
+	var (
+		b, c, h, w int64 = 4, 1, 8, 8
+		pixels     []float32
+	)
+	for i := int64(0); i < b; i++ {
+		for j := int64(0); j < c*h*w; j++ {
+			pixels = append(pixels, float32(i))
+		}
+	}
+	input := ts.MustOfSlice(pixels).MustView([]int64{b, c, h, w}, true)
+	target := ts.MustOfSlice([]int64{0, 1, 2, 3})
+	defer input.MustDrop()
+	defer target.MustDrop()
+
+	for _, mode := range []string{"MixUp", "CutMix"} {
+		a, err := NewBatchAugment(mode, b, WithMixUpAlpha(1.0), WithCutMixAlpha(1.0), WithBatchAugmentSeed(3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n := 0; n < 5; n++ {
+			mixed, soft := a.Apply(input, target, 0)
+			if got := soft.MustSize(); got[0] != b || got[1] != b {
+				t.Fatalf("%s: want soft target of shape [%d %d], got %v\n", mode, b, b, got)
+			}
+			mixedVals := mixed.Float64Values()
+			softVals := soft.Float64Values()
+			mixed.MustDrop()
+			soft.MustDrop()
+
+			for i := int64(0); i < b; i++ {
+				var sum, class, pixel float64
+				for k := int64(0); k < b; k++ {
+					sum += softVals[i*b+k]
+					class += float64(k) * softVals[i*b+k]
+				}
+				for _, v := range mixedVals[i*c*h*w : (i+1)*c*h*w] {
+					pixel += v
+				}
+				pixel /= float64(c * h * w)
+				if math.Abs(sum-1) > 1e-5 {
+					t.Errorf("%s: want target of sample %d summing to 1, got %v\n", mode, i, sum)
+				}
+				if math.Abs(class-pixel) > 1e-4 {
+					t.Errorf("%s: want labels of sample %d mixed in proportion of image %v, got %v\n", mode, i, pixel, class)
+				}
+			}
+		}
+	}
+}
+
+func TestSoftCrossEntropyLoss(t *testing.T) {
+	logits := ts.MustOfSlice([]float32{0, 0, float32(math.Log(3)), 0}).MustView([]int64{2, 2}, true)
+	target := ts.MustOfSlice([]float32{0.5, 0.5, 0.25, 0.75}).MustView([]int64{2, 2}, true)
+	defer logits.MustDrop()
+	defer target.MustDrop()
+
+	// Mean of H([0.5, 0.5], [0.5, 0.5]) and H([0.25, 0.75], [0.75, 0.25]).
+	want := (math.Log(2) - 0.25*math.Log(0.75) - 0.75*math.Log(0.25)) / 2
+	loss := SoftCrossEntropyLoss(logits, target)
+	if got := loss.Float64Values()[0]; math.Abs(got-want) > 1e-5 {
+		t.Errorf("Want loss %v, got %v\n", want, got)
+	}
+	loss.MustDrop()
+
+	// One-hot soft targets give the loss of class indexes.
+	oneHot := ts.MustOfSlice([]float32{0, 1, 1, 0}).MustView([]int64{2, 2}, true)
+	indexes := ts.MustOfSlice([]int64{1, 0})
+	defer oneHot.MustDrop()
+	defer indexes.MustDrop()
+	soft := CrossEntropyLoss(logits, oneHot)
+	hard := CrossEntropyLoss(logits, indexes)
+	if s, h := soft.Float64Values()[0], hard.Float64Values()[0]; math.Abs(s-h) > 1e-5 {
+		t.Errorf("Want loss of one-hot targets %v, got %v\n", h, s)
+	}
+	soft.MustDrop()
+	hard.MustDrop()
+}
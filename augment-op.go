@@ -0,0 +1,213 @@
+package lab
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/ts"
+	"github.com/sugarme/gotch/vision"
+)
+
+// Augment ops implemented in this package. All ops take uint8 image of shape [C, H, W]
+// and return a new tensor.
+
+// randOp is implemented by ops drawing their direction or location at random. Sign is +1 or -1.
+type randOp interface {
+	transform(img *ts.Tensor, v, sign float64, rng *rand.Rand) *ts.Tensor
+}
+
+// opTransformer applies a TransformOp with a fixed value. It implements aug.Transformer interface.
+//
+// If rng is not nil and op implements randOp, sign and rng are used instead of global rand.
+type opTransformer struct {
+	op   TransformOp
+	v    float64
+	sign float64
+	rng  *rand.Rand
+}
+
+// Transform implements aug.Transformer interface.
+func (t *opTransformer) Transform(image *ts.Tensor) *ts.Tensor {
+	if r, ok := t.op.(randOp); ok && t.rng != nil {
+		return r.transform(image, t.v, t.sign, t.rng)
+	}
+	return t.op.Transform(image, t.v)
+}
+
+// identityOp returns image unchanged.
+type identityOp struct{}
+
+func (identityOp) Transform(img *ts.Tensor, v float64) *ts.Tensor {
+	return img.MustShallowClone()
+}
+
+// solarizeAddOp adds v to pixels of value less than 128.
+type solarizeAddOp struct{}
+
+func (solarizeAddOp) Transform(img *ts.Tensor, v float64) *ts.Tensor {
+	x := img.MustTotype(gotch.Float, false)
+	mask := x.MustLt(ts.FloatScalar(128), false).MustTotype(gotch.Float, true)
+	added := mask.MustMulScalar(ts.FloatScalar(v), true)
+	out := x.MustAdd(added, true).
+		MustClamp(ts.FloatScalar(0), ts.FloatScalar(255), true).
+		MustTotype(gotch.Uint8, true)
+	added.MustDrop()
+	return out
+}
+
+// NOTE. Downsample, ZoomIn and ZoomOut of aug package can't be used here: aug.DownSample has no
+// option and returns image of half size, aug.ZoomIn panics (fitImg is not implemented) and
+// aug.ZoomOut returns float image.
+
+// downsampleOp resizes image by factor 1 - v/2 and back, losing details. Value v in range [0, 1]
+// goes from no change to half size.
+type downsampleOp struct{}
+
+func (downsampleOp) Transform(img *ts.Tensor, v float64) *ts.Tensor {
+	h, w := imageHW(img)
+	scale := 1 - v/2
+	smallH := maxInt64(int64(math.Round(float64(h)*scale)), 1)
+	smallW := maxInt64(int64(math.Round(float64(w)*scale)), 1)
+	if smallH == h && smallW == w {
+		return img.MustShallowClone()
+	}
+	small := mustResize(img, smallW, smallH)
+	out := mustResize(small, w, h)
+	small.MustDrop()
+	return out
+}
+
+// zoomInOp crops center of image by fraction v of its size then resizes to original size.
+type zoomInOp struct{}
+
+func (zoomInOp) Transform(img *ts.Tensor, v float64) *ts.Tensor {
+	h, w := imageHW(img)
+	cropH := maxInt64(int64(math.Round(float64(h)*(1-v))), 1)
+	cropW := maxInt64(int64(math.Round(float64(w)*(1-v))), 1)
+	crop := img.MustNarrow(1, (h-cropH)/2, cropH, false).MustNarrow(2, (w-cropW)/2, cropW, true)
+	out := mustResize(crop, w, h)
+	crop.MustDrop()
+	return out
+}
+
+// zoomOutOp pads image by fraction v of its size then resizes to original size.
+type zoomOutOp struct{}
+
+func (zoomOutOp) Transform(img *ts.Tensor, v float64) *ts.Tensor {
+	h, w := imageHW(img)
+	padH := int64(math.Round(float64(h) * v / 2))
+	padW := int64(math.Round(float64(w) * v / 2))
+	padded := img.MustConstantPadNd([]int64{padW, padW, padH, padH}, false)
+	out := mustResize(padded, w, h)
+	padded.MustDrop()
+	return out
+}
+
+// translateOp shifts image horizontally (or vertically) by fraction v of its size, or by v pixels
+// if absolute, in a random direction, filling with zeros.
+type translateOp struct {
+	vertical bool
+	absolute bool
+}
+
+func (t translateOp) Transform(img *ts.Tensor, v float64) *ts.Tensor {
+	return t.transform(img, v, randomSign(), nil)
+}
+
+func (t translateOp) transform(img *ts.Tensor, v, sign float64, rng *rand.Rand) *ts.Tensor {
+	h, w := imageHW(img)
+	if t.absolute {
+		h, w = 1, 1
+	}
+	if t.vertical {
+		return shiftImage(img, 0, int64(math.Round(sign*v*float64(h))))
+	}
+	return shiftImage(img, int64(math.Round(sign*v*float64(w))), 0)
+}
+
+// cutoutAbsOp fills a gray square of side v pixels at a random location.
+type cutoutAbsOp struct{}
+
+func (c cutoutAbsOp) Transform(img *ts.Tensor, v float64) *ts.Tensor {
+	return c.transform(img, v, 1, globalRand())
+}
+
+func (cutoutAbsOp) transform(img *ts.Tensor, v, sign float64, rng *rand.Rand) *ts.Tensor {
+	h, w := imageHW(img)
+	size := int64(v)
+	if size <= 0 {
+		return img.MustShallowClone()
+	}
+	cy, cx := rng.Int63n(h), rng.Int63n(w)
+	y1, y2 := maxInt64(cy-size/2, 0), minInt64(cy+size/2, h)
+	x1, x2 := maxInt64(cx-size/2, 0), minInt64(cx+size/2, w)
+	return fillRect(img, y1, x1, y2-y1, x2-x1, []int64{125, 125, 125})
+}
+
+// shiftImage shifts uint8 image of shape [C, H, W] by dx, dy pixels, filling with zeros.
+func shiftImage(img *ts.Tensor, dx, dy int64) *ts.Tensor {
+	h, w := imageHW(img)
+	dx = maxInt64(minInt64(dx, w), -w)
+	dy = maxInt64(minInt64(dy, h), -h)
+	padded := img.MustConstantPadNd([]int64{maxInt64(dx, 0), maxInt64(-dx, 0), maxInt64(dy, 0), maxInt64(-dy, 0)}, false)
+	return padded.MustNarrow(1, maxInt64(-dy, 0), h, true).
+		MustNarrow(2, maxInt64(-dx, 0), w, true).
+		MustContiguous(true)
+}
+
+// fillRect fills a box of image of shape [C, H, W] with color rgb (one value per channel).
+func fillRect(img *ts.Tensor, y, x, bh, bw int64, rgb []int64) *ts.Tensor {
+	size := img.MustSize()
+	c, h, w := size[0], size[1], size[2]
+	box := make([]float32, h*w)
+	for i := y; i < y+bh; i++ {
+		for j := x; j < x+bw; j++ {
+			box[i*w+j] = 1
+		}
+	}
+	color := make([]float32, c)
+	for i := range color {
+		color[i] = float32(rgb[i%len(rgb)])
+	}
+
+	device := img.MustDevice()
+	mask := ts.MustOfSlice(box).MustView([]int64{1, h, w}, true).MustTo(device, true)
+	fillColor := ts.MustOfSlice(color).MustView([]int64{c, 1, 1}, true).MustTo(device, true)
+	keep := mask.MustMulScalar(ts.FloatScalar(-1), false).MustAddScalar(ts.FloatScalar(1), true)
+	fill := mask.MustMul(fillColor, true)
+	out := img.MustTotype(gotch.Float, false).
+		MustMul(keep, true).
+		MustAdd(fill, true).
+		MustTotype(img.DType(), true)
+	keep.MustDrop()
+	fill.MustDrop()
+	fillColor.MustDrop()
+	return out
+}
+
+func imageHW(img *ts.Tensor) (int64, int64) {
+	size := img.MustSize()
+	return size[len(size)-2], size[len(size)-1]
+}
+
+func mustResize(img *ts.Tensor, w, h int64) *ts.Tensor {
+	out, err := vision.Resize(img, w, h)
+	if err != nil {
+		panic(fmt.Errorf("resize image failed: %w", err))
+	}
+	return out
+}
+
+// globalRand returns a generator seeded from global rand.
+func globalRand() *rand.Rand {
+	return rand.New(rand.NewSource(rand.Int63()))
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
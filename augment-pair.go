@@ -0,0 +1,314 @@
+package lab
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/ts"
+	"github.com/sugarme/gotch/vision"
+	"github.com/sugarme/gotch/vision/aug"
+)
+
+// PairTransformer transforms an image and its segmentation mask consistently.
+type PairTransformer interface {
+	// TransformPair transforms uint8 image of shape [C, H, W] and mask of shape [1, H, W].
+	// Random augment is drawn from seed.
+	TransformPair(image, mask *ts.Tensor, seed int64) (*ts.Tensor, *ts.Tensor, error)
+}
+
+// pairOp is a geometric op applied with the same random parameters, drawn from rng, to image
+// and mask. Mask can be nil.
+type pairOp interface {
+	apply(image, mask *ts.Tensor, rng *rand.Rand) (*ts.Tensor, *ts.Tensor, error)
+}
+
+// PairAugment applies geometric ops to both image and mask then photometric ops to image only.
+//
+// Geometric ops are applied in config order before photometric ops. As photometric ops act on
+// pixel values only, the result is the same as applying ops in config order.
+//
+// Geometric ops draw their params from the seed of TransformPair. Photometric ops are reproduced
+// from the seed only if they are built as a SeededTransformer (`seeded` or `record_augment`
+// transform config, see SeededAugment).
+type PairAugment struct {
+	geometric   []pairOp
+	photometric aug.Transformer // nil if no photometric op
+}
+
+// TransformPair implements PairTransformer interface.
+func (a *PairAugment) TransformPair(image, mask *ts.Tensor, seed int64) (*ts.Tensor, *ts.Tensor, error) {
+	rng := rand.New(rand.NewSource(seed))
+	img := image.MustShallowClone()
+	var m *ts.Tensor
+	if mask != nil {
+		m = mask.MustShallowClone()
+	}
+	for _, op := range a.geometric {
+		var err error
+		img, m, err = op.apply(img, m, rng)
+		if err != nil {
+			img.MustDrop()
+			if m != nil {
+				m.MustDrop()
+			}
+			return nil, nil, err
+		}
+	}
+
+	if a.photometric != nil {
+		var out *ts.Tensor
+		if t, ok := a.photometric.(SeededTransformer); ok {
+			out, _ = t.TransformSeeded(img, rng.Int63())
+		} else {
+			out = a.photometric.Transform(img)
+		}
+		img.MustDrop()
+		img = out
+	}
+
+	return img, m, nil
+}
+
+// Transform implements aug.Transformer interface so that PairAugment can also be used for images only.
+// Augment is drawn from a random seed.
+func (a *PairAugment) Transform(image *ts.Tensor) *ts.Tensor {
+	img, _, err := a.TransformPair(image, nil, rand.Int63())
+	if err != nil {
+		panic(fmt.Errorf("PairAugment.Transform failed: %w", err))
+	}
+	return img
+}
+
+// pairGeometricOps are augment names that are applied to both image and mask.
+var pairGeometricOps = map[string]bool{
+	"RandomHFlip":    true,
+	"RandomVFlip":    true,
+	"RandomRotate90": true,
+	"RandomCrop":     true,
+	"CenterCrop":     true,
+	"Resize":         true,
+}
+
+// pairUnsupportedOps are geometric augments of aug package that can't be applied consistently to masks.
+var pairUnsupportedOps = map[string]bool{
+	"RandomRotate":      true,
+	"Rotate":            true,
+	"RandomAffine":      true,
+	"RandomPerspective": true,
+	"ZoomIn":            true,
+	"ZoomOut":           true,
+}
+
+// MakePairTransformer creates a PairAugment from transform config.
+//
+// Geometric ops (RandomHFlip, RandomVFlip, RandomRotate90, RandomCrop, CenterCrop, Resize) are
+// applied to image and mask. Other augment options are photometric and built by MakeTransformer,
+// or by NewSeededAugment if `seeded` or `record_augment` is set.
+func MakePairTransformer(cfg TransformConfig) (*PairAugment, error) {
+	if cfg.IsTransformer {
+		err := fmt.Errorf("MakePairTransformer failed: transformer %q is not supported for image-mask pairs", cfg.TransformerName)
+		return nil, err
+	}
+
+	a := &PairAugment{}
+	var photometric []AugmentOpt
+	for _, augOpt := range cfg.AugmentOpts {
+		if pairUnsupportedOps[augOpt.Name] {
+			err := fmt.Errorf("MakePairTransformer failed: %q is not supported for image-mask pairs", augOpt.Name)
+			return nil, err
+		}
+		if !pairGeometricOps[augOpt.Name] {
+			photometric = append(photometric, augOpt)
+			continue
+		}
+
+		op, err := makePairOp(augOpt)
+		if err != nil {
+			return nil, err
+		}
+		a.geometric = append(a.geometric, op)
+	}
+
+	if len(photometric) > 0 {
+		if cfg.Seeded || cfg.RecordAugment {
+			t, err := NewSeededAugment(photometric)
+			if err != nil {
+				return nil, err
+			}
+			a.photometric = t
+		} else {
+			t, err := MakeTransformer(TransformConfig{AugmentOpts: photometric})
+			if err != nil {
+				return nil, err
+			}
+			a.photometric = t
+		}
+	}
+
+	return a, nil
+}
+
+func makePairOp(augOpt AugmentOpt) (pairOp, error) {
+	pvalue := 0.5
+	if v, ok := augOpt.Params["pvalue"]; ok {
+		pvalue = v.(float64)
+	}
+	size := func() ([]int64, error) {
+		var size []int64
+		if v, ok := augOpt.Params["size"]; ok {
+			size = sliceInterface2Int64(v.([]interface{}))
+		} else {
+			h, hok := augOpt.Params["height"]
+			w, wok := augOpt.Params["width"]
+			if hok && wok {
+				size = []int64{int64(h.(int)), int64(w.(int))}
+			}
+		}
+		if len(size) != 2 {
+			err := fmt.Errorf("MakePairTransformer failed: %q requires 'size: [height, width]'", augOpt.Name)
+			return nil, err
+		}
+		return size, nil
+	}
+
+	switch augOpt.Name {
+	case "RandomHFlip":
+		return &pairFlip{dim: -1, pvalue: pvalue}, nil
+	case "RandomVFlip":
+		return &pairFlip{dim: -2, pvalue: pvalue}, nil
+	case "RandomRotate90":
+		return &pairRotate90{pvalue: pvalue}, nil
+	case "RandomCrop", "CenterCrop":
+		s, err := size()
+		if err != nil {
+			return nil, err
+		}
+		return &pairCrop{height: s[0], width: s[1], random: augOpt.Name == "RandomCrop"}, nil
+	case "Resize":
+		s, err := size()
+		if err != nil {
+			return nil, err
+		}
+		return &pairResize{height: s[0], width: s[1]}, nil
+	default:
+		err := fmt.Errorf("MakePairTransformer failed: unsupported augment option %q", augOpt.Name)
+		return nil, err
+	}
+}
+
+// pairFlip flips image and mask along a dimension with probability pvalue.
+type pairFlip struct {
+	dim    int64
+	pvalue float64
+}
+
+func (op *pairFlip) apply(image, mask *ts.Tensor, rng *rand.Rand) (*ts.Tensor, *ts.Tensor, error) {
+	if rng.Float64() >= op.pvalue {
+		return image, mask, nil
+	}
+	dims := []int64{op.dim}
+	image = image.MustFlip(dims, true)
+	if mask != nil {
+		mask = mask.MustFlip(dims, true)
+	}
+	return image, mask, nil
+}
+
+// pairRotate90 rotates image and mask by a random multiple of 90 degrees with probability pvalue.
+type pairRotate90 struct {
+	pvalue float64
+}
+
+func (op *pairRotate90) apply(image, mask *ts.Tensor, rng *rand.Rand) (*ts.Tensor, *ts.Tensor, error) {
+	if rng.Float64() >= op.pvalue {
+		return image, mask, nil
+	}
+	k := int64(rng.Intn(3) + 1)
+	dims := []int64{-2, -1}
+	image = image.MustRot90(k, dims, true)
+	if mask != nil {
+		mask = mask.MustRot90(k, dims, true)
+	}
+	return image, mask, nil
+}
+
+// pairCrop crops image and mask at the same (random or center) location.
+type pairCrop struct {
+	height, width int64
+	random        bool
+}
+
+func (op *pairCrop) apply(image, mask *ts.Tensor, rng *rand.Rand) (*ts.Tensor, *ts.Tensor, error) {
+	size := image.MustSize()
+	h, w := size[len(size)-2], size[len(size)-1]
+	if h < op.height || w < op.width {
+		// Pad bottom and right to crop size.
+		pad := []int64{0, maxInt64(op.width-w, 0), 0, maxInt64(op.height-h, 0)}
+		image = image.MustConstantPadNd(pad, true)
+		if mask != nil {
+			mask = mask.MustConstantPadNd(pad, true)
+		}
+		h, w = maxInt64(h, op.height), maxInt64(w, op.width)
+	}
+
+	top, left := (h-op.height)/2, (w-op.width)/2
+	if op.random {
+		top = rng.Int63n(h - op.height + 1)
+		left = rng.Int63n(w - op.width + 1)
+	}
+	crop := func(x *ts.Tensor) *ts.Tensor {
+		n := len(x.MustSize())
+		return x.MustNarrow(int64(n-2), top, op.height, true).MustNarrow(int64(n-1), left, op.width, true)
+	}
+	image = crop(image)
+	if mask != nil {
+		mask = crop(mask)
+	}
+	return image, mask, nil
+}
+
+// pairResize resizes image with bilinear and mask with nearest interpolation.
+type pairResize struct {
+	height, width int64
+}
+
+func (op *pairResize) apply(image, mask *ts.Tensor, _ *rand.Rand) (*ts.Tensor, *ts.Tensor, error) {
+	return resizePair(image, mask, op.height, op.width)
+}
+
+// resizePair resizes uint8 image of shape [C, H, W] and mask of shape [1, H, W].
+// Input tensors are kept if resizing image fails.
+func resizePair(image, mask *ts.Tensor, height, width int64) (*ts.Tensor, *ts.Tensor, error) {
+	size := image.MustSize()
+	if size[len(size)-2] != height || size[len(size)-1] != width {
+		resized, err := vision.Resize(image, width, height)
+		if err != nil {
+			err = fmt.Errorf("resize image failed: %w", err)
+			return image, mask, err
+		}
+		image.MustDrop()
+		image = resized
+	}
+
+	if mask != nil {
+		msize := mask.MustSize()
+		if msize[len(msize)-2] != height || msize[len(msize)-1] != width {
+			dtype := mask.DType()
+			mask = mask.MustTotype(gotch.Float, true).
+				MustUnsqueeze(0, true).
+				MustUpsampleNearest2d([]int64{height, width}, nil, nil, true).
+				MustSqueezeDim(0, true).
+				MustTotype(dtype, true)
+		}
+	}
+
+	return image, mask, nil
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,255 @@
+package lab
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/sugarme/gotch/ts"
+	"github.com/sugarme/gotch/vision/aug"
+)
+
+// trivialAugmentWideOps are ops and wide value ranges of TrivialAugmentWide. Translation is in
+// pixels.
+var trivialAugmentWideOps []OpConfig = []OpConfig{
+	{"Identity", 0, 1},
+	{"ShearX", 0, 0.99},
+	{"ShearY", 0, 0.99},
+	{"TranslateXAbs", 0, 32},
+	{"TranslateYAbs", 0, 32},
+	{"Rotate", 0, 135},
+	{"Brightness", 0.01, 1.99},
+	{"Color", 0.01, 1.99},
+	{"Contrast", 0.01, 1.99},
+	{"Sharpness", 0.01, 1.99},
+	{"Posterize", 2, 8},
+	{"Solarize", 0, 256},
+	{"AutoContrast", 0, 1},
+	{"Equalize", 0, 1},
+}
+
+// TrivialAugmentWide applies one op chosen at random with a random magnitude to each image.
+// It implements aug.Transformer interface.
+//
+// Ref. https://arxiv.org/abs/2103.10158
+type TrivialAugmentWide struct {
+	numBins   int
+	normalize aug.Transformer // nil if not normalizing
+}
+
+// NewTrivialAugmentWide creates TrivialAugmentWide transformer. Magnitude is uniformly sampled from
+// numBins levels.
+func NewTrivialAugmentWide(numBins int, normalize bool) (*TrivialAugmentWide, error) {
+	if numBins < 2 {
+		err := fmt.Errorf("NewTrivialAugmentWide failed: number of bins must be at least 2. Got %d", numBins)
+		return nil, err
+	}
+
+	if err := validateOps(trivialAugmentWideOps, true); err != nil {
+		err = fmt.Errorf("NewTrivialAugmentWide failed: %w", err)
+		return nil, err
+	}
+
+	t := &TrivialAugmentWide{numBins: numBins}
+	if normalize {
+		n, err := imagenetNormalize()
+		if err != nil {
+			return nil, err
+		}
+		t.normalize = n
+	}
+	return t, nil
+}
+
+// Transform implements aug.Transformer interface.
+func (t *TrivialAugmentWide) Transform(image *ts.Tensor) *ts.Tensor {
+	out, _ := t.TransformSeeded(image, rand.Int63())
+	return out
+}
+
+// TransformSeeded implements SeededTransformer interface.
+func (t *TrivialAugmentWide) TransformSeeded(image *ts.Tensor, seed int64) (*ts.Tensor, []AppliedOp) {
+	rng := rand.New(rand.NewSource(seed))
+	cfg := trivialAugmentWideOps[rng.Intn(len(trivialAugmentWideOps))]
+	m := float64(rng.Intn(t.numBins))
+	v := cfg.value(m, float64(t.numBins-1), true, rngSign(rng))
+	img, op := applyOp(image.MustShallowClone(), cfg, v, rng)
+
+	return normalizeImage(img, t.normalize), []AppliedOp{op}
+}
+
+// SubPolicy is a pair of ops of an AutoAugment policy. Each op is applied with a probability
+// at a magnitude level in range [0, 9]. Level is ignored for ops without magnitude.
+type SubPolicy [2]struct {
+	OpName string
+	Prob   float64
+	Level  int
+}
+
+// ImageNetPolicy is the AutoAugment policy learned on ImageNet.
+var ImageNetPolicy []SubPolicy = []SubPolicy{
+	{{"Posterize", 0.4, 8}, {"Rotate", 0.6, 9}},
+	{{"Solarize", 0.6, 5}, {"AutoContrast", 0.6, 0}},
+	{{"Equalize", 0.8, 0}, {"Equalize", 0.6, 0}},
+	{{"Posterize", 0.6, 7}, {"Posterize", 0.6, 6}},
+	{{"Equalize", 0.4, 0}, {"Solarize", 0.2, 4}},
+	{{"Equalize", 0.4, 0}, {"Rotate", 0.8, 8}},
+	{{"Solarize", 0.6, 3}, {"Equalize", 0.6, 0}},
+	{{"Posterize", 0.8, 5}, {"Equalize", 1.0, 0}},
+	{{"Rotate", 0.2, 3}, {"Solarize", 0.6, 8}},
+	{{"Equalize", 0.6, 0}, {"Posterize", 0.4, 6}},
+	{{"Rotate", 0.8, 8}, {"Color", 0.4, 0}},
+	{{"Rotate", 0.4, 9}, {"Equalize", 0.6, 0}},
+	{{"Equalize", 0.0, 0}, {"Equalize", 0.8, 0}},
+	{{"Invert", 0.6, 0}, {"Equalize", 1.0, 0}},
+	{{"Color", 0.6, 4}, {"Contrast", 1.0, 8}},
+	{{"Rotate", 0.8, 8}, {"Color", 1.0, 2}},
+	{{"Color", 0.8, 8}, {"Solarize", 0.8, 7}},
+	{{"Sharpness", 0.4, 7}, {"Invert", 0.6, 0}},
+	{{"ShearX", 0.6, 5}, {"Equalize", 1.0, 0}},
+	{{"Color", 0.4, 0}, {"Equalize", 0.6, 0}},
+	{{"Equalize", 0.4, 0}, {"Solarize", 0.2, 4}},
+	{{"Solarize", 0.6, 5}, {"AutoContrast", 0.6, 0}},
+	{{"Invert", 0.6, 0}, {"Equalize", 1.0, 0}},
+	{{"Color", 0.6, 4}, {"Contrast", 1.0, 8}},
+	{{"Equalize", 0.8, 0}, {"Equalize", 0.6, 0}},
+}
+
+// autoAugmentMaxLevel is the highest magnitude level of AutoAugment policies.
+const autoAugmentMaxLevel = 9
+
+// AutoAugment applies a sub-policy chosen at random to each image. It implements aug.Transformer interface.
+//
+// Ref. https://arxiv.org/abs/1805.09501
+type AutoAugment struct {
+	policy    []SubPolicy
+	ops       map[string]OpConfig
+	normalize aug.Transformer // nil if not normalizing
+}
+
+// NewAutoAugment creates AutoAugment transformer of a policy. Only "imagenet" policy is supported.
+func NewAutoAugment(policy string, normalize bool) (*AutoAugment, error) {
+	var subPolicies []SubPolicy
+	switch policy {
+	case "imagenet", "ImageNet", "":
+		subPolicies = ImageNetPolicy
+	default:
+		err := fmt.Errorf("NewAutoAugment failed: unsupported policy %q", policy)
+		return nil, err
+	}
+
+	ops := make(map[string]OpConfig, len(optConfigs))
+	for _, cfg := range optConfigs {
+		ops[cfg.OpName] = cfg
+	}
+	for _, sp := range subPolicies {
+		for _, op := range sp {
+			cfg, ok := ops[op.OpName]
+			if !ok {
+				err := fmt.Errorf("NewAutoAugment failed: unsupported op %q", op.OpName)
+				return nil, err
+			}
+			if op.Level < 0 || op.Level > autoAugmentMaxLevel {
+				err := fmt.Errorf("NewAutoAugment failed: level %d of op %q out of range [0, %d]", op.Level, op.OpName, autoAugmentMaxLevel)
+				return nil, err
+			}
+			if err := validateOps([]OpConfig{cfg}, true); err != nil {
+				err = fmt.Errorf("NewAutoAugment failed: %w", err)
+				return nil, err
+			}
+		}
+	}
+
+	a := &AutoAugment{policy: subPolicies, ops: ops}
+	if normalize {
+		n, err := imagenetNormalize()
+		if err != nil {
+			return nil, err
+		}
+		a.normalize = n
+	}
+	return a, nil
+}
+
+// Transform implements aug.Transformer interface.
+func (a *AutoAugment) Transform(image *ts.Tensor) *ts.Tensor {
+	out, _ := a.TransformSeeded(image, rand.Int63())
+	return out
+}
+
+// TransformSeeded implements SeededTransformer interface.
+func (a *AutoAugment) TransformSeeded(image *ts.Tensor, seed int64) (*ts.Tensor, []AppliedOp) {
+	rng := rand.New(rand.NewSource(seed))
+	img := image.MustShallowClone()
+	var ops []AppliedOp
+	sp := a.policy[rng.Intn(len(a.policy))]
+	for _, op := range sp {
+		if rng.Float64() >= op.Prob {
+			continue
+		}
+		cfg := a.ops[op.OpName]
+		v := cfg.value(float64(op.Level), autoAugmentMaxLevel, true, rngSign(rng))
+		var applied AppliedOp
+		img, applied = applyOp(img, cfg, v, rng)
+		ops = append(ops, applied)
+	}
+
+	return normalizeImage(img, a.normalize), ops
+}
+
+// makePolicyTransformer creates RandomAugment, TrivialAugmentWide or AutoAugment transformer from
+// `transformer_params` of transform config.
+//
+// Params:
+// - RandomAugment: "n", "m", "max_magnitude", "magnitude_mode" and "increasing".
+// - TrivialAugmentWide: "num_bins" (default 31).
+// - AutoAugment: "policy" (default "imagenet").
+func makePolicyTransformer(name string, params map[string]interface{}, normalize bool) (aug.Transformer, error) {
+	switch name {
+	case "RandomAugment":
+		opts := []RandomAugmentOption{WithNormalize(normalize)}
+		for k, v := range params {
+			switch k {
+			case "n":
+				opts = append(opts, WithRandomAugmentNval(v.(int)))
+			case "m":
+				opts = append(opts, WithRandomAugmentMval(v.(int)))
+			case "max_magnitude":
+				opts = append(opts, WithRandomAugmentMaxMagnitude(v.(int)))
+			case "magnitude_mode":
+				opts = append(opts, WithRandomAugmentMagnitudeMode(v.(string)))
+			case "increasing":
+				opts = append(opts, WithRandomAugmentIncreasing(v.(bool)))
+			default:
+				err := fmt.Errorf("RandomAugment: unsupported param %q", k)
+				return nil, err
+			}
+		}
+		return NewRandomAugment(opts...)
+
+	case "TrivialAugmentWide":
+		numBins := 31
+		if v, ok := params["num_bins"]; ok {
+			numBins = v.(int)
+		}
+		t, err := NewTrivialAugmentWide(numBins, normalize)
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+
+	case "AutoAugment":
+		policy := "imagenet"
+		if v, ok := params["policy"]; ok {
+			policy = v.(string)
+		}
+		a, err := NewAutoAugment(policy, normalize)
+		if err != nil {
+			return nil, err
+		}
+		return a, nil
+
+	default:
+		err := fmt.Errorf("Unsupported policy transformer %q", name)
+		return nil, err
+	}
+}
@@ -0,0 +1,134 @@
+package lab
+
+import (
+	"math"
+	"testing"
+
+	"github.com/sugarme/gotch/ts"
+)
+
+func TestOpConfigValue(t *testing.T) {
+	posterize := OpConfig{"Posterize", 4, 8}
+	rotate := OpConfig{"Rotate", 0, 30}
+	color := OpConfig{"Color", 0.1, 1.9}
+
+	tests := []struct {
+		cfg        OpConfig
+		m          float64
+		increasing bool
+		want       float64
+	}{
+		{rotate, 0, false, 0},
+		{rotate, 15, false, 15},
+		{rotate, 40, false, 30}, // clipped to max magnitude
+		{posterize, 30, false, 8},
+		{posterize, 30, true, 4}, // fewer bits is stronger
+		{posterize, 15, true, 6},
+		{color, 0, false, 0.1},
+		{color, 0, true, 1}, // identity at zero magnitude
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.Value(tt.m, 30, tt.increasing); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s(m=%v, increasing=%v): want %v, got %v\n", tt.cfg.OpName, tt.m, tt.increasing, tt.want, got)
+		}
+	}
+
+	// Enhance ops deviate from 1 in either direction.
+	for i := 0; i < 20; i++ {
+		if got := color.Value(30, 30, true); math.Abs(math.Abs(got-1)-0.9) > 1e-9 {
+			t.Errorf("Want 0.1 or 1.9, got %v\n", got)
+		}
+	}
+}
+
+func TestPolicyOps(t *testing.T) {
+	for _, cfg := range append(optConfigs, trivialAugmentWideOps...) {
+		if _, ok := labOps[cfg.OpName]; ok {
+			continue
+		}
+		if _, err := cfg.Make(cfg.MaxVal); err != nil {
+			t.Errorf("%s: %v\n", cfg.OpName, err)
+		}
+	}
+
+	for _, increasing := range []bool{false, true} {
+		if err := validateOps(optConfigs, increasing); err != nil {
+			t.Errorf("RandomAugment ops (increasing=%v): %v\n", increasing, err)
+		}
+	}
+	if err := validateOps(trivialAugmentWideOps, true); err != nil {
+		t.Errorf("TrivialAugmentWide ops: %v\n", err)
+	}
+	invalid := []OpConfig{
+		{"Cutout", 0, 40},   // unsupported
+		{"Rotate", 30, 0},   // min greater than max
+		{"Color", 0.5, 1.9}, // increasing value 0.3 out of range
+	}
+	for _, cfg := range invalid {
+		if err := validateOps([]OpConfig{cfg}, true); err == nil {
+			t.Errorf("%v: want error\n", cfg)
+		}
+	}
+
+	if _, err := NewAutoAugment("imagenet", false); err != nil {
+		t.Error(err)
+	}
+	if _, err := NewAutoAugment("cifar10", false); err == nil {
+		t.Errorf("Want error for unsupported policy")
+	}
+	if _, err := makePolicyTransformer("TrivialAugmentWide", map[string]interface{}{"num_bins": 1}, false); err == nil {
+		t.Errorf("Want error for invalid number of bins")
+	}
+	if _, err := makePolicyTransformer("RandomAugment", map[string]interface{}{"m": 40}, false); err == nil {
+		t.Errorf("Want error for magnitude greater than max magnitude")
+	}
+	if _, err := makePolicyTransformer("RandomAugment", map[string]interface{}{"n": 2, "m": 9, "magnitude_mode": "random", "increasing": true}, false); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLabOps(t *testing.T) {
+	// Image of shape [1, 4, 64] with pixel value of its column index.
+	pixels := make([]uint8, 4*64)
+	for i := range pixels {
+		pixels[i] = uint8(i % 64)
+	}
+	img := ts.MustOfSlice(pixels).MustView([]int64{1, 4, 64}, true)
+	defer img.MustDrop()
+
+	// Translation by 32 pixels regardless of image size.
+	shifted := translateOp{absolute: true}.transform(img, 32, 1, nil)
+	vals := shifted.Int64Values()
+	shifted.MustDrop()
+	if vals[31] != 0 || vals[32] != 0 || vals[63] != 31 {
+		t.Errorf("Want image shifted right by 32 pixels, got row %v\n", vals[:64])
+	}
+
+	// Downsample of zero magnitude keeps image of striped columns, the strongest blurs them.
+	for i := range pixels {
+		pixels[i] = uint8(255 * (i % 2))
+	}
+	striped := ts.MustOfSlice(pixels).MustView([]int64{1, 4, 64}, true)
+	defer striped.MustDrop()
+	for _, tt := range []struct {
+		v       float64
+		changed bool
+	}{{0, false}, {1, true}} {
+		out := downsampleOp{}.Transform(striped, tt.v)
+		if got := out.MustSize(); got[1] != 4 || got[2] != 64 {
+			t.Errorf("Want image size kept, got %v\n", got)
+		}
+		got := out.Int64Values()
+		out.MustDrop()
+		var changed bool
+		for i, v := range got {
+			if v != int64(pixels[i]) {
+				changed = true
+				break
+			}
+		}
+		if changed != tt.changed {
+			t.Errorf("Magnitude %v: want image changed %v, got %v\n", tt.v, tt.changed, changed)
+		}
+	}
+}
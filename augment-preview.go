@@ -0,0 +1,321 @@
+package lab
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/ts"
+	ldraw "github.com/sugarme/lab/draw"
+	"github.com/sugarme/lab/plot"
+	xdraw "golang.org/x/image/draw"
+)
+
+// composeOrder is the order aug.Compose applies augment options in, regardless of their order
+// in config.
+var composeOrder = map[string]int{
+	"Rotate":                0,
+	"RandomRotate":          1,
+	"Resize":                2,
+	"ColorJitter":           3,
+	"RandomHFlip":           5,
+	"RandomVFlip":           6,
+	"CenterCrop":            8,
+	"RandomCutout":          9,
+	"RandomPerspective":     10,
+	"RandomAffine":          11,
+	"RandomGrayscale":       12,
+	"RandomSolarize":        13,
+	"RandomPosterize":       14,
+	"RandomInvert":          15,
+	"RandomAutocontrast":    16,
+	"RandomAdjustSharpness": 17,
+	"RandomEqualize":        18,
+	"Normalize":             19,
+	"ZoomOut":               22,
+}
+
+// ValidateTransformConfig checks a transform config for problems which do not fail
+// MakeTransformer but silently produce a wrong augment pipeline. It returns a list of warnings,
+// empty if none.
+func ValidateTransformConfig(cfg TransformConfig) []string {
+	var warnings []string
+
+	if cfg.IsTransformer {
+		for _, opt := range cfg.AugmentOpts {
+			if opt.Name != "Normalize" {
+				warnings = append(warnings, fmt.Sprintf("%s is ignored: %s only uses Normalize of augment options", opt.Name, cfg.TransformerName))
+			}
+		}
+		return warnings
+	}
+
+	seen := make(map[string]bool)
+	for _, opt := range cfg.AugmentOpts {
+		if _, ok := composeOrder[opt.Name]; !ok {
+			warnings = append(warnings, fmt.Sprintf("Unsupported augment option %q", opt.Name))
+			continue
+		}
+		if seen[opt.Name] {
+			warnings = append(warnings, fmt.Sprintf("%s is specified more than once: only the last one is used", opt.Name))
+		}
+		seen[opt.Name] = true
+
+		if v, ok := opt.Params["pvalue"]; ok {
+			if p, ok := v.(float64); ok && (p <= 0 || p > 1) {
+				warnings = append(warnings, fmt.Sprintf("%s has pvalue %v out of range (0, 1]", opt.Name, p))
+			}
+		}
+	}
+
+	if seen["Normalize"] && seen["ZoomOut"] {
+		warnings = append(warnings, "ZoomOut is always applied after Normalize: normalized pixels are clipped to [0, 255] before zooming out")
+	}
+
+	listed := supportedOpNames(cfg.AugmentOpts)
+	applied := append([]string(nil), listed...)
+	sort.SliceStable(applied, func(i, j int) bool {
+		return composeOrder[applied[i]] < composeOrder[applied[j]]
+	})
+	if strings.Join(listed, ",") != strings.Join(applied, ",") {
+		warnings = append(warnings, fmt.Sprintf("Augment options are applied in order %v, not in config order", applied))
+	}
+
+	return warnings
+}
+
+// supportedOpNames returns names of supported augment options in config order, without duplicates.
+func supportedOpNames(opts []AugmentOpt) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, opt := range opts {
+		if _, ok := composeOrder[opt.Name]; !ok || seen[opt.Name] {
+			continue
+		}
+		seen[opt.Name] = true
+		names = append(names, opt.Name)
+	}
+	return names
+}
+
+type PreviewOptions struct {
+	NumVariants int   // number of augmented variants of each image
+	TileSize    int   // size in pixels of image tiles
+	Seed        int64 // seed of augment of each tile
+}
+
+type PreviewOption func(*PreviewOptions)
+
+func defaultPreviewOptions() *PreviewOptions {
+	return &PreviewOptions{
+		NumVariants: 5,
+		TileSize:    160,
+		Seed:        1,
+	}
+}
+
+func WithPreviewVariants(n int) PreviewOption {
+	return func(o *PreviewOptions) {
+		o.NumVariants = n
+	}
+}
+
+func WithPreviewTileSize(size int) PreviewOption {
+	return func(o *PreviewOptions) {
+		o.TileSize = size
+	}
+}
+
+func WithPreviewSeed(seed int64) PreviewOption {
+	return func(o *PreviewOptions) {
+		o.Seed = seed
+	}
+}
+
+// PreviewAugment renders a grid of sample images to a PNG file. Each row shows an image followed by
+// its augmented variants, each annotated with the ops that fired. It returns warnings of
+// ValidateTransformConfig.
+func PreviewAugment(cfg TransformConfig, imageFiles []string, outFile string, opts ...PreviewOption) ([]string, error) {
+	warnings := ValidateTransformConfig(cfg)
+
+	var images []*ts.Tensor
+	defer func() {
+		for _, img := range images {
+			img.MustDrop()
+		}
+	}()
+	for _, file := range imageFiles {
+		pix, h, w, err := DecodeImageFile(file)
+		if err != nil {
+			err = fmt.Errorf("PreviewAugment - decode %q failed: %w\n", file, err)
+			return warnings, err
+		}
+		img := ts.MustOfSlice(pix).MustView([]int64{3, int64(h), int64(w)}, true)
+		images = append(images, img)
+	}
+
+	grid, err := RenderAugmentPreview(cfg, images, opts...)
+	if err != nil {
+		return warnings, err
+	}
+
+	if err := ldraw.SaveToPngFile(outFile, grid); err != nil {
+		err = fmt.Errorf("PreviewAugment - save %q failed: %w\n", outFile, err)
+		return warnings, err
+	}
+	return warnings, nil
+}
+
+// RenderAugmentPreview renders a grid of uint8 images of shape [C, H, W] and their augmented variants.
+// Variant j of image i is augmented with seed ItemSeed(seed, j, i).
+func RenderAugmentPreview(cfg TransformConfig, images []*ts.Tensor, opts ...PreviewOption) (*image.RGBA, error) {
+	options := defaultPreviewOptions()
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.NumVariants < 1 || options.TileSize < 16 {
+		err := fmt.Errorf("RenderAugmentPreview failed: invalid number of variants %d or tile size %d", options.NumVariants, options.TileSize)
+		return nil, err
+	}
+
+	t, err := MakeSeededTransformer(cfg)
+	if err != nil {
+		err = fmt.Errorf("RenderAugmentPreview - make transformer failed: %w", err)
+		return nil, err
+	}
+
+	const (
+		pad     = 6
+		lineH   = 14
+		maxLine = 3
+	)
+	tile := options.TileSize
+	cellW, cellH := tile+pad, tile+maxLine*lineH+pad
+	cols := options.NumVariants + 1
+	grid := plot.NewImageGraphics(cols*cellW+pad, len(images)*cellH+pad, color.RGBA{0xff, 0xff, 0xff, 0xff}, nil, nil)
+	gc := ldraw.NewGraphicContext(grid.Image)
+	gc.SetStrokeColor(color.RGBA{0x99, 0x99, 0x99, 0xff})
+	gc.SetLineWidth(1)
+	font := plot.Font{Size: plot.TinyFontSize}
+
+	for row, img := range images {
+		for col := 0; col < cols; col++ {
+			var (
+				out   *ts.Tensor
+				fired []string
+			)
+			if col == 0 {
+				out = img.MustShallowClone()
+				fired = []string{"original"}
+			} else {
+				var ops []AppliedOp
+				out, ops = t.TransformSeeded(img, ItemSeed(options.Seed, col-1, row))
+				fired = opLabels(ops)
+			}
+			if col > 0 && len(fired) == 0 {
+				fired = []string{"(none)"}
+			}
+
+			rgba, err := tensorToRGBA(out)
+			out.MustDrop()
+			if err != nil {
+				return nil, err
+			}
+
+			x, y := pad+col*cellW, pad+row*cellH
+			xdraw.ApproxBiLinear.Scale(grid.Image, fitRect(rgba.Bounds(), x, y, tile), rgba, rgba.Bounds(), xdraw.Src, nil)
+			gc.MoveTo(float64(x)-0.5, float64(y)-0.5)
+			gc.LineTo(float64(x+tile)+0.5, float64(y)-0.5)
+			gc.LineTo(float64(x+tile)+0.5, float64(y+tile)+0.5)
+			gc.LineTo(float64(x)-0.5, float64(y+tile)+0.5)
+			gc.Close()
+			gc.Stroke()
+
+			for i, line := range wrapLabels(fired, tile/6, maxLine) {
+				grid.Text(x, y+tile+2+i*lineH, line, "tl", 0, font)
+			}
+		}
+	}
+
+	return grid.Image, nil
+}
+
+// tensorToRGBA converts an image tensor of shape [C, H, W] with 1 or 3 channels to RGBA image.
+// Float images are expected in range [0, 1].
+func tensorToRGBA(x *ts.Tensor) (*image.RGBA, error) {
+	size := x.MustSize()
+	if len(size) != 3 || (size[0] != 1 && size[0] != 3) {
+		err := fmt.Errorf("tensorToRGBA failed: expected image shape [C, H, W] with 1 or 3 channels, got %v", size)
+		return nil, err
+	}
+
+	scale := 1.0
+	if x.DType() != gotch.Uint8 {
+		scale = 255
+	}
+	return pixelsToRGBA(x.Float64Values(), int(size[0]), int(size[1]), int(size[2]), scale), nil
+}
+
+// pixelsToRGBA converts pixels in CHW order to RGBA image. Pixels are multiplied by scale and
+// clipped to [0, 255].
+func pixelsToRGBA(pix []float64, c, h, w int, scale float64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	size := h * w
+	for i := 0; i < size; i++ {
+		var rgb [3]uint8
+		for ch := 0; ch < 3; ch++ {
+			v := pix[i]
+			if c == 3 {
+				v = pix[ch*size+i]
+			}
+			rgb[ch] = uint8(math.Max(0, math.Min(255, math.Round(v*scale))))
+		}
+		img.Pix[4*i], img.Pix[4*i+1], img.Pix[4*i+2], img.Pix[4*i+3] = rgb[0], rgb[1], rgb[2], 0xff
+	}
+	return img
+}
+
+// fitRect returns a rectangle at (x, y) fitting bounds into a square of given size, keeping aspect ratio.
+func fitRect(bounds image.Rectangle, x, y, size int) image.Rectangle {
+	w, h := bounds.Dx(), bounds.Dy()
+	if w >= h {
+		fh := int(math.Round(float64(size) * float64(h) / float64(w)))
+		return image.Rect(x, y, x+size, y+fh)
+	}
+	fw := int(math.Round(float64(size) * float64(w) / float64(h)))
+	return image.Rect(x, y, x+fw, y+size)
+}
+
+// wrapLabels joins labels into at most maxLines lines of at most width characters.
+// Labels which do not fit are replaced with "...".
+func wrapLabels(labels []string, width, maxLines int) []string {
+	var lines []string
+	var line string
+	for i, l := range labels {
+		if i < len(labels)-1 {
+			l += ","
+		}
+		switch {
+		case line == "":
+			line = l
+		case len(line)+1+len(l) <= width:
+			line += " " + l
+		default:
+			lines = append(lines, line)
+			line = l
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		lines[maxLines-1] += " ..."
+	}
+	return lines
+}
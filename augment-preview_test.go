@@ -0,0 +1,84 @@
+package lab
+
+import (
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateTransformConfig(t *testing.T) {
+	cfg := TransformConfig{
+		AugmentOpts: []AugmentOpt{
+			{Name: "Normalize"},
+			{Name: "ColorJitter"},
+			{Name: "RandomHFlip", Params: map[string]interface{}{"pvalue": 0.0}},
+			{Name: "RandomHFlip"},
+			{Name: "RandomBlur"},
+		},
+	}
+	warnings := ValidateTransformConfig(cfg)
+	for _, want := range []string{
+		"RandomHFlip is specified more than once",
+		"RandomHFlip has pvalue 0",
+		`Unsupported augment option "RandomBlur"`,
+		"applied in order [ColorJitter RandomHFlip Normalize]",
+	} {
+		found := false
+		for _, w := range warnings {
+			if strings.Contains(w, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Want warning %q, got %q\n", want, warnings)
+		}
+	}
+
+	cfg = TransformConfig{
+		AugmentOpts: []AugmentOpt{
+			{Name: "Resize"},
+			{Name: "ColorJitter"},
+			{Name: "RandomHFlip", Params: map[string]interface{}{"pvalue": 0.5}},
+			{Name: "Normalize"},
+		},
+	}
+	if warnings := ValidateTransformConfig(cfg); len(warnings) != 0 {
+		t.Errorf("Want no warnings, got %q\n", warnings)
+	}
+
+	cfg = TransformConfig{
+		IsTransformer:   true,
+		TransformerName: "RandomAugment",
+		AugmentOpts:     []AugmentOpt{{Name: "RandomHFlip"}, {Name: "Normalize"}},
+	}
+	if warnings := ValidateTransformConfig(cfg); len(warnings) != 1 || !strings.HasPrefix(warnings[0], "RandomHFlip is ignored") {
+		t.Errorf("Want ignored RandomHFlip warning, got %q\n", warnings)
+	}
+}
+
+func TestWrapLabels(t *testing.T) {
+	labels := []string{"RandomHFlip", "ColorJitter", "RandomAffine", "Normalize"}
+	want := []string{"RandomHFlip, ColorJitter,", "RandomAffine, Normalize"}
+	if got := wrapLabels(labels, 26, 3); !reflect.DeepEqual(got, want) {
+		t.Errorf("Want %q, got %q\n", want, got)
+	}
+	want = []string{"RandomHFlip, ColorJitter, ..."}
+	if got := wrapLabels(labels, 26, 1); !reflect.DeepEqual(got, want) {
+		t.Errorf("Want %q, got %q\n", want, got)
+	}
+}
+
+func TestPixelsToRGBA(t *testing.T) {
+	// 1x2 image: red and white pixels, float in [0, 1]
+	img := pixelsToRGBA([]float64{1, 1, 0, 1, 0, 1.2}, 3, 1, 2, 255)
+	want := []uint8{255, 0, 0, 255, 255, 255, 255, 255}
+	if !reflect.DeepEqual(img.Pix, want) {
+		t.Errorf("Want %v, got %v\n", want, img.Pix)
+	}
+
+	if got := fitRect(image.Rect(0, 0, 200, 100), 10, 20, 50); got != image.Rect(10, 20, 60, 45) {
+		t.Errorf("Want fitted rectangle (10,20)-(60,45), got %v\n", got)
+	}
+}
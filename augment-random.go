@@ -2,9 +2,8 @@ package lab
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
-	"sort"
-	"time"
 
 	"github.com/sugarme/gotch/ts"
 	"github.com/sugarme/gotch/vision/aug"
@@ -22,86 +21,147 @@ type OpConfig struct {
 }
 
 var optConfigs []OpConfig = []OpConfig{
+	{"AutoContrast", 0, 1},
 	{"Equalize", 0, 1},
-	{"Posterize", 0, 4},
+	{"Invert", 0, 1},
+	{"Posterize", 4, 8},
 	{"Color", 0.1, 1.9},
 	{"Brightness", 0.1, 1.9},
 	{"Contrast", 0.1, 1.9},
 	{"Sharpness", 0.1, 1.9},
 	{"Rotate", 0, 30},
-	{"Cutout", 0, 0.6},
+	{"CutoutAbs", 0, 40},
 	{"Solarize", 0, 256},
-	// {"SolarizeAdd", 0, 110},
-	{"Invert", 0, 1},
+	{"SolarizeAdd", 0, 110},
 	{"TranslateX", 0, 0.3},
 	{"TranslateY", 0, 0.3},
 	{"ShearX", 0, 0.3},
 	{"ShearY", 0, 0.3},
 
-	// {"Downsample", 0, 1},
-	// {"ZoomIn", 0, 0.5},
-	// {"ZoomOut", 0, 0.5},
-	// {"AutoContrast", 0, 1},
+	{"Downsample", 0, 1},
+	{"ZoomIn", 0, 0.5},
+	{"ZoomOut", 0, 0.5},
+}
+
+// labOps are ops implemented in this package rather than by aug package.
+var labOps = map[string]TransformOp{
+	"Identity":      identityOp{},
+	"SolarizeAdd":   solarizeAddOp{},
+	"Downsample":    downsampleOp{},
+	"ZoomIn":        zoomInOp{},
+	"ZoomOut":       zoomOutOp{},
+	"CutoutAbs":     cutoutAbsOp{},
+	"TranslateX":    translateOp{},
+	"TranslateY":    translateOp{vertical: true},
+	"TranslateXAbs": translateOp{absolute: true},
+	"TranslateYAbs": translateOp{vertical: true, absolute: true},
+}
+
+// Value maps magnitude m in range [0, maxM] linearly onto [MinVal, MaxVal].
+//
+// If increasing is true, a larger magnitude always gives a stronger augment: Posterize and
+// Solarize are mapped in reverse order and enhance ops (Color, Brightness, Contrast, Sharpness)
+// deviate from 1 (identity) in a random direction.
+func (c *OpConfig) Value(m, maxM float64, increasing bool) float64 {
+	return c.value(m, maxM, increasing, randomSign())
+}
+
+// value is Value with direction of enhance ops given by sign.
+func (c *OpConfig) value(m, maxM float64, increasing bool, sign float64) float64 {
+	frac := m / maxM
+	if frac < 0 {
+		frac = 0
+	}
+	if frac > 1 {
+		frac = 1
+	}
+
+	if increasing {
+		switch c.OpName {
+		case "Posterize", "Solarize":
+			return c.MaxVal - frac*(c.MaxVal-c.MinVal)
+		case "Color", "Brightness", "Contrast", "Sharpness":
+			return 1 + sign*frac*(c.MaxVal-c.MinVal)/2
+		}
+	}
+
+	return c.MinVal + frac*(c.MaxVal-c.MinVal)
 }
 
 // Make creates transformer option from specified input value.
+//
+// Geometric ops (Rotate, ShearX, ShearY) are applied in a random direction. Ops without magnitude
+// (AutoContrast, Equalize, Invert) are always applied. Ops implemented by this package (see MakeOp)
+// are not aug options and return an error.
 func (c *OpConfig) Make(v float64) (aug.Option, error) {
+	return c.make(v, randomSign())
+}
+
+// make is Make with direction of geometric ops given by sign.
+func (c *OpConfig) make(v, sign float64) (aug.Option, error) {
 	if err := c.validate(v); err != nil {
 		return nil, err
 	}
 
 	switch c.OpName {
 	case "AutoContrast":
-		return aug.WithRandomAutocontrast(v), nil
+		return aug.WithRandomAutocontrast(1.0), nil
 	case "Equalize":
-		return aug.WithRandomEqualize(v), nil
+		return aug.WithRandomEqualize(1.0), nil
 	case "Invert":
-		return aug.WithRandomInvert(v), nil
-	case "Downsample": // resize image to half
-		// TODO: resize downsample
-		// return aug.WithResize(h/2, w/2), nil
-	case "ZoomIn":
-		// TODO: resize crop image to v*w and v*h
-	case "ZoomOut":
-		// TODO: resize pad image to v*w and v*h
+		return aug.WithRandomInvert(1.0), nil
 	case "Rotate":
-		// return aug.WithRotate(v), nil
-		return aug.WithRandomAffine(aug.WithAffineDegree([]int64{0, int64(v)})), nil
+		d := int64(math.Round(sign * v))
+		return aug.WithRandomAffine(aug.WithAffineDegree([]int64{d, d})), nil
 	case "Posterize":
-		return aug.WithRandomPosterize(aug.WithPosterizeBits(uint8(v))), nil
+		return aug.WithRandomPosterize(aug.WithPosterizeBits(uint8(math.Round(v))), aug.WithPosterizePvalue(1.0)), nil
 	case "Solarize":
-		return aug.WithRandomSolarize(), nil
-	case "SolarizeAdd":
-		return aug.WithRandomSolarize(aug.WithSolarizeThreshold(v)), nil
+		return aug.WithRandomSolarize(aug.WithSolarizeThreshold(v), aug.WithSolarizePvalue(1.0)), nil
 	case "Color":
-		// TODO: is saturation?
 		return aug.WithColorJitter(aug.WithColorSaturation([]float64{v, v})), nil
 	case "Contrast":
 		return aug.WithColorJitter(aug.WithColorContrast([]float64{v, v})), nil
 	case "Brightness":
 		return aug.WithColorJitter(aug.WithColorBrightness([]float64{v, v})), nil
 	case "Sharpness":
-		return aug.WithRandomAdjustSharpness(aug.WithSharpnessFactor(v)), nil
+		return aug.WithRandomAdjustSharpness(aug.WithSharpnessFactor(v), aug.WithSharpnessPvalue(1.0)), nil
 	case "ShearX":
-		return aug.WithRandomAffine(aug.WithAffineShear([]float64{v, 1, 0, 0, 0, 1})), nil
+		// shear factor to degree
+		d := sign * math.Atan(v) * 180 / math.Pi
+		return aug.WithRandomAffine(aug.WithAffineShear([]float64{d, d})), nil
 	case "ShearY":
-		return aug.WithRandomAffine(aug.WithAffineShear([]float64{0, 1, 0, v, 0, 1})), nil
-	case "Cutout":
-		return aug.WithRandomCutout(aug.WithCutoutValue([]int64{127, 127, 127}), aug.WithCutoutRatio([]float64{0.0001, v / 2.0})), nil
-	case "CutoutAbs":
-		// TODO. For now, just the same as Cutout
-		return aug.WithRandomCutout(aug.WithCutoutValue([]int64{127, 127, 127}), aug.WithCutoutRatio([]float64{0.0001, v / 2.0})), nil
-	case "TranslateX":
-		return aug.WithRandomAffine(aug.WithAffineTranslate([]float64{v, 0})), nil
-	case "TranslateY":
-		return aug.WithRandomAffine(aug.WithAffineTranslate([]float64{0, v})), nil
-
+		d := sign * math.Atan(v) * 180 / math.Pi
+		return aug.WithRandomAffine(aug.WithAffineShear([]float64{0, 0, d, d})), nil
 	default:
+		if _, ok := labOps[c.OpName]; ok {
+			err := fmt.Errorf("%s is not an aug option. Use OpConfig.MakeOp instead\n", c.OpName)
+			return nil, err
+		}
 		err := fmt.Errorf("Unsupported transformer option: %s\n", c.OpName)
 		return nil, err
 	}
+}
 
-	return nil, nil
+// MakeOp creates a transformer applying the op with value v.
+func (c *OpConfig) MakeOp(v float64) (aug.Transformer, error) {
+	return c.makeOp(v, randomSign(), nil)
+}
+
+// makeOp is MakeOp with direction of geometric ops given by sign. Ops of this package draw random
+// location from rng if not nil.
+func (c *OpConfig) makeOp(v, sign float64, rng *rand.Rand) (aug.Transformer, error) {
+	if op, ok := labOps[c.OpName]; ok {
+		if err := c.validate(v); err != nil {
+			return nil, err
+		}
+		return &opTransformer{op: op, v: v, sign: sign, rng: rng}, nil
+	}
+
+	opt, err := c.make(v, sign)
+	if err != nil {
+		return nil, err
+	}
+	return aug.Compose(opt)
 }
 
 func (c *OpConfig) validate(v float64) error {
@@ -113,19 +173,47 @@ func (c *OpConfig) validate(v float64) error {
 	return err
 }
 
+// validateOps checks that ops are supported and values of all magnitudes are in their value range,
+// so that applying ops to images can not fail. Values are linear in magnitude, hence checking the
+// lowest and highest magnitude in both directions is enough.
+func validateOps(ops []OpConfig, increasing bool) error {
+	for _, c := range ops {
+		if c.MinVal > c.MaxVal {
+			err := fmt.Errorf("invalid op %s: min value %v greater than max value %v", c.OpName, c.MinVal, c.MaxVal)
+			return err
+		}
+		for _, m := range []float64{0, 1} {
+			for _, sign := range []float64{-1, 1} {
+				v := c.value(m, 1, increasing, sign)
+				if _, err := c.makeOp(v, sign, nil); err != nil {
+					err = fmt.Errorf("invalid op %s: %w", c.OpName, err)
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
 type RandomAugmentOptions struct {
-	N         int
-	M         int
-	Normalize bool
+	N             int
+	M             int
+	MaxMagnitude  int    // magnitude scale. M is in range [0, MaxMagnitude]
+	MagnitudeMode string // "constant", "random" (uniform in [0, M]) or "poisson" (mean M)
+	Increasing    bool   // larger magnitude is always stronger augment. See OpConfig.Value
+	Normalize     bool
 }
 
 type RandomAugmentOption func(*RandomAugmentOptions)
 
 func defaultRandomAugmentOptions() *RandomAugmentOptions {
 	return &RandomAugmentOptions{
-		N:         3,
-		M:         12,
-		Normalize: true,
+		N:             3,
+		M:             12,
+		MaxMagnitude:  30,
+		MagnitudeMode: "constant",
+		Increasing:    false,
+		Normalize:     true,
 	}
 }
 
@@ -141,16 +229,43 @@ func WithRandomAugmentMval(v int) RandomAugmentOption {
 	}
 }
 
+func WithRandomAugmentMaxMagnitude(v int) RandomAugmentOption {
+	return func(o *RandomAugmentOptions) {
+		o.MaxMagnitude = v
+	}
+}
+
+func WithRandomAugmentMagnitudeMode(mode string) RandomAugmentOption {
+	return func(o *RandomAugmentOptions) {
+		o.MagnitudeMode = mode
+	}
+}
+
+func WithRandomAugmentIncreasing(v bool) RandomAugmentOption {
+	return func(o *RandomAugmentOptions) {
+		o.Increasing = v
+	}
+}
+
 func WithNormalize(v bool) RandomAugmentOption {
 	return func(o *RandomAugmentOptions) {
 		o.Normalize = v
 	}
 }
 
-// NewRandomAugment randomly select n augmentation options from a list of augmentation options
-// to compose Transformer.
+// RandomAugment applies N ops randomly chosen for each image. It implements aug.Transformer interface.
+type RandomAugment struct {
+	*RandomAugmentOptions
+	ops       []OpConfig
+	normalize aug.Transformer // nil if not normalizing
+}
+
+// NewRandomAugment creates a RandAugment transformer. For each image, N ops are chosen (with
+// replacement) from a list of augmentation ops and applied with magnitude M mapped linearly onto
+// each op's value range.
 //
 // Ref.
+// https://arxiv.org/abs/1909.13719
 // https://raw.githubusercontent.com/ildoonet/pytorch-randaugment/master/RandAugment/augmentations.py
 // https://github.com/rpmcruz/autoaugment/blob/master/transformations.py
 func NewRandomAugment(opts ...RandomAugmentOption) (aug.Transformer, error) {
@@ -159,81 +274,140 @@ func NewRandomAugment(opts ...RandomAugmentOption) (aug.Transformer, error) {
 		opt(options)
 	}
 
-	var augOpts []aug.Option
-
-	choices, err := makeAugmentOptions(options.N, options.M)
-	if err != nil {
+	if options.N < 1 || options.MaxMagnitude < 1 || options.M < 0 || options.M > options.MaxMagnitude {
+		err := fmt.Errorf("NewRandomAugment failed: invalid N=%d, M=%d, max magnitude=%d", options.N, options.M, options.MaxMagnitude)
+		return nil, err
+	}
+	switch options.MagnitudeMode {
+	case "constant", "random", "poisson":
+	default:
+		err := fmt.Errorf("NewRandomAugment failed: unsupported magnitude mode %q", options.MagnitudeMode)
 		return nil, err
 	}
 
-	augOpts = append(augOpts, choices...)
+	if err := validateOps(optConfigs, options.Increasing); err != nil {
+		err = fmt.Errorf("NewRandomAugment failed: %w", err)
+		return nil, err
+	}
 
+	ra := &RandomAugment{
+		RandomAugmentOptions: options,
+		ops:                  optConfigs,
+	}
 	if options.Normalize {
-		normOpt := aug.WithNormalize(aug.WithNormalizeMean([]float64{0.485, 0.456, 0.406}), aug.WithNormalizeStd([]float64{0.229, 0.224, 0.225}))
-		augOpts = append(augOpts, normOpt)
+		normalize, err := imagenetNormalize()
+		if err != nil {
+			return nil, err
+		}
+		ra.normalize = normalize
 	}
 
-	t, err := aug.Compose(augOpts...)
-	return t, nil
+	return ra, nil
 }
 
-func makeAugmentOptions(n, m int) ([]aug.Option, error) {
-	var options []aug.Option
-	min := 0
-	var opIndexes []int
-	if len(optConfigs) > 0 {
-		// max := len(optConfigs) - 1
-		max := len(optConfigs)
-		opIndexes = randomInts(n, min, max)
+// magnitude samples a magnitude according to magnitude mode.
+func (ra *RandomAugment) magnitude(rng *rand.Rand) float64 {
+	m := float64(ra.M)
+	switch ra.MagnitudeMode {
+	case "random":
+		m = rng.Float64() * m
+	case "poisson":
+		m = randomPoisson(m, rng)
 	}
+	return math.Min(m, float64(ra.MaxMagnitude))
+}
 
-	for _, idx := range opIndexes {
-		cfg := optConfigs[idx]
-		m := randomPoisson(float64(m))
-		if m > 30 {
-			m = 30
-		}
-		val := float64(m)/float64(30)*(cfg.MaxVal-cfg.MinVal) + cfg.MinVal
-		// fmt.Printf("aug: %s - v value: %v\n", cfg.OpName,val)
+// Transform implements aug.Transformer interface.
+func (ra *RandomAugment) Transform(image *ts.Tensor) *ts.Tensor {
+	out, _ := ra.TransformSeeded(image, rand.Int63())
+	return out
+}
 
-		opt, err := cfg.Make(val)
-		if err != nil {
-			return nil, err
-		}
-		options = append(options, opt)
+// TransformSeeded implements SeededTransformer interface.
+func (ra *RandomAugment) TransformSeeded(image *ts.Tensor, seed int64) (*ts.Tensor, []AppliedOp) {
+	rng := rand.New(rand.NewSource(seed))
+	img := image.MustShallowClone()
+	var ops []AppliedOp
+	for i := 0; i < ra.N; i++ {
+		cfg := ra.ops[rng.Intn(len(ra.ops))]
+		v := cfg.value(ra.magnitude(rng), float64(ra.MaxMagnitude), ra.Increasing, rngSign(rng))
+		var op AppliedOp
+		img, op = applyOp(img, cfg, v, rng)
+		ops = append(ops, op)
 	}
 
-	return options, nil
+	return normalizeImage(img, ra.normalize), ops
 }
 
-// makes random n integers in range [min, max]
-func randomInts(n, min, max int) []int {
-	rand.Seed(time.Now().UnixNano())
-	var choices []int
-	for i := 0; i < n; i++ {
-		c := rand.Intn(max-min) + min
-		if !contains(c, choices) {
-			choices = append(choices, c)
-		}
+// geometricOps are ops applied in a random direction.
+var geometricOps = map[string]bool{
+	"Rotate":        true,
+	"ShearX":        true,
+	"ShearY":        true,
+	"TranslateX":    true,
+	"TranslateY":    true,
+	"TranslateXAbs": true,
+	"TranslateYAbs": true,
+}
+
+// applyOp applies op with value v to image and deletes input image. Direction of geometric ops and
+// location of cutout are drawn from rng. It returns the applied op with signed value.
+//
+// Ops must be checked with validateOps by the transformer constructor and v must be an op value
+// of a magnitude in range, then creating the op does not fail.
+func applyOp(img *ts.Tensor, cfg OpConfig, v float64, rng *rand.Rand) (*ts.Tensor, AppliedOp) {
+	sign := 1.0
+	if geometricOps[cfg.OpName] {
+		sign = rngSign(rng)
+	}
+	t, err := cfg.makeOp(v, sign, rng)
+	if err != nil {
+		// Unreachable with validated ops.
+		err = fmt.Errorf("Apply %s failed: %w", cfg.OpName, err)
+		panic(err)
 	}
+	out := t.Transform(img)
+	img.MustDrop()
 
-	// sort
-	sort.Ints(choices)
+	op := AppliedOp{Name: cfg.OpName}
+	switch cfg.OpName {
+	case "Identity", "AutoContrast", "Equalize", "Invert":
+	default:
+		op.Params = map[string]float64{"value": sign * v}
+	}
+	return out, op
+}
 
-	return choices
+// normalizeImage applies normalize transformer if not nil and deletes input image.
+func normalizeImage(img *ts.Tensor, normalize aug.Transformer) *ts.Tensor {
+	if normalize == nil {
+		return img
+	}
+	out := normalize.Transform(img)
+	img.MustDrop()
+	return out
 }
 
-func contains(item int, items []int) bool {
-	for _, i := range items {
-		if item == i {
-			return true
-		}
+func imagenetNormalize() (aug.Transformer, error) {
+	return aug.Compose(aug.WithNormalize(aug.WithNormalizeMean([]float64{0.485, 0.456, 0.406}), aug.WithNormalizeStd([]float64{0.229, 0.224, 0.225})))
+}
+
+func randomSign() float64 {
+	if rand.Intn(2) == 0 {
+		return -1
+	}
+	return 1
+}
+
+func rngSign(rng *rand.Rand) float64 {
+	if rng.Intn(2) == 0 {
+		return -1
 	}
-	return false
+	return 1
 }
 
-func randomPoisson(lambda float64) float64 {
-	p := Poisson{Lambda: lambda}
+func randomPoisson(lambda float64, rng *rand.Rand) float64 {
+	p := Poisson{Lambda: lambda, Src: rng}
 	return p.Rand()
 }
 
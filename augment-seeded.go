@@ -0,0 +1,306 @@
+package lab
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/sugarme/gotch/ts"
+	"github.com/sugarme/gotch/vision/aug"
+)
+
+// AppliedOp is an augment op applied to an image and its sampled params.
+type AppliedOp struct {
+	Name   string
+	Params map[string]float64 // nil if op has no sampled params
+}
+
+// String returns op name followed by its params, e.g. "RandomRotate angle=12.5".
+func (op AppliedOp) String() string {
+	if v, ok := op.Params["value"]; ok && len(op.Params) == 1 {
+		return fmt.Sprintf("%s %.3g", op.Name, v)
+	}
+	keys := make([]string, 0, len(op.Params))
+	for k := range op.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	s := op.Name
+	for _, k := range keys {
+		s += fmt.Sprintf(" %s=%.3g", k, op.Params[k])
+	}
+	return s
+}
+
+func opLabels(ops []AppliedOp) []string {
+	labels := make([]string, len(ops))
+	for i, op := range ops {
+		labels[i] = op.String()
+	}
+	return labels
+}
+
+// SeededTransformer is a transformer whose random augment is drawn from a seed only, so that
+// an augmented image can be reproduced. It reports ops applied to the image.
+type SeededTransformer interface {
+	aug.Transformer
+	TransformSeeded(image *ts.Tensor, seed int64) (*ts.Tensor, []AppliedOp)
+}
+
+// seededStep is an augment option of SeededAugment applied with probability pvalue, as aug does:
+// it fires if a uniform draw, or a standard normal draw if normal, is less than pvalue.
+// Its random params are drawn from rng.
+type seededStep struct {
+	name   string
+	pvalue float64
+	normal bool
+	apply  func(img *ts.Tensor, rng *rand.Rand) (*ts.Tensor, map[string]float64)
+}
+
+// SeededAugment wraps the aug options of MakeTransformer, applied in the same order, but draws
+// whether an option fires and its random params from a seed. It implements SeededTransformer.
+//
+// RandomAffine, RandomPerspective and RandomCutout draw their params, and RandomCutout whether it
+// fires, with libtorch so they are not reproducible. They are reported as applied without params.
+type SeededAugment struct {
+	steps []seededStep
+}
+
+// NewSeededAugment creates SeededAugment from augment options. Options take the same params as in
+// MakeTransformer.
+func NewSeededAugment(opts []AugmentOpt) (*SeededAugment, error) {
+	// Keep the last option of each name as aug.Compose does.
+	last := make(map[string]AugmentOpt)
+	for _, opt := range opts {
+		if _, ok := composeOrder[opt.Name]; !ok {
+			err := fmt.Errorf("NewSeededAugment failed: unsupported augment option %q", opt.Name)
+			return nil, err
+		}
+		last[opt.Name] = opt
+	}
+
+	names := supportedOpNames(opts)
+	sort.SliceStable(names, func(i, j int) bool {
+		return composeOrder[names[i]] < composeOrder[names[j]]
+	})
+
+	a := &SeededAugment{}
+	for _, name := range names {
+		step, err := newSeededStep(last[name])
+		if err != nil {
+			err = fmt.Errorf("NewSeededAugment - %s failed: %w", name, err)
+			return nil, err
+		}
+		if step != nil {
+			a.steps = append(a.steps, *step)
+		}
+	}
+	return a, nil
+}
+
+// Transform implements aug.Transformer interface.
+func (a *SeededAugment) Transform(image *ts.Tensor) *ts.Tensor {
+	out, _ := a.TransformSeeded(image, rand.Int63())
+	return out
+}
+
+// TransformSeeded implements SeededTransformer interface.
+func (a *SeededAugment) TransformSeeded(image *ts.Tensor, seed int64) (*ts.Tensor, []AppliedOp) {
+	rng := rand.New(rand.NewSource(seed))
+	img := image.MustShallowClone()
+	var ops []AppliedOp
+	for _, s := range a.steps {
+		// Each step has its own stream so that changing one option does not change the others.
+		stepRng := rand.New(rand.NewSource(rng.Int63()))
+		draw := stepRng.Float64()
+		if s.normal {
+			draw = stepRng.NormFloat64()
+		}
+		if draw >= s.pvalue {
+			continue
+		}
+		out, params := s.apply(img, stepRng)
+		img.MustDrop()
+		img = out
+		ops = append(ops, AppliedOp{Name: s.name, Params: params})
+	}
+	return img, ops
+}
+
+// MakeSeededTransformer creates a SeededTransformer from transform config: RandomAugment,
+// TrivialAugmentWide or AutoAugment if IsTransformer, otherwise SeededAugment of augment options.
+func MakeSeededTransformer(cfg TransformConfig) (SeededTransformer, error) {
+	if !cfg.IsTransformer {
+		a, err := NewSeededAugment(cfg.AugmentOpts)
+		if err != nil {
+			return nil, err
+		}
+		return a, nil
+	}
+
+	t, err := MakeTransformer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	st, ok := t.(SeededTransformer)
+	if !ok {
+		err := fmt.Errorf("MakeSeededTransformer failed: transformer %q is not seeded", cfg.TransformerName)
+		return nil, err
+	}
+	return st, nil
+}
+
+// newSeededStep creates a step of augment option from its aug option (see MakeTransformer). It
+// returns nil if the option is skipped.
+func newSeededStep(opt AugmentOpt) (*seededStep, error) {
+	pvalue := 0.5
+	if v, ok := opt.Params["pvalue"]; ok {
+		pvalue = v.(float64)
+	}
+
+	switch opt.Name {
+	// aug fires these if a uniform draw is less than pvalue.
+	case "RandomAutocontrast", "RandomInvert", "RandomGrayscale", "RandomEqualize",
+		"RandomSolarize", "RandomAdjustSharpness", "RandomPosterize":
+		return wrapStep(withPvalue(opt, 1.0), pvalue, false)
+
+	// aug flips if a standard normal draw is less than pvalue.
+	case "RandomHFlip", "RandomVFlip":
+		return wrapStep(withPvalue(opt, math.MaxFloat64), pvalue, true)
+
+	case "RandomRotate":
+		var min, max float64
+		if v, ok := opt.Params["min"]; ok {
+			min = v.(float64)
+		}
+		if v, ok := opt.Params["max"]; ok {
+			max = v.(float64)
+		}
+		apply := func(img *ts.Tensor, rng *rand.Rand) (*ts.Tensor, map[string]float64) {
+			angle := uniform(rng, min, max)
+			return composeApply(img, aug.WithRotate(angle)), map[string]float64{"angle": angle}
+		}
+		return &seededStep{name: opt.Name, pvalue: 1, apply: apply}, nil
+
+	case "ColorJitter":
+		ranges := make(map[string][]float64)
+		for _, k := range []string{"brightness", "contrast", "saturation", "hue"} {
+			if v, ok := opt.Params[k]; ok {
+				if r := colorJitterRange(sliceInterface2Float64(v.([]interface{})), k == "hue"); r != nil {
+					ranges[k] = r
+				}
+			}
+		}
+		apply := func(img *ts.Tensor, rng *rand.Rand) (*ts.Tensor, map[string]float64) {
+			sampled := make(map[string]float64)
+			var o []aug.ColorOption
+			// Sample in fixed order so that params do not depend on map iteration.
+			for _, k := range []string{"brightness", "contrast", "saturation", "hue"} {
+				r, ok := ranges[k]
+				if !ok {
+					continue
+				}
+				v := uniform(rng, r[0], r[1])
+				sampled[k] = v
+				switch k {
+				case "brightness":
+					o = append(o, aug.WithColorBrightness([]float64{v, v}))
+				case "contrast":
+					o = append(o, aug.WithColorContrast([]float64{v, v}))
+				case "saturation":
+					o = append(o, aug.WithColorSaturation([]float64{v, v}))
+				case "hue":
+					o = append(o, aug.WithColorHue([]float64{v, v}))
+				}
+			}
+			return composeApply(img, aug.WithColorJitter(o...)), sampled
+		}
+		return &seededStep{name: opt.Name, pvalue: 1, apply: apply}, nil
+
+	// Ops without random params, or drawing them (and RandomCutout whether it fires) with libtorch.
+	default:
+		return wrapStep(opt, 1, false)
+	}
+}
+
+// colorJitterRange returns range of a ColorJitter factor as aug does: a single value v means
+// [1-v, 1+v] (clipped at 0), or [-v, v] for hue. It returns nil if the factor does nothing.
+func colorJitterRange(vals []float64, hue bool) []float64 {
+	switch len(vals) {
+	case 1:
+		v := math.Abs(vals[0])
+		if hue {
+			if v == 0 {
+				return nil
+			}
+			return []float64{-v, v}
+		}
+		if v == 0 || v == 1 {
+			return nil
+		}
+		return []float64{math.Max(1-v, 0), 1 + v}
+	case 2:
+		min, max := math.Min(vals[0], vals[1]), math.Max(vals[0], vals[1])
+		if (min == 0 && max == 0) || (!hue && min == 1 && max == 1) {
+			return nil
+		}
+		return []float64{min, max}
+	default:
+		return nil
+	}
+}
+
+// wrapStep creates a step applying aug option of opt as is, fired with probability pvalue.
+func wrapStep(opt AugmentOpt, pvalue float64, normal bool) (*seededStep, error) {
+	o, err := augmentOption(opt)
+	if err != nil || o == nil {
+		return nil, err
+	}
+	t, err := aug.Compose(o)
+	if err != nil {
+		return nil, err
+	}
+	apply := func(img *ts.Tensor, rng *rand.Rand) (*ts.Tensor, map[string]float64) {
+		return t.Transform(img), nil
+	}
+	return &seededStep{name: opt.Name, pvalue: pvalue, normal: normal, apply: apply}, nil
+}
+
+// withPvalue returns a copy of opt with param pvalue.
+func withPvalue(opt AugmentOpt, pvalue float64) AugmentOpt {
+	params := make(map[string]interface{}, len(opt.Params)+1)
+	for k, v := range opt.Params {
+		params[k] = v
+	}
+	params["pvalue"] = pvalue
+	return AugmentOpt{Name: opt.Name, Params: params}
+}
+
+// composeApply applies an aug option with fixed params to image.
+func composeApply(img *ts.Tensor, opt aug.Option) *ts.Tensor {
+	t, err := aug.Compose(opt)
+	if err != nil {
+		panic(fmt.Errorf("compose augment failed: %w", err))
+	}
+	return t.Transform(img)
+}
+
+func uniform(rng *rand.Rand, min, max float64) float64 {
+	return min + rng.Float64()*(max-min)
+}
+
+// AugmentRecord is the augment applied to a dataset item. Item can be reloaded with the same
+// augment from its seed.
+type AugmentRecord struct {
+	Index int
+	Seed  int64
+	Ops   []AppliedOp
+}
+
+// String returns a one-line description of the record.
+func (r AugmentRecord) String() string {
+	return fmt.Sprintf("item %d (seed %d): %s", r.Index, r.Seed, strings.Join(opLabels(r.Ops), ", "))
+}
@@ -0,0 +1,91 @@
+package lab
+
+import (
+	"testing"
+)
+
+func TestAppliedOpString(t *testing.T) {
+	tests := []struct {
+		op   AppliedOp
+		want string
+	}{
+		{AppliedOp{Name: "RandomHFlip"}, "RandomHFlip"},
+		{AppliedOp{Name: "Rotate", Params: map[string]float64{"value": -12.5}}, "Rotate -12.5"},
+		{AppliedOp{Name: "ColorJitter", Params: map[string]float64{"contrast": 0.9, "brightness": 1.2}}, "ColorJitter brightness=1.2 contrast=0.9"},
+	}
+	for _, tt := range tests {
+		if got := tt.op.String(); got != tt.want {
+			t.Errorf("Want %q, got %q\n", tt.want, got)
+		}
+	}
+
+	r := AugmentRecord{Index: 3, Seed: 42, Ops: []AppliedOp{tests[0].op, tests[1].op}}
+	if got, want := r.String(), "item 3 (seed 42): RandomHFlip, Rotate -12.5"; got != want {
+		t.Errorf("Want %q, got %q\n", want, got)
+	}
+}
+
+func TestColorJitterRange(t *testing.T) {
+	tests := []struct {
+		vals []float64
+		hue  bool
+		want []float64
+	}{
+		{[]float64{0.3}, false, []float64{0.7, 1.3}},
+		{[]float64{1.5}, false, []float64{0, 2.5}},
+		{[]float64{1.2, 0.8}, false, []float64{0.8, 1.2}},
+		{[]float64{1, 1}, false, nil},
+		{[]float64{0.3}, true, []float64{-0.3, 0.3}},
+		{[]float64{0, 0}, true, nil},
+	}
+	for _, tt := range tests {
+		got := colorJitterRange(tt.vals, tt.hue)
+		if len(got) != len(tt.want) || (got != nil && (got[0] != tt.want[0] || got[1] != tt.want[1])) {
+			t.Errorf("%v (hue=%v): want %v, got %v\n", tt.vals, tt.hue, tt.want, got)
+		}
+	}
+}
+
+func TestNewSeededAugment(t *testing.T) {
+	opts := []AugmentOpt{
+		{Name: "Normalize"},
+		{Name: "RandomHFlip", Params: map[string]interface{}{"pvalue": 0.2}},
+		{Name: "Resize"},
+		{Name: "ColorJitter", Params: map[string]interface{}{"brightness": []interface{}{0.8, 1.2}}},
+		{Name: "RandomHFlip", Params: map[string]interface{}{"pvalue": 0.7}},
+	}
+	a, err := NewSeededAugment(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Same order as aug.Compose, Resize skipped and the last RandomHFlip kept.
+	want := []string{"ColorJitter", "RandomHFlip", "Normalize"}
+	if len(a.steps) != len(want) {
+		t.Fatalf("Want %d steps, got %d\n", len(want), len(a.steps))
+	}
+	for i, s := range a.steps {
+		if s.name != want[i] {
+			t.Errorf("Step %d: want %s, got %s\n", i, want[i], s.name)
+		}
+	}
+	if s := a.steps[1]; s.pvalue != 0.7 || !s.normal {
+		t.Errorf("Want pvalue 0.7 of a normal draw as aug flips, got %v (normal=%v)\n", s.pvalue, s.normal)
+	}
+
+	if _, err := NewSeededAugment([]AugmentOpt{{Name: "RandomBlur"}}); err == nil {
+		t.Errorf("Want error for unsupported augment option")
+	}
+
+	// Ops of uniform probability are drawn by the step and built to always fire.
+	a, err = NewSeededAugment([]AugmentOpt{{Name: "RandomInvert", Params: map[string]interface{}{"pvalue": 0.3}}, {Name: "RandomAffine"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := a.steps[0]; s.name != "RandomAffine" || s.pvalue != 1 {
+		t.Errorf("Want RandomAffine always applied, got %s with pvalue %v\n", s.name, s.pvalue)
+	}
+	if s := a.steps[1]; s.name != "RandomInvert" || s.pvalue != 0.3 || s.normal {
+		t.Errorf("Want RandomInvert with pvalue 0.3 of a uniform draw, got %s with %v (normal=%v)\n", s.name, s.pvalue, s.normal)
+	}
+}
@@ -2,7 +2,9 @@ package lab
 
 import (
 	"fmt"
-	"math"
+	"os"
+	"reflect"
+	"time"
 
 	"github.com/sugarme/gotch/dutil"
 	"github.com/sugarme/gotch/ts"
@@ -25,29 +27,424 @@ func NewBuilder(cfg *Config) *Builder {
 	}
 }
 
-func (b *Builder) BuildDataLoader(data dutil.Dataset, mode string) (*dutil.DataLoader, error) {
-	var shuffle bool
-	var batchSize int64
+// BuildDataLoader builds train or valid data loader. If `num_workers` of train or evaluation
+// config is positive, items are loaded concurrently by a ParallelLoader prefetching `prefetch` batches.
+func (b *Builder) BuildDataLoader(data dutil.Dataset, mode string) (Loader, error) {
+	var (
+		shuffle    bool
+		batchSize  int64
+		numWorkers int
+		prefetch   int
+	)
 	switch mode {
 	case "train":
 		shuffle = true
 		batchSize = b.Config.Train.BatchSize
+		numWorkers = b.Config.Train.NumWorkers
+		prefetch = b.Config.Train.Prefetch
 	case "valid":
 		shuffle = false
 		batchSize = b.Config.Evaluation.BatchSize
+		numWorkers = b.Config.Evaluation.NumWorkers
+		prefetch = b.Config.Evaluation.Prefetch
 	default:
 		err := fmt.Errorf("Unsuported mode: %q\n", mode)
 		return nil, err
 	}
 
-	n := data.Len()
-	sampler, err := dutil.NewBatchSampler(n, int(batchSize), true, shuffle)
+	var (
+		sampler dutil.Sampler
+		err     error
+	)
+	if shuffle {
+		sampler, err = b.BuildSampler(data, int(batchSize))
+	} else {
+		sampler, err = dutil.NewBatchSampler(data.Len(), int(batchSize), true, shuffle)
+	}
 	if err != nil {
 		err := fmt.Errorf("BuildDataLoader failed: %w\n", err)
 		return nil, err
 	}
 
-	return dutil.NewDataLoader(data, sampler)
+	if numWorkers > 0 {
+		opts := []ParallelLoaderOption{WithNumWorkers(numWorkers), WithLoaderSeed(b.Config.Seed)}
+		if prefetch > 0 {
+			opts = append(opts, WithPrefetch(prefetch))
+		}
+		loader, err := NewParallelLoader(data, sampler, opts...)
+		if err != nil {
+			err := fmt.Errorf("BuildDataLoader failed: %w\n", err)
+			return nil, err
+		}
+		return loader, nil
+	}
+
+	var loader *dutil.DataLoader
+	if shuffle {
+		loader, err = NewSamplerDataLoader(data, sampler, b.Config.Seed)
+	} else {
+		loader, err = dutil.NewDataLoader(data, sampler)
+	}
+	if err != nil {
+		err := fmt.Errorf("BuildDataLoader failed: %w\n", err)
+		return nil, err
+	}
+	return loader, nil
+}
+
+// BuildSampler builds train data sampler from `train.params.sampler` config.
+//
+// Supported samplers:
+// - "Random" (default): shuffles all samples each epoch.
+// - "WeightedRandom": draws `num_samples` (default: dataset length) samples with probabilities
+// from `weighting`: "median_frequency" (default, see ClassWeights), "inverse_frequency" or
+// "sample" (per-sample weights of SampleWeighted dataset). `replacement` defaults to true.
+// - "ClassBalanced": equal number of samples of each class per batch. `num_batches` defaults
+// to dataset length / batch size.
+// - "Stratified": every batch has the same class proportions as the dataset.
+//
+// Samplers are seeded with `seed` param, default to config seed.
+func (b *Builder) BuildSampler(data dutil.Dataset, batchSize int) (dutil.Sampler, error) {
+	cfg := b.Config.Train.Params.Sampler
+	p := newParamReader(cfg.Params)
+	seed := int64(p.int("seed", int(b.Config.Seed)))
+	dropLast := p.bool("drop_last", true)
+	if p.err != nil {
+		err := fmt.Errorf("BuildSampler failed: %w", p.err)
+		return nil, err
+	}
+
+	labels := func() ([]int, error) {
+		d, ok := data.(Labeled)
+		if !ok {
+			err := fmt.Errorf("sampler %q requires a dataset with labels", cfg.Name)
+			return nil, err
+		}
+		return d.Labels(), nil
+	}
+
+	switch cfg.Name {
+	case "", "Random":
+		return NewShuffleSampler(data.Len(), batchSize, dropLast, seed)
+
+	case "WeightedRandom":
+		numSamples := p.int("num_samples", data.Len())
+		replacement := p.bool("replacement", true)
+		weighting := p.string("weighting", "median_frequency")
+		if p.err != nil {
+			err := fmt.Errorf("BuildSampler failed: %w", p.err)
+			return nil, err
+		}
+
+		var weights []float64
+		switch weighting {
+		case "sample":
+			d, ok := data.(SampleWeighted)
+			if !ok {
+				err := fmt.Errorf("weighting %q requires a dataset with sample weights", weighting)
+				return nil, err
+			}
+			weights = d.SampleWeights()
+		case "median_frequency", "inverse_frequency":
+			lbls, err := labels()
+			if err != nil {
+				return nil, err
+			}
+			var classes []string
+			if d, ok := data.(*ImageDataset); ok {
+				classes = d.Classes
+			}
+			freqs := ClassFrequencies(lbls, classes)
+			classWeights := ClassWeights(freqs)
+			if weighting == "inverse_frequency" {
+				classWeights = InverseFrequencyWeights(freqs)
+			}
+			weights, err = ClassSampleWeights(lbls, classes, classWeights)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			err := fmt.Errorf("Unsupported sampler weighting: %q", weighting)
+			return nil, err
+		}
+		return NewWeightedRandomSampler(weights, numSamples, batchSize, replacement, dropLast, seed)
+
+	case "ClassBalanced":
+		lbls, err := labels()
+		if err != nil {
+			return nil, err
+		}
+		numBatches := p.int("num_batches", 0)
+		if p.err != nil {
+			err := fmt.Errorf("BuildSampler failed: %w", p.err)
+			return nil, err
+		}
+		return NewClassBalancedSampler(lbls, batchSize, numBatches, seed)
+
+	case "Stratified":
+		lbls, err := labels()
+		if err != nil {
+			return nil, err
+		}
+		return NewStratifiedBatchSampler(lbls, batchSize, dropLast, seed)
+
+	default:
+		err := fmt.Errorf("Unsupported sampler: %q", cfg.Name)
+		return nil, err
+	}
+}
+
+// BuildImageDatasets builds train and valid image classification datasets from dataset config.
+//
+// Supported dataset names:
+// - "ImageCSV": ground truth csv file `csv_filename` with images in `data_dir`. Labels of tasks of
+// `model.params.tasks` are class indices in columns of task names.
+// - "ImageFolder": class sub-directories in `data_dir[0]`. If `params.valid_dir` is specified,
+// it is used as valid dataset instead of splitting.
+//
+// Valid dataset is split by `params.valid_fold` of `params.fold_column` if specified, otherwise
+// by `params.valid_ratio` (default = 0.2). Train and valid transformers are built from transform config.
+func (b *Builder) BuildImageDatasets() (*ImageDataset, *ImageDataset, error) {
+	cfg := b.Config.Dataset
+	params := cfg.Params
+	getString := func(key string) string {
+		if v, ok := params[key]; ok {
+			return v.(string)
+		}
+		return ""
+	}
+
+	var opts []ImageDatasetOption
+	if v, ok := params["image_size"]; ok {
+		size := SliceInterface2Int64(v.([]interface{}))
+		if len(size) != 2 {
+			err := fmt.Errorf("BuildImageDatasets failed: expected image_size [height, width], got %v\n", size)
+			return nil, nil, err
+		}
+		opts = append(opts, WithImageSize(size[0], size[1]))
+	}
+
+	var (
+		samples []ImageSample
+		classes []string
+		valid   *ImageDataset
+		err     error
+	)
+	switch cfg.Name {
+	case "ImageCSV":
+		csvOpts := []ImageCSVOption{
+			WithImageColumn(getString("image_column")),
+			WithLabelColumn(getString("label_column")),
+			WithFoldColumn(getString("fold_column")),
+		}
+		if tasks := b.Config.Model.Params.Tasks; len(tasks) > 0 {
+			columns := make([]string, len(tasks))
+			for i, task := range tasks {
+				columns[i] = task.Name
+			}
+			csvOpts = append(csvOpts, WithTaskColumns(columns))
+		}
+		if v, ok := params["classes"]; ok {
+			var cls []string
+			for _, c := range v.([]interface{}) {
+				cls = append(cls, fmt.Sprint(c))
+			}
+			csvOpts = append(csvOpts, WithClasses(cls))
+		}
+		samples, classes, err = LoadImageCSV(cfg.CSVFilename, cfg.DataDir, csvOpts...)
+
+	case "ImageFolder":
+		if len(b.Config.Model.Params.Tasks) > 0 {
+			err := fmt.Errorf("BuildImageDatasets failed: multi-task labels (model.params.tasks) require ImageCSV dataset\n")
+			return nil, nil, err
+		}
+		if len(cfg.DataDir) == 0 {
+			err := fmt.Errorf("BuildImageDatasets failed: data_dir is required\n")
+			return nil, nil, err
+		}
+		samples, classes, err = LoadImageFolder(cfg.DataDir[0])
+		if err == nil && getString("valid_dir") != "" {
+			var validSamples []ImageSample
+			var validClasses []string
+			validSamples, validClasses, err = LoadImageFolder(getString("valid_dir"))
+			if err == nil && !reflect.DeepEqual(classes, validClasses) {
+				err = fmt.Errorf("classes of train (%v) and valid (%v) directories differ", classes, validClasses)
+			}
+			valid = NewImageDataset(validSamples, classes, opts...)
+		}
+
+	default:
+		err := fmt.Errorf("BuildImageDatasets failed: unsupported dataset %q\n", cfg.Name)
+		return nil, nil, err
+	}
+	if err == nil {
+		err = checkTaskLabels(samples, b.Config.Model.Params.Tasks)
+	}
+	if err != nil {
+		err = fmt.Errorf("BuildImageDatasets failed: %w\n", err)
+		return nil, nil, err
+	}
+
+	data := NewImageDataset(samples, classes, opts...)
+	var train *ImageDataset
+	switch {
+	case valid != nil:
+		train = data
+	case getString("fold_column") != "":
+		p := newParamReader(params)
+		fold := p.int("valid_fold", 0)
+		if err = p.err; err == nil {
+			train, valid, err = data.SplitFold(fold)
+		}
+	default:
+		p := newParamReader(params)
+		ratio := p.float("valid_ratio", 0.2)
+		if err = p.err; err == nil {
+			train, valid, err = data.Split(ratio, b.Config.Seed)
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("BuildImageDatasets failed: %w\n", err)
+		return nil, nil, err
+	}
+
+	trainTransformer, err := b.BuildTransformer("train")
+	if err != nil {
+		return nil, nil, err
+	}
+	validTransformer, err := b.BuildTransformer("valid")
+	if err != nil {
+		return nil, nil, err
+	}
+	train.SetTransformer(trainTransformer)
+	valid.SetTransformer(validTransformer)
+	train.SetAugmentRecord(b.Config.Transform.Train.RecordAugment)
+	valid.SetAugmentRecord(b.Config.Transform.Valid.RecordAugment)
+
+	// Saved teacher logits for distillation
+	if logitsFile := b.Config.Train.Params.Distill.LogitsFile; logitsFile != "" {
+		logits, err := LoadTeacherLogits(logitsFile)
+		if err == nil {
+			err = train.SetTeacherLogits(logits)
+		}
+		if err != nil {
+			err = fmt.Errorf("BuildImageDatasets failed: %w\n", err)
+			return nil, nil, err
+		}
+	}
+
+	return train, valid, nil
+}
+
+// checkTaskLabels checks that labels of samples are classes of tasks.
+func checkTaskLabels(samples []ImageSample, tasks []TaskConfig) error {
+	for i, s := range samples {
+		for j, task := range tasks {
+			if l := s.Labels[j]; int64(l) >= task.NumClasses {
+				err := fmt.Errorf("sample %d (%s): label %d of task %q out of range [0, %d)", i, s.Path, l, task.Name, task.NumClasses)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// BuildSegDatasets builds train and valid segmentation datasets from dataset config.
+//
+// Supported dataset names:
+// - "SegFolder": images in `data_dir[0]` and masks in `data_dir[1]` paired by name with `params.mask_suffix`.
+// - "SegCSV": csv file `csv_filename` of image-mask pairs (`params.mask_column`) or RLE masks (`params.rle_column`).
+//
+// Valid dataset is split by `params.valid_fold` of `params.fold_column` if specified, otherwise
+// by `params.valid_ratio` (default = 0.2). Paired transformers are built from transform config.
+func (b *Builder) BuildSegDatasets() (*SegDataset, *SegDataset, error) {
+	cfg := b.Config.Dataset
+	params := cfg.Params
+	getString := func(key string) string {
+		if v, ok := params[key]; ok {
+			return v.(string)
+		}
+		return ""
+	}
+
+	var opts []SegDatasetOption
+	if v, ok := params["image_size"]; ok {
+		size := SliceInterface2Int64(v.([]interface{}))
+		if len(size) != 2 {
+			err := fmt.Errorf("BuildSegDatasets failed: expected image_size [height, width], got %v\n", size)
+			return nil, nil, err
+		}
+		opts = append(opts, WithSegImageSize(size[0], size[1]))
+	}
+	if v := getString("rle_order"); v != "" {
+		opts = append(opts, WithRLEColMajor(v != "row"))
+	}
+	if v, ok := params["binary_mask"]; ok {
+		opts = append(opts, WithBinaryMask(v.(bool)))
+	}
+
+	var (
+		samples []SegSample
+		err     error
+	)
+	switch cfg.Name {
+	case "SegFolder":
+		if len(cfg.DataDir) != 2 {
+			err := fmt.Errorf("BuildSegDatasets failed: expected data_dir [image_dir, mask_dir], got %v\n", cfg.DataDir)
+			return nil, nil, err
+		}
+		samples, err = LoadSegFolder(cfg.DataDir[0], cfg.DataDir[1], getString("mask_suffix"))
+
+	case "SegCSV":
+		csvOpts := SegCSVOptions{
+			ImageColumn: getString("image_column"),
+			MaskColumn:  getString("mask_column"),
+			RLEColumn:   getString("rle_column"),
+			ClassColumn: getString("class_column"),
+			FoldColumn:  getString("fold_column"),
+		}
+		samples, err = LoadSegCSV(cfg.CSVFilename, segDataDirs(cfg.CSVFilename, cfg.DataDir), csvOpts)
+
+	default:
+		err := fmt.Errorf("BuildSegDatasets failed: unsupported dataset %q\n", cfg.Name)
+		return nil, nil, err
+	}
+	if err != nil {
+		err = fmt.Errorf("BuildSegDatasets failed: %w\n", err)
+		return nil, nil, err
+	}
+
+	data := NewSegDataset(samples, opts...)
+	var train, valid *SegDataset
+	p := newParamReader(params)
+	if getString("fold_column") != "" {
+		fold := p.int("valid_fold", 0)
+		if err = p.err; err == nil {
+			train, valid, err = data.SplitFold(fold)
+		}
+	} else {
+		ratio := p.float("valid_ratio", 0.2)
+		if err = p.err; err == nil {
+			train, valid, err = data.Split(ratio, b.Config.Seed)
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("BuildSegDatasets failed: %w\n", err)
+		return nil, nil, err
+	}
+
+	trainTransformer, err := b.BuildPairTransformer("train")
+	if err != nil {
+		return nil, nil, err
+	}
+	validTransformer, err := b.BuildPairTransformer("valid")
+	if err != nil {
+		return nil, nil, err
+	}
+	train.SetTransformer(trainTransformer)
+	valid.SetTransformer(validTransformer)
+
+	return train, valid, nil
 }
 
 func (b *Builder) BuildModel(configOpt ...ModelConfig) (*Model, error) {
@@ -63,21 +460,44 @@ func (b *Builder) BuildModel(configOpt ...ModelConfig) (*Model, error) {
 	}
 
 	// Build model
-	var module ts.ModuleT
+	if cfg.Params.Decoder != "" {
+		mclass = "Segmentation"
+	}
+	var (
+		module ts.ModuleT
+		head   *lib.Head
+		layers lib.Layers
+	)
 	switch mclass {
-	case "EffNet":
-		module = lib.EffNet(vs.Root(), cfg.Params.NumClasses, cfg.Params.Backbone, cfg.Params.Dropout)
+	case "Segmentation":
+		if len(cfg.Params.Tasks) > 0 {
+			err := fmt.Errorf("BuildModel failed: multi-task head (model.params.tasks) requires a classification model")
+			return nil, err
+		}
+		var err error
+		module, err = lib.SegModel(vs.Root(), cfg.Params.Decoder, backbone, cfg.Params.NumClasses, lib.WithDecoderChannels(cfg.Params.DecoderChannels), lib.WithActivation(cfg.Params.Activation))
+		if err != nil {
+			err = fmt.Errorf("BuildModel failed: %w\n", err)
+			return nil, err
+		}
 
 	case "UNet":
 		module = lib.UNet(vs.Root(), cfg.Params.Backbone)
 
-	case "ResNet":
-		module = lib.ResNet(vs.Root(), cfg.Params.NumClasses, cfg.Params.Backbone)
-
-	case "DenseNet":
-		module = lib.DenseNet(vs.Root(), cfg.Params.NumClasses, cfg.Params.Backbone)
+	case "EffNet", "ResNet", "DenseNet", "ResNeXt", "SEResNet", "MobileNet", "VGG", "RegNet", "ConvNeXt":
+		features, channels, err := lib.Features(vs.Root(), backbone)
+		if err != nil {
+			err = fmt.Errorf("BuildModel failed: %w\n", err)
+			return nil, err
+		}
+		head, err = lib.NewClassifierHead(vs.Root(), backbone, channels, cfg.Params.NumClasses, b.headOptions()...)
+		if err != nil {
+			err = fmt.Errorf("BuildModel failed: %w\n", err)
+			return nil, err
+		}
+		module = lib.Classifier(features, head)
+		layers = append(features, lib.Layer{Name: "head", Prefixes: head.Prefixes(), Module: head})
 
-	// TODO: continue
 	default:
 		err := fmt.Errorf("Invalid Model Class %q", mclass)
 		return nil, err
@@ -85,32 +505,114 @@ func (b *Builder) BuildModel(configOpt ...ModelConfig) (*Model, error) {
 
 	// Load pretrained if specified
 	if cfg.Params.Pretrained {
-		pretrainedFile, err := lib.PretrainedFile(cfg.Params.Backbone, lib.WithPath(cfg.Params.PretrainedPath))
+		if cfg.Params.PretrainedChecksums != "" {
+			f, err := os.Open(cfg.Params.PretrainedChecksums)
+			if err != nil {
+				err = fmt.Errorf("BuildModel failed: %w\n", err)
+				return nil, err
+			}
+			_, err = lib.LoadChecksums(f)
+			f.Close()
+			if err != nil {
+				err = fmt.Errorf("BuildModel failed: %w\n", err)
+				return nil, err
+			}
+		}
+		pretrainedFile, err := lib.PretrainedFile(cfg.Params.Backbone,
+			lib.WithPath(cfg.Params.PretrainedPath),
+			lib.WithMirror(cfg.Params.PretrainedMirror),
+			lib.WithVerify(cfg.Params.PretrainedVerify),
+		)
 		if err != nil {
 			err := fmt.Errorf("Get pretrained file failed: %w\n", err)
 			return nil, err
 		}
 		// Load partial because we modify number of classes in classify layer.
-		_, err = vs.LoadPartial(pretrainedFile)
+		_, _, err = lib.LoadWeightsPartial(vs, pretrainedFile)
 		if err != nil {
 			err = fmt.Errorf("Load pretrained backbone weights failed: %w\n", err)
 			return nil, err
 		}
 	}
 
+	// Load all weights of previous training if specified
+	var previous map[string]string
+	if file := b.Config.Train.LoadPrevious; file != "" {
+		var err error
+		previous, err = lib.LoadWeights(vs, file)
+		if err != nil {
+			err = fmt.Errorf("BuildModel failed to load previous weights: %w\n", err)
+			return nil, err
+		}
+	}
+
 	m := &Model{
 		Name:    backbone,
 		Weights: vs,
 		Module:  module,
+		Head:    head,
+		Layers:  layers,
+
+		Previous: previous,
+	}
+
+	// Freeze backbone stages if specified
+	if len(cfg.Params.FreezeStages) > 0 {
+		err := m.FreezeStages(cfg.Params.FreezeStages...)
+		if err != nil {
+			err = fmt.Errorf("BuildModel failed: %w\n", err)
+			return nil, err
+		}
 	}
+
 	return m, nil
 }
 
+// headOptions returns classification head options from model config. Multi-sample dropout
+// averages `multisample_count` (default 5) dropout masks. Tasks override `num_classes`.
+func (b *Builder) headOptions() []lib.HeadOption {
+	params := b.Config.Model.Params
+	opts := []lib.HeadOption{
+		lib.WithPooling(params.Pooling),
+		lib.WithHiddenLayers(params.HiddenLayers),
+		lib.WithHeadDropout(params.Dropout),
+	}
+	if params.MultisampleDropout {
+		n := params.MultisampleCount
+		if n == 0 {
+			n = 5
+		}
+		opts = append(opts, lib.WithMultisampleDropout(n))
+	}
+	if len(params.Tasks) > 0 {
+		tasks := make([]lib.Task, len(params.Tasks))
+		for i, task := range params.Tasks {
+			tasks[i] = lib.Task{Name: task.Name, NumClasses: task.NumClasses}
+		}
+		opts = append(opts, lib.WithTasks(tasks...))
+	}
+	return opts
+}
+
 type LossFunc func(logits, target *ts.Tensor) *ts.Tensor
 
 // BuildLoss builds loss function.
+//
+// Loss of a multi-task head (`model.params.tasks`) is averaged over tasks by Trainer and Evaluator
+// (see TaskLoss). It requires CrossEntropyLoss on class indices, i.e. without batch augment.
 func (b *Builder) BuildLoss() (LossFunc, error) {
 	name := b.Config.Loss.Name
+	if len(b.Config.Model.Params.Tasks) > 0 {
+		augName := b.Config.Transform.Train.BatchAugment.Name
+		switch {
+		case name != "CrossEntropyLoss":
+			err := fmt.Errorf("BuildLoss failed: multi-task head requires CrossEntropyLoss, got %q", name)
+			return nil, err
+		case augName != "" && augName != "None":
+			err := fmt.Errorf("BuildLoss failed: multi-task head does not support batch augment %q", augName)
+			return nil, err
+		}
+	}
 	var lossFunc LossFunc
 	switch name {
 	case "CrossEntropyLoss":
@@ -119,6 +621,11 @@ func (b *Builder) BuildLoss() (LossFunc, error) {
 		}
 
 	case "BCELoss":
+		// Mixed soft targets sum to 1 over classes which BCELoss would take as independent labels.
+		if augName := b.Config.Transform.Train.BatchAugment.Name; augName != "" && augName != "None" {
+			err := fmt.Errorf("BuildLoss failed: BCELoss does not support batch augment %q. Use CrossEntropyLoss instead", augName)
+			return nil, err
+		}
 		lossFunc = func(logits, target *ts.Tensor) *ts.Tensor {
 			return BCELoss(logits, target)
 		}
@@ -140,183 +647,233 @@ func (b *Builder) BuildLoss() (LossFunc, error) {
 	return lossFunc, nil
 }
 
-// BuildOptimizer builds optimizer.
-func (b *Builder) BuildOptimizer(vs *nn.VarStore) (*nn.Optimizer, error) {
-	modelParams := b.Config.Model.Params
-	params := b.Config.Optimizer.Params
-	name := b.Config.Optimizer.Name
-
-	var (
-		err error
-	)
-
-	fmt.Printf("modelParams: %+v\n", modelParams)
-	fmt.Printf("optimizer params: %+v\n", params)
-	fmt.Printf("optimizer name: %+v\n", name)
-
-	var lr float64
-	for k, v := range params {
-		switch k {
-		case "lr":
-			lr = v.(float64)
-			break
-		}
+// BuildDistiller builds knowledge distillation from `train.params.distill` config. It returns
+// nil if neither teachers nor teacher logits file are specified.
+//
+// Teachers are built from model config of their training `config` file, or of student if not
+// specified, with `backbone` overridden and weights of `checkpoint`. They must have the same
+// classes as student, which must be a single-task classification model. Teacher logits file
+// (see SaveTeacherLogits) is loaded with train data by BuildImageDatasets and does not support
+// batch augment.
+func (b *Builder) BuildDistiller() (*Distiller, error) {
+	cfg := b.Config.Train.Params.Distill
+	augName := b.Config.Transform.Train.BatchAugment.Name
+	switch {
+	case len(cfg.Teachers) == 0 && cfg.LogitsFile == "":
+		return nil, nil
+	case len(cfg.Teachers) > 0 && cfg.LogitsFile != "":
+		err := fmt.Errorf("BuildDistiller failed: expected either teachers or logits_file, got both")
+		return nil, err
+	case b.Config.Model.Params.Decoder != "" || len(b.Config.Model.Params.Tasks) > 0:
+		err := fmt.Errorf("BuildDistiller failed: distillation requires a single-task classification model")
+		return nil, err
+	case cfg.LogitsFile != "" && augName != "" && augName != "None":
+		// Saved logits are of unmixed images.
+		err := fmt.Errorf("BuildDistiller failed: logits_file does not support batch augment %q", augName)
+		return nil, err
 	}
 
-	switch name {
-	case "AdamW":
-		cfg := nn.DefaultAdamWConfig()
-		for k, v := range params {
-			switch k {
-			case "beta1":
-				cfg.Beta1 = v.(float64)
-			case "beta2":
-				cfg.Beta2 = v.(float64)
-			case "wd":
-				cfg.Wd = v.(float64)
-			}
-		}
-		opt, err := cfg.Build(vs, lr)
+	var teachers []*Model
+	for _, tc := range cfg.Teachers {
+		m, err := b.buildTeacher(tc)
 		if err != nil {
-			err = fmt.Errorf("Build AdamW optimizer failed: %w", err)
+			err = fmt.Errorf("BuildDistiller failed: %w", err)
 			return nil, err
 		}
-		return opt, nil
+		teachers = append(teachers, m)
+	}
 
-	case "Adam":
-		cfg := nn.DefaultAdamConfig()
-		for k, v := range params {
-			switch k {
-			case "beta1":
-				cfg.Beta1 = v.(float64)
-			case "beta2":
-				cfg.Beta2 = v.(float64)
-			case "wd":
-				cfg.Wd = v.(float64)
-			}
-		}
-		opt, err := cfg.Build(vs, lr)
-		if err != nil {
-			err = fmt.Errorf("Build Adam optimizer failed: %w", err)
-			return nil, err
-		}
-		return opt, nil
+	temperature := cfg.Temperature
+	if temperature == 0 {
+		temperature = 4
+	}
+	alpha := cfg.Alpha
+	if alpha == 0 {
+		alpha = 0.5
+	}
+	distiller, err := NewDistiller(teachers, temperature, alpha)
+	if err != nil {
+		err = fmt.Errorf("BuildDistiller failed: %w", err)
+		return nil, err
+	}
 
-	case "SGD":
-		cfg := nn.DefaultSGDConfig()
-		for k, v := range params {
-			switch k {
-			case "dampening":
-				cfg.Dampening = v.(float64)
-			case "momentum":
-				cfg.Momentum = v.(float64)
-			case "wd":
-				cfg.Wd = v.(float64)
-			case "nesterov":
-				cfg.Nesterov = v.(bool)
-			}
-		}
-		opt, err := cfg.Build(vs, lr)
+	return distiller, nil
+}
+
+// buildTeacher builds a frozen teacher model of distillation.
+func (b *Builder) buildTeacher(tc TeacherConfig) (*Model, error) {
+	if tc.Checkpoint == "" {
+		err := fmt.Errorf("teacher checkpoint is required")
+		return nil, err
+	}
+
+	c := *b.Config
+	if tc.Config != "" {
+		teacherConfig, err := NewConfig(tc.Config)
 		if err != nil {
-			err = fmt.Errorf("Build SGD optimizer failed: %w", err)
+			err = fmt.Errorf("load teacher config failed: %w", err)
 			return nil, err
 		}
-		return opt, nil
+		c.Model = teacherConfig.Model
+	}
+	if tc.Backbone != "" {
+		c.Model.Params.Backbone = tc.Backbone
+	}
+	params := c.Model.Params
+	if params.NumClasses != b.Config.Model.Params.NumClasses || len(params.Tasks) > 0 || params.Decoder != "" {
+		err := fmt.Errorf("teacher %q must be a single-task classification model of %d classes", params.Backbone, b.Config.Model.Params.NumClasses)
+		return nil, err
+	}
+	c.Model.Params.Pretrained = false
+	c.Model.Params.FreezeStages = nil
+	c.Train.LoadPrevious = tc.Checkpoint
 
-	default:
-		err = fmt.Errorf("Unsupported optimizer config %q\n", name)
+	return NewBuilder(&c).BuildModel()
+}
+
+// BuildOptimizer builds optimizer.
+//
+// Supported optimizers: "Adam", "AdamW", "SGD", "RMSprop", "Adagrad", "LAMB" and wrappers
+// "Lookahead" and "SAM" which wrap a base optimizer specified by "base" param.
+//
+// NOTE. It returns *Optimizer (was *nn.Optimizer up to v0.2.0) which embeds *nn.Optimizer, so
+// method calls are unchanged. Use its Optimizer field where *nn.Optimizer is required.
+func (b *Builder) BuildOptimizer(vs *nn.VarStore) (*Optimizer, error) {
+	modelParams := b.Config.Model.Params
+	params := b.Config.Optimizer.Params
+	name := b.Config.Optimizer.Name
+
+	fmt.Printf("modelParams: %+v\n", modelParams)
+	fmt.Printf("optimizer params: %+v\n", params)
+	fmt.Printf("optimizer name: %+v\n", name)
+
+	p := newParamReader(params)
+	lr := p.float("lr", 0)
+	if p.err != nil {
+		err := fmt.Errorf("BuildOptimizer failed: %w", p.err)
 		return nil, err
 	}
+
+	return buildOptimizer(vs, name, lr, params)
 }
 
 // BuildScheduler builds optimizer scheduler.
-func (b *Builder) BuildScheduler(opt *nn.Optimizer) (*Scheduler, error) {
-	// TODO.
+//
+// stepsPerEpoch is number of optimizer steps (batches) per epoch. It should be taken from
+// the train data loader (see StepsPerEpoch). Scheduler "None" (or empty name) builds a
+// scheduler that keeps learning rate unchanged.
+//
+// Common params:
+// - update: override when scheduler steps. One of "on_batch", "on_epoch", "on_valid".
+// - warmup_steps, warmup_epochs: warm up learning rate before handing over to the scheduler.
+// - warmup_mode: "linear" (default) or "cosine".
+// - warmup_start_factor: fraction of base LR to start warmup from. Default = 0.01.
+//
+// LambdaLR scales initial LR by epoch / `denominator` (default 30) and MultiplicativeLR
+// multiplies LR by `factor` (default 0.95) every epoch. Params of invalid type return an error.
+func (b *Builder) BuildScheduler(optimizer *Optimizer, stepsPerEpoch int) (*Scheduler, error) {
 	name := b.Config.Scheduler.Name
 	params := b.Config.Scheduler.Params
+	p := newParamReader(params)
+	opt := optimizer.Optimizer
+	if stepsPerEpoch <= 0 {
+		err := fmt.Errorf("BuildScheduler failed: expected steps per epoch > 0, got %d\n", stepsPerEpoch)
+		return nil, err
+	}
+
 	var s *nn.LRScheduler
 	var update string
 	switch name {
+	case "None", "":
+		s = NewNoopLR().Build()
+		update = "none"
 	case "OneCycleLR":
 		var opts []nn.OneCycleOption
 		var maxLR float64
-		for k, v := range params {
+		epochs := b.Config.Train.Params.Epochs
+		for k := range params {
 			switch k {
 			case "max_lr":
-				maxLR = v.(float64)
-			case "final_lr":
-				finalLR := v.(float64)
+				maxLR = p.float(k, 0)
+			case "final_lr", "final_div_factor":
+				finalLR := p.float(k, 0)
 				o := nn.WithOneCycleFinalDivFactor(finalLR)
 				opts = append(opts, o)
-
+			case "div_factor":
+				o := nn.WithOneCycleDivFactor(p.float(k, 0))
+				opts = append(opts, o)
+			case "anneal_strategy":
+				o := nn.WithOneCycleAnnealStrategy(p.string(k, ""))
+				opts = append(opts, o)
 			case "pct_start":
-				pctStart := v.(float64)
+				pctStart := p.float(k, 0)
 				o := nn.WithOneCyclePctStart(pctStart)
 				opts = append(opts, o)
 			case "epochs":
-				epochs := v.(int)
-				o := nn.WithOneCycleLastEpoch(epochs)
-				opts = append(opts, o)
+				epochs = p.int(k, 0)
 			}
 		}
-		stepPerEpoch := int(b.Config.Train.BatchSize)
-		o := nn.WithOneCycleStepsPerEpoch(stepPerEpoch)
-		opts = append(opts, o)
+		opts = append(opts, nn.WithOneCycleEpochs(epochs))
+		opts = append(opts, nn.WithOneCycleStepsPerEpoch(stepsPerEpoch))
 		s = nn.NewOneCycleLR(opt, maxLR, opts...).Build()
 		update = "on_batch"
 	case "CosineAnnealingWarmRestarts":
 		t0 := 10
 		tMult := 1
 		etaMin := 0.001
-		for k, v := range params {
+		for k := range params {
 			switch k {
 			case "t0":
-				t0 = v.(int)
+				t0 = p.int(k, 0)
 			case "t_mult":
-				tMult = v.(int)
+				tMult = p.int(k, 0)
 			case "eta_min":
-				etaMin = v.(float64)
+				etaMin = p.float(k, 0)
 			}
 		}
 		s = nn.NewCosineAnnealingWarmRestarts(opt, t0, nn.WithTMult(tMult), nn.WithEtaMin(etaMin)).Build()
 		update = "on_batch"
-	case "StepLR": // reduce LR by 0.1 every 10 epochs
+	case "StepLR": // default: reduce LR by 0.1 every 10 epochs
 		stepSize := 10
 		gamma := 0.1
-		for k, v := range params {
+		for k := range params {
 			switch k {
 			case "step_size":
-				stepSize = v.(int)
+				stepSize = p.int(k, 0)
+			case "gamma":
+				gamma = p.float(k, 0)
 			}
 		}
 		s = nn.NewStepLR(opt, stepSize, gamma).Build()
 		update = "on_epoch"
-	case "LambdaLR":
-		denominator := 30
-		for k, v := range params {
-			switch k {
-			case "denominator":
-				denominator = v.(int)
-			}
+	case "LambdaLR": // LR factor epoch / denominator
+		denominator := p.int("denominator", 30)
+		if denominator <= 0 {
+			err := fmt.Errorf("BuildScheduler failed: expected denominator > 0, got %d\n", denominator)
+			return nil, err
 		}
 		ld1 := func(epoch interface{}) float64 {
-			return float64(epoch.(int) / denominator)
+			return float64(epoch.(int)) / float64(denominator)
 		}
 		s = nn.NewLambdaLR(opt, []nn.LambdaFn{ld1}).Build()
 		update = "on_epoch"
-	case "MultiplicativeLR":
+	case "MultiplicativeLR": // multiply LR by factor every epoch
+		factor := p.float("factor", 0.95)
+		if factor <= 0 {
+			err := fmt.Errorf("BuildScheduler failed: expected factor > 0, got %v\n", factor)
+			return nil, err
+		}
 		ld1 := func(epoch interface{}) float64 {
-			e := float64(epoch.(int))
-			return math.Pow(2, e) // 2 ** epoch
+			return factor
 		}
 		s = nn.NewMultiplicativeLR(opt, []nn.LambdaFn{ld1}).Build()
 		update = "on_epoch"
 	case "ExponentialLR":
 		gamma := 0.1
-		for k, v := range params {
+		for k := range params {
 			switch k {
 			case "gamma":
-				gamma = v.(float64)
+				gamma = p.float(k, 0)
 			}
 		}
 		s = nn.NewExponentialLR(opt, gamma).Build()
@@ -324,12 +881,12 @@ func (b *Builder) BuildScheduler(opt *nn.Optimizer) (*Scheduler, error) {
 	case "CosineAnnealingLR":
 		tmax := 10
 		etaMin := 0.0
-		for k, v := range params {
+		for k := range params {
 			switch k {
 			case "tmax":
-				tmax = v.(int)
+				tmax = p.int(k, 0)
 			case "eta_min":
-				etaMin = v.(float64)
+				etaMin = p.float(k, 0)
 			}
 		}
 		s = nn.NewCosineAnnealingLR(opt, tmax, etaMin).Build()
@@ -337,41 +894,297 @@ func (b *Builder) BuildScheduler(opt *nn.Optimizer) (*Scheduler, error) {
 	case "CyclicLR":
 		baseLRs := []float64{0.001}
 		maxLRs := []float64{0.1}
+		opts := []nn.CyclicOption{
+			nn.WithCyclicStepSizeUp(stepsPerEpoch * 2), // cycle of 4 epochs
+			nn.WithCyclicMode("triangular"),
+		}
 
-		for k, v := range params {
+		for k := range params {
 			switch k {
 			case "base_lr":
-				baseLRs = sliceInterface2Float64(v.([]interface{}))
+				baseLRs = p.floats(k, nil)
 			case "max_lr":
-				maxLRs = sliceInterface2Float64(v.([]interface{}))
+				maxLRs = p.floats(k, nil)
+			case "step_size_up":
+				opts = append(opts, nn.WithCyclicStepSizeUp(p.int(k, 0)))
+			case "step_size_down":
+				opts = append(opts, nn.WithCyclicStepSizeDown(p.int(k, 0)))
+			case "mode":
+				opts = append(opts, nn.WithCyclicMode(p.string(k, "")))
+			case "gamma":
+				opts = append(opts, nn.WithCyclicGamma(p.float(k, 0)))
+			case "cycle_momentum":
+				opts = append(opts, nn.WithCyclicCycleMomentum(p.bool(k, false)))
 			}
 		}
-		s = nn.NewCyclicLR(opt, baseLRs, maxLRs, nn.WithCyclicStepSizeUp(5), nn.WithCyclicMode("triangular")).Build()
-		update = "on_epoch"
+		s = nn.NewCyclicLR(opt, baseLRs, maxLRs, opts...).Build()
+		update = "on_batch"
 	case "ReduceLROnPlateau":
-		s = nn.NewReduceLROnPlateau(opt).Build()
+		var opts []nn.ReduceLROnPlateauOption
+		for k := range params {
+			switch k {
+			case "mode":
+				opts = append(opts, nn.WithReduceOnPlateauMode(p.string(k, "")))
+			case "factor":
+				opts = append(opts, nn.WithReduceOnPlateauFactor(p.float(k, 0)))
+			case "patience":
+				opts = append(opts, nn.WithReduceOnPlateauPatience(p.int(k, 0)))
+			case "threshold":
+				opts = append(opts, nn.WithReduceOnPlateauThreshold(p.float(k, 0)))
+			case "threshold_mode":
+				opts = append(opts, nn.WithReduceOnPlateauThresholdMode(p.string(k, "")))
+			case "cooldown":
+				opts = append(opts, nn.WithReduceOnPlateauCooldown(p.int(k, 0)))
+			case "min_lr":
+				opts = append(opts, nn.WithReduceOnPlateauMinLRs([]float64{p.float(k, 0)}))
+			case "eps":
+				opts = append(opts, nn.WithReduceOnPlateauEps(p.float(k, 0)))
+			}
+		}
+		s = nn.NewReduceLROnPlateau(opt, opts...).Build()
 		update = "on_valid"
 	default:
 		err := fmt.Errorf("BuildScheduler failed: Unsupported LR scheduler: %q\n", name)
 		return nil, err
 	}
+	if p.err != nil {
+		err := fmt.Errorf("BuildScheduler failed: %w\n", p.err)
+		return nil, err
+	}
+
+	// Override update event if specified
+	if _, ok := params["update"]; ok {
+		update = p.string("update", "")
+		switch {
+		case p.err != nil:
+			err := fmt.Errorf("BuildScheduler failed: %w\n", p.err)
+			return nil, err
+		case update != "on_batch" && update != "on_epoch" && update != "on_valid":
+			err := fmt.Errorf("BuildScheduler failed: invalid update %q\n", update)
+			return nil, err
+		}
+	}
+
+	// Compose warmup if specified
+	var (
+		warmupSteps       int
+		warmupMode        string  = "linear"
+		warmupStartFactor float64 = 0.01
+	)
+	for k := range params {
+		switch k {
+		case "warmup_steps":
+			warmupSteps = p.int(k, 0)
+		case "warmup_epochs":
+			warmupSteps = p.int(k, 0)
+			if update == "on_batch" {
+				warmupSteps *= stepsPerEpoch
+			}
+		case "warmup_mode":
+			warmupMode = p.string(k, "")
+		case "warmup_start_factor":
+			warmupStartFactor = p.float(k, 0)
+		}
+	}
+	if p.err != nil {
+		err := fmt.Errorf("BuildScheduler failed: %w\n", p.err)
+		return nil, err
+	}
+	if warmupSteps > 0 {
+		if update == "none" {
+			update = "on_batch"
+		}
+		w, err := NewWarmupLR(opt, s, warmupSteps, warmupMode, warmupStartFactor)
+		if err != nil {
+			err = fmt.Errorf("BuildScheduler failed: %w\n", err)
+			return nil, err
+		}
+		s = w.Build()
+	}
 
 	scheduler := NewScheduler(s, name, update)
+	scheduler.WarmupSteps = warmupSteps
 
 	return scheduler, nil
 }
 
-func (b *Builder) BuildTransformer(mode string) (aug.Transformer, error) {
+// BuildTrackers builds experiment tracking backends of a new run.
+func (b *Builder) BuildTrackers() ([]Tracker, error) {
+	cfg := b.Config.Tracking
+	info := RunInfo{
+		ID:   NewRunID(),
+		Name: cfg.RunName,
+		Tags: cfg.Tags,
+	}
+
+	trackers := make([]Tracker, 0, len(cfg.Backends))
+	for _, backend := range cfg.Backends {
+		params := backend.Params
+		getString := func(key, defaultValue string) string {
+			if v, ok := params[key]; ok {
+				return v.(string)
+			}
+			return defaultValue
+		}
+
+		var (
+			tracker Tracker
+			err     error
+		)
+		switch backend.Name {
+		case "local", "Local":
+			tracker, err = NewLocalTracker(getString("dir", "runs"), info)
+		case "tensorboard", "TensorBoard":
+			tracker, err = NewTensorBoardTracker(getString("log_dir", "runs"), info)
+		case "mlflow", "MLflow":
+			tracker, err = NewMLflowTracker(getString("uri", "http://localhost:5000"), getString("experiment", "Default"), info)
+		default:
+			err = fmt.Errorf("Unsupported tracking backend: %q", backend.Name)
+		}
+		if err != nil {
+			for _, t := range trackers {
+				t.Close()
+			}
+			err = fmt.Errorf("BuildTrackers failed: %w\n", err)
+			return nil, err
+		}
+		trackers = append(trackers, tracker)
+	}
+
+	return trackers, nil
+}
+
+// BuildNotifier builds a Notifier from notification config and `slack_url`.
+// It returns nil if no sink is configured.
+func (b *Builder) BuildNotifier() (*Notifier, error) {
+	cfg := b.Config.Notification
+
+	var sinks []Sink
+	if b.Config.SlackURL != "" {
+		sink, err := NewWebhookSink(b.Config.SlackURL, "slack")
+		if err != nil {
+			err = fmt.Errorf("BuildNotifier failed: %w\n", err)
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	for _, sc := range cfg.Sinks {
+		params := sc.Params
+		getString := func(key string) string {
+			if v, ok := params[key]; ok {
+				return v.(string)
+			}
+			return ""
+		}
+
+		var (
+			sink Sink
+			err  error
+		)
+		switch sc.Name {
+		case "slack", "discord", "teams":
+			sink, err = NewWebhookSink(getString("url"), sc.Name)
+		case "webhook":
+			var ws *WebhookSink
+			ws, err = NewWebhookSink(getString("url"), "json")
+			if err == nil {
+				if headers, ok := params["headers"]; ok {
+					for k, v := range headers.(map[string]interface{}) {
+						ws.Headers[k] = fmt.Sprint(v)
+					}
+				}
+				sink = ws
+			}
+		case "email":
+			var to []string
+			if v, ok := params["to"]; ok {
+				for _, addr := range v.([]interface{}) {
+					to = append(to, addr.(string))
+				}
+			}
+			var ss *SMTPSink
+			ss, err = NewSMTPSink(getString("addr"), getString("from"), to)
+			if err == nil {
+				ss.Username = getString("username")
+				ss.Password = getString("password")
+				if subject := getString("subject"); subject != "" {
+					ss.Subject = subject
+				}
+				sink = ss
+			}
+		default:
+			err = fmt.Errorf("Unsupported notification sink: %q", sc.Name)
+		}
+		if err != nil {
+			err = fmt.Errorf("BuildNotifier failed: %w\n", err)
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+
+	var opts []NotifierOption
+	if len(cfg.Events) > 0 {
+		opts = append(opts, WithNotifierEvents(cfg.Events...))
+	}
+	if cfg.FlushInterval > 0 {
+		opts = append(opts, WithNotifierFlushInterval(time.Duration(cfg.FlushInterval*float64(time.Second))))
+	}
+	if cfg.MinInterval > 0 {
+		opts = append(opts, WithNotifierMinInterval(time.Duration(cfg.MinInterval*float64(time.Second))))
+	}
+	if cfg.MaxRetries != nil {
+		opts = append(opts, WithNotifierRetries(*cfg.MaxRetries, time.Second))
+	}
+
+	return NewNotifier(sinks, opts...), nil
+}
+
+// BuildPairTransformer builds a transformer of image-mask pairs for segmentation.
+func (b *Builder) BuildPairTransformer(mode string) (*PairAugment, error) {
 	switch mode {
 	case "train":
-		config := b.Config.Transform.Train
-		return MakeTransformer(config)
+		return MakePairTransformer(b.Config.Transform.Train)
+	case "valid":
+		return MakePairTransformer(b.Config.Transform.Valid)
+	default:
+		err := fmt.Errorf("Unsupported mode: %q\n", mode)
+		return nil, err
+	}
+}
 
+// BuildTransformer builds a transformer from transform config. If `seeded` or `record_augment`
+// is set, it builds a SeededTransformer so that augment of dataset items can be reproduced and
+// recorded.
+func (b *Builder) BuildTransformer(mode string) (aug.Transformer, error) {
+	var config TransformConfig
+	switch mode {
+	case "train":
+		config = b.Config.Transform.Train
 	case "valid":
-		config := b.Config.Transform.Train
-		return MakeTransformer(config)
+		config = b.Config.Transform.Valid
 	default:
 		err := fmt.Errorf("BuildTrainformer failed. Invalid mode. Mode should be either 'train' or 'valid'. Got %q\n", mode)
 		return nil, err
 	}
+
+	if !config.Seeded && !config.RecordAugment {
+		t, err := MakeTransformer(config)
+		if err != nil {
+			err = fmt.Errorf("BuildTransformer failed: %w\n", err)
+			return nil, err
+		}
+		return t, nil
+	}
+
+	t, err := MakeSeededTransformer(config)
+	if err != nil {
+		err = fmt.Errorf("BuildTransformer failed: %w\n", err)
+		return nil, err
+	}
+	return t, nil
 }
@@ -1,6 +1,8 @@
 package lab
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 
@@ -28,7 +30,18 @@ type TransformConfig struct{
 	IsTransformer bool `yaml:"is_transformer"`
 	TransformerName string `yaml:"transformer_name"`
 	Transformer aug.Transformer `yaml:"transformer"`
+	TransformerParams map[string]interface{} `yaml:"transformer_params"` // params of transformer_name
 	AugmentOpts []AugmentOpt `yaml:"augment_opts"` // Augment options to compose a transformer
+	BatchAugment BatchAugmentConfig `yaml:"batch_augment"` // batch-level augment after collation. Train only.
+	RecordAugment bool `yaml:"record_augment"` // record applied ops and seed of each item (ImageDataset.AugmentRecord). Implies seeded.
+	Seeded bool `yaml:"seeded"` // draw augment of each item from its seed (SeededAugment) so that it can be reproduced
+}
+
+// BatchAugmentConfig specifies batch-level augment.
+// Name: "MixUp", "CutMix" or "MixUpCutMix"
+type BatchAugmentConfig struct{
+	Name string `yaml:"name"`
+	Params map[string]interface{} `yaml:"params"`
 }
 
 
@@ -40,17 +53,37 @@ type ModelConfig struct {
 		Backbone           string  `yaml:"backbone"`
 		Pretrained         bool    `yaml:"pretrained"`
 		PretrainedPath     string  `yaml:"pretrained_path"`
+		PretrainedMirror   string  `yaml:"pretrained_mirror"`    // base URL or directory of pretrained weights. Default lab bucket or LAB_PRETRAINED_MIRROR.
+		PretrainedChecksums string `yaml:"pretrained_checksums"` // sha256sum file of pretrained weights to verify downloads.
+		PretrainedVerify   bool    `yaml:"pretrained_verify"`    // reject downloads of pretrained weights without checksum.
 		NumClasses         int64   `yaml:"num_classes"`
 		Dropout            float64 `yaml:"dropout"`
 		MultisampleDropout bool    `yaml:"multisample_dropout"`
+		MultisampleCount   int          `yaml:"multisample_count"` // number of dropout masks averaged if multisample_dropout. Default 5.
+		Pooling            string       `yaml:"pooling"`           // global pooling of classification head: avg (default), max, gem, avgmax.
+		HiddenLayers       []int64      `yaml:"hidden_layers"`     // sizes of hidden layers of classification head. Default none.
+		Tasks              []TaskConfig `yaml:"tasks"`             // named outputs of multi-task classification head. Labels are read from ImageCSV columns of task names.
+		FreezeStages       []string `yaml:"freeze_stages"` // backbone stages to freeze, e.g. ["layer1", "layer2"]
+		Decoder            string   `yaml:"decoder"`          // segmentation decoder on backbone: UNet, UNetPlusPlus, FPN, LinkNet, DeepLabV3Plus. Empty for classification.
+		DecoderChannels    []int64  `yaml:"decoder_channels"` // channels of segmentation decoder blocks. Default by decoder.
+		Activation         string   `yaml:"activation"`       // output activation of segmentation model: none (default), sigmoid, softmax.
 	} `yaml:"params"`
 }
 
+// TaskConfig is a named output of a multi-task classification head. Its labels are class indices
+// in range [0, num_classes) of the dataset csv column of the same name.
+type TaskConfig struct {
+	Name       string `yaml:"name"`
+	NumClasses int64  `yaml:"num_classes"`
+}
+
 // Train Config:
 // ============
 type TrainConfig struct {
 	BatchSize    int64  `yaml:"batch_size"`
-	LoadPrevious string `yaml:"load_previous"` // filepath to load pretrained weights
+	NumWorkers   int    `yaml:"num_workers"` // number of data loading goroutines. 0: load in training loop.
+	Prefetch     int    `yaml:"prefetch"`    // number of batches loaded ahead if num_workers > 0. Default 2.
+	LoadPrevious string `yaml:"load_previous"` // checkpoint file (.bin or .safetensors) to load all model weights from for continuing training
 	StartEpoch int `yaml:"start_epoch"` // start from epoch for continueing traing
 	TrainCount int `yaml:"train_count"` // for naming file when continuing training
 	Params       struct {
@@ -61,9 +94,44 @@ type TrainConfig struct {
 		Verbosity        int     `yaml:"verbosity"`
 		Amp              bool    `yaml:"amp"`
 		CUDA 						 bool		 `yaml:"cuda"`
+		UnfreezeSchedule []UnfreezeConfig `yaml:"unfreeze_schedule"` // stages to unfreeze at given epochs
+		Sampler          SamplerConfig    `yaml:"sampler"` // train data sampler. Default random shuffle.
+		Prune            PruneConfig      `yaml:"prune"`   // prune model weights before training and keep them pruned. Default none.
+		Distill          DistillConfig    `yaml:"distill"` // distill soft targets of teacher models. Default none.
 	} `yaml:"params"`
 }
 
+// SamplerConfig specifies train data sampler.
+// Name: "Random", "WeightedRandom", "ClassBalanced" or "Stratified"
+type SamplerConfig struct {
+	Name   string                 `yaml:"name"`
+	Params map[string]interface{} `yaml:"params"`
+}
+
+// PruneConfig specifies pruning of model weights for fine-tuning.
+// Method: "magnitude" (default) or "channel"
+type PruneConfig struct {
+	Method   string   `yaml:"method"`
+	Sparsity float64  `yaml:"sparsity"` // fraction of weights (magnitude) or output channels of each convolution (channel) to zero. 0: no pruning.
+	Exclude  []string `yaml:"exclude"`  // stages (see StagePrefixes) not pruned, e.g. ["stem", "head"]
+}
+
+// DistillConfig specifies knowledge distillation from frozen teachers or saved teacher logits.
+// Loss is alpha * T^2 * KL(teacher || student) at temperature T plus (1 - alpha) * criterion.
+type DistillConfig struct {
+	Teachers    []TeacherConfig `yaml:"teachers"`    // logits of several teachers are averaged
+	LogitsFile  string          `yaml:"logits_file"` // csv of saved teacher logits (see SaveTeacherLogits) instead of teachers
+	Temperature float64         `yaml:"temperature"` // softmax temperature of soft targets. Default 4.
+	Alpha       float64         `yaml:"alpha"`       // weight of distillation loss in (0, 1]. Default 0.5.
+}
+
+// TeacherConfig specifies a teacher model of distillation.
+type TeacherConfig struct {
+	Config     string `yaml:"config"`     // training config file of teacher to build its model from. Default student model config.
+	Backbone   string `yaml:"backbone"`   // overrides backbone of model config
+	Checkpoint string `yaml:"checkpoint"` // teacher weights (.bin or .safetensors)
+}
+
 // FindLR Config:
 // ==============
 type FindLRConfig struct{
@@ -79,6 +147,8 @@ type FindLRConfig struct{
 // ==================
 type EvaluationConfig struct{
 		BatchSize int64  `yaml:"batch_size"`
+		NumWorkers int   `yaml:"num_workers"` // number of data loading goroutines. 0: load in validation loop.
+		Prefetch   int   `yaml:"prefetch"`    // number of batches loaded ahead if num_workers > 0. Default 2.
 		Evaluator string `yaml:"evaluator"`
 		Params    struct {
 			SaveCheckpointDir string   `yaml:"save_checkpoint_dir"`
@@ -89,6 +159,7 @@ type EvaluationConfig struct{
 			Mode              string   `yaml:"mode"`
 			ImproveThresh     float64  `yaml:"improve_thresh"`
 			EarlyStopping int `yaml:"early_stopping"`
+			CheckpointExt string `yaml:"checkpoint_ext"` // ".bin" (default, gotch format) or ".safetensors"
 		} `yaml:"params"`
 }
 
@@ -125,6 +196,34 @@ type TestConfig struct{
 		SaveFile        string `yaml:"save_file"`
 }
 
+// Tracking Config:
+// ================
+type TrackerConfig struct{
+	Name string `yaml:"name"` // one of "local", "tensorboard", "mlflow"
+	Params map[string]interface{} `yaml:"params"`
+}
+
+type TrackingConfig struct{
+	RunName string `yaml:"run_name"`
+	Tags map[string]string `yaml:"tags"`
+	Backends []TrackerConfig `yaml:"backends"`
+}
+
+// Notification Config:
+// ====================
+type SinkConfig struct{
+	Name string `yaml:"name"` // one of "slack", "webhook", "discord", "teams", "email"
+	Params map[string]interface{} `yaml:"params"`
+}
+
+type NotificationConfig struct{
+	Events []string `yaml:"events"` // events to notify. Empty means all.
+	FlushInterval float64 `yaml:"flush_interval"` // seconds
+	MinInterval float64 `yaml:"min_interval"` // seconds between 2 sends
+	MaxRetries *int `yaml:"max_retries"`
+	Sinks []SinkConfig `yaml:"sinks"`
+}
+
 type Config struct {
 	Seed int64 `yaml:"seed"`
 	SlackURL string `yaml:"slack_url"`
@@ -141,6 +240,8 @@ type Config struct {
 	Optimizer OptimizerConfig `yaml:"optimizer"`
 	Scheduler LRSchedulerConfig `yaml:"scheduler"`
 	Test TestConfig `yaml:"test"`
+	Tracking TrackingConfig `yaml:"tracking"`
+	Notification NotificationConfig `yaml:"notification"`
 }
 
 // NewConfig returns a new Config struct
@@ -159,6 +260,16 @@ func NewConfig(filename string) (*Config, error) {
 	return c, nil
 }
 
+// Hash returns hex SHA-256 of config in YAML. It is saved in checkpoint metadata.
+func (cfg *Config) Hash() (string, error) {
+	buf, err := yaml.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:]), nil
+}
+
 func (cfg *Config) SetInferenceBatchSize() {
 	// TODO.
 }
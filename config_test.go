@@ -59,6 +59,38 @@ func TestDatasetConfig(t *testing.T){
 	}
 }
 
+func TestModelHeadConfig(t *testing.T){
+	yamlFile := []byte(`model:
+  params:
+    backbone: resnet34
+    pooling: gem
+    hidden_layers: [512, 256]
+    multisample_dropout: true
+    multisample_count: 8
+    tasks:
+      - name: label
+        num_classes: 7
+      - name: severity
+        num_classes: 3
+`)
+
+	var config Config
+	err := yaml.Unmarshal(yamlFile, &config)
+	if err != nil{
+		t.Errorf("Unmarshal data failed: %v\n", err)
+	}
+
+	params := config.Model.Params
+	if params.Pooling != "gem" || params.MultisampleCount != 8 || !reflect.DeepEqual(params.HiddenLayers, []int64{512, 256}){
+		t.Errorf("Got: %+v\n", params)
+	}
+	want := []TaskConfig{{"label", 7}, {"severity", 3}}
+	if !reflect.DeepEqual(want, params.Tasks){
+		t.Errorf("Want: %v\n", want)
+		t.Errorf("Got: %v\n", params.Tasks)
+	}
+}
+
 func TestTrainConfig(t *testing.T){
 
 }
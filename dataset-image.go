@@ -0,0 +1,769 @@
+package lab
+
+import (
+	"encoding/csv"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/ts"
+	"github.com/sugarme/gotch/vision"
+	"github.com/sugarme/gotch/vision/aug"
+)
+
+// DefaultImageExts are file extensions of supported images.
+var DefaultImageExts = []string{".jpg", ".jpeg", ".png"}
+
+// ImageSample is an image file and its class label.
+type ImageSample struct {
+	Path   string
+	Label  int
+	Labels []int // class of each task of a multi-task head, nil if single-task. Label is of the first task.
+	Fold   int   // -1 if not specified
+}
+
+// ImageDataset is an image classification dataset implementing dutil.Dataset interface.
+//
+// Item returns []ts.Tensor{image, label} where image is a float tensor of shape [C, H, W]
+// with values in range [0, 1] (or normalized if transformer does so) and label is an int64 scalar tensor,
+// or an int64 tensor of shape [ntasks] if samples have labels of tasks (see WithTaskColumns).
+// If teacher logits are set (see SetTeacherLogits), they are appended as a float tensor of shape [C].
+//
+// If transformer is a SeededTransformer, augment of an item is drawn from a seed (see ItemSeeded)
+// and the last augment of each item can be recorded for debugging (see AugmentRecord).
+type ImageDataset struct {
+	Samples []ImageSample
+	Classes []string
+
+	transformer   aug.Transformer
+	imageSize     []int64 // [height, width]
+	recordAugment bool
+	teacherLogits [][]float32 // teacher logits of samples for distillation. Nil if not set.
+
+	mu      sync.Mutex
+	records map[int]AugmentRecord // last augment of items
+}
+
+type ImageDatasetOptions struct {
+	Transformer   aug.Transformer
+	ImageSize     []int64 // [height, width] to resize images to before transforming. Nil to keep original size.
+	RecordAugment bool    // record augment of items. Transformer must be a SeededTransformer.
+}
+
+type ImageDatasetOption func(*ImageDatasetOptions)
+
+func defaultImageDatasetOptions() *ImageDatasetOptions {
+	return &ImageDatasetOptions{
+		Transformer:   nil,
+		ImageSize:     nil,
+		RecordAugment: false,
+	}
+}
+
+func WithImageTransformer(t aug.Transformer) ImageDatasetOption {
+	return func(o *ImageDatasetOptions) {
+		o.Transformer = t
+	}
+}
+
+func WithImageSize(height, width int64) ImageDatasetOption {
+	return func(o *ImageDatasetOptions) {
+		o.ImageSize = []int64{height, width}
+	}
+}
+
+func WithAugmentRecord(v bool) ImageDatasetOption {
+	return func(o *ImageDatasetOptions) {
+		o.RecordAugment = v
+	}
+}
+
+// NewImageDataset creates an ImageDataset from samples.
+func NewImageDataset(samples []ImageSample, classes []string, opts ...ImageDatasetOption) *ImageDataset {
+	options := defaultImageDatasetOptions()
+	for _, o := range opts {
+		o(options)
+	}
+
+	return &ImageDataset{
+		Samples:       samples,
+		Classes:       classes,
+		transformer:   options.Transformer,
+		imageSize:     options.ImageSize,
+		recordAugment: options.RecordAugment,
+	}
+}
+
+// Item implements dutil.Dataset interface. Augment is drawn from a random seed.
+func (d *ImageDataset) Item(idx int) (interface{}, error) {
+	return d.ItemSeeded(idx, rand.Int63())
+}
+
+// ItemSeeded implements SeededDataset interface. Loading an item with the same seed reproduces
+// its augment if transformer is a SeededTransformer.
+func (d *ImageDataset) ItemSeeded(idx int, seed int64) (interface{}, error) {
+	if idx < 0 || idx >= len(d.Samples) {
+		err := fmt.Errorf("ImageDataset.Item - index %d out of range [0, %d)", idx, len(d.Samples))
+		return nil, err
+	}
+	s := d.Samples[idx]
+
+	img, err := d.loadImage(s.Path, idx, seed)
+	if err != nil {
+		err = fmt.Errorf("ImageDataset.Item - Load image failed: %w", err)
+		return nil, err
+	}
+
+	label := ts.MustOfSlice([]int64{int64(s.Label)}).MustSqueeze(true)
+	if len(s.Labels) > 0 {
+		label.MustDrop()
+		labels := make([]int64, len(s.Labels))
+		for i, l := range s.Labels {
+			labels[i] = int64(l)
+		}
+		label = ts.MustOfSlice(labels)
+	}
+	if d.teacherLogits != nil {
+		logits := ts.MustOfSlice(d.teacherLogits[idx])
+		return []ts.Tensor{*img, *label, *logits}, nil
+	}
+
+	return []ts.Tensor{*img, *label}, nil
+}
+
+func (d *ImageDataset) loadImage(path string, idx int, seed int64) (*ts.Tensor, error) {
+	pix, h, w, err := DecodeImageFile(path)
+	if err != nil {
+		return nil, err
+	}
+	img := ts.MustOfSlice(pix).MustView([]int64{3, int64(h), int64(w)}, true)
+
+	if d.imageSize != nil && (int64(h) != d.imageSize[0] || int64(w) != d.imageSize[1]) {
+		resized, err := vision.Resize(img, d.imageSize[1], d.imageSize[0])
+		img.MustDrop()
+		if err != nil {
+			return nil, err
+		}
+		img = resized
+	}
+
+	if d.transformer != nil {
+		var out *ts.Tensor
+		if t, ok := d.transformer.(SeededTransformer); ok {
+			var ops []AppliedOp
+			out, ops = t.TransformSeeded(img, seed)
+			d.record(AugmentRecord{Index: idx, Seed: seed, Ops: ops})
+		} else {
+			out = d.transformer.Transform(img)
+		}
+		img.MustDrop()
+		img = out
+	}
+
+	if img.DType() == gotch.Uint8 {
+		img = img.MustTotype(gotch.Float, true).MustDivScalar(ts.FloatScalar(255.0), true)
+	}
+
+	return img, nil
+}
+
+// Len implements dutil.Dataset interface.
+func (d *ImageDataset) Len() int {
+	return len(d.Samples)
+}
+
+// DType implements dutil.Dataset interface.
+func (d *ImageDataset) DType() reflect.Type {
+	return reflect.TypeOf(d.Samples)
+}
+
+// ClassCounts returns number of samples of each class.
+func (d *ImageDataset) ClassCounts() map[string]int {
+	counts := make(map[string]int, len(d.Classes))
+	for _, s := range d.Samples {
+		name := strconv.Itoa(s.Label)
+		if s.Label < len(d.Classes) {
+			name = d.Classes[s.Label]
+		}
+		counts[name] += 1
+	}
+	return counts
+}
+
+// Labels implements Labeled interface.
+func (d *ImageDataset) Labels() []int {
+	labels := make([]int, len(d.Samples))
+	for i, s := range d.Samples {
+		labels[i] = s.Label
+	}
+	return labels
+}
+
+// subset creates a new dataset of samples at indexes sharing classes and options.
+func (d *ImageDataset) subset(indexes []int) *ImageDataset {
+	samples := make([]ImageSample, len(indexes))
+	var teacherLogits [][]float32
+	for i, idx := range indexes {
+		samples[i] = d.Samples[idx]
+		if d.teacherLogits != nil {
+			teacherLogits = append(teacherLogits, d.teacherLogits[idx])
+		}
+	}
+	return &ImageDataset{
+		Samples:       samples,
+		Classes:       d.Classes,
+		transformer:   d.transformer,
+		imageSize:     d.imageSize,
+		recordAugment: d.recordAugment,
+		teacherLogits: teacherLogits,
+	}
+}
+
+// SetTransformer sets transformer of dataset. i.e. to use different transformer for a valid split.
+func (d *ImageDataset) SetTransformer(t aug.Transformer) {
+	d.transformer = t
+}
+
+// SetTeacherLogits sets teacher logits of samples keyed by image path or file name (see
+// LoadTeacherLogits) to be returned with items for distillation. Every sample must have logits
+// of the same length. Nil unsets them.
+func (d *ImageDataset) SetTeacherLogits(logits map[string][]float32) error {
+	if logits == nil {
+		d.teacherLogits = nil
+		return nil
+	}
+
+	teacherLogits := make([][]float32, len(d.Samples))
+	for i, s := range d.Samples {
+		v, ok := logits[s.Path]
+		if !ok {
+			v, ok = logits[filepath.Base(s.Path)]
+		}
+		if !ok {
+			err := fmt.Errorf("SetTeacherLogits failed: no teacher logits of image %q", s.Path)
+			return err
+		}
+		if i > 0 && len(v) != len(teacherLogits[0]) {
+			err := fmt.Errorf("SetTeacherLogits failed: expected %d logits of image %q, got %d", len(teacherLogits[0]), s.Path, len(v))
+			return err
+		}
+		teacherLogits[i] = v
+	}
+	d.teacherLogits = teacherLogits
+	return nil
+}
+
+// SetAugmentRecord turns recording of item augment on or off. Turning it off clears records.
+func (d *ImageDataset) SetAugmentRecord(v bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.recordAugment = v
+	if !v {
+		d.records = nil
+	}
+}
+
+func (d *ImageDataset) record(r AugmentRecord) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.recordAugment {
+		return
+	}
+	if d.records == nil {
+		d.records = make(map[int]AugmentRecord)
+	}
+	d.records[r.Index] = r
+}
+
+// AugmentRecord returns the last recorded augment of item idx. Item can be reloaded with
+// the same augment by ItemSeeded(idx, record.Seed).
+func (d *ImageDataset) AugmentRecord(idx int) (AugmentRecord, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r, ok := d.records[idx]
+	return r, ok
+}
+
+// AugmentRecords returns last recorded augment of all items sorted by item index.
+func (d *ImageDataset) AugmentRecords() []AugmentRecord {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	records := make([]AugmentRecord, 0, len(d.records))
+	for _, r := range d.records {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Index < records[j].Index })
+	return records
+}
+
+// Split randomly splits dataset into train and valid datasets. Split is stratified by class
+// so that each class has `validRatio` of its samples in valid dataset.
+func (d *ImageDataset) Split(validRatio float64, seed int64) (*ImageDataset, *ImageDataset, error) {
+	if validRatio <= 0 || validRatio >= 1 {
+		err := fmt.Errorf("ImageDataset.Split failed: expected valid ratio in range (0, 1), got %v", validRatio)
+		return nil, nil, err
+	}
+
+	byClass := make(map[int][]int)
+	for i, s := range d.Samples {
+		byClass[s.Label] = append(byClass[s.Label], i)
+	}
+	labels := make([]int, 0, len(byClass))
+	for l := range byClass {
+		labels = append(labels, l)
+	}
+	sort.Ints(labels)
+
+	r := rand.New(rand.NewSource(seed))
+	var trainIdxs, validIdxs []int
+	for _, l := range labels {
+		idxs := byClass[l]
+		r.Shuffle(len(idxs), func(i, j int) { idxs[i], idxs[j] = idxs[j], idxs[i] })
+		nvalid := int(float64(len(idxs))*validRatio + 0.5)
+		validIdxs = append(validIdxs, idxs[:nvalid]...)
+		trainIdxs = append(trainIdxs, idxs[nvalid:]...)
+	}
+	sort.Ints(trainIdxs)
+	sort.Ints(validIdxs)
+
+	return d.subset(trainIdxs), d.subset(validIdxs), nil
+}
+
+// SplitFold splits dataset by fold column. Samples of `fold` go to valid dataset.
+func (d *ImageDataset) SplitFold(fold int) (*ImageDataset, *ImageDataset, error) {
+	var trainIdxs, validIdxs []int
+	for i, s := range d.Samples {
+		if s.Fold < 0 {
+			err := fmt.Errorf("ImageDataset.SplitFold failed: sample %q has no fold", s.Path)
+			return nil, nil, err
+		}
+		if s.Fold == fold {
+			validIdxs = append(validIdxs, i)
+		} else {
+			trainIdxs = append(trainIdxs, i)
+		}
+	}
+	if len(validIdxs) == 0 {
+		err := fmt.Errorf("ImageDataset.SplitFold failed: no samples of fold %d", fold)
+		return nil, nil, err
+	}
+
+	return d.subset(trainIdxs), d.subset(validIdxs), nil
+}
+
+// ImageCSVOptions specifies columns of a ground truth CSV file.
+type ImageCSVOptions struct {
+	ImageColumn string   // column of image names. Default to the first column.
+	LabelColumn string   // column of class labels. If empty, all columns but image and fold columns are one-hot class columns.
+	FoldColumn  string   // optional column of fold numbers.
+	Classes     []string // optional class order for a label column. Default to sorted unique labels.
+	TaskColumns []string // columns of class indices of tasks of a multi-task head. Label and one-hot columns are not used if set.
+	Exts        []string // image extensions to try if image names have no extension.
+}
+
+type ImageCSVOption func(*ImageCSVOptions)
+
+func defaultImageCSVOptions() *ImageCSVOptions {
+	return &ImageCSVOptions{
+		ImageColumn: "",
+		LabelColumn: "",
+		FoldColumn:  "",
+		Classes:     nil,
+		TaskColumns: nil,
+		Exts:        DefaultImageExts,
+	}
+}
+
+func WithImageColumn(name string) ImageCSVOption {
+	return func(o *ImageCSVOptions) {
+		o.ImageColumn = name
+	}
+}
+
+func WithLabelColumn(name string) ImageCSVOption {
+	return func(o *ImageCSVOptions) {
+		o.LabelColumn = name
+	}
+}
+
+func WithFoldColumn(name string) ImageCSVOption {
+	return func(o *ImageCSVOptions) {
+		o.FoldColumn = name
+	}
+}
+
+func WithClasses(classes []string) ImageCSVOption {
+	return func(o *ImageCSVOptions) {
+		o.Classes = classes
+	}
+}
+
+func WithTaskColumns(columns []string) ImageCSVOption {
+	return func(o *ImageCSVOptions) {
+		o.TaskColumns = columns
+	}
+}
+
+func WithImageExts(exts []string) ImageCSVOption {
+	return func(o *ImageCSVOptions) {
+		o.Exts = exts
+	}
+}
+
+// LoadImageCSV loads image samples from a ground truth CSV file. Image files are searched
+// (recursively) in data directories. It returns samples and class names.
+//
+// Two label formats are supported:
+// - one-hot columns (i.e. ISIC `GroundTruth.csv`: image,MEL,NV,BCC,...). Class is the column of max value.
+// - a single label column specified by `LabelColumn`.
+//
+// If `TaskColumns` are specified, each column holds class indices of a task of a multi-task head
+// (ImageSample.Labels) and no class names are returned.
+func LoadImageCSV(csvFile string, dataDirs []string, opts ...ImageCSVOption) ([]ImageSample, []string, error) {
+	options := defaultImageCSVOptions()
+	for _, o := range opts {
+		o(options)
+	}
+
+	f, err := os.Open(csvFile)
+	if err != nil {
+		err = fmt.Errorf("LoadImageCSV - Open file failed: %w", err)
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		err = fmt.Errorf("LoadImageCSV - Read csv failed: %w", err)
+		return nil, nil, err
+	}
+	if len(records) < 2 {
+		err := fmt.Errorf("LoadImageCSV failed: no data rows in %q", csvFile)
+		return nil, nil, err
+	}
+	header := records[0]
+	rows := records[1:]
+
+	colIdx := func(name string) (int, error) {
+		for i, h := range header {
+			if strings.TrimSpace(h) == name {
+				return i, nil
+			}
+		}
+		return -1, fmt.Errorf("LoadImageCSV failed: column %q not found in %v", name, header)
+	}
+
+	imageCol := 0
+	if options.ImageColumn != "" {
+		if imageCol, err = colIdx(options.ImageColumn); err != nil {
+			return nil, nil, err
+		}
+	}
+	foldCol := -1
+	if options.FoldColumn != "" {
+		if foldCol, err = colIdx(options.FoldColumn); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Labels
+	labels := make([]int, len(rows))
+	var (
+		classes    []string
+		taskLabels [][]int
+	)
+	switch {
+	case len(options.TaskColumns) > 0:
+		taskLabels = make([][]int, len(rows))
+		for i := range taskLabels {
+			taskLabels[i] = make([]int, len(options.TaskColumns))
+		}
+		for j, name := range options.TaskColumns {
+			col, err := colIdx(name)
+			if err != nil {
+				return nil, nil, err
+			}
+			for i, row := range rows {
+				l, err := strconv.Atoi(strings.TrimSpace(row[col]))
+				if err != nil || l < 0 {
+					err := fmt.Errorf("LoadImageCSV failed: row %d, column %q: invalid class index %q", i+2, name, row[col])
+					return nil, nil, err
+				}
+				taskLabels[i][j] = l
+			}
+		}
+		for i := range rows {
+			labels[i] = taskLabels[i][0]
+		}
+
+	case options.LabelColumn != "":
+		labelCol, err := colIdx(options.LabelColumn)
+		if err != nil {
+			return nil, nil, err
+		}
+		values := make([]string, len(rows))
+		for i, row := range rows {
+			values[i] = strings.TrimSpace(row[labelCol])
+		}
+		classes = options.Classes
+		if classes == nil {
+			classes = uniqueLabels(values)
+		}
+		classIdx := make(map[string]int, len(classes))
+		for i, c := range classes {
+			classIdx[c] = i
+		}
+		for i, v := range values {
+			l, ok := classIdx[v]
+			if !ok {
+				err := fmt.Errorf("LoadImageCSV failed: row %d has unknown label %q", i+2, v)
+				return nil, nil, err
+			}
+			labels[i] = l
+		}
+
+	default:
+		var classCols []int
+		for i, h := range header {
+			if i == imageCol || i == foldCol {
+				continue
+			}
+			classCols = append(classCols, i)
+			classes = append(classes, strings.TrimSpace(h))
+		}
+		if len(classCols) == 0 {
+			err := fmt.Errorf("LoadImageCSV failed: no one-hot class columns in %v", header)
+			return nil, nil, err
+		}
+		for i, row := range rows {
+			best, bestVal := -1, 0.0
+			for j, col := range classCols {
+				v, err := strconv.ParseFloat(strings.TrimSpace(row[col]), 64)
+				if err != nil {
+					err = fmt.Errorf("LoadImageCSV failed: row %d, column %q: %w", i+2, header[col], err)
+					return nil, nil, err
+				}
+				if best < 0 || v > bestVal {
+					best, bestVal = j, v
+				}
+			}
+			labels[i] = best
+		}
+	}
+
+	index, err := indexImageFiles(dataDirs, options.Exts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	samples := make([]ImageSample, len(rows))
+	for i, row := range rows {
+		name := strings.TrimSpace(row[imageCol])
+		path, ok := index.resolve(name)
+		if !ok {
+			err := fmt.Errorf("LoadImageCSV failed: image %q not found in %v", name, dataDirs)
+			return nil, nil, err
+		}
+		fold := -1
+		if foldCol >= 0 {
+			fold, err = strconv.Atoi(strings.TrimSpace(row[foldCol]))
+			if err != nil {
+				err = fmt.Errorf("LoadImageCSV failed: row %d has invalid fold: %w", i+2, err)
+				return nil, nil, err
+			}
+		}
+		samples[i] = ImageSample{Path: path, Label: labels[i], Fold: fold}
+		if taskLabels != nil {
+			samples[i].Labels = taskLabels[i]
+		}
+	}
+
+	return samples, classes, nil
+}
+
+// LoadImageFolder loads image samples from a directory of class sub-directories
+// i.e. `root/<class>/<image>`. Classes are sorted by name.
+func LoadImageFolder(root string, exts ...string) ([]ImageSample, []string, error) {
+	if len(exts) == 0 {
+		exts = DefaultImageExts
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		err = fmt.Errorf("LoadImageFolder - Read directory failed: %w", err)
+		return nil, nil, err
+	}
+
+	var classes []string
+	for _, e := range entries {
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			classes = append(classes, e.Name())
+		}
+	}
+	sort.Strings(classes)
+	if len(classes) == 0 {
+		err := fmt.Errorf("LoadImageFolder failed: no class directories in %q", root)
+		return nil, nil, err
+	}
+
+	var samples []ImageSample
+	for label, class := range classes {
+		var files []string
+		err := filepath.WalkDir(filepath.Join(root, class), func(path string, de os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !de.IsDir() && hasImageExt(path, exts) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			err = fmt.Errorf("LoadImageFolder failed: %w", err)
+			return nil, nil, err
+		}
+		sort.Strings(files)
+		for _, f := range files {
+			samples = append(samples, ImageSample{Path: f, Label: label, Fold: -1})
+		}
+	}
+
+	return samples, classes, nil
+}
+
+// DecodeImageFile decodes a JPEG or PNG file to RGB pixels in CHW order.
+func DecodeImageFile(path string) ([]uint8, int, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer f.Close()
+
+	return DecodeImage(f)
+}
+
+// DecodeImage decodes a JPEG or PNG image to RGB pixels in CHW order.
+func DecodeImage(r io.Reader) ([]uint8, int, int, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	b := img.Bounds()
+	h, w := b.Dy(), b.Dx()
+	size := h * w
+	pix := make([]uint8, 3*size)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			cr, cg, cb, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			i := y*w + x
+			pix[i] = uint8(cr >> 8)
+			pix[size+i] = uint8(cg >> 8)
+			pix[2*size+i] = uint8(cb >> 8)
+		}
+	}
+
+	return pix, h, w, nil
+}
+
+// imageIndex maps image names to file paths.
+type imageIndex struct {
+	byName map[string]string // relative path or base name with extension
+	byStem map[string]string // base name without extension
+	exts   []string
+}
+
+func indexImageFiles(dirs []string, exts []string) (*imageIndex, error) {
+	index := &imageIndex{
+		byName: make(map[string]string),
+		byStem: make(map[string]string),
+		exts:   exts,
+	}
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, de os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if de.IsDir() || !hasImageExt(path, exts) {
+				return nil
+			}
+			rel, _ := filepath.Rel(dir, path)
+			base := filepath.Base(path)
+			stem := strings.TrimSuffix(base, filepath.Ext(base))
+			// First directory wins.
+			for _, key := range []string{filepath.ToSlash(rel), base} {
+				if _, ok := index.byName[key]; !ok {
+					index.byName[key] = path
+				}
+			}
+			if _, ok := index.byStem[stem]; !ok {
+				index.byStem[stem] = path
+			}
+			return nil
+		})
+		if err != nil {
+			err = fmt.Errorf("Index image files failed: %w", err)
+			return nil, err
+		}
+	}
+
+	return index, nil
+}
+
+func (idx *imageIndex) resolve(name string) (string, bool) {
+	name = filepath.ToSlash(name)
+	if p, ok := idx.byName[name]; ok {
+		return p, true
+	}
+	if hasImageExt(name, idx.exts) {
+		return "", false
+	}
+	p, ok := idx.byStem[filepath.Base(name)]
+	return p, ok
+}
+
+func hasImageExt(path string, exts []string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range exts {
+		if ext == strings.ToLower(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueLabels returns sorted unique labels. Labels are sorted numerically if all are integers.
+func uniqueLabels(values []string) []string {
+	set := make(map[string]bool)
+	for _, v := range values {
+		set[v] = true
+	}
+	labels := make([]string, 0, len(set))
+	numeric := true
+	for v := range set {
+		labels = append(labels, v)
+		if _, err := strconv.Atoi(v); err != nil {
+			numeric = false
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if numeric {
+			a, _ := strconv.Atoi(labels[i])
+			b, _ := strconv.Atoi(labels[j])
+			return a < b
+		}
+		return labels[i] < labels[j]
+	})
+	return labels
+}
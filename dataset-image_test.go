@@ -0,0 +1,207 @@
+package lab
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sugarme/gotch/ts"
+)
+
+func writePNG(t *testing.T, path string, c color.RGBA) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 2, 3))
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 2; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodeImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.RGBA{10, 20, 30, 255})
+	img.Set(1, 0, color.RGBA{40, 50, 60, 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	pix, h, w, err := DecodeImage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint8{10, 40, 20, 50, 30, 60} // CHW
+	if h != 1 || w != 2 || !reflect.DeepEqual(pix, want) {
+		t.Errorf("Want %v (1x2), got %v (%dx%d)\n", want, pix, h, w)
+	}
+}
+
+func TestLoadImageCSV(t *testing.T) {
+	dir := t.TempDir()
+	dirA := filepath.Join(dir, "a")
+	dirB := filepath.Join(dir, "b")
+	writePNG(t, filepath.Join(dirA, "img1.png"), color.RGBA{255, 0, 0, 255})
+	writePNG(t, filepath.Join(dirB, "sub", "img2.png"), color.RGBA{0, 255, 0, 255})
+	writePNG(t, filepath.Join(dirB, "img3.png"), color.RGBA{0, 0, 255, 255})
+
+	// One-hot
+	oneHot := filepath.Join(dir, "GroundTruth.csv")
+	content := "image,MEL,NV,BCC\nimg1,0,1,0\nimg2,1,0,0\nimg3.png,0,0,1\n"
+	if err := os.WriteFile(oneHot, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	samples, classes, err := LoadImageCSV(oneHot, []string{dirA, dirB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(classes, []string{"MEL", "NV", "BCC"}) {
+		t.Errorf("Unexpected classes: %v\n", classes)
+	}
+	var labels []int
+	for _, s := range samples {
+		labels = append(labels, s.Label)
+	}
+	if !reflect.DeepEqual(labels, []int{1, 0, 2}) {
+		t.Errorf("Unexpected labels: %v\n", labels)
+	}
+	if samples[1].Path != filepath.Join(dirB, "sub", "img2.png") {
+		t.Errorf("Unexpected path: %v\n", samples[1].Path)
+	}
+
+	// Label and fold columns
+	labelCSV := filepath.Join(dir, "labels.csv")
+	content = "fold,file,label\n0,img1,10\n1,img2,2\n1,img3,10\n"
+	if err := os.WriteFile(labelCSV, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	samples, classes, err = LoadImageCSV(labelCSV, []string{dirA, dirB}, WithImageColumn("file"), WithLabelColumn("label"), WithFoldColumn("fold"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(classes, []string{"2", "10"}) {
+		t.Errorf("Unexpected classes: %v\n", classes)
+	}
+	if samples[0].Label != 1 || samples[1].Label != 0 || samples[1].Fold != 1 {
+		t.Errorf("Unexpected samples: %+v\n", samples)
+	}
+
+	d := NewImageDataset(samples, classes)
+	train, valid, err := d.SplitFold(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if train.Len() != 1 || valid.Len() != 2 {
+		t.Errorf("Want 1 train and 2 valid, got %d, %d\n", train.Len(), valid.Len())
+	}
+
+	// Task columns of class indices
+	taskCSV := filepath.Join(dir, "tasks.csv")
+	content = "image,color,shape\nimg1,2,0\nimg2,0,1\nimg3,1,1\n"
+	if err := os.WriteFile(taskCSV, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	samples, classes, err = LoadImageCSV(taskCSV, []string{dirA, dirB}, WithTaskColumns([]string{"shape", "color"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if classes != nil || !reflect.DeepEqual(samples[0].Labels, []int{0, 2}) || samples[1].Label != 1 {
+		t.Errorf("Unexpected task samples: %+v, classes %v\n", samples, classes)
+	}
+	item, err := NewImageDataset(samples, classes).Item(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if label := item.([]ts.Tensor)[1]; !reflect.DeepEqual(label.Int64Values(), []int64{1, 1}) {
+		t.Errorf("Want task labels [1 1], got %v\n", label.Int64Values())
+	}
+	os.WriteFile(taskCSV, []byte("image,color\nimg1,red\n"), 0644)
+	if _, _, err := LoadImageCSV(taskCSV, []string{dirA}, WithTaskColumns([]string{"color"})); err == nil {
+		t.Errorf("Want error for invalid task label")
+	}
+
+	// Missing image
+	missing := filepath.Join(dir, "missing.csv")
+	os.WriteFile(missing, []byte("image,A,B\nnope,1,0\n"), 0644)
+	if _, _, err := LoadImageCSV(missing, []string{dirA}); err == nil {
+		t.Errorf("Want error for missing image")
+	}
+}
+
+func TestLoadImageFolder(t *testing.T) {
+	root := t.TempDir()
+	writePNG(t, filepath.Join(root, "dog", "1.png"), color.RGBA{1, 1, 1, 255})
+	writePNG(t, filepath.Join(root, "cat", "1.png"), color.RGBA{2, 2, 2, 255})
+	writePNG(t, filepath.Join(root, "cat", "2.png"), color.RGBA{3, 3, 3, 255})
+	os.WriteFile(filepath.Join(root, "cat", "notes.txt"), []byte("x"), 0644)
+
+	samples, classes, err := LoadImageFolder(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(classes, []string{"cat", "dog"}) {
+		t.Errorf("Unexpected classes: %v\n", classes)
+	}
+	if len(samples) != 3 || samples[2].Label != 1 {
+		t.Errorf("Unexpected samples: %+v\n", samples)
+	}
+}
+
+func TestImageDatasetSplit(t *testing.T) {
+	var samples []ImageSample
+	for i := 0; i < 100; i++ {
+		samples = append(samples, ImageSample{Path: "x", Label: i % 4, Fold: -1})
+	}
+	d := NewImageDataset(samples, []string{"a", "b", "c", "d"})
+	train, valid, err := d.Split(0.2, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if train.Len() != 80 || valid.Len() != 20 {
+		t.Errorf("Want 80/20, got %d/%d\n", train.Len(), valid.Len())
+	}
+	for name, n := range valid.ClassCounts() {
+		if n != 5 {
+			t.Errorf("Want 5 valid samples of class %s, got %d\n", name, n)
+		}
+	}
+
+	train2, _, _ := d.Split(0.2, 42)
+	if !reflect.DeepEqual(train.Samples, train2.Samples) {
+		t.Errorf("Split is not deterministic for the same seed")
+	}
+}
+
+func TestBuildImageDatasets_InvalidSplitParams(t *testing.T) {
+	root := t.TempDir()
+	writePNG(t, filepath.Join(root, "dog", "1.png"), color.RGBA{1, 1, 1, 255})
+	writePNG(t, filepath.Join(root, "cat", "1.png"), color.RGBA{2, 2, 2, 255})
+
+	for _, ratio := range []interface{}{"0.2", true} {
+		cfg := &Config{}
+		cfg.Dataset.Name = "ImageFolder"
+		cfg.Dataset.DataDir = []string{root}
+		cfg.Dataset.Params = map[string]interface{}{"valid_ratio": ratio}
+		_, _, err := NewBuilder(cfg).BuildImageDatasets()
+		if err == nil || !strings.Contains(err.Error(), "valid_ratio") {
+			t.Errorf("valid_ratio %v: want invalid param error, got %v\n", ratio, err)
+		}
+	}
+}
@@ -0,0 +1,509 @@
+package lab
+
+import (
+	"encoding/csv"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/ts"
+)
+
+// RLE is a run-length encoded mask with the class value of its pixels.
+type RLE struct {
+	Counts string // "start length start length ..." with 1-indexed starts
+	Class  uint8
+}
+
+// SegSample is an image and its segmentation mask given as a mask file or RLE strings.
+type SegSample struct {
+	Image string
+	Mask  string // mask image file. Empty if mask is RLE encoded.
+	RLEs  []RLE  // RLE encoded masks painted in order. Empty RLEs means empty mask.
+	Fold  int    // -1 if not specified
+}
+
+// SegDataset is a segmentation dataset implementing dutil.Dataset interface.
+//
+// Item returns []ts.Tensor{image, mask} where image is a float tensor of shape [C, H, W]
+// and mask is a float tensor of shape [1, H, W] of class values.
+type SegDataset struct {
+	Samples []SegSample
+
+	transformer PairTransformer
+	imageSize   []int64 // [height, width]
+	rleColMajor bool
+	binaryMask  bool
+}
+
+type SegDatasetOptions struct {
+	Transformer PairTransformer
+	ImageSize   []int64 // [height, width] to resize image and mask to before transforming.
+	RLEColMajor bool    // whether RLE pixels are numbered top to bottom, then left to right. Default = true.
+	BinaryMask  bool    // whether to convert mask file values > 0 to 1. Default = true.
+}
+
+type SegDatasetOption func(*SegDatasetOptions)
+
+func defaultSegDatasetOptions() *SegDatasetOptions {
+	return &SegDatasetOptions{
+		Transformer: nil,
+		ImageSize:   nil,
+		RLEColMajor: true,
+		BinaryMask:  true,
+	}
+}
+
+func WithSegTransformer(t PairTransformer) SegDatasetOption {
+	return func(o *SegDatasetOptions) {
+		o.Transformer = t
+	}
+}
+
+func WithSegImageSize(height, width int64) SegDatasetOption {
+	return func(o *SegDatasetOptions) {
+		o.ImageSize = []int64{height, width}
+	}
+}
+
+func WithRLEColMajor(v bool) SegDatasetOption {
+	return func(o *SegDatasetOptions) {
+		o.RLEColMajor = v
+	}
+}
+
+func WithBinaryMask(v bool) SegDatasetOption {
+	return func(o *SegDatasetOptions) {
+		o.BinaryMask = v
+	}
+}
+
+// NewSegDataset creates a new SegDataset.
+func NewSegDataset(samples []SegSample, opts ...SegDatasetOption) *SegDataset {
+	options := defaultSegDatasetOptions()
+	for _, o := range opts {
+		o(options)
+	}
+
+	return &SegDataset{
+		Samples:     samples,
+		transformer: options.Transformer,
+		imageSize:   options.ImageSize,
+		rleColMajor: options.RLEColMajor,
+		binaryMask:  options.BinaryMask,
+	}
+}
+
+// Item implements dutil.Dataset interface. Augment is drawn from a random seed.
+func (d *SegDataset) Item(idx int) (interface{}, error) {
+	return d.ItemSeeded(idx, rand.Int63())
+}
+
+// ItemSeeded implements SeededDataset interface. Loading an item with the same seed reproduces
+// its geometric augment, and photometric augment if it is seeded (see PairAugment).
+func (d *SegDataset) ItemSeeded(idx int, seed int64) (interface{}, error) {
+	if idx < 0 || idx >= len(d.Samples) {
+		err := fmt.Errorf("SegDataset.Item - index %d out of range [0, %d)", idx, len(d.Samples))
+		return nil, err
+	}
+	s := d.Samples[idx]
+
+	pix, h, w, err := DecodeImageFile(s.Image)
+	if err != nil {
+		err = fmt.Errorf("SegDataset.Item - Load image failed: %w", err)
+		return nil, err
+	}
+	maskPix, err := d.loadMask(s, h, w)
+	if err != nil {
+		err = fmt.Errorf("SegDataset.Item - Load mask failed: %w", err)
+		return nil, err
+	}
+
+	img := ts.MustOfSlice(pix).MustView([]int64{3, int64(h), int64(w)}, true)
+	mask := ts.MustOfSlice(maskPix).MustView([]int64{1, int64(h), int64(w)}, true)
+
+	if d.imageSize != nil {
+		img, mask, err = resizePair(img, mask, d.imageSize[0], d.imageSize[1])
+		if err != nil {
+			img.MustDrop()
+			mask.MustDrop()
+			err = fmt.Errorf("SegDataset.Item - Resize failed: %w", err)
+			return nil, err
+		}
+	}
+	if d.transformer != nil {
+		outImg, outMask, err := d.transformer.TransformPair(img, mask, seed)
+		img.MustDrop()
+		mask.MustDrop()
+		if err != nil {
+			err = fmt.Errorf("SegDataset.Item - Transform failed: %w", err)
+			return nil, err
+		}
+		img, mask = outImg, outMask
+	}
+
+	if img.DType() == gotch.Uint8 {
+		img = img.MustTotype(gotch.Float, true).MustDivScalar(ts.FloatScalar(255.0), true)
+	}
+	mask = mask.MustTotype(gotch.Float, true)
+
+	return []ts.Tensor{*img, *mask}, nil
+}
+
+func (d *SegDataset) loadMask(s SegSample, h, w int) ([]uint8, error) {
+	if s.Mask == "" {
+		mask := make([]uint8, h*w)
+		for _, rle := range s.RLEs {
+			err := paintRLE(mask, rle, h, w, d.rleColMajor)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return mask, nil
+	}
+
+	mask, mh, mw, err := DecodeMaskFile(s.Mask)
+	if err != nil {
+		return nil, err
+	}
+	if mh != h || mw != w {
+		err := fmt.Errorf("mask size (%dx%d) differs from image size (%dx%d)", mh, mw, h, w)
+		return nil, err
+	}
+	if d.binaryMask {
+		for i, v := range mask {
+			if v > 0 {
+				mask[i] = 1
+			}
+		}
+	}
+	return mask, nil
+}
+
+// Len implements dutil.Dataset interface.
+func (d *SegDataset) Len() int {
+	return len(d.Samples)
+}
+
+// DType implements dutil.Dataset interface.
+func (d *SegDataset) DType() reflect.Type {
+	return reflect.TypeOf(d.Samples)
+}
+
+// SetTransformer sets paired transformer of dataset.
+func (d *SegDataset) SetTransformer(t PairTransformer) {
+	d.transformer = t
+}
+
+func (d *SegDataset) subset(indexes []int) *SegDataset {
+	samples := make([]SegSample, len(indexes))
+	for i, idx := range indexes {
+		samples[i] = d.Samples[idx]
+	}
+	sub := *d
+	sub.Samples = samples
+	return &sub
+}
+
+// Split randomly splits dataset into train and valid datasets.
+func (d *SegDataset) Split(validRatio float64, seed int64) (*SegDataset, *SegDataset, error) {
+	if validRatio <= 0 || validRatio >= 1 {
+		err := fmt.Errorf("SegDataset.Split failed: expected valid ratio in range (0, 1), got %v", validRatio)
+		return nil, nil, err
+	}
+
+	idxs := rand.New(rand.NewSource(seed)).Perm(len(d.Samples))
+	nvalid := int(float64(len(idxs))*validRatio + 0.5)
+	validIdxs, trainIdxs := idxs[:nvalid], idxs[nvalid:]
+	sort.Ints(trainIdxs)
+	sort.Ints(validIdxs)
+
+	return d.subset(trainIdxs), d.subset(validIdxs), nil
+}
+
+// SplitFold splits dataset by fold. Samples of `fold` go to valid dataset.
+func (d *SegDataset) SplitFold(fold int) (*SegDataset, *SegDataset, error) {
+	var trainIdxs, validIdxs []int
+	for i, s := range d.Samples {
+		if s.Fold < 0 {
+			err := fmt.Errorf("SegDataset.SplitFold failed: sample %q has no fold", s.Image)
+			return nil, nil, err
+		}
+		if s.Fold == fold {
+			validIdxs = append(validIdxs, i)
+		} else {
+			trainIdxs = append(trainIdxs, i)
+		}
+	}
+	if len(validIdxs) == 0 {
+		err := fmt.Errorf("SegDataset.SplitFold failed: no samples of fold %d", fold)
+		return nil, nil, err
+	}
+
+	return d.subset(trainIdxs), d.subset(validIdxs), nil
+}
+
+// LoadSegFolder pairs images in imageDir with masks in maskDir. Mask of image `<name>.<ext>` is
+// `<name><maskSuffix>.<any image ext>`, i.e. "ISIC_0000000.jpg" and "ISIC_0000000_segmentation.png".
+func LoadSegFolder(imageDir, maskDir, maskSuffix string) ([]SegSample, error) {
+	images, err := indexImageFiles([]string{imageDir}, DefaultImageExts)
+	if err != nil {
+		err = fmt.Errorf("LoadSegFolder failed: %w", err)
+		return nil, err
+	}
+	masks, err := indexImageFiles([]string{maskDir}, DefaultImageExts)
+	if err != nil {
+		err = fmt.Errorf("LoadSegFolder failed: %w", err)
+		return nil, err
+	}
+
+	stems := make([]string, 0, len(images.byStem))
+	for stem := range images.byStem {
+		stems = append(stems, stem)
+	}
+	sort.Strings(stems)
+
+	samples := make([]SegSample, 0, len(stems))
+	for _, stem := range stems {
+		mask, ok := masks.byStem[stem+maskSuffix]
+		if !ok {
+			err := fmt.Errorf("LoadSegFolder failed: no mask %q in %q for image %q", stem+maskSuffix, maskDir, images.byStem[stem])
+			return nil, err
+		}
+		samples = append(samples, SegSample{Image: images.byStem[stem], Mask: mask, Fold: -1})
+	}
+
+	return samples, nil
+}
+
+// SegCSVOptions specifies columns of a segmentation CSV file.
+type SegCSVOptions struct {
+	ImageColumn string // default = "image"
+	MaskColumn  string // column of mask file names. Either MaskColumn or RLEColumn is required.
+	RLEColumn   string // column of RLE encoded masks.
+	ClassColumn string // optional column of class values of RLE masks. Default class = 1.
+	FoldColumn  string // optional column of fold numbers.
+}
+
+// LoadSegCSV loads segmentation samples from a CSV file of image-mask pairs. Image and mask
+// files are searched in dataDirs. For RLE masks, rows of the same image are merged and
+// an empty RLE means no mask pixels.
+func LoadSegCSV(csvFile string, dataDirs []string, opts SegCSVOptions) ([]SegSample, error) {
+	if opts.ImageColumn == "" {
+		opts.ImageColumn = "image"
+	}
+	if (opts.MaskColumn == "") == (opts.RLEColumn == "") {
+		err := fmt.Errorf("LoadSegCSV failed: exactly one of mask or RLE column is required")
+		return nil, err
+	}
+
+	f, err := os.Open(csvFile)
+	if err != nil {
+		err = fmt.Errorf("LoadSegCSV - Open file failed: %w", err)
+		return nil, err
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		err = fmt.Errorf("LoadSegCSV - Read csv failed: %w", err)
+		return nil, err
+	}
+	if len(records) < 2 {
+		err := fmt.Errorf("LoadSegCSV failed: no data rows in %q", csvFile)
+		return nil, err
+	}
+
+	header := records[0]
+	colIdx := func(name string) int {
+		if name == "" {
+			return -1
+		}
+		for i, h := range header {
+			if strings.TrimSpace(h) == name {
+				return i
+			}
+		}
+		return -2
+	}
+	imageCol, maskCol, rleCol := colIdx(opts.ImageColumn), colIdx(opts.MaskColumn), colIdx(opts.RLEColumn)
+	classCol, foldCol := colIdx(opts.ClassColumn), colIdx(opts.FoldColumn)
+	for name, col := range map[string]int{opts.ImageColumn: imageCol, opts.MaskColumn: maskCol, opts.RLEColumn: rleCol, opts.ClassColumn: classCol, opts.FoldColumn: foldCol} {
+		if col == -2 {
+			err := fmt.Errorf("LoadSegCSV failed: column %q not found in %v", name, header)
+			return nil, err
+		}
+	}
+
+	index, err := indexImageFiles(dataDirs, DefaultImageExts)
+	if err != nil {
+		err = fmt.Errorf("LoadSegCSV failed: %w", err)
+		return nil, err
+	}
+
+	var samples []SegSample
+	byImage := make(map[string]int) // image path to sample index
+	for i, row := range records[1:] {
+		line := i + 2
+		name := strings.TrimSpace(row[imageCol])
+		imagePath, ok := index.resolve(name)
+		if !ok {
+			err := fmt.Errorf("LoadSegCSV failed: image %q not found in %v", name, dataDirs)
+			return nil, err
+		}
+		fold := -1
+		if foldCol >= 0 {
+			fold, err = strconv.Atoi(strings.TrimSpace(row[foldCol]))
+			if err != nil {
+				err = fmt.Errorf("LoadSegCSV failed: row %d has invalid fold: %w", line, err)
+				return nil, err
+			}
+		}
+
+		if maskCol >= 0 {
+			maskName := strings.TrimSpace(row[maskCol])
+			maskPath, ok := index.resolve(maskName)
+			if !ok {
+				err := fmt.Errorf("LoadSegCSV failed: mask %q not found in %v", maskName, dataDirs)
+				return nil, err
+			}
+			samples = append(samples, SegSample{Image: imagePath, Mask: maskPath, Fold: fold})
+			continue
+		}
+
+		class := uint8(1)
+		if classCol >= 0 {
+			c, err := strconv.Atoi(strings.TrimSpace(row[classCol]))
+			if err != nil || c < 0 || c > 255 {
+				err = fmt.Errorf("LoadSegCSV failed: row %d has invalid class %q", line, row[classCol])
+				return nil, err
+			}
+			class = uint8(c)
+		}
+		sidx, ok := byImage[imagePath]
+		if !ok {
+			sidx = len(samples)
+			byImage[imagePath] = sidx
+			samples = append(samples, SegSample{Image: imagePath, Fold: fold})
+		}
+		if counts := strings.TrimSpace(row[rleCol]); counts != "" {
+			samples[sidx].RLEs = append(samples[sidx].RLEs, RLE{Counts: counts, Class: class})
+		}
+	}
+
+	return samples, nil
+}
+
+// DecodeRLE decodes a run-length encoded mask of size h x w to a binary mask in row-major order.
+//
+// RLE is a string of space separated pairs "start length" with 1-indexed starts. If colMajor is true,
+// pixels are numbered top to bottom, then left to right (Kaggle convention).
+func DecodeRLE(counts string, h, w int, colMajor bool) ([]uint8, error) {
+	mask := make([]uint8, h*w)
+	err := paintRLE(mask, RLE{Counts: counts, Class: 1}, h, w, colMajor)
+	if err != nil {
+		return nil, err
+	}
+	return mask, nil
+}
+
+func paintRLE(mask []uint8, rle RLE, h, w int, colMajor bool) error {
+	fields := strings.Fields(rle.Counts)
+	if len(fields)%2 != 0 {
+		err := fmt.Errorf("invalid RLE: odd number of values (%d)", len(fields))
+		return err
+	}
+	n := h * w
+	for i := 0; i < len(fields); i += 2 {
+		start, err1 := strconv.Atoi(fields[i])
+		length, err2 := strconv.Atoi(fields[i+1])
+		if err1 != nil || err2 != nil || start < 1 || length < 0 || start-1+length > n {
+			err := fmt.Errorf("invalid RLE run %q %q for mask of %d pixels", fields[i], fields[i+1], n)
+			return err
+		}
+		for p := start - 1; p < start-1+length; p++ {
+			idx := p
+			if colMajor {
+				idx = (p%h)*w + p/h
+			}
+			mask[idx] = rle.Class
+		}
+	}
+	return nil
+}
+
+// EncodeRLE encodes non-zero pixels of a row-major mask of size h x w to RLE string.
+func EncodeRLE(mask []uint8, h, w int, colMajor bool) string {
+	var (
+		sb    strings.Builder
+		start = -1
+	)
+	n := h * w
+	for p := 0; p <= n; p++ {
+		on := false
+		if p < n {
+			idx := p
+			if colMajor {
+				idx = (p%h)*w + p/h
+			}
+			on = mask[idx] > 0
+		}
+		switch {
+		case on && start < 0:
+			start = p
+		case !on && start >= 0:
+			if sb.Len() > 0 {
+				sb.WriteByte(' ')
+			}
+			fmt.Fprintf(&sb, "%d %d", start+1, p-start)
+			start = -1
+		}
+	}
+	return sb.String()
+}
+
+// DecodeMaskFile decodes a mask image to single channel values in row-major order.
+// Palette images return palette indexes, other images return gray levels.
+func DecodeMaskFile(path string) ([]uint8, int, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	b := img.Bounds()
+	h, w := b.Dy(), b.Dx()
+	mask := make([]uint8, h*w)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			switch m := img.(type) {
+			case *image.Paletted:
+				mask[y*w+x] = m.ColorIndexAt(b.Min.X+x, b.Min.Y+y)
+			default:
+				mask[y*w+x] = color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+			}
+		}
+	}
+
+	return mask, h, w, nil
+}
+
+// segDataDirs returns data directories or directory of CSV file if none is specified.
+func segDataDirs(csvFile string, dirs []string) []string {
+	if len(dirs) > 0 {
+		return dirs
+	}
+	return []string{filepath.Dir(csvFile)}
+}
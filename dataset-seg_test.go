@@ -0,0 +1,180 @@
+package lab
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/ts"
+)
+
+func TestRLE(t *testing.T) {
+	// 3x4 mask, row-major:
+	// 0 1 1 0
+	// 0 1 0 0
+	// 0 0 0 1
+	mask := []uint8{
+		0, 1, 1, 0,
+		0, 1, 0, 0,
+		0, 0, 0, 1,
+	}
+
+	rowRLE := EncodeRLE(mask, 3, 4, false)
+	if rowRLE != "2 2 6 1 12 1" {
+		t.Errorf("Unexpected row-major RLE: %q\n", rowRLE)
+	}
+	colRLE := EncodeRLE(mask, 3, 4, true)
+	if colRLE != "4 2 7 1 12 1" {
+		t.Errorf("Unexpected column-major RLE: %q\n", colRLE)
+	}
+
+	for _, colMajor := range []bool{false, true} {
+		got, err := DecodeRLE(EncodeRLE(mask, 3, 4, colMajor), 3, 4, colMajor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, mask) {
+			t.Errorf("colMajor=%v: want %v, got %v\n", colMajor, mask, got)
+		}
+	}
+
+	if _, err := DecodeRLE("1 2 3", 3, 4, true); err == nil {
+		t.Errorf("Want error for odd RLE")
+	}
+	if _, err := DecodeRLE("10 5", 3, 4, true); err == nil {
+		t.Errorf("Want error for out of range RLE")
+	}
+}
+
+func TestLoadSegCSV(t *testing.T) {
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "a.png"), color.RGBA{1, 2, 3, 255})
+	writePNG(t, filepath.Join(dir, "b.png"), color.RGBA{1, 2, 3, 255})
+
+	csvFile := filepath.Join(dir, "train.csv")
+	content := "image,class,rle\na,1,1 2\na,2,5 1\nb,1,\n"
+	if err := os.WriteFile(csvFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	samples, err := LoadSegCSV(csvFile, []string{dir}, SegCSVOptions{RLEColumn: "rle", ClassColumn: "class"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("Want 2 samples, got %d\n", len(samples))
+	}
+	want := []RLE{{Counts: "1 2", Class: 1}, {Counts: "5 1", Class: 2}}
+	if !reflect.DeepEqual(samples[0].RLEs, want) || len(samples[1].RLEs) != 0 {
+		t.Errorf("Unexpected RLEs: %+v\n", samples)
+	}
+
+	// Paint merged masks of image a (3x2 image, column-major).
+	d := NewSegDataset(samples)
+	mask, err := d.loadMask(samples[0], 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wantMask := []uint8{1, 0, 1, 2, 0, 0}; !reflect.DeepEqual(mask, wantMask) {
+		t.Errorf("Want mask %v, got %v\n", wantMask, mask)
+	}
+
+	if _, err := LoadSegCSV(csvFile, []string{dir}, SegCSVOptions{}); err == nil {
+		t.Errorf("Want error without mask or RLE column")
+	}
+}
+
+func TestLoadSegFolder(t *testing.T) {
+	dir := t.TempDir()
+	imageDir := filepath.Join(dir, "images")
+	maskDir := filepath.Join(dir, "masks")
+	writePNG(t, filepath.Join(imageDir, "ISIC_1.png"), color.RGBA{1, 2, 3, 255})
+	writePNG(t, filepath.Join(maskDir, "ISIC_1_segmentation.png"), color.RGBA{255, 255, 255, 255})
+
+	samples, err := LoadSegFolder(imageDir, maskDir, "_segmentation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 1 || samples[0].Mask != filepath.Join(maskDir, "ISIC_1_segmentation.png") {
+		t.Errorf("Unexpected samples: %+v\n", samples)
+	}
+
+	d := NewSegDataset(samples)
+	mask, err := d.loadMask(samples[0], 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint8{1, 1, 1, 1, 1, 1}; !reflect.DeepEqual(mask, want) {
+		t.Errorf("Want binary mask %v, got %v\n", want, mask)
+	}
+
+	if _, err := LoadSegFolder(imageDir, imageDir, "_mask"); err == nil {
+		t.Errorf("Want error for missing mask")
+	}
+}
+
+func TestMakePairTransformer(t *testing.T) {
+	cfg := TransformConfig{
+		AugmentOpts: []AugmentOpt{
+			{Name: "RandomHFlip", Params: map[string]interface{}{"pvalue": 0.5}},
+			{Name: "RandomCrop", Params: map[string]interface{}{"size": []interface{}{64, 64}}},
+			{Name: "RandomRotate90"},
+		},
+	}
+	a, err := MakePairTransformer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.geometric) != 3 || a.photometric != nil {
+		t.Errorf("Want 3 geometric and no photometric ops, got %d, %v\n", len(a.geometric), a.photometric)
+	}
+
+	cfg.AugmentOpts = append(cfg.AugmentOpts, AugmentOpt{Name: "RandomPerspective"})
+	if _, err := MakePairTransformer(cfg); err == nil {
+		t.Errorf("Want error for RandomPerspective")
+	}
+}
+
+func TestPairAugment_Seeded(t *testing.T) {
+	cfg := TransformConfig{
+		AugmentOpts: []AugmentOpt{
+			{Name: "RandomHFlip", Params: map[string]interface{}{"pvalue": 0.5}},
+			{Name: "RandomRotate90", Params: map[string]interface{}{"pvalue": 0.5}},
+			{Name: "RandomCrop", Params: map[string]interface{}{"size": []interface{}{4, 4}}},
+		},
+	}
+	a, err := MakePairTransformer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mask equals the first image channel so that it must follow image geometry.
+	pix := make([]uint8, 3*8*8)
+	for i := range pix {
+		pix[i] = uint8(i % 64)
+	}
+	image := ts.MustOfSlice(pix).MustView([]int64{3, 8, 8}, true)
+	mask := image.MustNarrow(0, 0, 1, false)
+	defer image.MustDrop()
+	defer mask.MustDrop()
+
+	transform := func(seed int64) ([]float64, []float64) {
+		img, m, err := a.TransformPair(image, mask, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img.MustTotype(gotch.Double, true).Float64Values(true), m.MustTotype(gotch.Double, true).Float64Values(true)
+	}
+
+	img1, mask1 := transform(7)
+	img2, mask2 := transform(7)
+	if !reflect.DeepEqual(img1, img2) || !reflect.DeepEqual(mask1, mask2) {
+		t.Errorf("Want same augment from same seed\n")
+	}
+	if !reflect.DeepEqual(img1[:16], mask1) {
+		t.Errorf("Want mask transformed as image, got image %v, mask %v\n", img1[:16], mask1)
+	}
+}
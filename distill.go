@@ -0,0 +1,228 @@
+package lab
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/ts"
+)
+
+// Knowledge distillation:
+// ======================
+// A student model is trained on soft targets of frozen teacher models, e.g. an ensemble of large
+// models, combined with hard labels (`train.params.distill`). Soft targets come either from
+// teachers run on each training batch, seeing the same augment as the student, or from teacher
+// logits saved ahead by SaveTeacherLogits, which saves running teachers during training.
+
+// Distiller produces soft targets of frozen teachers and combines distillation loss with
+// criterion of hard labels.
+type Distiller struct {
+	Teachers    []*Model // run on training batches. Nil if teacher logits are loaded with data.
+	Temperature float64
+	Alpha       float64 // weight of distillation loss. Criterion is weighted 1 - Alpha.
+}
+
+// NewDistiller creates a Distiller and freezes teachers. Teachers may be nil if teacher logits
+// are loaded with data (see ImageDataset.SetTeacherLogits).
+func NewDistiller(teachers []*Model, temperature, alpha float64) (*Distiller, error) {
+	if temperature <= 0 {
+		err := fmt.Errorf("NewDistiller failed: expected positive temperature, got %v", temperature)
+		return nil, err
+	}
+	if alpha <= 0 || alpha > 1 {
+		err := fmt.Errorf("NewDistiller failed: expected alpha in range (0, 1], got %v", alpha)
+		return nil, err
+	}
+	for _, m := range teachers {
+		m.Eval()
+	}
+
+	return &Distiller{
+		Teachers:    teachers,
+		Temperature: temperature,
+		Alpha:       alpha,
+	}, nil
+}
+
+// TeacherLogits returns mean logits of teachers on input in evaluation mode. It does not
+// delete input.
+func (d *Distiller) TeacherLogits(input *ts.Tensor) *ts.Tensor {
+	var logits *ts.Tensor
+	ts.NoGrad(func() {
+		for _, m := range d.Teachers {
+			out := m.Module.ForwardT(input, false)
+			if logits == nil {
+				logits = out
+				continue
+			}
+			logits = logits.MustAdd(out, true)
+			out.MustDrop()
+		}
+		if len(d.Teachers) > 1 {
+			logits = logits.MustDivScalar(ts.FloatScalar(float64(len(d.Teachers))), true)
+		}
+	})
+	return logits
+}
+
+// Loss returns Alpha weighted DistillationLoss of logits from teacher logits plus 1 - Alpha
+// weighted criterion of logits and target.
+func (d *Distiller) Loss(logits, target, teacherLogits *ts.Tensor, criterion LossFunc) *ts.Tensor {
+	loss := DistillationLoss(logits, teacherLogits, d.Temperature).MustMulScalar(ts.FloatScalar(d.Alpha), true)
+	if d.Alpha == 1 {
+		return loss
+	}
+	hardLoss := criterion(logits, target).MustMulScalar(ts.FloatScalar(1-d.Alpha), true)
+	loss = loss.MustAdd(hardLoss, true)
+	hardLoss.MustDrop()
+	return loss
+}
+
+// String reports teachers and weighting.
+func (d *Distiller) String() string {
+	teachers := "saved teacher logits"
+	if len(d.Teachers) > 0 {
+		var names []string
+		for _, m := range d.Teachers {
+			names = append(names, m.Name)
+		}
+		teachers = fmt.Sprintf("teachers %v", names)
+	}
+	return fmt.Sprintf("Distilling %s (temperature %v, alpha %v)\n", teachers, d.Temperature, d.Alpha)
+}
+
+// SaveTeacherLogits runs teachers of `train.params.distill` on train images of cfg with valid
+// transform and saves mean logits to csv file of columns image, logit_0, ..., logit_{C-1}, to be
+// used as `logits_file` of later trainings.
+func SaveTeacherLogits(cfg *Config, file string) error {
+	c := *cfg
+	c.Train.Params.Distill.LogitsFile = ""
+	b := NewBuilder(&c)
+	distiller, err := b.BuildDistiller()
+	if err == nil && (distiller == nil || len(distiller.Teachers) == 0) {
+		err = fmt.Errorf("no teachers specified")
+	}
+	if err != nil {
+		err = fmt.Errorf("SaveTeacherLogits failed: %w", err)
+		return err
+	}
+
+	train, _, err := b.BuildImageDatasets()
+	if err != nil {
+		err = fmt.Errorf("SaveTeacherLogits failed: %w", err)
+		return err
+	}
+	validTransformer, err := b.BuildTransformer("valid")
+	if err != nil {
+		err = fmt.Errorf("SaveTeacherLogits failed: %w", err)
+		return err
+	}
+	train.SetTransformer(validTransformer)
+	train.SetAugmentRecord(false)
+
+	f, err := os.Create(file)
+	if err != nil {
+		err = fmt.Errorf("SaveTeacherLogits failed: %w", err)
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	header := []string{"image"}
+	for i := int64(0); i < c.Model.Params.NumClasses; i++ {
+		header = append(header, fmt.Sprintf("logit_%d", i))
+	}
+	if err := w.Write(header); err != nil {
+		err = fmt.Errorf("SaveTeacherLogits failed: %w", err)
+		return err
+	}
+
+	batchSize := int(c.Evaluation.BatchSize)
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	device := gotch.CudaIfAvailable()
+	for start := 0; start < train.Len(); start += batchSize {
+		end := start + batchSize
+		if end > train.Len() {
+			end = train.Len()
+		}
+		var batch []ts.Tensor
+		for idx := start; idx < end; idx++ {
+			item, err := train.Item(idx)
+			if err != nil {
+				err = fmt.Errorf("SaveTeacherLogits failed: %w", err)
+				return err
+			}
+			x := item.([]ts.Tensor)
+			x[1].MustDrop()
+			batch = append(batch, x[0])
+		}
+		input := ts.MustStack(batch, 0).MustTo(device, true)
+		for i := range batch {
+			batch[i].MustDrop()
+		}
+		logits := distiller.TeacherLogits(input)
+		vals := logits.Float64Values()
+		n := len(vals) / (end - start)
+		input.MustDrop()
+		logits.MustDrop()
+
+		for i := 0; i < end-start; i++ {
+			record := []string{train.Samples[start+i].Path}
+			for _, v := range vals[i*n : (i+1)*n] {
+				record = append(record, strconv.FormatFloat(v, 'g', -1, 32))
+			}
+			if err := w.Write(record); err != nil {
+				err = fmt.Errorf("SaveTeacherLogits failed: %w", err)
+				return err
+			}
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		err = fmt.Errorf("SaveTeacherLogits failed: %w", err)
+		return err
+	}
+
+	return nil
+}
+
+// LoadTeacherLogits loads teacher logits csv file of SaveTeacherLogits format. Logits are keyed
+// by value of the first column, an image path or file name.
+func LoadTeacherLogits(file string) (map[string][]float32, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		err = fmt.Errorf("LoadTeacherLogits failed: %w", err)
+		return nil, err
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		err = fmt.Errorf("LoadTeacherLogits failed: %w", err)
+		return nil, err
+	}
+	if len(records) < 2 || len(records[0]) < 2 {
+		err = fmt.Errorf("LoadTeacherLogits failed: expected header and rows of image and logits in %q", file)
+		return nil, err
+	}
+
+	logits := make(map[string][]float32, len(records)-1)
+	for i, record := range records[1:] {
+		vals := make([]float32, len(record)-1)
+		for j, s := range record[1:] {
+			v, err := strconv.ParseFloat(s, 32)
+			if err != nil {
+				err = fmt.Errorf("LoadTeacherLogits failed: row %d: %w", i+2, err)
+				return nil, err
+			}
+			vals[j] = float32(v)
+		}
+		logits[record[0]] = vals
+	}
+
+	return logits, nil
+}
@@ -0,0 +1,173 @@
+package lab
+
+import (
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+func TestDistillationLoss(t *testing.T) {
+	logits := ts.MustOfSlice([]float32{0, 0, 0, 0}).MustView([]int64{2, 2}, true)
+	teacherLogits := ts.MustOfSlice([]float32{float32(math.Log(3)), 0, float32(math.Log(3)), 0}).MustView([]int64{2, 2}, true)
+	defer logits.MustDrop()
+	defer teacherLogits.MustDrop()
+
+	tests := []struct {
+		temperature float64
+		want        float64
+	}{
+		// KL([0.75, 0.25] || [0.5, 0.5])
+		{1, 0.75*math.Log(1.5) + 0.25*math.Log(0.5)},
+		// KL([s, 1 - s] || [0.5, 0.5]) * 4 with s = sqrt(3) / (1 + sqrt(3))
+		{2, 4 * (0.633975*math.Log(2*0.633975) + 0.366025*math.Log(2*0.366025))},
+	}
+	for _, tt := range tests {
+		loss := DistillationLoss(logits, teacherLogits, tt.temperature)
+		got := loss.Float64Values()[0]
+		loss.MustDrop()
+		if math.Abs(got-tt.want) > 1e-4 {
+			t.Errorf("Temperature %v: want loss %v, got %v\n", tt.temperature, tt.want, got)
+		}
+	}
+
+	loss := DistillationLoss(teacherLogits, teacherLogits, 4)
+	if got := loss.Float64Values()[0]; math.Abs(got) > 1e-6 {
+		t.Errorf("Want zero loss of same logits, got %v\n", got)
+	}
+	loss.MustDrop()
+}
+
+func TestDistiller(t *testing.T) {
+	teacher := func(name string, scale float64) *Model {
+		return &Model{
+			Name:    name,
+			Weights: nn.NewVarStore(gotch.CPU),
+			Module: nn.NewFuncT(func(x *ts.Tensor, train bool) *ts.Tensor {
+				return x.MustMulScalar(ts.FloatScalar(scale), false)
+			}),
+		}
+	}
+	d, err := NewDistiller([]*Model{teacher("efficientnet_b7", 2), teacher("efficientnet_b6", 4)}, 2, 0.7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x := ts.MustOfSlice([]float32{1, 2, 3, 4, 5, 6}).MustView([]int64{2, 3}, true)
+	defer x.MustDrop()
+	teacherLogits := d.TeacherLogits(x)
+	defer teacherLogits.MustDrop()
+	if got, want := teacherLogits.Float64Values(), []float64{3, 6, 9, 12, 15, 18}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Want mean teacher logits %v, got %v\n", want, got)
+	}
+
+	target := ts.MustOfSlice([]int64{0, 2})
+	defer target.MustDrop()
+	loss := d.Loss(x, target, teacherLogits, CrossEntropyLoss)
+	kd := DistillationLoss(x, teacherLogits, 2)
+	ce := CrossEntropyLoss(x, target)
+	want := 0.7*kd.Float64Values()[0] + 0.3*ce.Float64Values()[0]
+	if got := loss.Float64Values()[0]; math.Abs(got-want) > 1e-5 {
+		t.Errorf("Want loss %v, got %v\n", want, got)
+	}
+	loss.MustDrop()
+	kd.MustDrop()
+	ce.MustDrop()
+
+	if s := d.String(); !strings.Contains(s, "efficientnet_b7 efficientnet_b6") {
+		t.Errorf("Want teachers reported, got %q\n", s)
+	}
+	if _, err := NewDistiller(nil, 4, 1.5); err == nil {
+		t.Errorf("Want error for alpha out of range\n")
+	}
+
+	// Config
+	cfg := &Config{}
+	d, err = NewBuilder(cfg).BuildDistiller()
+	if err != nil || d != nil {
+		t.Errorf("Want no distiller if not specified, got %v, %v\n", d, err)
+	}
+	cfg.Train.Params.Distill = DistillConfig{LogitsFile: "teacher.csv"}
+	d, err = NewBuilder(cfg).BuildDistiller()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Temperature != 4 || d.Alpha != 0.5 || d.Teachers != nil {
+		t.Errorf("Want default temperature 4 and alpha 0.5 without teachers, got %+v\n", d)
+	}
+	// Saved logits are of unmixed images.
+	cfg.Transform.Train.BatchAugment.Name = "None"
+	if _, err := NewBuilder(cfg).BuildDistiller(); err != nil {
+		t.Errorf("Want logits file without batch augment \"None\", got %v\n", err)
+	}
+	cfg.Transform.Train.BatchAugment.Name = "MixUp"
+	if _, err := NewBuilder(cfg).BuildDistiller(); err == nil {
+		t.Errorf("Want error for logits file with batch augment\n")
+	}
+	cfg.Transform.Train.BatchAugment.Name = ""
+	cfg.Train.Params.Distill.Teachers = []TeacherConfig{{Checkpoint: "teacher.bin"}}
+	if _, err := NewBuilder(cfg).BuildDistiller(); err == nil {
+		t.Errorf("Want error for both teachers and logits file\n")
+	}
+}
+
+func TestTeacherLogits(t *testing.T) {
+	dir := t.TempDir()
+	img1 := filepath.Join(dir, "img1.png")
+	img2 := filepath.Join(dir, "sub", "img2.png")
+	writePNG(t, img1, color.RGBA{255, 0, 0, 255})
+	writePNG(t, img2, color.RGBA{0, 255, 0, 255})
+	file := filepath.Join(dir, "teacher.csv")
+	content := "image,logit_0,logit_1\nimg1.png,1.5,-2\n" + img2 + ",3,4\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	logits, err := LoadTeacherLogits(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := []ImageSample{{Path: img1, Label: 0}, {Path: img2, Label: 1}}
+	data := NewImageDataset(samples, []string{"a", "b"})
+	if err := data.SetTeacherLogits(logits); err != nil {
+		t.Fatal(err)
+	}
+
+	// Logits are matched by path or file name.
+	for idx, want := range [][]float64{{1.5, -2}, {3, 4}} {
+		item, err := data.Item(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x := item.([]ts.Tensor)
+		if len(x) != 3 {
+			t.Fatalf("Want image, label and teacher logits, got %d tensors\n", len(x))
+		}
+		if got := x[2].Float64Values(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Want teacher logits %v of item %d, got %v\n", want, idx, got)
+		}
+		dropItems([]interface{}{x})
+	}
+
+	missing := NewImageDataset([]ImageSample{{Path: filepath.Join(dir, "img3.png")}}, []string{"a", "b"})
+	if err := missing.SetTeacherLogits(logits); err == nil {
+		t.Errorf("Want error for image without teacher logits\n")
+	}
+
+	data.SetTeacherLogits(nil)
+	item, err := data.Item(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(item.([]ts.Tensor)); n != 2 {
+		t.Errorf("Want image and label after unsetting teacher logits, got %d tensors\n", n)
+	}
+	dropItems([]interface{}{item})
+}
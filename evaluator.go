@@ -1,17 +1,19 @@
 package lab
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"math"
 	"os"
-	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/sugarme/gotch"
-	"github.com/sugarme/gotch/dutil"
 	"github.com/sugarme/gotch/nn"
 	"github.com/sugarme/gotch/ts"
+
+	lib "github.com/sugarme/lab/model"
 )
 
 type EvalOptions struct {
@@ -56,8 +58,11 @@ func WithEvalLabelsAvailable(l bool) EvalOption {
 	}
 }
 
-func (e *Evaluator) evaluate(model ts.ModuleT, criterion LossFunc, epoch int) (map[string]float64, float64, float64) {
+// evaluate evaluates model with head of its outputs. Loss and metrics of a multi-task head are
+// averaged over tasks, metrics of each task are also returned as "<task>/<metric>".
+func (e *Evaluator) evaluate(model ts.ModuleT, head *lib.Head, criterion LossFunc, epoch int) (map[string]float64, float64, float64) {
 	e.Epoch = epoch
+	criterion = TaskLoss(head, criterion)
 	metrics := make(map[string][]float64, 0)
 	var (
 		validMetrics []float64
@@ -113,7 +118,7 @@ func (e *Evaluator) evaluate(model ts.ModuleT, criterion LossFunc, epoch int) (m
 		losses = append(losses, lossVal)
 
 		// metrics
-		stepMetrics, stepValidMetric, err := e.calculateMetrics(logits, target)
+		stepMetrics, stepValidMetric, err := e.calculateMetrics(logits, target, head)
 		for k, v := range stepMetrics {
 			metrics[k] = append(metrics[k], v)
 		}
@@ -140,7 +145,7 @@ func (e *Evaluator) evaluate(model ts.ModuleT, criterion LossFunc, epoch int) (m
 
 type Evaluator struct {
 	// Predictor *Predictor
-	Loader          *dutil.DataLoader
+	Loader          Loader
 	LabelsAvailable bool
 	CUDA            bool
 	Debug           bool
@@ -175,6 +180,10 @@ type Evaluator struct {
 	BestModel string
 	BestScore float64
 
+	CheckpointExt string // checkpoint file extension: ".bin" (gotch format) or ".safetensors"
+	ConfigHash    string // saved in safetensors checkpoint metadata
+	Step          int    // global training step of metrics sent to trackers, set by Trainer
+
 	Logger *Logger
 }
 
@@ -215,9 +224,38 @@ func (e *Evaluator) checkImprovement(score float64) bool {
 	return improved
 }
 
-func (e *Evaluator) saveCheckpoint(weights *nn.VarStore, validMetric float64) error {
-	saveFile := fmt.Sprintf("%s_%03d_VM-%0.4f.bin", e.Prefix, e.Epoch, validMetric)
-	saveFile = strings.ToUpper(saveFile)
+// checkpointExt returns checkpoint file extension of config. Default ".bin".
+func checkpointExt(cfg *Config) string {
+	ext := cfg.Evaluation.Params.CheckpointExt
+	switch {
+	case ext == "":
+		return ".bin"
+	case !strings.HasPrefix(ext, "."):
+		return "." + ext
+	}
+	return ext
+}
+
+// checkpointMetadata returns safetensors metadata of a checkpoint: config hash, backbone, epoch
+// and metrics in JSON.
+func checkpointMetadata(configHash, backbone string, epoch int, metrics map[string]float64) map[string]string {
+	metadata := map[string]string{
+		"config_hash": configHash,
+		"backbone":    backbone,
+		"epoch":       strconv.Itoa(epoch),
+	}
+	if len(metrics) > 0 {
+		buf, err := json.Marshal(metrics)
+		if err == nil {
+			metadata["metrics"] = string(buf)
+		}
+	}
+	return metadata
+}
+
+func (e *Evaluator) saveCheckpoint(weights *nn.VarStore, validMetric float64, metadata map[string]string) error {
+	saveFile := fmt.Sprintf("%s_%03d_VM-%0.4f", e.Prefix, e.Epoch, validMetric)
+	saveFile = strings.ToUpper(saveFile) + e.CheckpointExt
 	saveFile = fmt.Sprintf("%s/%s", e.SaveCheckpointDir, saveFile)
 
 	// If valid metric improved, save model weights.
@@ -234,7 +272,7 @@ func (e *Evaluator) saveCheckpoint(weights *nn.VarStore, validMetric float64) er
 			}
 
 			// save a new best model
-			err := weights.Save(saveFile)
+			err := lib.SaveWeights(weights, saveFile, metadata)
 			if err != nil {
 				err = fmt.Errorf("SaveCheckpoint - Save model failed: %w\n", err)
 				return err
@@ -245,7 +283,7 @@ func (e *Evaluator) saveCheckpoint(weights *nn.VarStore, validMetric float64) er
 
 		case false:
 			// save a new best model
-			err := weights.Save(saveFile)
+			err := lib.SaveWeights(weights, saveFile, metadata)
 			if err != nil {
 				err = fmt.Errorf("SaveCheckpoint - Save model failed: %w\n", err)
 				return err
@@ -256,51 +294,71 @@ func (e *Evaluator) saveCheckpoint(weights *nn.VarStore, validMetric float64) er
 	return nil
 }
 
-func (e *Evaluator) calculateMetrics(logits, target *ts.Tensor) (map[string]float64, float64, error) {
+func (e *Evaluator) calculateMetrics(logits, target *ts.Tensor, head *lib.Head) (map[string]float64, float64, error) {
 	// map of metric name and its value
 	metrics := make(map[string]float64, 0)
 
-	for _, m := range e.Metrics {
-		val := m.Calculate(logits, target, WithMetricThreshold(e.Threshold))
-		n := m.Name()
-		metrics[n] = val
-	}
+	outputs := splitTasks(head, logits, target)
+	defer dropTasks(head, outputs)
+	var validMetric float64
+	for _, o := range outputs {
+		prefix := ""
+		if o.name != "" {
+			prefix = o.name + "/"
+		}
+		for _, m := range e.Metrics {
+			val := m.Calculate(o.logits, o.target, WithMetricThreshold(e.Threshold))
+			n := prefix + m.Name()
+			metrics[n] = val
+			if prefix != "" {
+				metrics[m.Name()] += val / float64(len(outputs))
+			}
+		}
 
-	validMetric := e.ValidMetric.Calculate(logits, target, WithMetricThreshold(e.Threshold))
+		val := e.ValidMetric.Calculate(o.logits, o.target, WithMetricThreshold(e.Threshold))
+		if prefix != "" {
+			metrics[prefix+"vm"] = val
+		}
+		validMetric += val / float64(len(outputs))
+	}
 	metrics["vm"] = validMetric
 
 	return metrics, validMetric, nil
 }
 
 // Validate validates model and returns valid metric and loss values.
+// Metrics are sent to trackers at global training step e.Step.
 func (e *Evaluator) Validate(model *Model, criterion LossFunc, currentEpoch int) (float64, float64, error) {
-	metrics, validMetric, loss := e.evaluate(model.Module, criterion, currentEpoch)
+	metrics, validMetric, loss := e.evaluate(model.Module, model.Head, criterion, currentEpoch)
 
 	// Log results
-	var keys []string
-	for k := range metrics {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-	for _, k := range keys {
-		v := metrics[k]
-		msg := fmt.Sprintf("%-60s| %0.4f\n", k, v)
-		e.Logger.Printf(msg)
-		e.Logger.SendSlack(msg)
+	msg := e.Logger.PrintMetrics(metrics)
+	e.Logger.Notify(EventValid, fmt.Sprintf("Epoch %d\n%s", e.Epoch+1, msg))
+
+	trackMetrics := make(map[string]float64, len(metrics)+1)
+	for k, v := range metrics {
+		trackMetrics["valid/"+k] = v
 	}
+	trackMetrics["valid/loss"] = loss
+	trackMetrics["valid/epoch"] = float64(e.Epoch)
+	e.Logger.LogMetrics(e.Step, trackMetrics)
 
+	metadata := checkpointMetadata(e.ConfigHash, model.Name, e.Epoch, metrics)
 	metrics["epoch"] = float64(e.Epoch)
 	e.History = append(e.History, metrics)
 
-	err := e.saveCheckpoint(model.Weights, validMetric)
+	err := e.saveCheckpoint(model.Weights, validMetric, metadata)
 	if err != nil {
 		return -1, -1, err
 	}
+	if e.Stopping == 0 { // improved
+		e.Logger.Notify(EventBest, fmt.Sprintf("Epoch %d: new best %s %0.4f", e.Epoch+1, e.ValidMetric.Name(), validMetric))
+	}
 
 	return validMetric, loss, nil
 }
 
-func NewEvaluator(cfg *Config, loader *dutil.DataLoader, metrics []Metric, validMetric Metric, opts ...EvalOption) (*Evaluator, error) {
+func NewEvaluator(cfg *Config, loader Loader, metrics []Metric, validMetric Metric, opts ...EvalOption) (*Evaluator, error) {
 	options := defaultEvalOptions()
 	for _, o := range opts {
 		o(options)
@@ -316,6 +374,12 @@ func NewEvaluator(cfg *Config, loader *dutil.DataLoader, metrics []Metric, valid
 		earlyStopping = math.MaxInt32
 	}
 
+	configHash, err := cfg.Hash()
+	if err != nil {
+		err = fmt.Errorf("NewEvaluator failed: %w", err)
+		return nil, err
+	}
+
 	metricsFile := fmt.Sprintf("%s/%s", saveCheckpointDir, "metrics.csv")
 
 	eval := &Evaluator{
@@ -337,6 +401,8 @@ func NewEvaluator(cfg *Config, loader *dutil.DataLoader, metrics []Metric, valid
 		History:           nil,
 		BestModel:         "",
 		BestScore:         math.Inf(-1),
+		CheckpointExt:     checkpointExt(cfg),
+		ConfigHash:        configHash,
 		Logger:            nil,
 	}
 
@@ -6,7 +6,6 @@ import (
 	"os"
 
 	"github.com/sugarme/gotch"
-	"github.com/sugarme/gotch/dutil"
 	"github.com/sugarme/gotch/nn"
 	"github.com/sugarme/gotch/ts"
 )
@@ -31,7 +30,7 @@ type history struct {
 // LRFinder is a struct to determine an initial LR.
 // It is essentially similar to Trainer.
 type LRFinder struct {
-	Loader    *dutil.DataLoader
+	Loader    Loader
 	Model     *Model
 	Optimizer *nn.Optimizer
 	Scheduler *Scheduler
@@ -43,7 +42,7 @@ type LRFinder struct {
 }
 
 // NewLRFinder creates a new LRFinder.
-func NewLRFinder(model *Model, loader *dutil.DataLoader, opt *nn.Optimizer, criterion LossFunc, saveDir string, cudaOpt ...bool) (*LRFinder, error) {
+func NewLRFinder(model *Model, loader Loader, opt *nn.Optimizer, criterion LossFunc, saveDir string, cudaOpt ...bool) (*LRFinder, error) {
 	// Make SaveDir if not existing
 	err := MakeDir(saveDir)
 	if err != nil {
@@ -169,7 +168,7 @@ func (fd *LRFinder) FindLR(startLR, endLR float64, totalSteps int, opts ...LRFin
 		target := labelTs.MustDetach(true).MustTo(device, true)
 
 		logits := fd.Model.Module.ForwardT(input, true)
-		lossTs := fd.Criterion(logits, target)
+		lossTs := TaskLoss(fd.Model.Head, fd.Criterion)(logits, target)
 		if !lossTs.MustRequiresGrad() {
 			fmt.Printf("Reset loss required grad... done.\n")
 			lossTs.MustRequiresGrad_(true)
@@ -0,0 +1,304 @@
+package lab
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sugarme/gotch/dutil"
+	"github.com/sugarme/gotch/ts"
+)
+
+// Loader iterates a dataset in batches. It is implemented by dutil.DataLoader and ParallelLoader.
+type Loader interface {
+	HasNext() bool
+	Next() (interface{}, error)
+	Reset(shuffleOpt ...bool)
+	Len() int // number of samples to be iterated
+}
+
+// SeededDataset is a dataset that can load an item deterministically from a given seed,
+// i.e. random augmentation of the item is drawn from the seed.
+type SeededDataset interface {
+	dutil.Dataset
+	ItemSeeded(idx int, seed int64) (interface{}, error)
+}
+
+// ItemSeed returns seed of dataset item idx at an epoch derived from loader seed.
+func ItemSeed(seed int64, epoch, idx int) int64 {
+	// splitmix64 finalizer
+	z := uint64(seed) + uint64(epoch+1)*0x9E3779B97F4A7C15 + uint64(idx)*0xD1B54A32D192ED03
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	z ^= z >> 31
+	return int64(z >> 1)
+}
+
+type ParallelLoaderOptions struct {
+	NumWorkers int   // number of goroutines loading items
+	Prefetch   int   // number of batches loaded ahead
+	Seed       int64 // seed of per-item seeds for SeededDataset
+}
+
+type ParallelLoaderOption func(*ParallelLoaderOptions)
+
+func defaultParallelLoaderOptions() *ParallelLoaderOptions {
+	return &ParallelLoaderOptions{
+		NumWorkers: runtime.NumCPU(),
+		Prefetch:   2,
+		Seed:       0,
+	}
+}
+
+func WithNumWorkers(n int) ParallelLoaderOption {
+	return func(o *ParallelLoaderOptions) {
+		o.NumWorkers = n
+	}
+}
+
+func WithPrefetch(n int) ParallelLoaderOption {
+	return func(o *ParallelLoaderOptions) {
+		o.Prefetch = n
+	}
+}
+
+func WithLoaderSeed(seed int64) ParallelLoaderOption {
+	return func(o *ParallelLoaderOptions) {
+		o.Seed = seed
+	}
+}
+
+// ParallelLoader loads items of batches concurrently in a pool of worker goroutines and
+// prefetches batches ahead of training loop.
+//
+// Batches are returned in sampler order regardless of which worker loads which item. If dataset
+// implements SeededDataset, each item is loaded with ItemSeed(seed, epoch, idx) so that
+// augmentation does not depend on goroutine scheduling.
+//
+// Workers start at the first Next() of an epoch and stop after the last batch is dispatched,
+// on Reset() or on Close(). Close() should be called if iteration stops before the end of an
+// epoch, i.e. early stopping.
+type ParallelLoader struct {
+	data      dutil.Dataset
+	sampler   dutil.Sampler
+	opts      *ParallelLoaderOptions
+	indexes   []int
+	batchSize int
+	epoch     int
+	consumed  int // number of batches returned in current epoch
+
+	running bool
+	batches chan *loaderBatch
+	quit    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// loaderBatch holds items of a batch being loaded.
+type loaderBatch struct {
+	items   []interface{}
+	errs    []error
+	pending int32
+	done    chan struct{} // closed when all items are loaded
+}
+
+type loaderJob struct {
+	batch *loaderBatch
+	pos   int // position in batch
+	idx   int // dataset index
+}
+
+// NewParallelLoader creates a ParallelLoader iterating data in order of sampler indexes.
+func NewParallelLoader(data dutil.Dataset, sampler dutil.Sampler, opts ...ParallelLoaderOption) (*ParallelLoader, error) {
+	options := defaultParallelLoaderOptions()
+	for _, o := range opts {
+		o(options)
+	}
+	if options.NumWorkers < 1 {
+		err := fmt.Errorf("NewParallelLoader failed: number of workers must be positive. Got %d", options.NumWorkers)
+		return nil, err
+	}
+	if options.Prefetch < 1 {
+		options.Prefetch = 1
+	}
+	if sampler.BatchSize() < 1 {
+		err := fmt.Errorf("NewParallelLoader failed: invalid batch size %d", sampler.BatchSize())
+		return nil, err
+	}
+
+	return &ParallelLoader{
+		data:      data,
+		sampler:   sampler,
+		opts:      options,
+		indexes:   sampler.Sample(),
+		batchSize: sampler.BatchSize(),
+	}, nil
+}
+
+// numBatches returns number of batches per epoch.
+func (l *ParallelLoader) numBatches() int {
+	return (len(l.indexes) + l.batchSize - 1) / l.batchSize
+}
+
+// HasNext implements Loader interface.
+func (l *ParallelLoader) HasNext() bool {
+	return l.consumed < l.numBatches()
+}
+
+// Len implements Loader interface.
+func (l *ParallelLoader) Len() int {
+	return len(l.indexes)
+}
+
+// Next implements Loader interface. It returns a slice of items of a batch.
+func (l *ParallelLoader) Next() (interface{}, error) {
+	if !l.HasNext() {
+		err := fmt.Errorf("ParallelLoader.Next - no more item to iterate")
+		return nil, err
+	}
+	if !l.running {
+		l.start()
+	}
+
+	b := <-l.batches
+	<-b.done
+	l.consumed++
+
+	for _, err := range b.errs {
+		if err != nil {
+			dropItems(b.items)
+			return nil, err
+		}
+	}
+
+	elemType := reflect.TypeOf(b.items[0])
+	items := reflect.MakeSlice(reflect.SliceOf(elemType), 0, len(b.items))
+	for _, item := range b.items {
+		items = reflect.Append(items, reflect.ValueOf(item))
+	}
+
+	return items.Interface(), nil
+}
+
+// Reset implements Loader interface. It stops loading of current epoch and redraws sampler
+// indexes if shuffle is true.
+func (l *ParallelLoader) Reset(shuffleOpt ...bool) {
+	l.stop()
+	if len(shuffleOpt) > 0 && shuffleOpt[0] {
+		l.indexes = l.sampler.Sample()
+	}
+	l.epoch++
+	l.consumed = 0
+}
+
+// Close stops worker goroutines and drops prefetched batches. It implements io.Closer interface.
+func (l *ParallelLoader) Close() error {
+	l.stop()
+	l.consumed = l.numBatches()
+	return nil
+}
+
+func (l *ParallelLoader) start() {
+	quit := make(chan struct{})
+	batches := make(chan *loaderBatch, l.opts.Prefetch)
+	jobs := make(chan loaderJob, l.batchSize)
+	l.quit, l.batches = quit, batches
+
+	seeded, isSeeded := l.data.(SeededDataset)
+	seed, epoch := l.opts.Seed, l.epoch
+	load := func(idx int) (interface{}, error) {
+		if isSeeded {
+			return seeded.ItemSeeded(idx, ItemSeed(seed, epoch, idx))
+		}
+		return l.data.Item(idx)
+	}
+
+	for w := 0; w < l.opts.NumWorkers; w++ {
+		l.wg.Add(1)
+		go func() {
+			defer l.wg.Done()
+			for job := range jobs {
+				select {
+				case <-quit:
+					// Skip loading. Batch will be dropped.
+				default:
+					job.batch.items[job.pos], job.batch.errs[job.pos] = load(job.idx)
+				}
+				if atomic.AddInt32(&job.batch.pending, -1) == 0 {
+					close(job.batch.done)
+				}
+			}
+		}()
+	}
+
+	// Dispatch batches in order. Channel capacity bounds number of batches loaded ahead.
+	indexes, batchSize, consumed := l.indexes, l.batchSize, l.consumed
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		defer close(batches)
+		defer close(jobs)
+		for start := consumed * batchSize; start < len(indexes); start += batchSize {
+			end := start + batchSize
+			if end > len(indexes) {
+				end = len(indexes)
+			}
+			b := &loaderBatch{
+				items:   make([]interface{}, end-start),
+				errs:    make([]error, end-start),
+				pending: int32(end - start),
+				done:    make(chan struct{}),
+			}
+			select {
+			case batches <- b:
+			case <-quit:
+				return
+			}
+			for i, idx := range indexes[start:end] {
+				select {
+				case jobs <- loaderJob{batch: b, pos: i, idx: idx}:
+				case <-quit:
+					return
+				}
+			}
+		}
+	}()
+
+	l.running = true
+}
+
+// stop signals workers to quit, waits for them and drops items of batches not consumed.
+func (l *ParallelLoader) stop() {
+	if !l.running {
+		return
+	}
+	close(l.quit)
+	l.wg.Wait()
+	for b := range l.batches {
+		dropItems(b.items)
+	}
+	l.running = false
+}
+
+// dropItems frees tensors of loaded items.
+func dropItems(items []interface{}) {
+	for _, item := range items {
+		switch v := item.(type) {
+		case []ts.Tensor:
+			for _, x := range v {
+				x.MustDrop()
+			}
+		case *ts.Tensor:
+			v.MustDrop()
+		}
+	}
+}
+
+// closeLoader closes loader if it is an io.Closer.
+func closeLoader(l Loader) {
+	if c, ok := l.(io.Closer); ok {
+		c.Close()
+	}
+}
@@ -0,0 +1,151 @@
+package lab
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sugarme/gotch/dutil"
+)
+
+// slowDataset loads items with random delay so that workers finish out of order.
+type slowDataset struct {
+	intDataset
+	loaded int32
+}
+
+func (d *slowDataset) Item(idx int) (interface{}, error) {
+	time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+	atomic.AddInt32(&d.loaded, 1)
+	if d.intDataset[idx] < 0 {
+		return nil, fmt.Errorf("bad item %d", idx)
+	}
+	return d.intDataset[idx], nil
+}
+
+type seededDataset struct {
+	intDataset
+}
+
+func (d seededDataset) ItemSeeded(idx int, seed int64) (interface{}, error) {
+	return rand.New(rand.NewSource(seed)).Int(), nil
+}
+
+func TestParallelLoader(t *testing.T) {
+	data := make(intDataset, 103)
+	for i := range data {
+		data[i] = i
+	}
+	sampler, err := NewShuffleSampler(len(data), 8, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loader, err := NewParallelLoader(&slowDataset{intDataset: data}, sampler, WithNumWorkers(4), WithPrefetch(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := NewShuffleSampler(len(data), 8, false, 1)
+	for epoch := 0; epoch < 3; epoch++ {
+		wantIndexes := want.Sample()
+		var got []int
+		nbatches := 0
+		for loader.HasNext() {
+			batch, err := loader.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, batch.([]int)...)
+			nbatches++
+		}
+		if nbatches != 13 {
+			t.Errorf("Want 13 batches, got %d\n", nbatches)
+		}
+		if !reflect.DeepEqual(got, wantIndexes) {
+			t.Fatalf("Epoch %d: batches are not in sampler order\n", epoch)
+		}
+		loader.Reset(true)
+	}
+}
+
+func TestParallelLoaderClose(t *testing.T) {
+	data := &slowDataset{intDataset: make(intDataset, 1000)}
+	sampler, _ := dutil.NewBatchSampler(data.Len(), 10, true, false)
+	before := runtime.NumGoroutine()
+
+	loader, err := NewParallelLoader(data, sampler, WithNumWorkers(4), WithPrefetch(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loader.Next(); err != nil {
+		t.Fatal(err)
+	}
+	loader.Close()
+	if loader.HasNext() {
+		t.Errorf("Want no more batch after Close")
+	}
+
+	// At most consumed batch, prefetched batches and one being dispatched are loaded.
+	if n := atomic.LoadInt32(&data.loaded); n > 10*(1+2+1) {
+		t.Errorf("Want at most 40 loaded items, got %d\n", n)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Worker goroutines leaked: %d before, %d after\n", before, after)
+	}
+}
+
+func TestParallelLoaderError(t *testing.T) {
+	data := intDataset{0, 1, 2, -1, 4, 5}
+	sampler, _ := dutil.NewBatchSampler(data.Len(), 2, true, false)
+	loader, err := NewParallelLoader(&slowDataset{intDataset: data}, sampler, WithNumWorkers(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loader.Close()
+
+	if _, err := loader.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loader.Next(); err == nil {
+		t.Errorf("Want error of bad item")
+	}
+}
+
+func TestParallelLoaderSeeded(t *testing.T) {
+	data := seededDataset{make(intDataset, 20)}
+	run := func(workers int) [][]int {
+		sampler, _ := dutil.NewBatchSampler(data.Len(), 4, true, false)
+		loader, err := NewParallelLoader(data, sampler, WithNumWorkers(workers), WithLoaderSeed(7))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer loader.Close()
+		var epochs [][]int
+		for e := 0; e < 2; e++ {
+			var items []int
+			for loader.HasNext() {
+				batch, err := loader.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				items = append(items, batch.([]int)...)
+			}
+			epochs = append(epochs, items)
+			loader.Reset()
+		}
+		return epochs
+	}
+
+	a, b := run(1), run(5)
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("Seeded items depend on number of workers")
+	}
+	if reflect.DeepEqual(a[0], a[1]) {
+		t.Errorf("Want different item seeds in different epochs")
+	}
+}
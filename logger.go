@@ -8,6 +8,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"sort"
 	"time"
 )
 
@@ -16,11 +17,15 @@ type Logger struct {
 	*log.Logger
 	logFile *os.File
 	slackURL string
+	trackers []Tracker
+	notifier *Notifier
 }
 
 type logOptions struct{
 	logFile string
 	slackURL string
+	trackers []Tracker
+	notifier *Notifier
 }
 
 type LoggerOption func(*logOptions)
@@ -43,6 +48,21 @@ func WithLoggerSlackURL(url string) LoggerOption{
 	}
 }
 
+// WithLoggerNotifier sets notifier for sending event notifications. If not set and
+// Slack URL is specified, a Slack notifier is created.
+func WithLoggerNotifier(n *Notifier) LoggerOption{
+	return func(o *logOptions){
+		o.notifier = n
+	}
+}
+
+// WithLoggerTrackers attaches experiment tracking backends to logger.
+func WithLoggerTrackers(trackers ...Tracker) LoggerOption{
+	return func(o *logOptions){
+		o.trackers = append(o.trackers, trackers...)
+	}
+}
+
 // NewLogger creates a new logger of combined stdout and file logger.
 func NewLogger(opts ...LoggerOption) (*Logger, error) {
 	var (
@@ -73,13 +93,97 @@ func NewLogger(opts ...LoggerOption) (*Logger, error) {
 		logger.SetOutput(mw)
 	}
 
-	l := &Logger{logger, f, options.slackURL}
+	notifier := options.notifier
+	if notifier == nil && options.slackURL != "" {
+		sink, err := NewWebhookSink(options.slackURL, "slack")
+		if err != nil {
+			return nil, err
+		}
+		notifier = NewNotifier([]Sink{sink})
+	}
+
+	l := &Logger{logger, f, options.slackURL, options.trackers, notifier}
 	return l, nil
 }
 
-// Close closes logger file.
+// Close closes logger file, sends pending notifications and ends runs of all trackers.
 func (l *Logger) Close() {
-	l.logFile.Close()
+	if err := l.notifier.Close(); err != nil {
+		l.Println(err)
+	}
+	l.notifier = nil
+	for _, t := range l.trackers {
+		if err := t.Close(); err != nil {
+			l.Printf("Logger - Close tracker failed: %v\n", err)
+		}
+	}
+	l.trackers = nil
+	if l.logFile != nil {
+		l.logFile.Close()
+	}
+}
+
+// AddTracker attaches an experiment tracking backend to logger.
+func (l *Logger) AddTracker(t Tracker) {
+	l.trackers = append(l.trackers, t)
+}
+
+// HasTracker returns whether logger has any tracking backend.
+func (l *Logger) HasTracker() bool {
+	return len(l.trackers) > 0
+}
+
+// LogParams sends hyperparameters to all trackers.
+func (l *Logger) LogParams(params map[string]interface{}) {
+	for _, t := range l.trackers {
+		if err := t.LogParams(params); err != nil {
+			l.Printf("Logger - Log params failed: %v\n", err)
+		}
+	}
+}
+
+// LogMetrics sends metrics at a step to all trackers. Metrics are not printed.
+func (l *Logger) LogMetrics(step int, metrics map[string]float64) {
+	for _, t := range l.trackers {
+		if err := t.LogMetrics(metrics, step); err != nil {
+			l.Printf("Logger - Log metrics failed: %v\n", err)
+		}
+	}
+}
+
+// LogArtifact sends a local file to all trackers.
+func (l *Logger) LogArtifact(filePath string) {
+	for _, t := range l.trackers {
+		if err := t.LogArtifact(filePath); err != nil {
+			l.Printf("Logger - Log artifact failed: %v\n", err)
+		}
+	}
+}
+
+// SetTags sets tags of runs of all trackers.
+func (l *Logger) SetTags(tags map[string]string) {
+	for _, t := range l.trackers {
+		if err := t.SetTags(tags); err != nil {
+			l.Printf("Logger - Set tags failed: %v\n", err)
+		}
+	}
+}
+
+// PrintMetrics prints metrics as a table sorted by metric name and returns printed message.
+func (l *Logger) PrintMetrics(metrics map[string]float64) string {
+	var keys []string
+	for k := range metrics {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var msg string
+	for _, k := range keys {
+		msg += fmt.Sprintf("%-60s| %0.4f\n", k, metrics[k])
+	}
+	l.Print(msg)
+
+	return msg
 }
 
 // HasSlack returns whether logger has Slack webhook url
@@ -91,7 +195,13 @@ type SlackRequestBody struct {
     Text string `json:"text"`
 }
 
-// SendSlack send notification to Slack if being configured.
+// Notify sends notification of an event asynchronously if logger has a notifier.
+func (l *Logger) Notify(event, msg string) {
+	l.notifier.Notify(event, msg)
+}
+
+// SendSlack send notification to Slack if being configured. It blocks until Slack
+// responds. Use Notify for non-blocking notifications.
 func(l *Logger) SendSlack(msg string) error{
 	if !l.HasSlack(){
 		err := fmt.Errorf("No Slack webhook URL configured for the logger.")
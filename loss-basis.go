@@ -1,14 +1,60 @@
 package lab
 
 import (
+	"github.com/sugarme/gotch"
 	"github.com/sugarme/gotch/ts"
 )
 
 // CrossEntropyLoss calculates cross entropy loss.
+//
+// Target is either class indexes of shape [B] or soft targets of shape [B, C], i.e. from MixUp/CutMix.
 func CrossEntropyLoss(logits, target *ts.Tensor) *ts.Tensor {
+	if isSoftTarget(target) {
+		return SoftCrossEntropyLoss(logits, target)
+	}
 	return logits.CrossEntropyForLogits(target)
 }
 
+// SoftCrossEntropyLoss calculates cross entropy loss with soft targets.
+//
+// - logits: tensor of shape [B, C]
+// - target: class probabilities of shape [B, C]
+func SoftCrossEntropyLoss(logits, target *ts.Tensor) *ts.Tensor {
+	logProbs := logits.MustLogSoftmax(-1, gotch.Float, false)
+	loss := logProbs.MustMul(target, true).
+		MustSumDimIntlist([]int64{-1}, false, gotch.Float, true).
+		MustNeg(true).
+		MustMean(gotch.Float, true)
+	return loss
+}
+
+// DistillationLoss calculates KL divergence of student from teacher class probabilities softened
+// at temperature, averaged over batch and scaled by temperature^2 so that gradients keep their
+// magnitude across temperatures. See "Distilling the Knowledge in a Neural Network", Hinton et al 2015
+// (https://arxiv.org/abs/1503.02531).
+//
+// - logits: student logits of shape [B, C]
+// - teacherLogits: teacher logits of shape [B, C]
+func DistillationLoss(logits, teacherLogits *ts.Tensor, temperature float64) *ts.Tensor {
+	logProbs := logits.MustDivScalar(ts.FloatScalar(temperature), false).MustLogSoftmax(-1, gotch.Float, true)
+	teacherLogProbs := teacherLogits.MustDivScalar(ts.FloatScalar(temperature), false).MustLogSoftmax(-1, gotch.Float, true)
+	teacherProbs := teacherLogProbs.MustExp(false)
+	batchSize := float64(logits.MustSize()[0])
+	loss := teacherLogProbs.MustSub(logProbs, true).
+		MustMul(teacherProbs, true).
+		MustSum(gotch.Float, true).
+		MustMulScalar(ts.FloatScalar(temperature*temperature/batchSize), true)
+	logProbs.MustDrop()
+	teacherProbs.MustDrop()
+	return loss
+}
+
+// isSoftTarget returns whether target holds class probabilities rather than class indexes.
+func isSoftTarget(target *ts.Tensor) bool {
+	dtype := target.DType()
+	return target.Dim() == 2 && (dtype == gotch.Float || dtype == gotch.Double || dtype == gotch.Half)
+}
+
 // BCELoss calculates a binary cross entropy loss.
 //
 // - logits: tensor of shape [B, C, H, W] corresponding the raw output of the model.
@@ -0,0 +1,81 @@
+package lab
+
+import (
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/ts"
+
+	lib "github.com/sugarme/lab/model"
+)
+
+// taskOutput is logits and target of a task. Name is empty for a single-task head.
+type taskOutput struct {
+	name   string
+	logits *ts.Tensor
+	target *ts.Tensor
+}
+
+// isMultiTask returns whether head has named tasks (see model.WithTasks).
+func isMultiTask(head *lib.Head) bool {
+	if head == nil {
+		return false
+	}
+	tasks := head.Tasks()
+	return len(tasks) > 0 && tasks[0].Name != ""
+}
+
+// splitTasks splits logits by tasks of head (see Head.Split) and target of shape [B, ntasks] by
+// columns. Logits and target are returned as is if head is not multi-task. Otherwise, returned
+// tensors are views to be deleted with dropTasks.
+func splitTasks(head *lib.Head, logits, target *ts.Tensor) []taskOutput {
+	if !isMultiTask(head) {
+		return []taskOutput{{logits: logits, target: target}}
+	}
+
+	tasks := head.Tasks()
+	// Trainer squeezes stacked targets, i.e. to [ntasks] of batch size 1.
+	target2D := target.MustView([]int64{-1, int64(len(tasks))}, false)
+	outputs := head.Split(logits)
+	out := make([]taskOutput, len(tasks))
+	for i, task := range tasks {
+		out[i] = taskOutput{
+			name:   task.Name,
+			logits: outputs[task.Name],
+			target: target2D.MustSelect(1, int64(i), false),
+		}
+	}
+	target2D.MustDrop()
+	return out
+}
+
+// dropTasks deletes views of splitTasks.
+func dropTasks(head *lib.Head, outputs []taskOutput) {
+	if !isMultiTask(head) {
+		return
+	}
+	for _, o := range outputs {
+		o.logits.MustDrop()
+		o.target.MustDrop()
+	}
+}
+
+// TaskLoss returns loss of a multi-task head: mean of criterion of tasks on logits split by
+// Head.Split and target of class indices of shape [B, ntasks]. It returns criterion if head is
+// not multi-task.
+func TaskLoss(head *lib.Head, criterion LossFunc) LossFunc {
+	if !isMultiTask(head) {
+		return criterion
+	}
+	return func(logits, target *ts.Tensor) *ts.Tensor {
+		outputs := splitTasks(head, logits, target)
+		defer dropTasks(head, outputs)
+		losses := make([]ts.Tensor, len(outputs))
+		for i, o := range outputs {
+			losses[i] = *criterion(o.logits, o.target)
+		}
+		loss := ts.MustStack(losses, 0).MustMean(gotch.Float, true)
+		for i := range losses {
+			losses[i].MustDrop()
+		}
+		return loss
+	}
+}
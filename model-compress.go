@@ -0,0 +1,210 @@
+package lab
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/ts"
+
+	lib "github.com/sugarme/lab/model"
+)
+
+// Model compression for CPU inference:
+// ===================================
+// Pruning zeroes weights before fine-tuning with Trainer (`train.params.prune`); Trainer keeps
+// pruned weights zero. Pruned weights and channels are masked, not removed, so pruning alone
+// does not make a model smaller or faster. SimulateQuantization reports valid metrics and CPU
+// latency of a trained model before and after post-training dynamic int8 quantization of the
+// head; backbone layers stay float. Model.QuantizeDynamic converts the head to int8 kernels and
+// Model.SaveQuantized saves it with int8 weights for deployment.
+
+// Prune prunes model weights as specified. Excluded stages are resolved by StagePrefixes.
+func (m *Model) Prune(cfg PruneConfig) (*lib.Pruner, error) {
+	var exclude []string
+	for _, spec := range cfg.Exclude {
+		prefixes, err := StagePrefixes(m.Name, spec)
+		if err != nil {
+			err = fmt.Errorf("Prune failed: %w", err)
+			return nil, err
+		}
+		exclude = append(exclude, prefixes...)
+	}
+
+	pruner, err := lib.Prune(m.Weights, cfg.Sparsity, lib.WithPruneMethod(cfg.Method), lib.WithPruneExclude(exclude...))
+	if err != nil {
+		err = fmt.Errorf("Prune failed: %w", err)
+		return nil, err
+	}
+	return pruner, nil
+}
+
+// QuantizeReport compares valid metrics and CPU latency of a model before and after dynamic
+// quantization of the head.
+type QuantizeReport struct {
+	ValidMetric   string             // name of valid metric of evaluator
+	Before        map[string]float64 // Evaluator metrics with valid metric "vm" and "loss"
+	After         map[string]float64
+	ValidBefore   float64
+	ValidAfter    float64
+	LossBefore    float64
+	LossAfter     float64
+	LatencyBefore time.Duration // mean forward time of a batch on CPU
+	LatencyAfter  time.Duration
+}
+
+// Delta returns change of metric after quantization.
+func (r *QuantizeReport) Delta(metric string) float64 {
+	return r.After[metric] - r.Before[metric]
+}
+
+// Speedup returns latency before over latency after quantization.
+func (r *QuantizeReport) Speedup() float64 {
+	if r.LatencyAfter == 0 {
+		return 0
+	}
+	return float64(r.LatencyBefore) / float64(r.LatencyAfter)
+}
+
+// String formats report as a table of valid metric, loss and other metrics followed by latency.
+func (r *QuantizeReport) String() string {
+	var names []string
+	for k := range r.Before {
+		if k != "vm" && k != "loss" {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Quantization: dynamic int8 of head linear layers, backbone float\n")
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Metric\tBefore\tAfter\tDelta")
+	fmt.Fprintf(w, "%s (valid)\t%.4f\t%.4f\t%+.4f\n", r.ValidMetric, r.ValidBefore, r.ValidAfter, r.ValidAfter-r.ValidBefore)
+	fmt.Fprintf(w, "loss\t%.4f\t%.4f\t%+.4f\n", r.LossBefore, r.LossAfter, r.LossAfter-r.LossBefore)
+	for _, k := range names {
+		fmt.Fprintf(w, "%s\t%.4f\t%.4f\t%+.4f\n", k, r.Before[k], r.After[k], r.Delta(k))
+	}
+	w.Flush()
+	fmt.Fprintf(&b, "Latency per batch (head-only dynamic quantization): %v -> %v (x%.2f)\n", r.LatencyBefore, r.LatencyAfter, r.Speedup())
+
+	return b.String()
+}
+
+// SimulateQuantization estimates valid metrics of a classification model after post-training
+// dynamic int8 quantization of head linear layers on CPU, as Model.QuantizeDynamic. It reports
+// metrics of evaluator and latency on the first batch of loader before and after, and restores
+// the float model, so m is unchanged. Backbone layers stay float, so latency measures head-only
+// quantization. Evaluator runs on CPU.
+func SimulateQuantization(m *Model, loader Loader, evaluator *Evaluator, criterion LossFunc) (*QuantizeReport, error) {
+	switch {
+	case m.Weights.Device() != gotch.CPU:
+		err := fmt.Errorf("SimulateQuantization failed: expected model on CPU, got %v", m.Weights.Device())
+		return nil, err
+	case m.Head == nil:
+		err := fmt.Errorf("SimulateQuantization failed: dynamic quantization requires a classification head")
+		return nil, err
+	}
+
+	loader.Reset()
+	input, err := loaderInput(loader)
+	if err != nil {
+		err = fmt.Errorf("SimulateQuantization failed: %w", err)
+		return nil, err
+	}
+	defer input.MustDrop()
+
+	cuda := evaluator.CUDA
+	evaluator.CUDA = false
+	defer func() { evaluator.CUDA = cuda }()
+
+	r := &QuantizeReport{ValidMetric: evaluator.ValidMetric.Name()}
+	r.Before, r.ValidBefore, r.LossBefore = evaluator.evaluate(m.Module, m.Head, criterion, evaluator.Epoch)
+	r.LatencyBefore = measureLatency(m.Module, input, 10)
+
+	restore, err := m.Head.SimulateDynamicQuantization()
+	if err != nil {
+		err = fmt.Errorf("SimulateQuantization failed: %w", err)
+		return nil, err
+	}
+	defer restore()
+
+	r.After, r.ValidAfter, r.LossAfter = evaluator.evaluate(m.Module, m.Head, criterion, evaluator.Epoch)
+	r.LatencyAfter = measureLatency(m.Module, input, 10)
+
+	return r, nil
+}
+
+// QuantizeDynamic converts linear layers of the head of a classification model on CPU to int8
+// kernels in place for inference (see model.Head.QuantizeDynamic). Backbone layers stay float.
+func (m *Model) QuantizeDynamic() error {
+	switch {
+	case m.Head == nil:
+		err := fmt.Errorf("QuantizeDynamic failed: expected a classification head")
+		return err
+	case m.Weights.Device() != gotch.CPU:
+		err := fmt.Errorf("QuantizeDynamic failed: expected model on CPU, got %v", m.Weights.Device())
+		return err
+	}
+	return m.Head.QuantizeDynamic()
+}
+
+// SaveQuantized saves a model converted by QuantizeDynamic to a safetensors file with int8
+// weights of head linear layers (see model.SaveQuantized). Backbone name is stored as metadata
+// "backbone".
+func (m *Model) SaveQuantized(file string) error {
+	if m.Head == nil {
+		err := fmt.Errorf("SaveQuantized failed: expected a classification head")
+		return err
+	}
+	return lib.SaveQuantized(file, m.Weights, m.Head, map[string]string{"backbone": m.Name})
+}
+
+// LoadQuantized loads a file of SaveQuantized into a classification model on CPU, running
+// head linear layers on int8 kernels.
+func (m *Model) LoadQuantized(file string) error {
+	if m.Head == nil {
+		err := fmt.Errorf("LoadQuantized failed: expected a classification head")
+		return err
+	}
+	_, err := lib.LoadQuantized(file, m.Weights, m.Head)
+	return err
+}
+
+// loaderInput returns next batch of images of loader stacked on CPU.
+func loaderInput(loader Loader) (*ts.Tensor, error) {
+	dataItem, err := loader.Next()
+	if err != nil {
+		return nil, err
+	}
+	items := dataItem.([][]ts.Tensor)
+	batch := make([]ts.Tensor, len(items))
+	for i, item := range items {
+		batch[i] = item[0]
+	}
+	input := ts.MustStack(batch, 0).MustTo(gotch.CPU, true)
+	for _, item := range items {
+		for i := range item {
+			item[i].MustDrop()
+		}
+	}
+	return input, nil
+}
+
+// measureLatency returns mean time of n forward passes of module on input in evaluation mode,
+// after a warm-up pass.
+func measureLatency(module ts.ModuleT, input *ts.Tensor, n int) time.Duration {
+	var elapsed time.Duration
+	ts.NoGrad(func() {
+		module.ForwardT(input, false).MustDrop()
+		start := time.Now()
+		for i := 0; i < n; i++ {
+			module.ForwardT(input, false).MustDrop()
+		}
+		elapsed = time.Since(start)
+	})
+	return elapsed / time.Duration(n)
+}
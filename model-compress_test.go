@@ -0,0 +1,211 @@
+package lab
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+	lib "github.com/sugarme/lab/model"
+)
+
+func TestPrune(t *testing.T) {
+	// zeros counts zero elements of variable.
+	zeros := func(vs *nn.VarStore, name string) int64 {
+		x := vs.Variables()[name]
+		n := x.MustEq(ts.FloatScalar(0), false).MustSum(gotch.Int64, true)
+		defer n.MustDrop()
+		return n.Int64Values()[0]
+	}
+	newModel := func() *Model {
+		vs := nn.NewVarStore(gotch.CPU)
+		p := vs.Root().Sub("layer1").Sub("0")
+		config := nn.DefaultConv2DConfig()
+		config.Bias = false
+		nn.NewConv2D(p.Sub("conv1"), 2, 4, 3, config)
+		bnConfig := nn.DefaultBatchNormConfig()
+		bnConfig.WsInit = nn.NewConstInit(1.0)
+		bnConfig.BsInit = nn.NewConstInit(1.0)
+		nn.BatchNorm2D(p.Sub("bn1"), 4, bnConfig)
+		nn.NewLinear(vs.Root().Sub("head").Sub("fc"), 4, 3, nn.DefaultLinearConfig())
+		return &Model{Name: "resnet18", Weights: vs}
+	}
+
+	m := newModel()
+	pruner, err := m.Prune(PruneConfig{Method: "magnitude", Sparsity: 0.5, Exclude: []string{"head"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := zeros(m.Weights, "layer1.0.conv1.weight"); got != 36 {
+		t.Errorf("Want 36 of 72 conv weights pruned, got %d\n", got)
+	}
+	if got := zeros(m.Weights, "head.fc.weight"); got != 0 {
+		t.Errorf("Want excluded head not pruned, got %d zeros\n", got)
+	}
+
+	// Masks are re-applied after weights are updated, e.g. by optimizer.
+	ts.NoGrad(func() {
+		x := m.Weights.Variables()["layer1.0.conv1.weight"]
+		x.MustFill_(ts.FloatScalar(1.0))
+	})
+	pruner.Apply()
+	if got := zeros(m.Weights, "layer1.0.conv1.weight"); got != 36 {
+		t.Errorf("Want 36 conv weights zero after Apply, got %d\n", got)
+	}
+	if z, total := pruner.Zeros(); z != 36 || total != 72 {
+		t.Errorf("Want 36 of 72 zeros, got %d of %d\n", z, total)
+	}
+	pruner.Drop()
+
+	// Channel pruning zeroes filters with batch norm weight and bias.
+	m = newModel()
+	pruner, err = m.Prune(PruneConfig{Method: "channel", Sparsity: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pruner.Drop()
+	if got := zeros(m.Weights, "layer1.0.conv1.weight"); got != 2*18 {
+		t.Errorf("Want 2 of 4 filters pruned, got %d zero weights\n", got)
+	}
+	for _, name := range []string{"layer1.0.bn1.weight", "layer1.0.bn1.bias"} {
+		if got := zeros(m.Weights, name); got != 2 {
+			t.Errorf("Want 2 channels of %s pruned, got %d\n", name, got)
+		}
+	}
+
+	if _, err := m.Prune(PruneConfig{Method: "random", Sparsity: 0.5}); err == nil {
+		t.Errorf("Want error for invalid method\n")
+	}
+}
+
+func TestQuantize(t *testing.T) {
+	// relErr returns relative L2 error of got to want.
+	relErr := func(got, want *ts.Tensor) float64 {
+		diff := got.MustSub(want, false).MustNorm(true)
+		norm := want.MustNorm(false)
+		defer diff.MustDrop()
+		defer norm.MustDrop()
+		return diff.Float64Values()[0] / norm.Float64Values()[0]
+	}
+
+	// Dynamic quantization of head linear layers.
+	vs := nn.NewVarStore(gotch.CPU)
+	head, err := lib.NewHead(vs.Root().Sub("head"), 64, 10, lib.WithHiddenLayers([]int64{32}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := ts.MustRandn([]int64{8, 64, 2, 2}, gotch.Float, gotch.CPU)
+	ts.NoGrad(func() {
+		want := head.ForwardT(x, false)
+		restore, err := head.SimulateDynamicQuantization()
+		if err != nil {
+			t.Skipf("fbgemm int8 kernels not supported: %v", err)
+		}
+		got := head.ForwardT(x, false)
+		if e := relErr(got, want); e > 0.05 {
+			t.Errorf("Want dynamic quantized head output close to float output, got relative error %v\n", e)
+		}
+		restore()
+		restored := head.ForwardT(x, false)
+		if e := relErr(restored, want); e != 0 {
+			t.Errorf("Want float head output after restore, got relative error %v\n", e)
+		}
+		restored.MustDrop()
+		got.MustDrop()
+		want.MustDrop()
+	})
+	x.MustDrop()
+
+	r := &QuantizeReport{
+		ValidMetric:   "accuracy",
+		Before:        map[string]float64{"vm": 0.9, "loss": 0.3, "f1": 0.8},
+		After:         map[string]float64{"vm": 0.88, "loss": 0.35, "f1": 0.75},
+		ValidBefore:   0.9,
+		ValidAfter:    0.88,
+		LossBefore:    0.3,
+		LossAfter:     0.35,
+		LatencyBefore: 30 * time.Millisecond,
+		LatencyAfter:  20 * time.Millisecond,
+	}
+	if d := r.Delta("vm"); d > -0.0199 || d < -0.0201 {
+		t.Errorf("Want vm delta -0.02, got %v\n", d)
+	}
+	if got := r.Speedup(); got != 1.5 {
+		t.Errorf("Want speedup 1.5, got %v\n", got)
+	}
+	s := r.String()
+	for _, want := range []string{"accuracy (valid)", "-0.0200", "+0.0500", "f1", "-0.0500", "head-only dynamic quantization): 30ms -> 20ms (x1.50)"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("Want report containing %q, got:\n%s", want, s)
+		}
+	}
+	if strings.Contains(s, "vm") {
+		t.Errorf("Want report without vm row, got:\n%s", s)
+	}
+}
+
+func TestSaveQuantized(t *testing.T) {
+	newModel := func() *Model {
+		vs := nn.NewVarStore(gotch.CPU)
+		head, err := lib.NewHead(vs.Root().Sub("head"), 64, 10, lib.WithHiddenLayers([]int64{32}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Model{Name: "resnet18", Module: head, Weights: vs, Head: head}
+	}
+	m := newModel()
+	file := filepath.Join(t.TempDir(), "model.safetensors")
+	if err := m.SaveQuantized(file); err == nil {
+		t.Errorf("Want error for model not quantized\n")
+	}
+	if err := m.QuantizeDynamic(); err != nil {
+		t.Skipf("fbgemm int8 kernels not supported: %v", err)
+	}
+	if err := m.SaveQuantized(file); err != nil {
+		t.Fatal(err)
+	}
+
+	// Linear weights are saved in int8 with their scale.
+	tensors, metadata, err := lib.ReadSafetensors(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"head.hidden.0.weight", "head.fc.weight"} {
+		if x, ok := tensors[name]; !ok || x.DType() != gotch.Int8 {
+			t.Errorf("Want int8 variable %q\n", name)
+		}
+		if _, ok := tensors[strings.TrimSuffix(name, ".weight")+".weight_scale"]; !ok {
+			t.Errorf("Want scale of %q\n", name)
+		}
+	}
+	if got := tensors["head.fc.bias"].DType(); got != gotch.Float {
+		t.Errorf("Want float bias, got %v\n", got)
+	}
+	if got := metadata["backbone"]; got != "resnet18" {
+		t.Errorf("Want metadata backbone resnet18, got %q\n", got)
+	}
+	for _, x := range tensors {
+		x.MustDrop()
+	}
+
+	// Loaded model runs the same int8 layers.
+	loaded := newModel()
+	if err := loaded.LoadQuantized(file); err != nil {
+		t.Fatal(err)
+	}
+	x := ts.MustRandn([]int64{8, 64, 2, 2}, gotch.Float, gotch.CPU)
+	defer x.MustDrop()
+	ts.NoGrad(func() {
+		want := m.Module.ForwardT(x, false)
+		got := loaded.Module.ForwardT(x, false)
+		diff := got.MustSub(want, true).MustAbs(true).MustMax(true)
+		if d := diff.Float64Values()[0]; d > 1e-5 {
+			t.Errorf("Want loaded quantized output equal to saved model output, got max difference %v\n", d)
+		}
+		diff.MustDrop()
+		want.MustDrop()
+	})
+}
@@ -0,0 +1,333 @@
+package lab
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	lib "github.com/sugarme/lab/model"
+)
+
+// Backbone stages:
+// ================
+// A stage is a named group of VarStore variables sharing a path prefix
+// (e.g. "layer1" of ResNet, "_blocks.3" of EfficientNet, "features.denseblock1"
+// of DenseNet). Stages can be frozen at start and unfrozen at given epochs.
+
+// stageAliases maps model class (value of ModelZoo) to stage aliases and
+// their VarStore path prefixes.
+var stageAliases map[string]map[string][]string = map[string]map[string][]string{
+	"ResNet":   resNetStages,
+	"ResNeXt":  resNetStages,
+	"SEResNet": resNetStages,
+	"EffNet": {
+		"stem":   {"_conv_stem", "_bn0"},
+		"blocks": {"_blocks"},
+		"head":   {"_conv_head", "_bn1", "_fc"},
+	},
+	"DenseNet": {
+		"stem":        {"features.conv0", "features.norm0"},
+		"denseblock1": {"features.denseblock1"},
+		"denseblock2": {"features.denseblock2"},
+		"denseblock3": {"features.denseblock3"},
+		"denseblock4": {"features.denseblock4"},
+		"transition1": {"features.transition1"},
+		"transition2": {"features.transition2"},
+		"transition3": {"features.transition3"},
+		"head":        {"features.norm5", "classifier"},
+	},
+	"MobileNet": {
+		"stem":     {"features.0"},
+		"features": {"features"},
+		"head":     {"classifier"},
+	},
+	"VGG": {
+		"features": {"features"},
+		"head":     {"classifier"},
+	},
+	"RegNet": {
+		"stem":   {"stem"},
+		"block1": {"trunk_output.block1"},
+		"block2": {"trunk_output.block2"},
+		"block3": {"trunk_output.block3"},
+		"block4": {"trunk_output.block4"},
+		"head":   {"fc"},
+	},
+	"ConvNeXt": {
+		"stem":        {"features.0"},
+		"stage1":      {"features.1"},
+		"downsample1": {"features.2"},
+		"stage2":      {"features.3"},
+		"downsample2": {"features.4"},
+		"stage3":      {"features.5"},
+		"downsample3": {"features.6"},
+		"stage4":      {"features.7"},
+		"head":        {"classifier"},
+	},
+}
+
+// resNetStages are stage aliases of ResNet and its variants.
+var resNetStages map[string][]string = map[string][]string{
+	"stem":   {"conv1", "bn1"},
+	"layer1": {"layer1"},
+	"layer2": {"layer2"},
+	"layer3": {"layer3"},
+	"layer4": {"layer4"},
+	"head":   {"fc"},
+}
+
+// StagePrefixes resolves a stage spec to VarStore path prefixes for given backbone.
+//
+// A stage spec can be:
+// - a stage alias of the backbone class, e.g. "stem", "layer1", "head".
+// - a VarStore path prefix, e.g. "layer3", "_blocks.3", "features.denseblock1".
+// - either of above followed by ":N" to select the first N numbered sub-modules,
+// e.g. "blocks:5" or "_blocks:5" select EfficientNet blocks 0 to 4.
+func StagePrefixes(backbone string, spec string) ([]string, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		err := fmt.Errorf("StagePrefixes failed: empty stage spec")
+		return nil, err
+	}
+
+	name := spec
+	count := -1
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		n, err := strconv.Atoi(spec[i+1:])
+		if err != nil || n < 0 {
+			err = fmt.Errorf("StagePrefixes failed: invalid stage spec %q. Expected format 'name:N'", spec)
+			return nil, err
+		}
+		name = spec[:i]
+		count = n
+	}
+
+	prefixes := []string{name}
+	if aliases, ok := stageAliases[ModelZoo[backbone]]; ok {
+		if p, ok := aliases[name]; ok {
+			prefixes = p
+		}
+	}
+
+	if count < 0 {
+		return prefixes, nil
+	}
+
+	var retVal []string
+	for _, p := range prefixes {
+		for i := 0; i < count; i++ {
+			retVal = append(retVal, fmt.Sprintf("%s.%d", p, i))
+		}
+	}
+
+	return retVal, nil
+}
+
+// hasPrefix checks whether variable name belongs to module path prefix.
+func hasPrefix(name, prefix string) bool {
+	return name == prefix || strings.HasPrefix(name, prefix+".")
+}
+
+// inStages returns whether variable name belongs to any of input prefixes.
+func inStages(name string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if hasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveStages resolves stage specs to prefixes and checks that each of them
+// matches at least one model variable.
+func (m *Model) resolveStages(stages []string) ([]string, error) {
+	vars := m.Weights.Variables()
+	var prefixes []string
+	for _, s := range stages {
+		ps, err := StagePrefixes(m.Name, s)
+		if err != nil {
+			return nil, err
+		}
+		if s == "head" && m.Head != nil {
+			ps = m.headPrefixes(ps)
+		}
+		for _, p := range ps {
+			found := false
+			for name := range vars {
+				if hasPrefix(name, p) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				err := fmt.Errorf("Stage %q (prefix %q) does not match any variable of model %q", s, p, m.Name)
+				return nil, err
+			}
+		}
+		prefixes = append(prefixes, ps...)
+	}
+
+	return prefixes, nil
+}
+
+// headPrefixes returns prefixes of "head" stage alias of a model with head: alias prefixes
+// matching model variables and prefixes of head. Heads with custom layers have variables
+// under "head" instead of the original classifier (see model.NewClassifierHead).
+func (m *Model) headPrefixes(aliases []string) []string {
+	vars := m.Weights.Variables()
+	var prefixes []string
+	for _, p := range aliases {
+		for name := range vars {
+			if hasPrefix(name, p) {
+				prefixes = append(prefixes, p)
+				break
+			}
+		}
+	}
+	for _, p := range m.Head.Prefixes() {
+		if !inStages(p, prefixes) {
+			prefixes = append(prefixes, p)
+		}
+	}
+
+	return prefixes
+}
+
+// FreezeStages freezes variables of specified stages. Frozen stages stay frozen
+// after switching model between evaluation and training mode until they are unfrozen
+// by UnfreezeStages.
+//
+// NOTE. Frozen variables are still registered with the optimizer. They get no gradient
+// and therefore are skipped when updating weights.
+func (m *Model) FreezeStages(stages ...string) error {
+	prefixes, err := m.resolveStages(stages)
+	if err != nil {
+		err = fmt.Errorf("FreezeStages failed: %w", err)
+		return err
+	}
+
+	if m.frozen == nil {
+		m.frozen = make(map[string]bool)
+	}
+	for _, p := range prefixes {
+		m.frozen[p] = true
+	}
+
+	m.applyFrozen()
+	return nil
+}
+
+// UnfreezeStages unfreezes variables of specified stages.
+func (m *Model) UnfreezeStages(stages ...string) error {
+	prefixes, err := m.resolveStages(stages)
+	if err != nil {
+		err = fmt.Errorf("UnfreezeStages failed: %w", err)
+		return err
+	}
+
+	for _, p := range prefixes {
+		delete(m.frozen, p)
+	}
+
+	// NOTE. Variables still under other frozen stages are frozen again.
+	m.setRequiresGrad(prefixes, true)
+	m.applyFrozen()
+
+	return nil
+}
+
+// FrozenStages returns sorted VarStore path prefixes of frozen stages.
+func (m *Model) FrozenStages() []string {
+	var prefixes []string
+	for p := range m.frozen {
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+
+	return prefixes
+}
+
+// setRequiresGrad enables or disables gradient of parameters under prefixes, or of all
+// parameters if prefixes is nil. Buffers such as batchnorm running stats are skipped.
+//
+// NOTE. VarStore.Unfreeze can't be used as it returns after the first parameter.
+func (m *Model) setRequiresGrad(prefixes []string, v bool) {
+	for name, x := range m.Weights.Variables() {
+		if lib.IsBuffer(name) || (prefixes != nil && !inStages(name, prefixes)) {
+			continue
+		}
+		x.MustRequiresGrad_(v)
+	}
+}
+
+// applyFrozen disables gradient of variables under frozen stages.
+func (m *Model) applyFrozen() {
+	if len(m.frozen) == 0 {
+		return
+	}
+	prefixes := m.FrozenStages()
+	for name, x := range m.Weights.Variables() {
+		if inStages(name, prefixes) {
+			x.MustRequiresGrad_(false)
+		}
+	}
+}
+
+// ParamCounts returns number of trainable and frozen parameters of the model.
+// Buffers such as batchnorm running stats are not counted.
+//
+// NOTE. It should be called in training mode as Model.Eval freezes all parameters.
+func (m *Model) ParamCounts() (trainable, frozen int64) {
+	for name, x := range m.Weights.Variables() {
+		if lib.IsBuffer(name) {
+			continue
+		}
+		n := int64(1)
+		for _, d := range x.MustSize() {
+			n *= d
+		}
+		if x.MustRequiresGrad() {
+			trainable += n
+		} else {
+			frozen += n
+		}
+	}
+
+	return trainable, frozen
+}
+
+// ParamReport returns a summary of trainable and frozen parameters.
+func (m *Model) ParamReport() string {
+	trainable, frozen := m.ParamCounts()
+	total := trainable + frozen
+	var pct float64
+	if total > 0 {
+		pct = float64(trainable) / float64(total) * 100
+	}
+	msg := fmt.Sprintf("Parameters: %d total - %d trainable (%0.2f%%) - %d frozen\n", total, trainable, pct, frozen)
+	if stages := m.FrozenStages(); len(stages) > 0 {
+		msg += fmt.Sprintf("Frozen stages: %v\n", stages)
+	}
+
+	return msg
+}
+
+// UnfreezeConfig specifies stages to unfreeze at an epoch.
+type UnfreezeConfig struct {
+	Epoch  int      `yaml:"epoch"`
+	Stages []string `yaml:"stages"`
+}
+
+// StagesToUnfreeze returns stages scheduled to unfreeze at or before input epoch, so that
+// training resumed after a scheduled epoch still unfreezes its stages.
+func StagesToUnfreeze(schedule []UnfreezeConfig, epoch int) []string {
+	var stages []string
+	for _, s := range schedule {
+		if s.Epoch <= epoch {
+			stages = append(stages, s.Stages...)
+		}
+	}
+
+	return stages
+}
@@ -0,0 +1,108 @@
+package lab
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/nn"
+	lib "github.com/sugarme/lab/model"
+)
+
+func TestStagePrefixes(t *testing.T) {
+	tests := []struct {
+		backbone string
+		spec     string
+		want     []string
+	}{
+		{"resnet34", "layer1", []string{"layer1"}},
+		{"resnet50", "stem", []string{"conv1", "bn1"}},
+		{"efficientnet_b0", "blocks:3", []string{"_blocks.0", "_blocks.1", "_blocks.2"}},
+		{"efficientnet_b0", "_blocks:2", []string{"_blocks.0", "_blocks.1"}},
+		{"densenet121", "features.denseblock1", []string{"features.denseblock1"}},
+		{"densenet121", "head", []string{"features.norm5", "classifier"}},
+	}
+
+	for _, tt := range tests {
+		got, err := StagePrefixes(tt.backbone, tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(tt.want, got) {
+			t.Errorf("%s - %q: Want %v\n", tt.backbone, tt.spec, tt.want)
+			t.Errorf("%s - %q: Got %v\n", tt.backbone, tt.spec, got)
+		}
+	}
+
+	_, err := StagePrefixes("efficientnet_b0", "blocks:x")
+	if err == nil {
+		t.Errorf("Want error for invalid stage spec\n")
+	}
+}
+
+func TestStagesToUnfreeze(t *testing.T) {
+	schedule := []UnfreezeConfig{
+		{Epoch: 2, Stages: []string{"layer3"}},
+		{Epoch: 4, Stages: []string{"layer1", "layer2"}},
+	}
+
+	got := StagesToUnfreeze(schedule, 4)
+	want := []string{"layer3", "layer1", "layer2"}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Want: %v\n", want)
+		t.Errorf("Got: %v\n", got)
+	}
+
+	// Resumed training past a scheduled epoch still unfreezes its stages.
+	if got, want := StagesToUnfreeze(schedule, 3), []string{"layer3"}; !reflect.DeepEqual(want, got) {
+		t.Errorf("Want %v at epoch 3. Got: %v\n", want, got)
+	}
+	if got := StagesToUnfreeze(schedule, 1); len(got) != 0 {
+		t.Errorf("Want no stages at epoch 1. Got: %v\n", got)
+	}
+}
+
+func TestUnfreezeStages(t *testing.T) {
+	vs := nn.NewVarStore(gotch.CPU)
+	for _, layer := range []string{"layer1", "layer2"} {
+		p := vs.Root().Sub(layer).Sub("0")
+		config := nn.DefaultConv2DConfig()
+		config.Bias = false
+		nn.NewConv2D(p.Sub("conv1"), 2, 4, 3, config)                // 72 params
+		nn.BatchNorm2D(p.Sub("bn1"), 4, nn.DefaultBatchNormConfig()) // 8 params, 9 buffer values
+	}
+	m := &Model{Name: "resnet18", Weights: vs}
+
+	if err := m.FreezeStages("layer1", "layer2"); err != nil {
+		t.Fatal(err)
+	}
+	if trainable, frozen := m.ParamCounts(); trainable != 0 || frozen != 160 {
+		t.Errorf("Want 0 trainable and 160 frozen params, got %d and %d\n", trainable, frozen)
+	}
+
+	// All params of unfrozen stage track gradient again, also after switching mode.
+	if err := m.UnfreezeStages("layer1"); err != nil {
+		t.Fatal(err)
+	}
+	m.Eval()
+	m.Train()
+	if trainable, frozen := m.ParamCounts(); trainable != 80 || frozen != 80 {
+		t.Errorf("Want 80 trainable and 80 frozen params, got %d and %d\n", trainable, frozen)
+	}
+	for name, x := range vs.Variables() {
+		want := strings.HasPrefix(name, "layer1") && !lib.IsBuffer(name)
+		if got := x.MustRequiresGrad(); got != want {
+			t.Errorf("%s: want requires grad %v, got %v\n", name, want, got)
+		}
+	}
+}
+
+func TestHasPrefix(t *testing.T) {
+	if !hasPrefix("layer1.0.conv1.weight", "layer1") {
+		t.Errorf("Want 'layer1.0.conv1.weight' in stage 'layer1'\n")
+	}
+	if hasPrefix("layer10.weight", "layer1") {
+		t.Errorf("Want 'layer10.weight' not in stage 'layer1'\n")
+	}
+}
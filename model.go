@@ -3,6 +3,8 @@ package lab
 import (
 	"github.com/sugarme/gotch/nn"
 	"github.com/sugarme/gotch/ts"
+
+	lib "github.com/sugarme/lab/model"
 )
 
 var ModelZoo map[string]string = map[string]string{
@@ -30,11 +32,58 @@ var ModelZoo map[string]string = map[string]string{
 	"resnet101": "ResNet",
 	"resnet152": "ResNet",
 
+	"resnext50_32x4d":  "ResNeXt",
+	"resnext101_32x8d": "ResNeXt",
+	"resnext101_64x4d": "ResNeXt",
+	"wide_resnet50_2":  "ResNeXt",
+	"wide_resnet101_2": "ResNeXt",
+
+	"seresnet18":         "SEResNet",
+	"seresnet34":         "SEResNet",
+	"seresnet50":         "SEResNet",
+	"seresnet101":        "SEResNet",
+	"seresnet152":        "SEResNet",
+	"seresnext50_32x4d":  "SEResNet",
+	"seresnext101_32x8d": "SEResNet",
+
 	"densenet121": "DenseNet",
 	"densenet161": "DenseNet",
 	"densenet169": "DenseNet",
 	"densenet201": "DenseNet",
 
+	"mobilenet_v2":       "MobileNet",
+	"mobilenet_v3_large": "MobileNet",
+	"mobilenet_v3_small": "MobileNet",
+
+	"vgg11":    "VGG",
+	"vgg11_bn": "VGG",
+	"vgg13":    "VGG",
+	"vgg13_bn": "VGG",
+	"vgg16":    "VGG",
+	"vgg16_bn": "VGG",
+	"vgg19":    "VGG",
+	"vgg19_bn": "VGG",
+
+	"regnet_y_400mf": "RegNet",
+	"regnet_y_800mf": "RegNet",
+	"regnet_y_1_6gf": "RegNet",
+	"regnet_y_3_2gf": "RegNet",
+	"regnet_y_8gf":   "RegNet",
+	"regnet_y_16gf":  "RegNet",
+	"regnet_y_32gf":  "RegNet",
+	"regnet_x_400mf": "RegNet",
+	"regnet_x_800mf": "RegNet",
+	"regnet_x_1_6gf": "RegNet",
+	"regnet_x_3_2gf": "RegNet",
+	"regnet_x_8gf":   "RegNet",
+	"regnet_x_16gf":  "RegNet",
+	"regnet_x_32gf":  "RegNet",
+
+	"convnext_tiny":  "ConvNeXt",
+	"convnext_small": "ConvNeXt",
+	"convnext_base":  "ConvNeXt",
+	"convnext_large": "ConvNeXt",
+
 	"resnet34_unet": "UNet",
 }
 
@@ -43,6 +92,12 @@ type Model struct {
 	Name    string
 	Module  ts.ModuleT
 	Weights *nn.VarStore
+	Head    *lib.Head  // classification head. Nil for other models.
+	Layers  lib.Layers // named layers of classification models including head, for Summary. Nil for other models.
+
+	Previous map[string]string // metadata of weights of `train.load_previous` loaded by BuildModel
+
+	frozen map[string]bool // VarStore path prefixes of frozen stages
 }
 
 // Eval set model to evaluation mode
@@ -50,7 +105,8 @@ func (m *Model) Eval() {
 	m.Weights.Freeze()
 }
 
-// Train set model to training mode
+// Train set model to training mode. Frozen stages (see FreezeStages) stay frozen.
 func (m *Model) Train() {
-	m.Weights.Unfreeze()
+	m.setRequiresGrad(nil, true)
+	m.applyFrozen()
 }
@@ -1 +1,372 @@
 package model
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+// Backbone is a feature extractor returning feature maps of its stages, to build detection,
+// segmentation or metric learning heads on.
+//
+// Backbone variables are named as in its classification model so that pretrained
+// classification weights can be loaded to backbone.
+type Backbone interface {
+	// ForwardFeatures returns output of each stage, from the shallowest. Caller
+	// owns returned tensors.
+	ForwardFeatures(x *ts.Tensor, train bool) []*ts.Tensor
+
+	// Channels returns number of channels of each stage output.
+	Channels() []int64
+
+	// Strides returns stride of each stage output relative to input.
+	Strides() []int64
+}
+
+type BackboneOptions struct {
+	OutStages []int // indices of stages whose outputs are returned. Default all.
+}
+
+type BackboneOption func(*BackboneOptions)
+
+func defaultBackboneOptions() *BackboneOptions {
+	return &BackboneOptions{}
+}
+
+// WithOutStages selects stages whose outputs are returned, e.g. 2, 3, 4 for strides 8, 16
+// and 32. Stages after the last selected one are skipped in forward pass.
+func WithOutStages(stages ...int) BackboneOption {
+	return func(o *BackboneOptions) {
+		o.OutStages = stages
+	}
+}
+
+// NewBackbone creates a backbone of 5 stages with strides 2, 4, 8, 16 and 32 for ResNet,
+// ResNeXt, Wide ResNet, SE-ResNet, EfficientNet and DenseNet.
+func NewBackbone(p *nn.Path, backbone string, opts ...BackboneOption) (Backbone, error) {
+	options := defaultBackboneOptions()
+	for _, o := range opts {
+		o(options)
+	}
+
+	var (
+		b   *stagedBackbone
+		err error
+	)
+	_, isResNeXt := resNeXtConfigs[backbone]
+	_, isSEResNet := seResNetConfigs[backbone]
+	switch {
+	case strings.HasPrefix(backbone, "resnet"), isResNeXt, isSEResNet:
+		b, err = resNetBackbone(p, backbone)
+	case strings.Contains(backbone, "efficientnet_b"):
+		b, err = effNetBackbone(p, backbone)
+	case strings.HasPrefix(backbone, "densenet"):
+		b, err = denseNetBackbone(p, backbone)
+	default:
+		err = fmt.Errorf("unsupported backbone %q", backbone)
+	}
+	if err != nil {
+		err = fmt.Errorf("NewBackbone failed: %w", err)
+		return nil, err
+	}
+
+	if len(options.OutStages) > 0 {
+		if err := b.selectStages(options.OutStages); err != nil {
+			err = fmt.Errorf("NewBackbone failed: %w", err)
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+// stagedBackbone applies stages sequentially and returns output of selected stages.
+type stagedBackbone struct {
+	stages   []ts.ModuleT
+	names    []string   // stage names. Optional.
+	prefixes [][]string // variable path prefixes of each stage. Optional.
+	channels []int64
+	strides  []int64
+	out      []bool // whether output of stage is returned. Nil if all.
+}
+
+// selectStages keeps stages up to the last selected one and returns only selected outputs.
+func (b *stagedBackbone) selectStages(stages []int) error {
+	out := make([]bool, len(b.stages))
+	last := -1
+	for _, i := range stages {
+		if i < 0 || i >= len(b.stages) {
+			err := fmt.Errorf("invalid stage %d. Expected stage in range [0, %d]", i, len(b.stages)-1)
+			return err
+		}
+		out[i] = true
+		if i > last {
+			last = i
+		}
+	}
+
+	var channels, strides []int64
+	for i := 0; i <= last; i++ {
+		if out[i] {
+			channels = append(channels, b.channels[i])
+			strides = append(strides, b.strides[i])
+		}
+	}
+	b.stages = b.stages[:last+1]
+	if b.names != nil {
+		b.names = b.names[:last+1]
+		b.prefixes = b.prefixes[:last+1]
+	}
+	b.out = out[:last+1]
+	b.channels = channels
+	b.strides = strides
+
+	return nil
+}
+
+// ForwardFeatures implements Backbone.
+func (b *stagedBackbone) ForwardFeatures(x *ts.Tensor, train bool) []*ts.Tensor {
+	var features []*ts.Tensor
+	var prev *ts.Tensor // output of previous stage if not returned
+	in := x
+	for i, stage := range b.stages {
+		out := stage.ForwardT(in, train)
+		if prev != nil {
+			prev.MustDrop()
+			prev = nil
+		}
+		if b.out == nil || b.out[i] {
+			features = append(features, out)
+		} else {
+			prev = out
+		}
+		in = out
+	}
+	if prev != nil {
+		prev.MustDrop()
+	}
+
+	return features
+}
+
+// layers returns stages as named layers.
+func (b *stagedBackbone) layers() Layers {
+	layers := make(Layers, len(b.stages))
+	for i, stage := range b.stages {
+		layers[i] = Layer{Name: fmt.Sprintf("stage%d", i), Module: stage}
+		if b.names != nil {
+			layers[i].Name, layers[i].Prefixes = b.names[i], b.prefixes[i]
+		}
+	}
+	return layers
+}
+
+// Channels implements Backbone.
+func (b *stagedBackbone) Channels() []int64 {
+	return b.channels
+}
+
+// Strides implements Backbone.
+func (b *stagedBackbone) Strides() []int64 {
+	return b.strides
+}
+
+// resNetBackbone returns ResNet stem before max pooling and outputs of layer1 to layer4.
+func resNetBackbone(p *nn.Path, backbone string) (*stagedBackbone, error) {
+	// layer returns i-th layer of given input channels and its output channels.
+	var layer func(i int, cIn int64) (ts.ModuleT, int64)
+
+	var (
+		layers     [4]int64
+		bottleneck bool
+	)
+	switch backbone {
+	case "resnet18":
+		layers = [4]int64{2, 2, 2, 2}
+	case "resnet34":
+		layers = [4]int64{3, 4, 6, 3}
+	case "resnet50":
+		layers, bottleneck = [4]int64{3, 4, 6, 3}, true
+	case "resnet101":
+		layers, bottleneck = [4]int64{3, 4, 23, 3}, true
+	case "resnet152":
+		layers, bottleneck = [4]int64{3, 8, 36, 3}, true
+	default:
+		config, ok := resNeXtConfigs[backbone]
+		if !ok {
+			config, ok = seResNetConfigs[backbone]
+		}
+		if !ok {
+			err := fmt.Errorf("resNetBackbone failed: unsupported backbone %q", backbone)
+			return nil, err
+		}
+		layer = func(i int, cIn int64) (ts.ModuleT, int64) {
+			return resNetVLayer(p, i, cIn, config)
+		}
+	}
+	if layer == nil {
+		layer = func(i int, cIn int64) (ts.ModuleT, int64) {
+			path := p.Sub(fmt.Sprintf("layer%d", i+1))
+			planes := int64(64) << i
+			stride := int64(2)
+			if i == 0 {
+				stride = 1
+			}
+			if bottleneck {
+				return bottleneckLayer(path, cIn, planes, stride, layers[i]), 4 * planes
+			}
+			return basicLayer(path, cIn, planes, stride, layers[i]), planes
+		}
+	}
+
+	stem := nn.SeqT()
+	stem.Add(conv2dNoBias(p.Sub("conv1"), 3, 64, 7, 3, 2))
+	stem.Add(nn.BatchNorm2D(p.Sub("bn1"), 64, nn.DefaultBatchNormConfig()))
+	stem.AddFn(nn.NewFunc(relu))
+
+	b := &stagedBackbone{
+		stages:   []ts.ModuleT{stem},
+		names:    []string{"stem"},
+		prefixes: [][]string{{"conv1", "bn1"}},
+		channels: []int64{64},
+		strides:  []int64{2, 4, 8, 16, 32},
+	}
+	cIn := int64(64)
+	for i := 0; i < 4; i++ {
+		stage := nn.SeqT()
+		if i == 0 {
+			stage.AddFn(nn.NewFunc(func(xs *ts.Tensor) *ts.Tensor {
+				return xs.MustMaxPool2d([]int64{3, 3}, []int64{2, 2}, []int64{1, 1}, []int64{1, 1}, false, false)
+			}))
+		}
+		var l ts.ModuleT
+		l, cIn = layer(i, cIn)
+		stage.Add(l)
+		name := fmt.Sprintf("layer%d", i+1)
+		b.stages = append(b.stages, stage)
+		b.names = append(b.names, name)
+		b.prefixes = append(b.prefixes, []string{name})
+		b.channels = append(b.channels, cIn)
+	}
+
+	return b, nil
+}
+
+// effNetBackbone returns EfficientNet outputs of the last blocks at each stride.
+func effNetBackbone(p *nn.Path, backbone string) (*stagedBackbone, error) {
+	fn, ok := effNetParams[strings.TrimSuffix(strings.TrimPrefix(backbone, "tf_"), "_ns")]
+	if !ok {
+		err := fmt.Errorf("effNetBackbone failed: unsupported backbone %q", backbone)
+		return nil, err
+	}
+	params := fn()
+
+	bnConfig := nn.DefaultBatchNormConfig()
+	bnConfig.Momentum = 1.0 - batchNormMomentum
+	bnConfig.Eps = batchNormEpsilon
+	convS2Config := nn.DefaultConv2DConfig()
+	convS2Config.Stride = []int64{2, 2}
+	convS2Config.Bias = false
+
+	stemC := params.roundFilters(32)
+	stem := nn.SeqT()
+	stem.Add(enConv2d(p.Sub("_conv_stem"), 3, stemC, 3, convS2Config, false))
+	stem.Add(nn.BatchNorm2D(p.Sub("_bn0"), stemC, bnConfig))
+	stem.AddFn(nn.NewFunc(func(xs *ts.Tensor) *ts.Tensor {
+		return xs.Swish()
+	}))
+
+	// A stage ends before the next block group with stride 2.
+	groups, channels := blockGroups(p.Sub("_blocks"), params)
+	args := blockArgs()
+	b := &stagedBackbone{strides: []int64{2, 4, 8, 16, 32}}
+	stage := nn.SeqT()
+	stage.Add(stem)
+	for i, g := range groups {
+		stage.Add(g)
+		if i == len(groups)-1 || args[i+1].Stride != 1 {
+			b.stages = append(b.stages, stage)
+			b.channels = append(b.channels, channels[i])
+			stage = nn.SeqT()
+		}
+	}
+
+	return b, nil
+}
+
+// denseNetBackbone returns DenseNet stem before max pooling and outputs of dense blocks
+// after batch norm and ReLU of transitions (norm5 for the last one).
+func denseNetBackbone(p *nn.Path, backbone string) (*stagedBackbone, error) {
+	var (
+		cIn, growth int64
+		blockConfig []int64
+	)
+	switch backbone {
+	case "densenet121":
+		cIn, growth, blockConfig = 64, 32, []int64{6, 12, 24, 16}
+	case "densenet161":
+		cIn, growth, blockConfig = 96, 48, []int64{6, 12, 36, 24}
+	case "densenet169":
+		cIn, growth, blockConfig = 64, 32, []int64{6, 12, 32, 32}
+	case "densenet201":
+		cIn, growth, blockConfig = 64, 32, []int64{6, 12, 48, 32}
+	default:
+		err := fmt.Errorf("denseNetBackbone failed: unsupported backbone %q", backbone)
+		return nil, err
+	}
+	const bnSize = 4
+
+	fp := p.Sub("features")
+	stem := nn.SeqT()
+	stem.Add(dnConv2d(fp.Sub("conv0"), 3, cIn, 7, 3, 2))
+	stem.Add(nn.BatchNorm2D(fp.Sub("norm0"), cIn, nn.DefaultBatchNormConfig()))
+	stem.AddFn(nn.NewFunc(relu))
+
+	b := &stagedBackbone{
+		stages:   []ts.ModuleT{stem},
+		names:    []string{"stem"},
+		prefixes: [][]string{{"features.conv0", "features.norm0"}},
+		channels: []int64{cIn},
+		strides:  []int64{2, 4, 8, 16, 32},
+	}
+	nfeat := cIn
+	for i, nlayers := range blockConfig {
+		stage := nn.SeqT()
+		if i == 0 {
+			stage.AddFn(nn.NewFunc(func(xs *ts.Tensor) *ts.Tensor {
+				return xs.MustMaxPool2d([]int64{3, 3}, []int64{2, 2}, []int64{1, 1}, []int64{1, 1}, false, false)
+			}))
+		} else {
+			// Convolution and pooling of previous transition.
+			stage.Add(dnConv2d(fp.Sub(fmt.Sprintf("transition%v", i)).Sub("conv"), nfeat, nfeat/2, 1, 0, 1))
+			stage.AddFn(nn.NewFunc(func(xs *ts.Tensor) *ts.Tensor {
+				// AvgPool2DDefault sums as it overrides divisor to 1.
+				return xs.MustAvgPool2d([]int64{2, 2}, []int64{2, 2}, []int64{0, 0}, false, true, nil, false)
+			}))
+			nfeat = nfeat / 2
+		}
+
+		stage.Add(denseBlock(fp.Sub(fmt.Sprintf("denseblock%v", 1+i)), nfeat, bnSize, growth, nlayers))
+		nfeat += nlayers * growth
+
+		norm := fp.Sub("norm5")
+		if i+1 != len(blockConfig) {
+			norm = fp.Sub(fmt.Sprintf("transition%v", 1+i)).Sub("norm")
+		}
+		stage.Add(nn.BatchNorm2D(norm, nfeat, nn.DefaultBatchNormConfig()))
+		stage.AddFn(nn.NewFunc(relu))
+
+		prefixes := []string{fmt.Sprintf("features.denseblock%v", 1+i), strings.Join(norm.Paths(), ".")}
+		if i > 0 {
+			prefixes = append(prefixes, fmt.Sprintf("features.transition%v.conv", i))
+		}
+		b.stages = append(b.stages, stage)
+		b.names = append(b.names, fmt.Sprintf("denseblock%v", 1+i))
+		b.prefixes = append(b.prefixes, prefixes)
+		b.channels = append(b.channels, nfeat)
+	}
+
+	return b, nil
+}
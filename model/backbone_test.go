@@ -0,0 +1,83 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+func TestBackboneStages(t *testing.T) {
+	tests := []struct {
+		backbone string
+		channels []int64
+	}{
+		{"resnet18", []int64{64, 64, 128, 256, 512}},
+		{"resnet50", []int64{64, 256, 512, 1024, 2048}},
+		{"efficientnet_b0", []int64{16, 24, 40, 112, 320}},
+		{"densenet121", []int64{64, 256, 512, 1024, 1024}},
+	}
+	allStrides := []int64{2, 4, 8, 16, 32}
+
+	const h, w = 64, 96
+	x := ts.MustRandn([]int64{1, 3, h, w}, gotch.Float, gotch.CPU)
+	defer x.MustDrop()
+
+	// check compares channels, strides and shapes of features with want channels and strides.
+	check := func(name string, b Backbone, channels, strides []int64) {
+		if !reflect.DeepEqual(b.Channels(), channels) {
+			t.Errorf("%s: want channels %v, got %v\n", name, channels, b.Channels())
+		}
+		if !reflect.DeepEqual(b.Strides(), strides) {
+			t.Errorf("%s: want strides %v, got %v\n", name, strides, b.Strides())
+		}
+		features := b.ForwardFeatures(x, false)
+		if len(features) != len(channels) {
+			t.Errorf("%s: want %d feature maps, got %d\n", name, len(channels), len(features))
+		}
+		for i, f := range features {
+			if i < len(channels) {
+				want := []int64{1, channels[i], h / strides[i], w / strides[i]}
+				if got := f.MustSize(); !reflect.DeepEqual(got, want) {
+					t.Errorf("%s: want feature map %d of shape %v, got %v\n", name, i, want, got)
+				}
+			}
+			f.MustDrop()
+		}
+	}
+
+	for _, tt := range tests {
+		b, err := NewBackbone(nn.NewVarStore(gotch.CPU).Root(), tt.backbone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(tt.backbone, b, tt.channels, allStrides)
+
+		// Strides 8, 16 and 32.
+		b, err = NewBackbone(nn.NewVarStore(gotch.CPU).Root(), tt.backbone, WithOutStages(2, 3, 4))
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(tt.backbone+" stages 2-4", b, tt.channels[2:], allStrides[2:])
+
+		// Stages after the last selected one are skipped.
+		b, err = NewBackbone(nn.NewVarStore(gotch.CPU).Root(), tt.backbone, WithOutStages(3, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(b.(*stagedBackbone).stages); n != 4 {
+			t.Errorf("%s: want 4 stages run up to stage 3, got %d\n", tt.backbone, n)
+		}
+		check(tt.backbone+" stages 1, 3", b, []int64{tt.channels[1], tt.channels[3]}, []int64{4, 16})
+
+		if _, err := NewBackbone(nn.NewVarStore(gotch.CPU).Root(), tt.backbone, WithOutStages(5)); err == nil {
+			t.Errorf("%s: want error for invalid stage\n", tt.backbone)
+		}
+	}
+
+	if _, err := NewBackbone(nn.NewVarStore(gotch.CPU).Root(), "vgg16"); err == nil {
+		t.Errorf("Want error for unsupported backbone\n")
+	}
+}
@@ -0,0 +1,64 @@
+// Command checksums writes SHA-256, size and name of pretrained weight files (.bin and
+// .safetensors) of a mirror directory in the format of the embedded registry manifest.
+//
+//	go run ./model/cmd/checksums -dir /data/mirror/models > model/pretrained.sha256
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+func main() {
+	dir := flag.String("dir", "", "directory of pretrained weight files")
+	flag.Parse()
+	if *dir == "" {
+		flag.Usage()
+		log.Fatal("-dir is required")
+	}
+
+	var files []string
+	for _, pattern := range []string{"*.bin", "*.safetensors"} {
+		matches, err := filepath.Glob(filepath.Join(*dir, pattern))
+		if err != nil {
+			log.Fatal(err)
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+
+	fmt.Println("# SHA-256, size in bytes and name of pretrained weight files of the mirror (BucketPrefix),")
+	fmt.Println("# loaded into PretrainedModels. Files not listed here are not verified.")
+	fmt.Println("#")
+	fmt.Println("# <sha256>  <size>  <file>")
+	for _, file := range files {
+		sum, size, err := checksum(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s  %d  %s\n", sum, size, filepath.Base(file))
+	}
+}
+
+// checksum returns hex SHA-256 and size of file.
+func checksum(file string) (string, int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
@@ -0,0 +1,38 @@
+// Command convert converts a PyTorch state_dict (.pt/.pth) or safetensors file of torchvision,
+// timm or lukemelas/EfficientNet-PyTorch weights to a VarStore file loadable by lab models.
+//
+//	go run ./model/cmd/convert -in resnet50.pth -out resnet50.bin -backbone resnet50
+//	go run ./model/cmd/convert -in model.safetensors -out efficientnet_b0.bin -backbone efficientnet_b0 -source timm
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/sugarme/lab/model"
+)
+
+func main() {
+	var (
+		in       = flag.String("in", "", "input state_dict file (.pt, .pth or .safetensors)")
+		out      = flag.String("out", "", "output VarStore file (.bin, or .safetensors for safetensors format)")
+		backbone = flag.String("backbone", "", "backbone name of ModelZoo, e.g. resnet50, efficientnet_b0, densenet121, convnext_tiny")
+		source   = flag.String("source", "torchvision", fmt.Sprintf("source of parameter names: %s", strings.Join(model.StateDictSources, ", ")))
+	)
+	flag.Parse()
+	if *in == "" || *out == "" || *backbone == "" {
+		flag.Usage()
+		log.Fatal("-in, -out and -backbone are required")
+	}
+
+	report, err := model.ConvertPretrained(*in, *out, *backbone, *source)
+	if report != nil {
+		fmt.Print(report)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("saved %q\n", *out)
+}
@@ -0,0 +1,421 @@
+package model
+
+// Conversion of PyTorch state dicts to VarStore files.
+//
+// Parameter names of torchvision and timm checkpoints are mapped to VarStore paths of models
+// built by `lab.Builder.BuildModel`: backbone variables of `Features` and the original
+// classifier of `NewClassifierHead`.
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/pickle"
+	"github.com/sugarme/gotch/ts"
+)
+
+// StateDictSources are supported checkpoint naming conventions. "lukemelas" is
+// EfficientNet-PyTorch, whose names this repo uses.
+var StateDictSources []string = []string{"torchvision", "timm", "lukemelas"}
+
+// NameMapper maps a source parameter name to VarStore variable name. It returns false if
+// parameter should be skipped.
+type NameMapper func(name string) (string, bool)
+
+// LoadStateDict reads a PyTorch state dict saved by `torch.save()` (zip or legacy pickle
+// `.pt`/`.pth`) or a `.safetensors` file. Caller owns returned tensors.
+func LoadStateDict(file string) (map[string]*ts.Tensor, error) {
+	if IsSafetensors(file) {
+		tensors, _, err := ReadSafetensors(file)
+		if err != nil {
+			err = fmt.Errorf("LoadStateDict failed: %w", err)
+			return nil, err
+		}
+		return tensors, nil
+	}
+
+	tensors, err := pickle.Decode(file)
+	if err != nil {
+		err = fmt.Errorf("LoadStateDict failed: %w", err)
+		return nil, err
+	}
+	return tensors, nil
+}
+
+// NewNameMapper creates a NameMapper of source convention ("torchvision", "timm" or
+// "lukemelas") for ResNet, ResNeXt, SE-ResNet, EfficientNet and DenseNet backbones, and of
+// "torchvision" for MobileNet, VGG, RegNet and ConvNeXt backbones.
+// BatchNorm "num_batches_tracked" buffers are skipped.
+func NewNameMapper(backbone, source string) (NameMapper, error) {
+	var mapper NameMapper
+	_, isResNeXt := resNeXtConfigs[backbone]
+	_, isSEResNet := seResNetConfigs[backbone]
+	effNetFn, isEffNet := effNetParams[strings.TrimSuffix(strings.TrimPrefix(backbone, "tf_"), "_ns")]
+	_, isVGG := vggConfigs[strings.TrimSuffix(backbone, "_bn")]
+	_, isRegNet := regNetConfigs[backbone]
+	_, isConvNeXt := convNeXtConfigs[backbone]
+	switch {
+	case strings.HasPrefix(backbone, "resnet"), isResNeXt, isSEResNet:
+		if source != "torchvision" && source != "timm" {
+			break
+		}
+		// torchvision and timm ResNet names are the same as ours.
+		mapper = sameName
+	case strings.HasPrefix(backbone, "densenet"):
+		if source != "torchvision" && source != "timm" {
+			break
+		}
+		mapper = denseNetNameMapper
+	case strings.HasPrefix(backbone, "mobilenet"), isVGG, isRegNet, isConvNeXt:
+		if source != "torchvision" {
+			break
+		}
+		// Names follow torchvision (see testdata/torchvision-keys.json).
+		mapper = sameName
+	case isEffNet:
+		switch source {
+		case "lukemelas":
+			mapper = sameName
+		case "timm":
+			mapper = effNetTimmNameMapper(effNetFn())
+		case "torchvision":
+			mapper = effNetTorchvisionNameMapper(effNetFn())
+		}
+	default:
+		err := fmt.Errorf("NewNameMapper failed: unsupported backbone %q", backbone)
+		return nil, err
+	}
+	if mapper == nil {
+		err := fmt.Errorf("NewNameMapper failed: unsupported source %q for backbone %q", source, backbone)
+		return nil, err
+	}
+
+	return func(name string) (string, bool) {
+		if strings.HasSuffix(name, "num_batches_tracked") {
+			return "", false
+		}
+		return mapper(name)
+	}, nil
+}
+
+// sameName is a NameMapper keeping parameter names.
+func sameName(name string) (string, bool) {
+	return name, true
+}
+
+// Old torchvision DenseNet checkpoints name dense layer params e.g. "norm.1.weight".
+var denseLayerPattern = regexp.MustCompile(`^(.*denselayer\d+\.(?:norm|relu|conv))\.((?:[12])\.(?:weight|bias|running_mean|running_var))$`)
+
+func denseNetNameMapper(name string) (string, bool) {
+	if m := denseLayerPattern.FindStringSubmatch(name); m != nil {
+		name = m[1] + m[2]
+	}
+	return name, true
+}
+
+// effNetBlockOffsets returns index of the first "_blocks.N" block of each block group.
+func effNetBlockOffsets(params *params) []int {
+	var offsets []int
+	n := 0
+	for _, arg := range blockArgs() {
+		offsets = append(offsets, n)
+		n += int(params.roundRepeats(arg.NumRepeat))
+	}
+	return offsets
+}
+
+// effNetTimmNameMapper maps timm names "blocks.i.j.*" of stage i and block j.
+func effNetTimmNameMapper(params *params) NameMapper {
+	offsets := effNetBlockOffsets(params)
+	args := blockArgs()
+	top := map[string]string{
+		"conv_stem":  "_conv_stem",
+		"bn1":        "_bn0",
+		"conv_head":  "_conv_head",
+		"bn2":        "_bn1",
+		"classifier": "_fc",
+	}
+	// Depthwise separable block of expand ratio 1 and inverted residual block.
+	dsBlock := map[string]string{
+		"conv_dw":        "_depthwise_conv",
+		"bn1":            "_bn1",
+		"se.conv_reduce": "_se_reduce",
+		"se.conv_expand": "_se_expand",
+		"conv_pw":        "_project_conv",
+		"bn2":            "_bn2",
+	}
+	irBlock := map[string]string{
+		"conv_pw":        "_expand_conv",
+		"bn1":            "_bn0",
+		"conv_dw":        "_depthwise_conv",
+		"bn2":            "_bn1",
+		"se.conv_reduce": "_se_reduce",
+		"se.conv_expand": "_se_expand",
+		"conv_pwl":       "_project_conv",
+		"bn3":            "_bn2",
+	}
+
+	return func(name string) (string, bool) {
+		module, param := splitParam(name)
+		if v, ok := top[module]; ok {
+			return v + "." + param, true
+		}
+
+		parts := strings.SplitN(module, ".", 4)
+		if len(parts) != 4 || parts[0] != "blocks" {
+			return name, true
+		}
+		stage, err1 := strconv.Atoi(parts[1])
+		block, err2 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil || stage >= len(offsets) {
+			return name, true
+		}
+		layers := irBlock
+		if args[stage].ExpandRatio == 1 {
+			layers = dsBlock
+		}
+		layer, ok := layers[parts[3]]
+		if !ok {
+			return name, true
+		}
+		return fmt.Sprintf("_blocks.%d.%s.%s", offsets[stage]+block, layer, param), true
+	}
+}
+
+// effNetTorchvisionNameMapper maps torchvision names "features.{i+1}.j.block.k.*" of block
+// group i and block j. Stem is "features.0" and head convolution the last features.
+func effNetTorchvisionNameMapper(params *params) NameMapper {
+	offsets := effNetBlockOffsets(params)
+	args := blockArgs()
+	last := strconv.Itoa(len(args) + 1)
+	convBn := func(conv, bn, sub string) (string, bool) {
+		switch sub {
+		case "0":
+			return conv, true
+		case "1":
+			return bn, true
+		}
+		return "", false
+	}
+
+	return func(name string) (string, bool) {
+		module, param := splitParam(name)
+		parts := strings.Split(module, ".")
+		if parts[0] == "classifier" && len(parts) == 2 {
+			return "_fc." + param, true
+		}
+		if parts[0] != "features" || len(parts) < 3 {
+			return name, true
+		}
+		switch {
+		case parts[1] == "0" && len(parts) == 3:
+			if v, ok := convBn("_conv_stem", "_bn0", parts[2]); ok {
+				return v + "." + param, true
+			}
+		case parts[1] == last && len(parts) == 3:
+			if v, ok := convBn("_conv_head", "_bn1", parts[2]); ok {
+				return v + "." + param, true
+			}
+		case len(parts) == 6 && parts[3] == "block":
+			group, err1 := strconv.Atoi(parts[1])
+			block, err2 := strconv.Atoi(parts[2])
+			k, err3 := strconv.Atoi(parts[4])
+			if err1 != nil || err2 != nil || err3 != nil || group < 1 || group > len(args) {
+				return name, true
+			}
+			// Without expansion, block layers start from depthwise convolution.
+			if args[group-1].ExpandRatio == 1 {
+				k++
+			}
+			var layer string
+			var ok bool
+			switch k {
+			case 0:
+				layer, ok = convBn("_expand_conv", "_bn0", parts[5])
+			case 1:
+				layer, ok = convBn("_depthwise_conv", "_bn1", parts[5])
+			case 2:
+				layer, ok = map[string]string{"fc1": "_se_reduce", "fc2": "_se_expand"}[parts[5]]
+			case 3:
+				layer, ok = convBn("_project_conv", "_bn2", parts[5])
+			}
+			if ok {
+				return fmt.Sprintf("_blocks.%d.%s.%s", offsets[group-1]+block, layer, param), true
+			}
+		}
+		return name, true
+	}
+}
+
+// splitParam splits "module.path.weight" into module path and parameter name.
+func splitParam(name string) (string, string) {
+	i := strings.LastIndex(name, ".")
+	if i < 0 {
+		return "", name
+	}
+	return name[:i], name[i+1:]
+}
+
+// ShapeMismatch is a parameter whose shape differs from its VarStore variable.
+type ShapeMismatch struct {
+	Name   string
+	Source []int64
+	Target []int64
+}
+
+// ConvertReport lists result of loading a state dict into a VarStore.
+type ConvertReport struct {
+	Loaded     []string        // VarStore variables loaded
+	Missing    []string        // VarStore variables without source parameter
+	Unexpected []string        // source parameters (original names) without VarStore variable
+	Mismatched []ShapeMismatch // VarStore variables not loaded because of shape mismatch
+	Skipped    []string        // source parameters skipped by mapper
+}
+
+// String formats report as a summary line followed by lists of problems.
+func (r *ConvertReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "loaded: %d, missing: %d, unexpected: %d, mismatched: %d, skipped: %d\n",
+		len(r.Loaded), len(r.Missing), len(r.Unexpected), len(r.Mismatched), len(r.Skipped))
+	for _, name := range r.Missing {
+		fmt.Fprintf(&b, "missing: %s\n", name)
+	}
+	for _, name := range r.Unexpected {
+		fmt.Fprintf(&b, "unexpected: %s\n", name)
+	}
+	for _, m := range r.Mismatched {
+		fmt.Fprintf(&b, "mismatched: %s - source %v, target %v\n", m.Name, m.Source, m.Target)
+	}
+	return b.String()
+}
+
+// ConvertStateDict copies tensors of state dict to VarStore variables of mapped names. It
+// does not delete state dict tensors.
+func ConvertStateDict(vs *nn.VarStore, stateDict map[string]*ts.Tensor, mapper NameMapper) *ConvertReport {
+	return matchStateDict(vs, stateDict, mapper, true)
+}
+
+// matchStateDict matches state dict tensors to VarStore variables of mapped names and copies
+// them if load is true.
+func matchStateDict(vs *nn.VarStore, stateDict map[string]*ts.Tensor, mapper NameMapper, load bool) *ConvertReport {
+	report := &ConvertReport{}
+	vars := vs.Variables()
+	mapped := make(map[string]bool, len(stateDict))
+
+	names := make([]string, 0, len(stateDict))
+	for name := range stateDict {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		target, ok := mapper(name)
+		if !ok {
+			report.Skipped = append(report.Skipped, name)
+			continue
+		}
+		v, ok := vars[target]
+		if !ok {
+			report.Unexpected = append(report.Unexpected, name)
+			continue
+		}
+		mapped[target] = true
+
+		src := stateDict[name]
+		srcShape, dstShape := src.MustSize(), v.MustSize()
+		if !equalShape(srcShape, dstShape) {
+			report.Mismatched = append(report.Mismatched, ShapeMismatch{target, srcShape, dstShape})
+			continue
+		}
+		if load {
+			ts.NoGrad(func() {
+				v.Copy_(src)
+			})
+		}
+		report.Loaded = append(report.Loaded, target)
+	}
+
+	for name := range vars {
+		if !mapped[name] {
+			report.Missing = append(report.Missing, name)
+		}
+	}
+	sort.Strings(report.Missing)
+
+	return report
+}
+
+func equalShape(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ConvertPretrained converts a PyTorch or safetensors checkpoint of backbone from source
+// convention ("torchvision", "timm" or "lukemelas") to a VarStore file loadable by
+// `BuildModel` with `pretrained_path`. The classifier is kept with number of classes of the
+// checkpoint if present. Output is in safetensors format if outFile has ".safetensors" extension.
+func ConvertPretrained(inFile, outFile, backbone, source string) (*ConvertReport, error) {
+	mapper, err := NewNameMapper(backbone, source)
+	if err != nil {
+		err = fmt.Errorf("ConvertPretrained failed: %w", err)
+		return nil, err
+	}
+	stateDict, err := LoadStateDict(inFile)
+	if err != nil {
+		err = fmt.Errorf("ConvertPretrained failed: %w", err)
+		return nil, err
+	}
+	defer func() {
+		for _, x := range stateDict {
+			x.MustDrop()
+		}
+	}()
+
+	vs := nn.NewVarStore(gotch.CPU)
+	_, channels, err := Features(vs.Root(), backbone)
+	if err != nil {
+		err = fmt.Errorf("ConvertPretrained failed: %w", err)
+		return nil, err
+	}
+	// Final layer name of the original classifier.
+	probe, err := NewClassifierHead(nn.NewVarStore(gotch.CPU).Root(), backbone, channels, 1)
+	if err != nil {
+		err = fmt.Errorf("ConvertPretrained failed: %w", err)
+		return nil, err
+	}
+	for name, x := range stateDict {
+		if target, ok := mapper(name); ok && target == probe.fcNames[0]+".weight" {
+			nclasses := x.MustSize()[0]
+			if _, err := NewClassifierHead(vs.Root(), backbone, channels, nclasses); err != nil {
+				err = fmt.Errorf("ConvertPretrained failed: %w", err)
+				return nil, err
+			}
+			break
+		}
+	}
+
+	report := ConvertStateDict(vs, stateDict, mapper)
+	if len(report.Loaded) == 0 {
+		err := fmt.Errorf("ConvertPretrained failed: no parameter of %q matches backbone %q", inFile, backbone)
+		return report, err
+	}
+	metadata := map[string]string{"backbone": backbone, "source": source, "converted_from": filepath.Base(inFile)}
+	if err := SaveWeights(vs, outFile, metadata); err != nil {
+		err = fmt.Errorf("ConvertPretrained failed: %w", err)
+		return report, err
+	}
+
+	return report, nil
+}
@@ -0,0 +1,62 @@
+package model
+
+import "testing"
+
+func TestNameMapper(t *testing.T) {
+	tests := []struct {
+		backbone, source string
+		names            map[string]string
+	}{
+		{"efficientnet_b0", "timm", map[string]string{
+			"conv_stem.weight":           "_conv_stem.weight",
+			"bn1.running_var":            "_bn0.running_var",
+			"blocks.0.0.conv_dw.weight":  "_blocks.0._depthwise_conv.weight",
+			"blocks.1.1.conv_pwl.weight": "_blocks.2._project_conv.weight",
+			"blocks.6.0.bn3.weight":      "_blocks.15._bn2.weight",
+			"bn2.bias":                   "_bn1.bias",
+			"classifier.weight":          "_fc.weight",
+		}},
+		{"efficientnet_b0", "torchvision", map[string]string{
+			"features.0.0.weight":             "_conv_stem.weight",
+			"features.1.0.block.1.fc1.weight": "_blocks.0._se_reduce.weight",
+			"features.2.1.block.3.0.weight":   "_blocks.2._project_conv.weight",
+			"features.8.0.weight":             "_conv_head.weight",
+			"classifier.1.bias":               "_fc.bias",
+		}},
+		{"densenet121", "torchvision", map[string]string{
+			"features.denseblock1.denselayer1.norm.1.weight": "features.denseblock1.denselayer1.norm1.weight",
+			"classifier.weight": "classifier.weight",
+		}},
+		{"resnet50", "torchvision", map[string]string{
+			"layer1.0.conv1.weight": "layer1.0.conv1.weight",
+			"fc.bias":               "fc.bias",
+		}},
+		{"convnext_tiny", "torchvision", map[string]string{
+			"features.1.0.layer_scale": "features.1.0.layer_scale",
+			"classifier.2.weight":      "classifier.2.weight",
+		}},
+		{"regnet_y_400mf", "torchvision", map[string]string{
+			"trunk_output.block1.block1-0.f.se.fc1.weight": "trunk_output.block1.block1-0.f.se.fc1.weight",
+		}},
+	}
+	for _, tt := range tests {
+		mapper, err := NewNameMapper(tt.backbone, tt.source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, want := range tt.names {
+			if got, ok := mapper(name); !ok || got != want {
+				t.Errorf("%s/%s: want %q mapped to %q, got %q (%v)\n", tt.backbone, tt.source, name, want, got, ok)
+			}
+		}
+		if _, ok := mapper("bn1.num_batches_tracked"); ok {
+			t.Errorf("%s/%s: want num_batches_tracked skipped\n", tt.backbone, tt.source)
+		}
+	}
+	if _, err := NewNameMapper("vgg16", "timm"); err == nil {
+		t.Errorf("Want error for unsupported source of VGG\n")
+	}
+	if _, err := NewNameMapper("resnet50", "keras"); err == nil {
+		t.Errorf("Want error for unsupported source\n")
+	}
+}
@@ -0,0 +1,183 @@
+package model
+
+// ConvNeXt implementation.
+//
+// See "A ConvNet for the 2020s", Liu et al 2022.
+// https://arxiv.org/abs/2201.03545
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+// convNeXtConfig holds channels and depths of stages and maximal stochastic depth
+// probability of blocks.
+type convNeXtConfig struct {
+	dims                [4]int64
+	depths              [4]int64
+	stochasticDepthProb float64
+}
+
+var convNeXtConfigs map[string]convNeXtConfig = map[string]convNeXtConfig{
+	"convnext_tiny":  {[4]int64{96, 192, 384, 768}, [4]int64{3, 3, 9, 3}, 0.1},
+	"convnext_small": {[4]int64{96, 192, 384, 768}, [4]int64{3, 3, 27, 3}, 0.4},
+	"convnext_base":  {[4]int64{128, 256, 512, 1024}, [4]int64{3, 3, 27, 3}, 0.5},
+	"convnext_large": {[4]int64{192, 384, 768, 1536}, [4]int64{3, 3, 27, 3}, 0.5},
+}
+
+// ConvNeXt creates ConvNeXt ModuleT.
+func ConvNeXt(p *nn.Path, nclasses int64, backbone string) ts.ModuleT {
+	config, ok := convNeXtConfigs[backbone]
+	if !ok {
+		log.Fatalf("Invalid backbone type: %s\n", backbone)
+	}
+
+	return convNeXt(p, nclasses, config)
+}
+
+// cnBlock is ConvNeXt block: depthwise 7x7 convolution, layer norm and inverted bottleneck MLP
+// scaled by learnable layer scale, with stochastic depth on residual branch.
+type cnBlock struct {
+	DwConv     *nn.Conv2D
+	Norm       *nn.LayerNorm
+	Fc1        *nn.Linear
+	Fc2        *nn.Linear
+	LayerScale *ts.Tensor
+	dropProb   float64
+}
+
+func newCNBlock(p *nn.Path, dim int64, dropProb float64) *cnBlock {
+	block := p.Sub("block")
+	normConfig := nn.DefaultLayerNormConfig()
+	normConfig.Eps = 1e-6
+
+	return &cnBlock{
+		DwConv:     groupConv2d(block.Sub("0"), dim, dim, 7, 1, dim, true),
+		Norm:       nn.NewLayerNorm(block.Sub("2"), []int64{dim}, normConfig),
+		Fc1:        nn.NewLinear(block.Sub("3"), dim, 4*dim, nn.DefaultLinearConfig()),
+		Fc2:        nn.NewLinear(block.Sub("5"), 4*dim, dim, nn.DefaultLinearConfig()),
+		LayerScale: p.MustNewVar("layer_scale", []int64{dim, 1, 1}, nn.NewConstInit(1e-6)),
+		dropProb:   dropProb,
+	}
+}
+
+// ForwardT implements ModuleT for cnBlock.
+func (b *cnBlock) ForwardT(x *ts.Tensor, train bool) *ts.Tensor {
+	dw := b.DwConv.Forward(x)
+	nhwc := dw.MustPermute([]int64{0, 2, 3, 1}, true)
+	norm := b.Norm.Forward(nhwc)
+	nhwc.MustDrop()
+	fc1 := b.Fc1.Forward(norm)
+	norm.MustDrop()
+	act := fc1.MustGelu(true)
+	fc2 := b.Fc2.Forward(act)
+	act.MustDrop()
+	nchw := fc2.MustPermute([]int64{0, 3, 1, 2}, true)
+	scaled := nchw.MustMul(b.LayerScale, true)
+
+	if train && b.dropProb > 0 {
+		scaled = stochasticDepth(scaled, b.dropProb)
+	}
+
+	out := scaled.MustAdd(x, true)
+
+	return out
+}
+
+// stochasticDepth randomly zeroes samples of residual branch x with probability p and
+// scales the others by 1/(1-p). It deletes x.
+func stochasticDepth(x *ts.Tensor, p float64) *ts.Tensor {
+	size := x.MustSize()
+	shape := make([]int64, len(size))
+	shape[0] = size[0]
+	for i := 1; i < len(shape); i++ {
+		shape[i] = 1
+	}
+	keep := 1 - p
+	noise := ts.MustRand(shape, gotch.Float, x.MustDevice())
+	mask := noise.MustLessEqual(ts.FloatScalar(keep), true).MustTotype(x.DType(), true).MustDivScalar(ts.FloatScalar(keep), true)
+	out := x.MustMul(mask, true)
+	mask.MustDrop()
+
+	return out
+}
+
+// convNeXtFeatures creates ConvNeXt stem, stages and downsampling layers and returns their
+// output channels.
+func convNeXtFeatures(p *nn.Path, config convNeXtConfig) (Layers, int64) {
+	features := p.Sub("features")
+
+	var seq Layers
+	stem := nn.SeqT()
+	stem.Add(patchifyConv(features.Sub("0").Sub("0"), 3, config.dims[0], 4))
+	stem.Add(newLayerNorm2d(features.Sub("0").Sub("1"), config.dims[0], 1e-6))
+	seq.add(features.Sub("0"), stem)
+
+	var total int64
+	for _, d := range config.depths {
+		total += d
+	}
+	var blockID int64
+	idx := 1
+	for i, depth := range config.depths {
+		dim := config.dims[i]
+		stage := features.Sub(fmt.Sprint(idx))
+		for j := int64(0); j < depth; j++ {
+			dropProb := config.stochasticDepthProb * float64(blockID) / float64(total-1)
+			seq.add(stage.Sub(fmt.Sprint(j)), newCNBlock(stage.Sub(fmt.Sprint(j)), dim, dropProb))
+			blockID++
+		}
+		idx++
+
+		if i < len(config.depths)-1 {
+			down := features.Sub(fmt.Sprint(idx))
+			downsample := nn.SeqT()
+			downsample.Add(newLayerNorm2d(down.Sub("0"), dim, 1e-6))
+			downsample.Add(patchifyConv(down.Sub("1"), dim, config.dims[i+1], 2))
+			seq.add(down, downsample)
+			idx++
+		}
+	}
+
+	return seq, config.dims[len(config.dims)-1]
+}
+
+// convNeXt creates a ConvNeXt model. Without final linear layer if nclasses is 0.
+func convNeXt(p *nn.Path, nclasses int64, config convNeXtConfig) ts.ModuleT {
+	seq, lastDim := convNeXtFeatures(p, config)
+	classifier := p.Sub("classifier")
+	norm := newLayerNorm2d(classifier.Sub("0"), lastDim, 1e-6)
+	var fc *nn.Linear
+	if nclasses > 0 {
+		fc = nn.NewLinear(classifier.Sub("2"), lastDim, nclasses, nn.DefaultLinearConfig())
+	}
+
+	return nn.NewFuncT(func(x *ts.Tensor, train bool) *ts.Tensor {
+		output := seq.ForwardT(x, train)
+		avgpool := output.MustAdaptiveAvgPool2d([]int64{1, 1}, true)
+		normed := norm.ForwardT(avgpool, train)
+		avgpool.MustDrop()
+		fv := normed.FlatView()
+		normed.MustDrop()
+		if fc == nil {
+			return fv
+		}
+		retVal := fc.Forward(fv)
+		fv.MustDrop()
+
+		return retVal
+	})
+}
+
+// patchifyConv creates a patchify convolution with kernel size equal to stride and no padding.
+func patchifyConv(p *nn.Path, cIn, cOut, ksize int64) *nn.Conv2D {
+	config := nn.DefaultConv2DConfig()
+	config.Stride = []int64{ksize, ksize}
+	config.Padding = []int64{0, 0}
+
+	return nn.NewConv2D(p, cIn, cOut, ksize, config)
+}
@@ -13,7 +13,7 @@ import (
 	"github.com/sugarme/gotch/ts"
 )
 
-// DenseNet creates DenseNet ModuleT.
+// DenseNet creates DenseNet ModuleT. Without final classifier if nclasses is 0.
 func DenseNet(p *nn.Path, nclasses int64, backbone string) ts.ModuleT {
 	var m ts.ModuleT
 	switch backbone {
@@ -101,7 +101,8 @@ func transition(p *nn.Path, cIn, cOut int64) ts.ModuleT {
 	seq.Add(dnConv2d(p.Sub("conv"), cIn, cOut, 1, 0, 1))
 
 	seq.AddFn(nn.NewFunc(func(xs *ts.Tensor) *ts.Tensor {
-		return xs.AvgPool2DDefault(2, false)
+		// AvgPool2DDefault sums as it overrides divisor to 1.
+		return xs.MustAvgPool2d([]int64{2, 2}, []int64{2, 2}, []int64{0, 0}, false, true, nil, false)
 	}))
 
 	return seq
@@ -137,12 +138,17 @@ func densenet(p *nn.Path, cIn, bnSize int64, growth int64, blockConfig []int64,
 
 	seq.AddFn(nn.NewFunc(func(xs *ts.Tensor) *ts.Tensor {
 		tmp1 := xs.MustRelu(false)
-		tmp2 := tmp1.MustAvgPool2d([]int64{7, 7}, []int64{1, 1}, []int64{0, 0}, false, true, []int64{1}, true)
+		tmp2 := tmp1.MustAvgPool2d([]int64{7, 7}, []int64{1, 1}, []int64{0, 0}, false, true, nil, true)
 		res := tmp2.FlatView()
 		tmp2.MustDrop()
 		return res
 	}))
 
+	// No final layer: output pooled features.
+	if cOut <= 0 {
+		return seq
+	}
+
 	seq.Add(nn.NewLinear(p.Sub("classifier"), nfeat, cOut, nn.DefaultLinearConfig()))
 
 	return seq
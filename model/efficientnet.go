@@ -274,7 +274,41 @@ func block(p *nn.Path, args BlockArgs) ts.ModuleT {
 	})
 }
 
-func efficientnet(p *nn.Path, params *params, nclasses int64) ts.ModuleT {
+// blockGroups creates blocks of each block args, numbered consecutively under path p.
+// It returns block groups and their output channels.
+func blockGroups(p *nn.Path, params *params) ([]*nn.SequentialT, []int64) {
+	var (
+		groups   []*nn.SequentialT
+		channels []int64
+	)
+	blockIdx := 0
+	for _, arg := range blockArgs() {
+		group := nn.SeqT()
+		a1 := arg
+		a1.InputFilters = params.roundFilters(arg.InputFilters)
+		a1.OutputFilter = params.roundFilters(arg.OutputFilter)
+
+		group.Add(block(p.Sub(fmt.Sprintf("%v", blockIdx)), a1))
+		blockIdx += 1
+
+		a2 := a1
+		a2.InputFilters = a1.OutputFilter
+		a2.Stride = 1
+
+		for i := 1; i < int(params.roundRepeats(a2.NumRepeat)); i++ {
+			group.Add(block(p.Sub(fmt.Sprintf("%v", blockIdx)), a2))
+			blockIdx += 1
+		}
+		groups = append(groups, group)
+		channels = append(channels, a1.OutputFilter)
+	}
+
+	return groups, channels
+}
+
+// effNetFeatures creates EfficientNet layers before pooling and returns their output channels.
+// Layers are stem, block groups and head convolution.
+func effNetFeatures(p *nn.Path, params *params) (Layers, int64) {
 
 	args := blockArgs()
 
@@ -289,37 +323,47 @@ func efficientnet(p *nn.Path, params *params, nclasses int64) ts.ModuleT {
 	convS2Config.Stride = []int64{2, 2}
 	convS2Config.Bias = false
 
-	outC := params.roundFilters(32)
-	convStem := enConv2d(p.Sub("_conv_stem"), 3, outC, 3, convS2Config, false)
-	bn0 := nn.BatchNorm2D(p.Sub("_bn0"), outC, bn2dConfig)
-
-	blocks := nn.SeqT()
-	blockP := p.Sub("_blocks")
-	blockIdx := 0
-	for _, arg := range args {
-		a1 := arg
-		a1.InputFilters = params.roundFilters(arg.InputFilters)
-		a1.OutputFilter = params.roundFilters(arg.OutputFilter)
-
-		blocks.Add(block(blockP.Sub(fmt.Sprintf("%v", blockIdx)), a1))
-		blockIdx += 1
-
-		a2 := a1
-		a2.InputFilters = a1.OutputFilter
-		a2.Stride = 1
+	swish := nn.NewFunc(func(xs *ts.Tensor) *ts.Tensor {
+		return xs.Swish()
+	})
 
-		for i := 1; i < int(params.roundRepeats(a2.NumRepeat)); i++ {
-			blocks.Add(block(blockP.Sub(fmt.Sprintf("%v", blockIdx)), a2))
-			blockIdx += 1
+	outC := params.roundFilters(32)
+	stem := nn.SeqT()
+	stem.Add(enConv2d(p.Sub("_conv_stem"), 3, outC, 3, convS2Config, false))
+	stem.Add(nn.BatchNorm2D(p.Sub("_bn0"), outC, bn2dConfig))
+	stem.AddFn(swish)
+	layers := Layers{{Name: "stem", Prefixes: []string{"_conv_stem", "_bn0"}, Module: stem}}
+
+	groups, _ := blockGroups(p.Sub("_blocks"), params)
+	offsets := effNetBlockOffsets(params)
+	for i, g := range groups {
+		end := offsets[i] + int(params.roundRepeats(args[i].NumRepeat))
+		var prefixes []string
+		for j := offsets[i]; j < end; j++ {
+			prefixes = append(prefixes, fmt.Sprintf("_blocks.%d", j))
+		}
+		name := prefixes[0]
+		if len(prefixes) > 1 {
+			name = fmt.Sprintf("_blocks.%d-%d", offsets[i], end-1)
 		}
+		layers = append(layers, Layer{Name: name, Prefixes: prefixes, Module: g})
 	}
 
 	lastArg := args[len(args)-1]
 	inChannels := params.roundFilters(lastArg.OutputFilter)
 	outC = params.roundFilters(1280)
 
-	convHead := enConv2d(p.Sub("_conv_head"), inChannels, outC, 1, convConfigNoBias, false)
-	bn1 := nn.BatchNorm2D(p.Sub("_bn1"), outC, bn2dConfig)
+	head := nn.SeqT()
+	head.Add(enConv2d(p.Sub("_conv_head"), inChannels, outC, 1, convConfigNoBias, false))
+	head.Add(nn.BatchNorm2D(p.Sub("_bn1"), outC, bn2dConfig))
+	head.AddFn(swish)
+	layers = append(layers, Layer{Name: "_conv_head", Prefixes: []string{"_conv_head", "_bn1"}, Module: head})
+
+	return layers, outC
+}
+
+func efficientnet(p *nn.Path, params *params, nclasses int64) ts.ModuleT {
+	features, outC := effNetFeatures(p, params)
 
 	classifier := nn.SeqT()
 
@@ -328,27 +372,22 @@ func efficientnet(p *nn.Path, params *params, nclasses int64) ts.ModuleT {
 		return ts.MustDropout(xs, params.Dropout, train)
 	}))
 
-	classifier.Add(nn.NewLinear(p.Sub("_fc"), outC, nclasses, nn.DefaultLinearConfig()))
+	if nclasses > 0 {
+		classifier.Add(nn.NewLinear(p.Sub("_fc"), outC, nclasses, nn.DefaultLinearConfig()))
+	}
 
 	return nn.NewFuncT(func(xs *ts.Tensor, train bool) *ts.Tensor {
-		tmp1 := xs.ApplyT(convStem, false)
-		tmp2 := tmp1.ApplyT(bn0, train)
-		tmp1.MustDrop()
-		tmp3 := tmp2.Swish()
-		tmp2.MustDrop()
-		tmp4 := tmp3.ApplyT(blocks, train)
-		tmp3.MustDrop()
-		tmp5 := tmp4.ApplyT(convHead, false)
-		tmp4.MustDrop()
-		tmp6 := tmp5.ApplyT(bn1, train)
-		tmp5.MustDrop()
-		tmp7 := tmp6.Swish()
-		tmp6.MustDrop()
+		tmp7 := features.ForwardT(xs, train)
 		tmp8 := tmp7.MustAdaptiveAvgPool2d([]int64{1, 1}, false)
 		tmp7.MustDrop()
 		tmp9 := tmp8.MustSqueezeDim(-1, true)
 		tmp10 := tmp9.MustSqueezeDim(-1, true)
 
+		// No final layer: output pooled features.
+		if nclasses <= 0 {
+			return tmp10
+		}
+
 		res := tmp10.ApplyT(classifier, train)
 		tmp10.MustDrop()
 		return res
@@ -356,6 +395,18 @@ func efficientnet(p *nn.Path, params *params, nclasses int64) ts.ModuleT {
 
 }
 
+// effNetParams are EfficientNet params by backbone name, without "tf_" prefix and "_ns" suffix.
+var effNetParams map[string]func(...float64) *params = map[string]func(...float64) *params{
+	"efficientnet_b0": b0,
+	"efficientnet_b1": b1,
+	"efficientnet_b2": b2,
+	"efficientnet_b3": b3,
+	"efficientnet_b4": b4,
+	"efficientnet_b5": b5,
+	"efficientnet_b6": b6,
+	"efficientnet_b7": b7,
+}
+
 func EfficientNetB0(p *nn.Path, nclasses int64) ts.ModuleT {
 	return efficientnet(p, b0(), nclasses)
 }
@@ -388,7 +439,8 @@ func EfficientNetB7(p *nn.Path, nclasses int64) ts.ModuleT {
 	return efficientnet(p, b7(), nclasses)
 }
 
-// EffNet is custom efficientnet with specified dropout
+// EffNet is custom efficientnet with specified dropout. Without final classifier if
+// nclasses is 0.
 func EffNet(p *nn.Path, nclasses int64, backbone string, dOpt ...float64) ts.ModuleT {
 	var m ts.ModuleT
 	switch backbone {
@@ -5,8 +5,10 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 )
 
@@ -42,22 +44,26 @@ func init() {
 // 2. Check it at `CachePath`, if exists, then return the candidate. If not
 // 3. Retrieves and Caches data to `CachePath` and returns path to cached data
 func CachedPath(urlOrFilename string) (resolvedPath string, err error) {
+	return cachedPath(urlOrFilename, DefaultCachePath)
+}
 
+func cachedPath(urlOrFilename, cacheDir string) (string, error) {
 	// 1. Resolves to "candidate" filename at `CachePath`
 	filename := path.Base(urlOrFilename)
-	cachedFileCandidate := fmt.Sprintf("%s/%v", DefaultCachePath, filename)
+	cachedFileCandidate := filepath.Join(cacheDir, filename)
 
 	// 1. Cached candidate exists
-	// if _, err := os.Stat(cachedFileCandidate); os.IsExist(err) {
 	if _, err := os.Stat(cachedFileCandidate); err == nil {
-		fmt.Println("found file in cache dir")
 		return cachedFileCandidate, nil
 	}
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", err
+	}
 
 	// 2. If valid fullpath to local file, caches it and return cached filename
 	if _, err := os.Stat(urlOrFilename); err == nil {
 		fmt.Println("cached file not found. Copying to cache dir...")
-		err := copyFile(urlOrFilename, cachedFileCandidate)
+		err := copyVerified(urlOrFilename, cachedFileCandidate, 0, "")
 		if err != nil {
 			return "", err
 		}
@@ -67,64 +73,108 @@ func CachedPath(urlOrFilename string) (resolvedPath string, err error) {
 	// 3. If a valid URL, download it to `CachePath`
 	if isValidURL(urlOrFilename) {
 		fmt.Println("cached file not found. Downloading file from remote URL...")
-		if _, err := http.Get(urlOrFilename); err == nil {
-			err := downloadFile(urlOrFilename, cachedFileCandidate)
-			if err != nil {
-				return "", err
-			}
-
-			return cachedFileCandidate, nil
-		} else {
-			fmt.Printf("Error: %v\n", err)
-			err = fmt.Errorf("Unable to parse '%v' as a URL or as a local path.\n", urlOrFilename)
+		err := downloadFile(urlOrFilename, cachedFileCandidate, 0, "")
+		if err != nil {
 			return "", err
 		}
+		return cachedFileCandidate, nil
 	}
 
 	// Not resolves
-	err = fmt.Errorf("Unable to parse '%v' as a URL or as a local path.\n", urlOrFilename)
+	err := fmt.Errorf("Unable to parse '%v' as a URL or as a local path.\n", urlOrFilename)
 	return "", err
 }
 
-func isValidURL(url string) bool {
-
-	// TODO: implement
-	return true
+// isValidURL returns whether input is an absolute HTTP or HTTPS URL.
+func isValidURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
 }
 
 // downloadFile downloads file from URL and stores it in local filepath.
 // It writes to the destination file as it downloads it, without loading
 // the entire file into memory. An `io.TeeReader` is passed into Copy()
 // to report progress on the download.
-func downloadFile(url string, filepath string) error {
+//
+// Data is written to "<filepath>.tmp" which is resumed with a range request if it exists from
+// an interrupted download. The file is verified against size (if positive) and hex SHA-256 (if
+// not empty) before being renamed to filepath. A file failing verification is removed.
+func downloadFile(url string, filepath string, size int64, sum string) error {
+	tmpFile := filepath + ".tmp"
+
+	var offset int64
+	if fi, err := os.Stat(tmpFile); err == nil {
+		offset = fi.Size()
+	}
+	if size > 0 && offset > size {
+		offset = 0
+	}
 
-	// Create the file with .tmp extension, so that we won't overwrite a
-	// file until it's downloaded fully
-	out, err := os.Create(filepath + ".tmp")
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
 
 	// Get the data
-	resp, err := http.Get(url)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	// Create our bytes counter and pass it to be used alongside our writer
-	counter := &writeCounter{}
-	_, err = io.Copy(out, io.TeeReader(resp.Body, counter))
-	if err != nil {
+	flag := os.O_CREATE | os.O_WRONLY
+	complete := false
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		flag |= os.O_APPEND
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// Partial file is already complete.
+		complete = true
+	case resp.StatusCode == http.StatusOK:
+		// Server ignored range: start over.
+		flag |= os.O_TRUNC
+		offset = 0
+	default:
+		err := fmt.Errorf("download %q failed: %s", url, resp.Status)
 		return err
 	}
 
-	// The progress use the same line so print a new line once it's finished downloading
-	fmt.Println()
+	if !complete {
+		// Create the file with .tmp extension, so that we won't overwrite a
+		// file until it's downloaded fully
+		out, err := os.OpenFile(tmpFile, flag, 0644)
+		if err != nil {
+			return err
+		}
+
+		// Create our bytes counter and pass it to be used alongside our writer
+		counter := &writeCounter{Total: uint64(offset)}
+		_, err = io.Copy(out, io.TeeReader(resp.Body, counter))
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			// Keep partial file to resume.
+			return err
+		}
+
+		// The progress use the same line so print a new line once it's finished downloading
+		fmt.Println()
+	}
+
+	if err := VerifyFile(tmpFile, size, sum); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
 
 	// Rename the tmp file back to the original file
-	err = os.Rename(filepath+".tmp", filepath)
+	err = os.Rename(tmpFile, filepath)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,536 @@
+package model
+
+// Classification heads on backbone feature maps.
+//
+// See "Fine-tuning CNN Image Retrieval with No Human Annotation", Radenović et al 2017 for GeM
+// pooling (https://arxiv.org/abs/1711.02512) and "Multi-Sample Dropout for Accelerated Training
+// and Better Generalization", Inoue 2019 (https://arxiv.org/abs/1905.09788).
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+// Poolings are global pooling types of Head.
+var Poolings []string = []string{"avg", "max", "gem", "avgmax"}
+
+// Task is a named output of a multi-task Head.
+type Task struct {
+	Name       string
+	NumClasses int64
+}
+
+type HeadOptions struct {
+	Pooling            string  // "avg" (default), "max", "gem" or "avgmax" (concatenated average and max)
+	HiddenLayers       []int64 // output sizes of hidden linear layers with ReLU. Default none.
+	Dropout            float64 // dropout probability before each linear layer. Default 0.
+	MultisampleDropout int     // number of dropout masks of final layer averaged at train time. Default 1.
+	Tasks              []Task  // named outputs. Default a single output of nclasses.
+}
+
+type HeadOption func(*HeadOptions)
+
+func defaultHeadOptions() *HeadOptions {
+	return &HeadOptions{
+		Pooling:            "avg",
+		MultisampleDropout: 1,
+	}
+}
+
+func WithPooling(pooling string) HeadOption {
+	return func(o *HeadOptions) {
+		if pooling != "" {
+			o.Pooling = pooling
+		}
+	}
+}
+
+func WithHiddenLayers(sizes []int64) HeadOption {
+	return func(o *HeadOptions) {
+		o.HiddenLayers = sizes
+	}
+}
+
+func WithHeadDropout(p float64) HeadOption {
+	return func(o *HeadOptions) {
+		o.Dropout = p
+	}
+}
+
+// WithMultisampleDropout sets number of dropout masks applied to input of final layer at train
+// time. Outputs of all masks are averaged.
+func WithMultisampleDropout(n int) HeadOption {
+	return func(o *HeadOptions) {
+		o.MultisampleDropout = n
+	}
+}
+
+// WithTasks sets named outputs of head, each with its own final layer. It overrides nclasses.
+func WithTasks(tasks ...Task) HeadOption {
+	return func(o *HeadOptions) {
+		o.Tasks = tasks
+	}
+}
+
+// Head pools backbone feature maps [N, C, H, W], applies optional hidden layers and a final
+// linear layer per task. Its output is logits of all tasks concatenated along dim 1 (see Split).
+//
+// Variables of NewHead are named "gem.p" (GeM pooling), "hidden.i", and "fc" for a single
+// output or "tasks.<name>" for each task. Heads of NewClassifierHead without custom layers keep
+// the original classifier names of their backbone.
+type Head struct {
+	pooling     string
+	poolSize    int64         // output size of average pooling flattened to features. 0 for global pooling.
+	gemP        *ts.Tensor    // learnable GeM exponent. Nil if other pooling.
+	norm        *nn.LayerNorm // layer norm of pooled features. Nil if none.
+	hidden      []ts.Module   // *nn.Linear or *QuantizedLinear
+	act         activation    // activation of hidden layers
+	fcs         []ts.Module
+	gemName     string   // VarStore path of GeM exponent
+	hiddenNames []string // VarStore path prefixes of hidden layers
+	fcNames     []string // VarStore path prefixes of final layers
+	prefixes    []string // VarStore path prefixes of all head variables
+	tasks       []Task
+	dropout     float64
+	dropFirst   bool // dropout before the first hidden layer
+	nsamples    int
+	nfeatures   int64
+}
+
+// checkHeadOptions validates dropout settings of head options.
+func checkHeadOptions(o *HeadOptions) error {
+	if o.Dropout < 0 || o.Dropout >= 1 {
+		return fmt.Errorf("expected dropout in range [0, 1), got %v", o.Dropout)
+	}
+	if o.MultisampleDropout < 1 {
+		return fmt.Errorf("expected multi-sample dropout count >= 1, got %d", o.MultisampleDropout)
+	}
+	if o.MultisampleDropout > 1 && o.Dropout == 0 {
+		return fmt.Errorf("multi-sample dropout requires dropout > 0")
+	}
+	return nil
+}
+
+// NewHead creates a Head on features of cIn channels. If nclasses is 0 and there are no tasks,
+// there is no final layer and the head outputs features after pooling and hidden layers.
+func NewHead(p *nn.Path, cIn, nclasses int64, opts ...HeadOption) (*Head, error) {
+	options := defaultHeadOptions()
+	for _, o := range opts {
+		o(options)
+	}
+
+	h := &Head{
+		pooling:   options.Pooling,
+		act:       relu,
+		prefixes:  []string{strings.Join(p.Paths(), ".")},
+		dropout:   options.Dropout,
+		dropFirst: true,
+		nsamples:  options.MultisampleDropout,
+	}
+	switch options.Pooling {
+	case "avg", "max":
+	case "gem":
+		gem := p.Sub("gem")
+		h.gemP = gem.MustNewVar("p", []int64{1}, nn.NewConstInit(3.0))
+		h.gemName = strings.Join(gem.Paths(), ".") + ".p"
+	case "avgmax":
+		cIn *= 2
+	default:
+		err := fmt.Errorf("NewHead failed: invalid pooling %q. Expected one of %s", options.Pooling, strings.Join(Poolings, ", "))
+		return nil, err
+	}
+	if err := checkHeadOptions(options); err != nil {
+		err = fmt.Errorf("NewHead failed: %w", err)
+		return nil, err
+	}
+
+	for i, size := range options.HiddenLayers {
+		h.addHidden(p.Sub("hidden").Sub(fmt.Sprint(i)), cIn, size)
+		cIn = size
+	}
+	h.nfeatures = cIn
+
+	tasks := options.Tasks
+	if len(tasks) == 0 && nclasses > 0 {
+		tasks = []Task{{"", nclasses}}
+	}
+	names := make(map[string]bool)
+	for _, task := range tasks {
+		if task.NumClasses <= 0 {
+			err := fmt.Errorf("NewHead failed: expected number of classes > 0 for task %q, got %d", task.Name, task.NumClasses)
+			return nil, err
+		}
+		if names[task.Name] {
+			err := fmt.Errorf("NewHead failed: duplicate task %q", task.Name)
+			return nil, err
+		}
+		names[task.Name] = true
+
+		path := p.Sub("fc")
+		if len(options.Tasks) > 0 {
+			path = p.Sub("tasks").Sub(task.Name)
+		}
+		h.addFinal(path, cIn, task.NumClasses)
+	}
+	h.tasks = tasks
+
+	return h, nil
+}
+
+// NewClassifierHead creates the classification head of backbone on features of cIn channels,
+// where p is the root path of the model.
+//
+// Without custom layers (average pooling, no hidden layers and no tasks) the head has the
+// original classifier layers and variable names of backbone, e.g. "fc" of ResNet and RegNet,
+// "classifier" of DenseNet, "_fc" of EfficientNet, the MLP "classifier.{0,3,6}" of VGG on 7x7
+// pooled features, so that weights of these models load into it. Dropout of final layer is
+// the original one of backbone unless set by options.
+//
+// Otherwise it is a NewHead under "head". ConvNeXt pooled features are normalized by its
+// classifier layer norm "classifier.0" in both cases.
+func NewClassifierHead(p *nn.Path, backbone string, cIn, nclasses int64, opts ...HeadOption) (*Head, error) {
+	options := defaultHeadOptions()
+	for _, o := range opts {
+		o(options)
+	}
+	_, isConvNeXt := convNeXtConfigs[backbone]
+
+	if options.Pooling == "avg" && len(options.HiddenLayers) == 0 && len(options.Tasks) == 0 {
+		h, err := defaultHead(p, backbone, cIn, nclasses, options)
+		if err != nil {
+			err = fmt.Errorf("NewClassifierHead failed: %w", err)
+			return nil, err
+		}
+		return h, nil
+	}
+
+	h, err := NewHead(p.Sub("head"), cIn, nclasses, opts...)
+	if err != nil {
+		err = fmt.Errorf("NewClassifierHead failed: %w", err)
+		return nil, err
+	}
+	if isConvNeXt {
+		if options.Pooling == "avgmax" {
+			cIn *= 2
+		}
+		h.addNorm(p.Sub("classifier").Sub("0"), cIn)
+	}
+	return h, nil
+}
+
+// defaultHead creates the original classifier of backbone. See NewClassifierHead.
+func defaultHead(p *nn.Path, backbone string, cIn, nclasses int64, options *HeadOptions) (*Head, error) {
+	h := &Head{
+		pooling:  "avg",
+		act:      relu,
+		nsamples: options.MultisampleDropout,
+	}
+
+	var (
+		hidden      []int64
+		hiddenPaths []*nn.Path
+		fc          *nn.Path
+	)
+	_, isResNeXt := resNeXtConfigs[backbone]
+	_, isSEResNet := seResNetConfigs[backbone]
+	effNetFn, isEffNet := effNetParams[strings.TrimSuffix(strings.TrimPrefix(backbone, "tf_"), "_ns")]
+	_, isVGG := vggConfigs[strings.TrimSuffix(backbone, "_bn")]
+	_, isRegNet := regNetConfigs[backbone]
+	_, isConvNeXt := convNeXtConfigs[backbone]
+	classifier := p.Sub("classifier")
+	switch {
+	case strings.HasPrefix(backbone, "resnet"), isResNeXt, isSEResNet, isRegNet:
+		fc = p.Sub("fc")
+	case strings.HasPrefix(backbone, "densenet"):
+		fc = classifier
+	case isEffNet:
+		fc = p.Sub("_fc")
+		h.dropout = effNetFn().Dropout
+	case backbone == "mobilenet_v2":
+		fc = classifier.Sub("1")
+		h.dropout = 0.2
+	case backbone == "mobilenet_v3_large", backbone == "mobilenet_v3_small":
+		size := int64(1280)
+		if backbone == "mobilenet_v3_small" {
+			size = 1024
+		}
+		// Without final layer, MobileNetV3 outputs pooled features.
+		if nclasses > 0 {
+			hidden, hiddenPaths = []int64{size}, []*nn.Path{classifier.Sub("0")}
+		}
+		fc = classifier.Sub("3")
+		h.act = hardswish
+		h.dropout = 0.2
+	case isVGG:
+		h.poolSize = 7
+		cIn *= 7 * 7
+		hidden, hiddenPaths = []int64{4096, 4096}, []*nn.Path{classifier.Sub("0"), classifier.Sub("3")}
+		fc = classifier.Sub("6")
+		h.dropout = 0.5
+	case isConvNeXt:
+		h.addNorm(classifier.Sub("0"), cIn)
+		fc = classifier.Sub("2")
+	default:
+		err := fmt.Errorf("unsupported backbone %q", backbone)
+		return nil, err
+	}
+	if options.Dropout > 0 {
+		h.dropout = options.Dropout
+	}
+	if err := checkHeadOptions(&HeadOptions{Dropout: h.dropout, MultisampleDropout: h.nsamples}); err != nil {
+		return nil, err
+	}
+
+	for i, size := range hidden {
+		h.addHidden(hiddenPaths[i], cIn, size)
+		cIn = size
+	}
+	h.nfeatures = cIn
+	if nclasses > 0 {
+		h.addFinal(fc, cIn, nclasses)
+		h.tasks = []Task{{"", nclasses}}
+	}
+
+	return h, nil
+}
+
+func (h *Head) addHidden(p *nn.Path, cIn, cOut int64) {
+	name := strings.Join(p.Paths(), ".")
+	h.hidden = append(h.hidden, nn.NewLinear(p, cIn, cOut, nn.DefaultLinearConfig()))
+	h.hiddenNames = append(h.hiddenNames, name)
+	h.addPrefix(name)
+}
+
+func (h *Head) addFinal(p *nn.Path, cIn, cOut int64) {
+	name := strings.Join(p.Paths(), ".")
+	h.fcs = append(h.fcs, nn.NewLinear(p, cIn, cOut, nn.DefaultLinearConfig()))
+	h.fcNames = append(h.fcNames, name)
+	h.addPrefix(name)
+}
+
+// addNorm adds layer norm of c pooled features.
+func (h *Head) addNorm(p *nn.Path, c int64) {
+	config := nn.DefaultLayerNormConfig()
+	config.Eps = 1e-6
+	h.norm = nn.NewLayerNorm(p, []int64{c}, config)
+	h.addPrefix(strings.Join(p.Paths(), "."))
+}
+
+// addPrefix adds VarStore path prefix of head variables unless it is under a known one.
+func (h *Head) addPrefix(name string) {
+	for _, p := range h.prefixes {
+		if name == p || strings.HasPrefix(name, p+".") {
+			return
+		}
+	}
+	h.prefixes = append(h.prefixes, name)
+}
+
+// Prefixes returns VarStore path prefixes of head variables.
+func (h *Head) Prefixes() []string {
+	return h.prefixes
+}
+
+// Tasks returns tasks of head in output order.
+func (h *Head) Tasks() []Task {
+	return h.tasks
+}
+
+// OutputSize returns size of head output dim 1.
+func (h *Head) OutputSize() int64 {
+	if len(h.tasks) == 0 {
+		return h.nfeatures
+	}
+	var n int64
+	for _, task := range h.tasks {
+		n += task.NumClasses
+	}
+	return n
+}
+
+// Split splits head output into logits of each task by name. Returned tensors are views of
+// output.
+func (h *Head) Split(output *ts.Tensor) map[string]*ts.Tensor {
+	outputs := make(map[string]*ts.Tensor, len(h.tasks))
+	var start int64
+	for _, task := range h.tasks {
+		outputs[task.Name] = output.MustNarrow(1, start, task.NumClasses, false)
+		start += task.NumClasses
+	}
+	return outputs
+}
+
+// ForwardT implements ModuleT for Head. It does not delete x.
+func (h *Head) ForwardT(x *ts.Tensor, train bool) *ts.Tensor {
+	out := h.pool(x)
+	for i, l := range h.hidden {
+		if i > 0 || h.dropFirst {
+			d := ts.MustDropout(out, h.dropout, train)
+			out.MustDrop()
+			out = d
+		}
+		y := l.Forward(out)
+		out.MustDrop()
+		out = h.act(y)
+		y.MustDrop()
+	}
+	if len(h.fcs) == 0 {
+		return out
+	}
+
+	// Multi-sample dropout: average final layer outputs of several dropout masks.
+	n := 1
+	if train {
+		n = h.nsamples
+	}
+	var logits *ts.Tensor
+	for i := 0; i < n; i++ {
+		d := ts.MustDropout(out, h.dropout, train)
+		y := h.final(d)
+		d.MustDrop()
+		if logits == nil {
+			logits = y
+			continue
+		}
+		logits = logits.MustAdd(y, true)
+		y.MustDrop()
+	}
+	out.MustDrop()
+	if n > 1 {
+		logits = logits.MustDivScalar(ts.IntScalar(int64(n)), true)
+	}
+
+	return logits
+}
+
+// final applies final layers of all tasks and concatenates their outputs.
+func (h *Head) final(x *ts.Tensor) *ts.Tensor {
+	if len(h.fcs) == 1 {
+		return h.fcs[0].Forward(x)
+	}
+
+	outputs := make([]ts.Tensor, len(h.fcs))
+	for i, fc := range h.fcs {
+		outputs[i] = *fc.Forward(x)
+	}
+	out := ts.MustCat(outputs, 1)
+	for i := range outputs {
+		outputs[i].MustDrop()
+	}
+	return out
+}
+
+// pool applies pooling to x [N, C, H, W] and returns [N, C] (or [N, 2C] for "avgmax",
+// [N, C * poolSize * poolSize] for average pooling of poolSize), normalized if head has a norm.
+func (h *Head) pool(x *ts.Tensor) *ts.Tensor {
+	out := h.globalPool(x)
+	if h.norm == nil {
+		return out
+	}
+	normed := h.norm.Forward(out)
+	out.MustDrop()
+	return normed
+}
+
+func (h *Head) globalPool(x *ts.Tensor) *ts.Tensor {
+	if h.poolSize > 0 {
+		avgpool := x.MustAdaptiveAvgPool2d([]int64{h.poolSize, h.poolSize}, false)
+		fv := avgpool.FlatView()
+		avgpool.MustDrop()
+		return fv
+	}
+
+	switch h.pooling {
+	case "max":
+		return x.MustAmax([]int64{2, 3}, false, false)
+	case "gem":
+		// (mean(x^p))^(1/p)
+		clamped := x.MustClampMin(ts.FloatScalar(1e-6), false)
+		powed := clamped.MustPow(h.gemP, true)
+		avg := globalAvgPool(powed)
+		powed.MustDrop()
+		invP := h.gemP.MustReciprocal(false)
+		out := avg.MustPow(invP, true)
+		invP.MustDrop()
+		return out
+	case "avgmax":
+		avg := globalAvgPool(x)
+		mx := x.MustAmax([]int64{2, 3}, false, false)
+		out := ts.MustCat([]ts.Tensor{*avg, *mx}, 1)
+		avg.MustDrop()
+		mx.MustDrop()
+		return out
+	default:
+		return globalAvgPool(x)
+	}
+}
+
+// globalAvgPool averages x [N, C, H, W] over spatial dims to [N, C].
+func globalAvgPool(x *ts.Tensor) *ts.Tensor {
+	avgpool := x.MustAdaptiveAvgPool2d([]int64{1, 1}, false)
+	fv := avgpool.FlatView()
+	avgpool.MustDrop()
+	return fv
+}
+
+// Features creates layers of a backbone classification model before pooling and returns
+// them with number of output channels. Variables are named as in the classification model.
+//
+// NOTE. ResNet and DenseNet layers are stages of NewBackbone. ConvNeXt classifier layer norm
+// ("classifier.0") is applied to pooled features by NewClassifierHead.
+func Features(p *nn.Path, backbone string) (Layers, int64, error) {
+	var (
+		features Layers
+		channels int64
+	)
+	_, isResNeXt := resNeXtConfigs[backbone]
+	_, isSEResNet := seResNetConfigs[backbone]
+	effNetFn, isEffNet := effNetParams[strings.TrimSuffix(strings.TrimPrefix(backbone, "tf_"), "_ns")]
+	vggConfig, isVGG := vggConfigs[strings.TrimSuffix(backbone, "_bn")]
+	regNetConfig, isRegNet := regNetConfigs[backbone]
+	convNeXtConfig, isConvNeXt := convNeXtConfigs[backbone]
+	switch {
+	case strings.HasPrefix(backbone, "resnet"), isResNeXt, isSEResNet, strings.HasPrefix(backbone, "densenet"):
+		b, err := NewBackbone(p, backbone, WithOutStages(4))
+		if err != nil {
+			err = fmt.Errorf("Features failed: %w", err)
+			return nil, 0, err
+		}
+		features = b.(*stagedBackbone).layers()
+		channels = b.Channels()[0]
+	case isEffNet:
+		features, channels = effNetFeatures(p, effNetFn())
+	case backbone == "mobilenet_v2":
+		features, channels = mobileNetV2Features(p)
+	case backbone == "mobilenet_v3_large":
+		features, channels = mobileNetV3Features(p, mobileNetV3LargeBlocks)
+	case backbone == "mobilenet_v3_small":
+		features, channels = mobileNetV3Features(p, mobileNetV3SmallBlocks)
+	case isVGG:
+		features = vggFeatures(p.Sub("features"), vggConfig, strings.HasSuffix(backbone, "_bn"))
+		channels = 512
+	case isRegNet:
+		features, channels = regNetFeatures(p, regNetConfig)
+	case isConvNeXt:
+		features, channels = convNeXtFeatures(p, convNeXtConfig)
+	default:
+		err := fmt.Errorf("Features failed: unsupported backbone %q", backbone)
+		return nil, 0, err
+	}
+
+	return features, channels, nil
+}
+
+// Classifier applies head to output of features.
+func Classifier(features ts.ModuleT, head ts.ModuleT) ts.ModuleT {
+	return nn.NewFuncT(func(x *ts.Tensor, train bool) *ts.Tensor {
+		output := features.ForwardT(x, train)
+		retVal := head.ForwardT(output, train)
+		output.MustDrop()
+
+		return retVal
+	})
+}
@@ -0,0 +1,170 @@
+package model
+
+// Building blocks shared by classification models. Module names follow torchvision
+// (e.g. "features.0.0" of Conv2dNormActivation, "fc1"/"fc2" of SqueezeExcitation)
+// so that converted torchvision weights can be loaded to VarStore.
+
+import (
+	"strings"
+
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+// activation is an activation function. It does not delete its input.
+type activation func(x *ts.Tensor) *ts.Tensor
+
+func relu(x *ts.Tensor) *ts.Tensor        { return x.MustRelu(false) }
+func relu6(x *ts.Tensor) *ts.Tensor       { return x.MustRelu6(false) }
+func hardswish(x *ts.Tensor) *ts.Tensor   { return x.MustHardswish(false) }
+func hardsigmoid(x *ts.Tensor) *ts.Tensor { return x.MustHardsigmoid(false) }
+func sigmoid(x *ts.Tensor) *ts.Tensor     { return x.MustSigmoid(false) }
+func gelu(x *ts.Tensor) *ts.Tensor        { return x.MustGelu(false) }
+
+// makeDivisible rounds v to nearest multiple of divisor, not going down by more than 10%.
+func makeDivisible(v float64, divisor int64) int64 {
+	newV := (int64(v+float64(divisor)/2) / divisor) * divisor
+	if newV < divisor {
+		newV = divisor
+	}
+	if float64(newV) < 0.9*v {
+		newV += divisor
+	}
+	return newV
+}
+
+// groupConv2d creates a convolution with "same" padding for odd kernel size.
+func groupConv2d(p *nn.Path, cIn, cOut, ksize, stride, groups int64, bias bool) *nn.Conv2D {
+	config := nn.DefaultConv2DConfig()
+	config.Stride = []int64{stride, stride}
+	config.Padding = []int64{(ksize - 1) / 2, (ksize - 1) / 2}
+	config.Groups = groups
+	config.Bias = bias
+
+	return nn.NewConv2D(p, cIn, cOut, ksize, config)
+}
+
+// convNormAct creates a convolution without bias ("0") followed by batch norm ("1") and
+// activation. Activation is skipped if nil.
+func convNormAct(p *nn.Path, cIn, cOut, ksize, stride, groups int64, bnConfig *nn.BatchNormConfig, act activation) *nn.SequentialT {
+	seq := nn.SeqT()
+	seq.Add(groupConv2d(p.Sub("0"), cIn, cOut, ksize, stride, groups, false))
+	seq.Add(nn.BatchNorm2D(p.Sub("1"), cOut, bnConfig))
+	if act != nil {
+		seq.AddFn(nn.NewFunc(act))
+	}
+
+	return seq
+}
+
+// squeezeExcitation is the channel attention block of "Squeeze-and-Excitation Networks",
+// Hu et al 2017. https://arxiv.org/abs/1709.01507
+type squeezeExcitation struct {
+	Fc1  *nn.Conv2D
+	Fc2  *nn.Conv2D
+	act  activation
+	gate activation
+}
+
+func newSqueezeExcitation(p *nn.Path, c, cSqueeze int64, act, gate activation) *squeezeExcitation {
+	fc1 := groupConv2d(p.Sub("fc1"), c, cSqueeze, 1, 1, 1, true)
+	fc2 := groupConv2d(p.Sub("fc2"), cSqueeze, c, 1, 1, 1, true)
+
+	return &squeezeExcitation{fc1, fc2, act, gate}
+}
+
+// ForwardT implements ModuleT for squeezeExcitation.
+func (se *squeezeExcitation) ForwardT(x *ts.Tensor, train bool) *ts.Tensor {
+	pool := x.MustAdaptiveAvgPool2d([]int64{1, 1}, false)
+	s1 := se.Fc1.Forward(pool)
+	pool.MustDrop()
+	a1 := se.act(s1)
+	s1.MustDrop()
+	s2 := se.Fc2.Forward(a1)
+	a1.MustDrop()
+	scale := se.gate(s2)
+	s2.MustDrop()
+	out := x.MustMul(scale, false)
+	scale.MustDrop()
+
+	return out
+}
+
+// layerNorm2d normalizes [N, C, H, W] input over channel dimension.
+type layerNorm2d struct {
+	ln *nn.LayerNorm
+}
+
+func newLayerNorm2d(p *nn.Path, c int64, eps float64) *layerNorm2d {
+	config := nn.DefaultLayerNormConfig()
+	config.Eps = eps
+
+	return &layerNorm2d{nn.NewLayerNorm(p, []int64{c}, config)}
+}
+
+// ForwardT implements ModuleT for layerNorm2d.
+func (l *layerNorm2d) ForwardT(x *ts.Tensor, train bool) *ts.Tensor {
+	nhwc := x.MustPermute([]int64{0, 2, 3, 1}, false)
+	norm := l.ln.Forward(nhwc)
+	nhwc.MustDrop()
+	out := norm.MustPermute([]int64{0, 3, 1, 2}, true)
+
+	return out
+}
+
+// pooledClassifier applies features, global average pooling and flattening followed by
+// head. Head is skipped if nil.
+func pooledClassifier(features ts.ModuleT, head ts.ModuleT) nn.FuncT {
+	return nn.NewFuncT(func(x *ts.Tensor, train bool) *ts.Tensor {
+		output := features.ForwardT(x, train)
+		avgpool := output.MustAdaptiveAvgPool2d([]int64{1, 1}, true)
+		fv := avgpool.FlatView()
+		avgpool.MustDrop()
+		if head == nil {
+			return fv
+		}
+		retVal := head.ForwardT(fv, train)
+		fv.MustDrop()
+
+		return retVal
+	})
+}
+
+// dropout creates a dropout layer active in training mode.
+func dropout(p float64) nn.FuncT {
+	return nn.NewFuncT(func(x *ts.Tensor, train bool) *ts.Tensor {
+		return ts.MustDropout(x, p, train)
+	})
+}
+
+// Layer is a named module of Layers. Its variables are under path prefixes Prefixes.
+type Layer struct {
+	Name     string
+	Prefixes []string
+	Module   ts.ModuleT
+}
+
+// Layers applies named layers in sequence. Model summaries report output of each layer.
+type Layers []Layer
+
+// add appends module named by its path.
+func (l *Layers) add(p *nn.Path, m ts.ModuleT) {
+	name := strings.Join(p.Paths(), ".")
+	*l = append(*l, Layer{Name: name, Prefixes: []string{name}, Module: m})
+}
+
+// ForwardT implements ModuleT for Layers. It does not delete x.
+func (l Layers) ForwardT(x *ts.Tensor, train bool) *ts.Tensor {
+	if len(l) == 0 {
+		return x.MustShallowClone()
+	}
+	in := x
+	for _, layer := range l {
+		out := layer.Module.ForwardT(in, train)
+		if in != x {
+			in.MustDrop()
+		}
+		in = out
+	}
+	return in
+}
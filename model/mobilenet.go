@@ -0,0 +1,240 @@
+package model
+
+// MobileNet implementation.
+//
+// See "MobileNetV2: Inverted Residuals and Linear Bottlenecks", Sandler et al 2018.
+// https://arxiv.org/abs/1801.04381
+// and "Searching for MobileNetV3", Howard et al 2019.
+// https://arxiv.org/abs/1905.02244
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+// MobileNet creates MobileNet ModuleT.
+func MobileNet(p *nn.Path, nclasses int64, backbone string) ts.ModuleT {
+	var m ts.ModuleT
+	switch backbone {
+	case "mobilenet_v2":
+		m = MobileNetV2(p, nclasses)
+	case "mobilenet_v3_large":
+		m = MobileNetV3Large(p, nclasses)
+	case "mobilenet_v3_small":
+		m = MobileNetV3Small(p, nclasses)
+	default:
+		log.Fatalf("Invalid backbone type: %s\n", backbone)
+	}
+
+	return m
+}
+
+// invertedResidual is MobileNet block: expansion, depthwise convolution, optional
+// squeeze-excitation and linear projection.
+type invertedResidual struct {
+	Block       ts.ModuleT
+	UseResidual bool
+}
+
+// ForwardT implements ModuleT for invertedResidual.
+func (b *invertedResidual) ForwardT(x *ts.Tensor, train bool) *ts.Tensor {
+	out := b.Block.ForwardT(x, train)
+	if !b.UseResidual {
+		return out
+	}
+
+	return out.MustAdd(x, true)
+}
+
+func newInvertedResidualV2(p *nn.Path, cIn, cOut, stride, expandRatio int64) *invertedResidual {
+	bnConfig := nn.DefaultBatchNormConfig()
+	hidden := cIn * expandRatio
+	conv := p.Sub("conv")
+
+	seq := nn.SeqT()
+	idx := 0
+	if expandRatio != 1 {
+		seq.Add(convNormAct(conv.Sub(fmt.Sprint(idx)), cIn, hidden, 1, 1, 1, bnConfig, relu6))
+		idx++
+	}
+	seq.Add(convNormAct(conv.Sub(fmt.Sprint(idx)), hidden, hidden, 3, stride, hidden, bnConfig, relu6))
+	seq.Add(groupConv2d(conv.Sub(fmt.Sprint(idx+1)), hidden, cOut, 1, 1, 1, false))
+	seq.Add(nn.BatchNorm2D(conv.Sub(fmt.Sprint(idx+2)), cOut, bnConfig))
+
+	return &invertedResidual{
+		Block:       seq,
+		UseResidual: stride == 1 && cIn == cOut,
+	}
+}
+
+// mobileNetV2Features creates MobileNetV2 layers before pooling and returns their output channels.
+func mobileNetV2Features(p *nn.Path) (Layers, int64) {
+	// expand ratio, output channels, number of blocks, stride
+	settings := [][4]int64{
+		{1, 16, 1, 1},
+		{6, 24, 2, 2},
+		{6, 32, 3, 2},
+		{6, 64, 4, 2},
+		{6, 96, 3, 1},
+		{6, 160, 3, 2},
+		{6, 320, 1, 1},
+	}
+	bnConfig := nn.DefaultBatchNormConfig()
+	features := p.Sub("features")
+
+	var seq Layers
+	seq.add(features.Sub("0"), convNormAct(features.Sub("0"), 3, 32, 3, 2, 1, bnConfig, relu6))
+	cIn := int64(32)
+	idx := 1
+	for _, s := range settings {
+		t, c, n, stride := s[0], s[1], s[2], s[3]
+		for i := 0; i < int(n); i++ {
+			if i > 0 {
+				stride = 1
+			}
+			path := features.Sub(fmt.Sprint(idx))
+			seq.add(path, newInvertedResidualV2(path, cIn, c, stride, t))
+			cIn = c
+			idx++
+		}
+	}
+	lastChannel := int64(1280)
+	path := features.Sub(fmt.Sprint(idx))
+	seq.add(path, convNormAct(path, cIn, lastChannel, 1, 1, 1, bnConfig, relu6))
+
+	return seq, lastChannel
+}
+
+// MobileNetV2 creates a MobileNetV2 model. Without final classifier if nclasses is 0.
+func MobileNetV2(p *nn.Path, nclasses int64) ts.ModuleT {
+	seq, lastChannel := mobileNetV2Features(p)
+	if nclasses <= 0 {
+		return pooledClassifier(seq, nil)
+	}
+
+	classifier := p.Sub("classifier")
+	head := nn.SeqT()
+	head.Add(dropout(0.2))
+	head.Add(nn.NewLinear(classifier.Sub("1"), lastChannel, nclasses, nn.DefaultLinearConfig()))
+
+	return pooledClassifier(seq, head)
+}
+
+// mobileNetV3Block holds settings of a MobileNetV3 block.
+type mobileNetV3Block struct {
+	cIn      int64
+	ksize    int64
+	expanded int64
+	cOut     int64
+	useSE    bool
+	act      activation
+	stride   int64
+}
+
+func newInvertedResidualV3(p *nn.Path, b mobileNetV3Block, bnConfig *nn.BatchNormConfig) *invertedResidual {
+	block := p.Sub("block")
+
+	seq := nn.SeqT()
+	idx := 0
+	if b.expanded != b.cIn {
+		seq.Add(convNormAct(block.Sub(fmt.Sprint(idx)), b.cIn, b.expanded, 1, 1, 1, bnConfig, b.act))
+		idx++
+	}
+	seq.Add(convNormAct(block.Sub(fmt.Sprint(idx)), b.expanded, b.expanded, b.ksize, b.stride, b.expanded, bnConfig, b.act))
+	idx++
+	if b.useSE {
+		cSqueeze := makeDivisible(float64(b.expanded/4), 8)
+		seq.Add(newSqueezeExcitation(block.Sub(fmt.Sprint(idx)), b.expanded, cSqueeze, relu, hardsigmoid))
+		idx++
+	}
+	seq.Add(convNormAct(block.Sub(fmt.Sprint(idx)), b.expanded, b.cOut, 1, 1, 1, bnConfig, nil))
+
+	return &invertedResidual{
+		Block:       seq,
+		UseResidual: b.stride == 1 && b.cIn == b.cOut,
+	}
+}
+
+// mobileNetV3Features creates MobileNetV3 layers before pooling and returns their output channels.
+func mobileNetV3Features(p *nn.Path, blocks []mobileNetV3Block) (Layers, int64) {
+	bnConfig := nn.DefaultBatchNormConfig()
+	bnConfig.Eps = 0.001
+	bnConfig.Momentum = 0.01
+	features := p.Sub("features")
+
+	var seq Layers
+	seq.add(features.Sub("0"), convNormAct(features.Sub("0"), 3, blocks[0].cIn, 3, 2, 1, bnConfig, hardswish))
+	for i, b := range blocks {
+		path := features.Sub(fmt.Sprint(i + 1))
+		seq.add(path, newInvertedResidualV3(path, b, bnConfig))
+	}
+	lastIn := blocks[len(blocks)-1].cOut
+	lastOut := 6 * lastIn
+	path := features.Sub(fmt.Sprint(len(blocks) + 1))
+	seq.add(path, convNormAct(path, lastIn, lastOut, 1, 1, 1, bnConfig, hardswish))
+
+	return seq, lastOut
+}
+
+func mobileNetV3(p *nn.Path, nclasses int64, blocks []mobileNetV3Block, lastChannel int64) ts.ModuleT {
+	seq, lastOut := mobileNetV3Features(p, blocks)
+	if nclasses <= 0 {
+		return pooledClassifier(seq, nil)
+	}
+
+	classifier := p.Sub("classifier")
+	head := nn.SeqT()
+	head.Add(nn.NewLinear(classifier.Sub("0"), lastOut, lastChannel, nn.DefaultLinearConfig()))
+	head.AddFn(nn.NewFunc(hardswish))
+	head.Add(dropout(0.2))
+	head.Add(nn.NewLinear(classifier.Sub("3"), lastChannel, nclasses, nn.DefaultLinearConfig()))
+
+	return pooledClassifier(seq, head)
+}
+
+// mobileNetV3LargeBlocks are block settings of MobileNetV3-Large.
+var mobileNetV3LargeBlocks = []mobileNetV3Block{
+	{16, 3, 16, 16, false, relu, 1},
+	{16, 3, 64, 24, false, relu, 2},
+	{24, 3, 72, 24, false, relu, 1},
+	{24, 5, 72, 40, true, relu, 2},
+	{40, 5, 120, 40, true, relu, 1},
+	{40, 5, 120, 40, true, relu, 1},
+	{40, 3, 240, 80, false, hardswish, 2},
+	{80, 3, 200, 80, false, hardswish, 1},
+	{80, 3, 184, 80, false, hardswish, 1},
+	{80, 3, 184, 80, false, hardswish, 1},
+	{80, 3, 480, 112, true, hardswish, 1},
+	{112, 3, 672, 112, true, hardswish, 1},
+	{112, 5, 672, 160, true, hardswish, 2},
+	{160, 5, 960, 160, true, hardswish, 1},
+	{160, 5, 960, 160, true, hardswish, 1},
+}
+
+// MobileNetV3Large creates a MobileNetV3-Large model. Without final classifier if nclasses is 0.
+func MobileNetV3Large(p *nn.Path, nclasses int64) ts.ModuleT {
+	return mobileNetV3(p, nclasses, mobileNetV3LargeBlocks, 1280)
+}
+
+// mobileNetV3SmallBlocks are block settings of MobileNetV3-Small.
+var mobileNetV3SmallBlocks = []mobileNetV3Block{
+	{16, 3, 16, 16, true, relu, 2},
+	{16, 3, 72, 24, false, relu, 2},
+	{24, 3, 88, 24, false, relu, 1},
+	{24, 5, 96, 40, true, hardswish, 2},
+	{40, 5, 240, 40, true, hardswish, 1},
+	{40, 5, 240, 40, true, hardswish, 1},
+	{40, 5, 120, 48, true, hardswish, 1},
+	{48, 5, 144, 48, true, hardswish, 1},
+	{48, 5, 288, 96, true, hardswish, 2},
+	{96, 5, 576, 96, true, hardswish, 1},
+	{96, 5, 576, 96, true, hardswish, 1},
+}
+
+// MobileNetV3Small creates a MobileNetV3-Small model. Without final classifier if nclasses is 0.
+func MobileNetV3Small(p *nn.Path, nclasses int64) ts.ModuleT {
+	return mobileNetV3(p, nclasses, mobileNetV3SmallBlocks, 1024)
+}
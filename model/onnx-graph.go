@@ -0,0 +1,365 @@
+package model
+
+// Graph of models exported by ExportONNX, built from variable data without libtorch.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// onnxHead is the layout of a Head in an exported graph. Names are variable prefixes as in
+// NewClassifierHead.
+type onnxHead struct {
+	pooling     string
+	gemName     string   // GeM exponent variable
+	hiddenNames []string // hidden linear layers with ReLU
+	fcNames     []string // output linear layers, concatenated
+	outputSize  int64
+}
+
+// buildONNX builds the graph of a classification model of backbone features and head from
+// variables. Mean and std, if any, normalize the input in graph.
+func buildONNX(backbone string, vars map[string]onnxTensor, head onnxHead, mean, std []float32, metadata map[string]string) (*onnxModel, error) {
+	b := &onnxBuilder{vars: vars, added: make(map[string]bool)}
+	x := "input"
+	if len(mean) > 0 {
+		mean := b.constant("mean", []int64{1, 3, 1, 1}, mean)
+		std := b.constant("std", []int64{1, 3, 1, 1}, std)
+		x = b.node("Div", []string{b.node("Sub", []string{x, mean}), std})
+	}
+	effNetFn, isEffNet := effNetParams[strings.TrimSuffix(strings.TrimPrefix(backbone, "tf_"), "_ns")]
+	switch {
+	case strings.HasPrefix(backbone, "densenet"):
+		x = b.denseNet(x)
+	case isEffNet:
+		x = b.effNet(x, effNetFn())
+	default:
+		x = b.resNet(x)
+	}
+	b.head(head, x)
+	if b.err != nil {
+		return nil, b.err
+	}
+	// Name output of the last node.
+	last := &b.graph.nodes[len(b.graph.nodes)-1]
+	last.outputs[0] = "output"
+
+	b.graph.name = backbone
+	b.graph.inputs = []onnxValueInfo{{name: "input", dims: []int64{0, 3, 0, 0}, params: []string{"N", "", "H", "W"}}}
+	b.graph.outputs = []onnxValueInfo{{name: "output", dims: []int64{0, head.outputSize}, params: []string{"N", ""}}}
+	return &onnxModel{producer: "lab", graph: b.graph, metadata: metadata}, nil
+}
+
+// onnxBuilder builds an ONNX graph from variables. Variables are added as initializers of the
+// same name on first use. The first error is kept in err.
+type onnxBuilder struct {
+	vars  map[string]onnxTensor
+	added map[string]bool
+	graph onnxGraph
+	n     int // number of node outputs
+	err   error
+}
+
+// has reports whether variable name exists.
+func (b *onnxBuilder) has(name string) bool {
+	_, ok := b.vars[name]
+	return ok
+}
+
+// param adds variable name as initializer and returns its name and shape.
+func (b *onnxBuilder) param(name string) (string, []int64) {
+	x, ok := b.vars[name]
+	if !ok {
+		if b.err == nil {
+			b.err = fmt.Errorf("missing variable %q", name)
+		}
+		return name, nil
+	}
+	if !b.added[name] {
+		b.constant(name, x.dims, x.data)
+	}
+	return name, x.dims
+}
+
+// constant adds an initializer and returns its name.
+func (b *onnxBuilder) constant(name string, dims []int64, data []float32) string {
+	b.graph.initializers = append(b.graph.initializers, onnxTensor{name: name, dims: dims, data: data})
+	b.added[name] = true
+	return name
+}
+
+// node adds a node of a single output and returns output name.
+func (b *onnxBuilder) node(opType string, inputs []string, attrs ...onnxAttr) string {
+	b.n++
+	out := fmt.Sprintf("%s_%d", strings.ToLower(opType), b.n)
+	b.graph.nodes = append(b.graph.nodes, onnxNode{opType: opType, inputs: inputs, outputs: []string{out}, attrs: attrs})
+	return out
+}
+
+// conv adds convolution of variables at prefix with "same" padding on x of cIn channels. It
+// returns output and its number of channels.
+func (b *onnxBuilder) conv(prefix, x string, cIn, stride int64) (string, int64) {
+	w, size := b.param(prefix + ".weight")
+	if len(size) != 4 {
+		if b.err == nil {
+			b.err = fmt.Errorf("expected 4D convolution weight %q, got shape %v", w, size)
+		}
+		return x, cIn
+	}
+	inputs := []string{x, w}
+	if b.has(prefix + ".bias") {
+		bias, _ := b.param(prefix + ".bias")
+		inputs = append(inputs, bias)
+	}
+	k, pad := size[2], (size[2]-1)/2
+	out := b.node("Conv", inputs,
+		intsAttr("kernel_shape", k, k),
+		intsAttr("strides", stride, stride),
+		intsAttr("pads", pad, pad, pad, pad),
+		intAttr("group", cIn/size[1]),
+	)
+	return out, size[0]
+}
+
+// convSame adds convolution of variables at prefix with TensorFlow "same" padding (enConv2d),
+// i.e. extra padding at the end, on x of cIn channels. It returns output and its number of
+// channels.
+func (b *onnxBuilder) convSame(prefix, x string, cIn, stride int64) (string, int64) {
+	w, size := b.param(prefix + ".weight")
+	if len(size) != 4 {
+		if b.err == nil {
+			b.err = fmt.Errorf("expected 4D convolution weight %q, got shape %v", w, size)
+		}
+		return x, cIn
+	}
+	if size[2] == 1 && stride == 1 {
+		return b.conv(prefix, x, cIn, stride)
+	}
+	inputs := []string{x, w}
+	if b.has(prefix + ".bias") {
+		bias, _ := b.param(prefix + ".bias")
+		inputs = append(inputs, bias)
+	}
+	out := b.node("Conv", inputs,
+		stringAttr("auto_pad", "SAME_UPPER"),
+		intsAttr("kernel_shape", size[2], size[3]),
+		intsAttr("strides", stride, stride),
+		intAttr("group", cIn/size[1]),
+	)
+	return out, size[0]
+}
+
+// batchNorm adds batch norm of variables at prefix in evaluation mode.
+func (b *onnxBuilder) batchNorm(prefix, x string) string {
+	return b.batchNormEps(prefix, x, 1e-5)
+}
+
+// batchNormEps adds batch norm of variables at prefix with epsilon eps in evaluation mode.
+func (b *onnxBuilder) batchNormEps(prefix, x string, eps float32) string {
+	inputs := []string{x}
+	for _, name := range []string{"weight", "bias", "running_mean", "running_var"} {
+		v, _ := b.param(prefix + "." + name)
+		inputs = append(inputs, v)
+	}
+	return b.node("BatchNormalization", inputs, floatAttr("epsilon", eps))
+}
+
+// gemm adds linear layer of variables at prefix on x [N, in].
+func (b *onnxBuilder) gemm(prefix, x string) string {
+	w, _ := b.param(prefix + ".weight")
+	bias, _ := b.param(prefix + ".bias")
+	return b.node("Gemm", []string{x, w, bias}, intAttr("transB", 1))
+}
+
+func (b *onnxBuilder) relu(x string) string {
+	return b.node("Relu", []string{x})
+}
+
+// swish adds x * sigmoid(x).
+func (b *onnxBuilder) swish(x string) string {
+	return b.node("Mul", []string{x, b.node("Sigmoid", []string{x})})
+}
+
+// maxPool adds 3x3 max pooling of stride 2 of ResNet and DenseNet stems.
+func (b *onnxBuilder) maxPool(x string) string {
+	return b.node("MaxPool", []string{x},
+		intsAttr("kernel_shape", 3, 3),
+		intsAttr("strides", 2, 2),
+		intsAttr("pads", 1, 1, 1, 1),
+	)
+}
+
+// flatten flattens pooled x [N, C, 1, 1] to [N, C].
+func (b *onnxBuilder) flatten(x string) string {
+	return b.node("Flatten", []string{x}, intAttr("axis", 1))
+}
+
+// resNet adds ResNet features of resNetBackbone. Number of blocks, bottleneck, groups and
+// squeeze-excitation are inferred from variables.
+func (b *onnxBuilder) resNet(x string) string {
+	x, c := b.conv("conv1", x, 3, 2)
+	x = b.maxPool(b.relu(b.batchNorm("bn1", x)))
+	for i := 1; i <= 4; i++ {
+		for j := 0; b.has(fmt.Sprintf("layer%d.%d.conv1.weight", i, j)); j++ {
+			stride := int64(1)
+			if i > 1 && j == 0 {
+				stride = 2
+			}
+			x, c = b.resBlock(fmt.Sprintf("layer%d.%d", i, j), x, c, stride)
+		}
+	}
+	return x
+}
+
+// resBlock adds a basic or bottleneck residual block at prefix. Stride applies to the 3x3
+// convolution: conv1 of basic blocks and conv2 of bottleneck blocks.
+func (b *onnxBuilder) resBlock(prefix, x string, cIn, stride int64) (string, int64) {
+	strided := 1
+	if b.has(prefix + ".conv3.weight") {
+		strided = 2
+	}
+	out, c := x, cIn
+	for k := 1; b.has(fmt.Sprintf("%s.conv%d.weight", prefix, k)); k++ {
+		s := int64(1)
+		if k == strided {
+			s = stride
+		}
+		out, c = b.conv(fmt.Sprintf("%s.conv%d", prefix, k), out, c, s)
+		out = b.batchNorm(fmt.Sprintf("%s.bn%d", prefix, k), out)
+		if b.has(fmt.Sprintf("%s.conv%d.weight", prefix, k+1)) {
+			out = b.relu(out)
+		}
+	}
+	if b.has(prefix + ".se.fc1.weight") {
+		s := b.node("GlobalAveragePool", []string{out})
+		s, cs := b.conv(prefix+".se.fc1", s, c, 1)
+		s, _ = b.conv(prefix+".se.fc2", b.relu(s), cs, 1)
+		out = b.node("Mul", []string{out, b.node("Sigmoid", []string{s})})
+	}
+
+	shortcut := x
+	if b.has(prefix + ".downsample.0.weight") {
+		shortcut, _ = b.conv(prefix+".downsample.0", x, cIn, stride)
+		shortcut = b.batchNorm(prefix+".downsample.1", shortcut)
+	}
+	return b.relu(b.node("Add", []string{shortcut, out})), c
+}
+
+// denseNet adds DenseNet features of denseNetBackbone. Number of blocks and layers are inferred
+// from variables.
+func (b *onnxBuilder) denseNet(x string) string {
+	x, c := b.conv("features.conv0", x, 3, 2)
+	x = b.maxPool(b.relu(b.batchNorm("features.norm0", x)))
+	for i := 1; b.has(fmt.Sprintf("features.denseblock%d.denselayer1.conv1.weight", i)); i++ {
+		if i > 1 {
+			x, c = b.conv(fmt.Sprintf("features.transition%d.conv", i-1), x, c, 1)
+			x = b.node("AveragePool", []string{x},
+				intsAttr("kernel_shape", 2, 2),
+				intsAttr("strides", 2, 2),
+			)
+		}
+		for j := 1; b.has(fmt.Sprintf("features.denseblock%d.denselayer%d.conv1.weight", i, j)); j++ {
+			prefix := fmt.Sprintf("features.denseblock%d.denselayer%d", i, j)
+			y := b.relu(b.batchNorm(prefix+".norm1", x))
+			y, cy := b.conv(prefix+".conv1", y, c, 1)
+			y = b.relu(b.batchNorm(prefix+".norm2", y))
+			y, cy = b.conv(prefix+".conv2", y, cy, 1)
+			x = b.node("Concat", []string{x, y}, intAttr("axis", 1))
+			c += cy
+		}
+		// Transition norm, or norm5 after the last block.
+		norm := fmt.Sprintf("features.transition%d.norm", i)
+		if !b.has(norm + ".weight") {
+			norm = "features.norm5"
+		}
+		x = b.relu(b.batchNorm(norm, x))
+	}
+	return x
+}
+
+// effNet adds EfficientNet features of effNetFeatures. Block strides follow blockArgs of params,
+// expansion and squeeze-excitation are inferred from variables.
+func (b *onnxBuilder) effNet(x string, params *params) string {
+	var strides []int64
+	for _, arg := range blockArgs() {
+		strides = append(strides, arg.Stride)
+		for i := 1; i < int(params.roundRepeats(arg.NumRepeat)); i++ {
+			strides = append(strides, 1)
+		}
+	}
+
+	x, c := b.convSame("_conv_stem", x, 3, 2)
+	x = b.swish(b.batchNormEps("_bn0", x, float32(batchNormEpsilon)))
+	for i := 0; b.has(fmt.Sprintf("_blocks.%d._project_conv.weight", i)); i++ {
+		if i >= len(strides) {
+			if b.err == nil {
+				b.err = fmt.Errorf("expected %d EfficientNet blocks, got block %d", len(strides), i)
+			}
+			return x
+		}
+		x, c = b.mbConv(fmt.Sprintf("_blocks.%d", i), x, c, strides[i])
+	}
+	x, _ = b.convSame("_conv_head", x, c, 1)
+	return b.swish(b.batchNormEps("_bn1", x, float32(batchNormEpsilon)))
+}
+
+// mbConv adds an EfficientNet block at prefix with residual connection if stride is 1 and
+// number of channels is kept.
+func (b *onnxBuilder) mbConv(prefix, x string, cIn, stride int64) (string, int64) {
+	eps := float32(batchNormEpsilon)
+	out, c := x, cIn
+	if b.has(prefix + "._expand_conv.weight") {
+		out, c = b.convSame(prefix+"._expand_conv", out, c, 1)
+		out = b.swish(b.batchNormEps(prefix+"._bn0", out, eps))
+	}
+	out, c = b.convSame(prefix+"._depthwise_conv", out, c, stride)
+	out = b.swish(b.batchNormEps(prefix+"._bn1", out, eps))
+	if b.has(prefix + "._se_reduce.weight") {
+		s := b.node("GlobalAveragePool", []string{out})
+		s, cs := b.convSame(prefix+"._se_reduce", s, c, 1)
+		s, _ = b.convSame(prefix+"._se_expand", b.swish(s), cs, 1)
+		out = b.node("Mul", []string{out, b.node("Sigmoid", []string{s})})
+	}
+	out, c = b.convSame(prefix+"._project_conv", out, c, 1)
+	out = b.batchNormEps(prefix+"._bn2", out, eps)
+	if stride == 1 && c == cIn {
+		out = b.node("Add", []string{out, x})
+	}
+	return out, c
+}
+
+// head adds head h in evaluation mode.
+func (b *onnxBuilder) head(h onnxHead, x string) string {
+	switch h.pooling {
+	case "max":
+		x = b.flatten(b.node("GlobalMaxPool", []string{x}))
+	case "gem":
+		p, _ := b.param(h.gemName)
+		eps := b.constant("head.gem.eps", []int64{1}, []float32{1e-6})
+		powed := b.node("Pow", []string{b.node("Max", []string{x, eps}), p})
+		avg := b.node("GlobalAveragePool", []string{powed})
+		x = b.flatten(b.node("Pow", []string{avg, b.node("Reciprocal", []string{p})}))
+	case "avgmax":
+		avg := b.flatten(b.node("GlobalAveragePool", []string{x}))
+		mx := b.flatten(b.node("GlobalMaxPool", []string{x}))
+		x = b.node("Concat", []string{avg, mx}, intAttr("axis", 1))
+	default:
+		x = b.flatten(b.node("GlobalAveragePool", []string{x}))
+	}
+	for _, name := range h.hiddenNames {
+		x = b.relu(b.gemm(name, x))
+	}
+
+	switch len(h.fcNames) {
+	case 0:
+		// Identity so that output is produced by the last node.
+		return b.node("Identity", []string{x})
+	case 1:
+		return b.gemm(h.fcNames[0], x)
+	}
+	outputs := make([]string, len(h.fcNames))
+	for i, name := range h.fcNames {
+		outputs[i] = b.gemm(name, x)
+	}
+	return b.node("Concat", outputs, intAttr("axis", 1))
+}
@@ -0,0 +1,511 @@
+package model
+
+// Protocol buffers wire format of the ONNX messages used by ExportONNX and LoadONNX.
+//
+// Only fields written by ExportONNX are encoded and decoded; other fields are skipped. Field
+// numbers follow https://github.com/onnx/onnx/blob/main/onnx/onnx.proto. Repeated scalars
+// are written unpacked (proto2) and read packed or unpacked.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	onnxIRVersion int64 = 7  // IR version of opset 13
+	onnxOpset     int64 = 13 // default domain opset
+	onnxFloat     int64 = 1  // TensorProto.FLOAT
+
+	// AttributeProto.AttributeType
+	onnxAttrFloat  int64 = 1
+	onnxAttrInt    int64 = 2
+	onnxAttrString int64 = 3
+	onnxAttrInts   int64 = 7
+)
+
+// onnxModel is an ONNX ModelProto.
+type onnxModel struct {
+	producer string
+	graph    onnxGraph
+	metadata map[string]string
+}
+
+// onnxGraph is an ONNX GraphProto.
+type onnxGraph struct {
+	name         string
+	nodes        []onnxNode
+	initializers []onnxTensor
+	inputs       []onnxValueInfo
+	outputs      []onnxValueInfo
+}
+
+// onnxNode is an ONNX NodeProto of the default domain.
+type onnxNode struct {
+	opType  string
+	inputs  []string
+	outputs []string
+	attrs   []onnxAttr
+}
+
+// onnxAttr is an ONNX AttributeProto of type float, int, string or ints.
+type onnxAttr struct {
+	name string
+	typ  int64
+	f    float32
+	i    int64
+	s    string
+	ints []int64
+}
+
+// onnxTensor is an ONNX TensorProto of float data.
+type onnxTensor struct {
+	name string
+	dims []int64
+	data []float32
+}
+
+// onnxValueInfo is an ONNX ValueInfoProto of a float tensor. Dims of value <= 0 are symbolic
+// and named by params.
+type onnxValueInfo struct {
+	name   string
+	dims   []int64
+	params []string
+}
+
+func intAttr(name string, v int64) onnxAttr {
+	return onnxAttr{name: name, typ: onnxAttrInt, i: v}
+}
+
+func intsAttr(name string, v ...int64) onnxAttr {
+	return onnxAttr{name: name, typ: onnxAttrInts, ints: v}
+}
+
+func floatAttr(name string, v float32) onnxAttr {
+	return onnxAttr{name: name, typ: onnxAttrFloat, f: v}
+}
+
+func stringAttr(name string, v string) onnxAttr {
+	return onnxAttr{name: name, typ: onnxAttrString, s: v}
+}
+
+// attr returns attribute of node by name.
+func (n *onnxNode) attr(name string) (onnxAttr, bool) {
+	for _, a := range n.attrs {
+		if a.name == name {
+			return a, true
+		}
+	}
+	return onnxAttr{}, false
+}
+
+// protoWriter appends protocol buffers fields to buf.
+type protoWriter struct {
+	buf []byte
+}
+
+func (w *protoWriter) varint(v uint64) {
+	for v >= 0x80 {
+		w.buf = append(w.buf, byte(v)|0x80)
+		v >>= 7
+	}
+	w.buf = append(w.buf, byte(v))
+}
+
+func (w *protoWriter) tag(field int, wireType int) {
+	w.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (w *protoWriter) int(field int, v int64) {
+	w.tag(field, 0)
+	w.varint(uint64(v))
+}
+
+func (w *protoWriter) float(field int, v float32) {
+	w.tag(field, 5)
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], math.Float32bits(v))
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *protoWriter) bytes(field int, b []byte) {
+	w.tag(field, 2)
+	w.varint(uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *protoWriter) string(field int, s string) {
+	w.bytes(field, []byte(s))
+}
+
+func (m *onnxModel) encode() []byte {
+	w := &protoWriter{}
+	w.int(1, onnxIRVersion)
+	w.string(2, m.producer)
+	w.bytes(7, m.graph.encode())
+	opset := &protoWriter{}
+	opset.string(1, "")
+	opset.int(2, onnxOpset)
+	w.bytes(8, opset.buf)
+
+	keys := make([]string, 0, len(m.metadata))
+	for k := range m.metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		entry := &protoWriter{}
+		entry.string(1, k)
+		entry.string(2, m.metadata[k])
+		w.bytes(14, entry.buf)
+	}
+
+	return w.buf
+}
+
+func (g *onnxGraph) encode() []byte {
+	w := &protoWriter{}
+	for _, n := range g.nodes {
+		w.bytes(1, n.encode())
+	}
+	w.string(2, g.name)
+	for _, t := range g.initializers {
+		w.bytes(5, t.encode())
+	}
+	for _, v := range g.inputs {
+		w.bytes(11, v.encode())
+	}
+	for _, v := range g.outputs {
+		w.bytes(12, v.encode())
+	}
+	return w.buf
+}
+
+func (n *onnxNode) encode() []byte {
+	w := &protoWriter{}
+	for _, s := range n.inputs {
+		w.string(1, s)
+	}
+	for _, s := range n.outputs {
+		w.string(2, s)
+	}
+	w.string(4, n.opType)
+	for _, a := range n.attrs {
+		w.bytes(5, a.encode())
+	}
+	return w.buf
+}
+
+func (a *onnxAttr) encode() []byte {
+	w := &protoWriter{}
+	w.string(1, a.name)
+	switch a.typ {
+	case onnxAttrFloat:
+		w.float(2, a.f)
+	case onnxAttrInt:
+		w.int(3, a.i)
+	case onnxAttrString:
+		w.string(4, a.s)
+	case onnxAttrInts:
+		for _, v := range a.ints {
+			w.int(8, v)
+		}
+	}
+	w.int(20, a.typ)
+	return w.buf
+}
+
+func (t *onnxTensor) encode() []byte {
+	w := &protoWriter{}
+	for _, d := range t.dims {
+		w.int(1, d)
+	}
+	w.int(2, onnxFloat)
+	w.string(8, t.name)
+	raw := make([]byte, 4*len(t.data))
+	for i, v := range t.data {
+		binary.LittleEndian.PutUint32(raw[4*i:], math.Float32bits(v))
+	}
+	w.bytes(9, raw)
+	return w.buf
+}
+
+func (v *onnxValueInfo) encode() []byte {
+	shape := &protoWriter{}
+	for i, d := range v.dims {
+		dim := &protoWriter{}
+		if d > 0 {
+			dim.int(1, d)
+		} else {
+			dim.string(2, v.params[i])
+		}
+		shape.bytes(1, dim.buf)
+	}
+	tensorType := &protoWriter{}
+	tensorType.int(1, onnxFloat)
+	tensorType.bytes(2, shape.buf)
+	typ := &protoWriter{}
+	typ.bytes(1, tensorType.buf)
+
+	w := &protoWriter{}
+	w.string(1, v.name)
+	w.bytes(2, typ.buf)
+	return w.buf
+}
+
+// protoField is a decoded protocol buffers field. Value v holds varint and fixed-size values,
+// b length-delimited values.
+type protoField struct {
+	num      int
+	wireType int
+	v        uint64
+	b        []byte
+}
+
+func readVarint(b []byte) (uint64, int, error) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * i)
+		if b[i] < 0x80 {
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid varint")
+}
+
+// protoFields decodes fields of a message.
+func protoFields(b []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(b) > 0 {
+		key, n, err := readVarint(b)
+		if err != nil {
+			return nil, err
+		}
+		b = b[n:]
+		f := protoField{num: int(key >> 3), wireType: int(key & 7)}
+		switch f.wireType {
+		case 0:
+			f.v, n, err = readVarint(b)
+			if err != nil {
+				return nil, err
+			}
+		case 1:
+			if len(b) < 8 {
+				return nil, fmt.Errorf("truncated fixed64 field %d", f.num)
+			}
+			f.v, n = binary.LittleEndian.Uint64(b), 8
+		case 2:
+			size, m, err := readVarint(b)
+			if err != nil {
+				return nil, err
+			}
+			if uint64(len(b)-m) < size {
+				return nil, fmt.Errorf("truncated field %d", f.num)
+			}
+			f.b, n = b[m:m+int(size)], m+int(size)
+		case 5:
+			if len(b) < 4 {
+				return nil, fmt.Errorf("truncated fixed32 field %d", f.num)
+			}
+			f.v, n = uint64(binary.LittleEndian.Uint32(b)), 4
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d of field %d", f.wireType, f.num)
+		}
+		b = b[n:]
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// ints returns values of a repeated int64 field, packed or not.
+func (f protoField) ints() ([]int64, error) {
+	if f.wireType == 0 {
+		return []int64{int64(f.v)}, nil
+	}
+	var vals []int64
+	b := f.b
+	for len(b) > 0 {
+		v, n, err := readVarint(b)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, int64(v))
+		b = b[n:]
+	}
+	return vals, nil
+}
+
+func decodeONNXModel(b []byte) (*onnxModel, error) {
+	fields, err := protoFields(b)
+	if err != nil {
+		return nil, err
+	}
+	m := &onnxModel{metadata: make(map[string]string)}
+	for _, f := range fields {
+		switch f.num {
+		case 2:
+			m.producer = string(f.b)
+		case 7:
+			if err := m.graph.decode(f.b); err != nil {
+				return nil, err
+			}
+		case 14:
+			entry, err := protoFields(f.b)
+			if err != nil {
+				return nil, err
+			}
+			var k, v string
+			for _, e := range entry {
+				switch e.num {
+				case 1:
+					k = string(e.b)
+				case 2:
+					v = string(e.b)
+				}
+			}
+			m.metadata[k] = v
+		}
+	}
+	return m, nil
+}
+
+func (g *onnxGraph) decode(b []byte) error {
+	fields, err := protoFields(b)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			var n onnxNode
+			if err := n.decode(f.b); err != nil {
+				return err
+			}
+			g.nodes = append(g.nodes, n)
+		case 2:
+			g.name = string(f.b)
+		case 5:
+			var t onnxTensor
+			if err := t.decode(f.b); err != nil {
+				return err
+			}
+			g.initializers = append(g.initializers, t)
+		case 11, 12:
+			v, err := decodeValueInfoName(f.b)
+			if err != nil {
+				return err
+			}
+			if f.num == 11 {
+				g.inputs = append(g.inputs, v)
+			} else {
+				g.outputs = append(g.outputs, v)
+			}
+		}
+	}
+	return nil
+}
+
+func (n *onnxNode) decode(b []byte) error {
+	fields, err := protoFields(b)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			n.inputs = append(n.inputs, string(f.b))
+		case 2:
+			n.outputs = append(n.outputs, string(f.b))
+		case 4:
+			n.opType = string(f.b)
+		case 5:
+			var a onnxAttr
+			if err := a.decode(f.b); err != nil {
+				return err
+			}
+			n.attrs = append(n.attrs, a)
+		}
+	}
+	return nil
+}
+
+func (a *onnxAttr) decode(b []byte) error {
+	fields, err := protoFields(b)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			a.name = string(f.b)
+		case 2:
+			a.f = math.Float32frombits(uint32(f.v))
+		case 3:
+			a.i = int64(f.v)
+		case 4:
+			a.s = string(f.b)
+		case 8:
+			vals, err := f.ints()
+			if err != nil {
+				return err
+			}
+			a.ints = append(a.ints, vals...)
+		case 20:
+			a.typ = int64(f.v)
+		}
+	}
+	return nil
+}
+
+func (t *onnxTensor) decode(b []byte) error {
+	fields, err := protoFields(b)
+	if err != nil {
+		return err
+	}
+	dtype := onnxFloat
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			vals, err := f.ints()
+			if err != nil {
+				return err
+			}
+			t.dims = append(t.dims, vals...)
+		case 2:
+			dtype = int64(f.v)
+		case 8:
+			t.name = string(f.b)
+		case 9:
+			if len(f.b)%4 != 0 {
+				return fmt.Errorf("tensor %q: raw data size %d not a multiple of 4", t.name, len(f.b))
+			}
+			t.data = make([]float32, len(f.b)/4)
+			for i := range t.data {
+				t.data[i] = math.Float32frombits(binary.LittleEndian.Uint32(f.b[4*i:]))
+			}
+		}
+	}
+	if dtype != onnxFloat {
+		return fmt.Errorf("tensor %q: unsupported data type %d", t.name, dtype)
+	}
+	if int64(len(t.data)) != numel(t.dims) {
+		return fmt.Errorf("tensor %q: expected %d values of shape %v, got %d", t.name, numel(t.dims), t.dims, len(t.data))
+	}
+	return nil
+}
+
+// decodeValueInfoName decodes name of a ValueInfoProto. Type is not decoded.
+func decodeValueInfoName(b []byte) (onnxValueInfo, error) {
+	var v onnxValueInfo
+	fields, err := protoFields(b)
+	if err != nil {
+		return v, err
+	}
+	for _, f := range fields {
+		if f.num == 1 {
+			v.name = string(f.b)
+		}
+	}
+	return v, nil
+}
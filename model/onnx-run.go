@@ -0,0 +1,185 @@
+package model
+
+// Runner of exported ONNX graphs with libtorch ops, to check exports without an ONNX runtime.
+// Operators are tested against the ONNX operator definitions; onnxruntime remains the reference
+// of exports (see testdata/check_onnx.py).
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sugarme/gotch/ts"
+)
+
+// onnxOps are operators supported by ONNXModel, i.e. those written by ExportONNX.
+var onnxOps map[string]bool = map[string]bool{
+	"Conv": true, "BatchNormalization": true, "MaxPool": true, "AveragePool": true, "GlobalAveragePool": true,
+	"GlobalMaxPool": true, "Flatten": true, "Gemm": true, "Concat": true, "Identity": true,
+	"Relu": true, "Sigmoid": true, "Reciprocal": true,
+	"Add": true, "Sub": true, "Mul": true, "Div": true, "Pow": true, "Max": true,
+}
+
+// ONNXModel is an ONNX graph written by ExportONNX, run with libtorch ops on CPU.
+type ONNXModel struct {
+	Metadata map[string]string
+	graph    onnxGraph
+	params   map[string]*ts.Tensor
+}
+
+// LoadONNX loads an ONNX file written by ExportONNX. Other graphs may use unsupported
+// operators or data types and fail to load.
+func LoadONNX(file string) (*ONNXModel, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		err = fmt.Errorf("LoadONNX failed: %w", err)
+		return nil, err
+	}
+	m, err := decodeONNXModel(b)
+	if err != nil {
+		err = fmt.Errorf("LoadONNX failed: %w", err)
+		return nil, err
+	}
+	if len(m.graph.inputs) != 1 || len(m.graph.outputs) != 1 {
+		err = fmt.Errorf("LoadONNX failed: expected single input and output, got %d and %d", len(m.graph.inputs), len(m.graph.outputs))
+		return nil, err
+	}
+	for _, n := range m.graph.nodes {
+		if !onnxOps[n.opType] {
+			err = fmt.Errorf("LoadONNX failed: unsupported operator %q", n.opType)
+			return nil, err
+		}
+	}
+
+	params := make(map[string]*ts.Tensor, len(m.graph.initializers))
+	for _, t := range m.graph.initializers {
+		params[t.name] = ts.MustOfSlice(t.data).MustView(t.dims, true)
+	}
+
+	return &ONNXModel{Metadata: m.metadata, graph: m.graph, params: params}, nil
+}
+
+// Forward runs graph on CPU input x and returns graph output.
+func (m *ONNXModel) Forward(x *ts.Tensor) (retVal *ts.Tensor, err error) {
+	values := map[string]*ts.Tensor{m.graph.inputs[0].name: x}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("ONNXModel Forward failed: %v", r)
+		}
+		for name, v := range values {
+			if name != m.graph.inputs[0].name && v != retVal {
+				v.MustDrop()
+			}
+		}
+	}()
+
+	for _, n := range m.graph.nodes {
+		inputs := make([]*ts.Tensor, len(n.inputs))
+		for i, name := range n.inputs {
+			v, ok := values[name]
+			if !ok {
+				v, ok = m.params[name]
+			}
+			if !ok {
+				err = fmt.Errorf("ONNXModel Forward failed: node %s: undefined input %q", n.opType, name)
+				return nil, err
+			}
+			inputs[i] = v
+		}
+		values[n.outputs[0]] = runONNXNode(&n, inputs)
+	}
+
+	retVal = values[m.graph.outputs[0].name]
+	return retVal, nil
+}
+
+// Drop deletes initializer tensors.
+func (m *ONNXModel) Drop() {
+	for _, x := range m.params {
+		x.MustDrop()
+	}
+}
+
+// runONNXNode applies operator of node n to inputs in evaluation mode.
+func runONNXNode(n *onnxNode, inputs []*ts.Tensor) *ts.Tensor {
+	ints := func(name string, defaults ...int64) []int64 {
+		if a, ok := n.attr(name); ok {
+			return a.ints
+		}
+		return defaults
+	}
+	x := inputs[0]
+
+	switch n.opType {
+	case "Conv":
+		bias := ts.NewTensor()
+		if len(inputs) > 2 {
+			bias = inputs[2]
+		}
+		group, _ := n.attr("group")
+		strides := ints("strides", 1, 1)
+		if autoPad, _ := n.attr("auto_pad"); autoPad.s == "SAME_UPPER" {
+			// Output size is ceil(input / stride) with extra padding at the end.
+			size, k := x.MustSize(), inputs[1].MustSize()
+			pads := make([]int64, 2)
+			for i := range pads {
+				out := (size[2+i] + strides[i] - 1) / strides[i]
+				if pad := (out-1)*strides[i] + k[2+i] - size[2+i]; pad > 0 {
+					pads[i] = pad
+				}
+			}
+			padded := x.MustZeroPad2d(pads[1]/2, pads[1]-pads[1]/2, pads[0]/2, pads[0]-pads[0]/2, false)
+			defer padded.MustDrop()
+			return ts.MustConv2d(padded, inputs[1], bias, strides, []int64{0, 0}, ints("dilations", 1, 1), group.i)
+		}
+		return ts.MustConv2d(x, inputs[1], bias, strides, ints("pads", 0, 0)[:2], ints("dilations", 1, 1), group.i)
+	case "BatchNormalization":
+		eps, _ := n.attr("epsilon")
+		return ts.MustBatchNorm(x, inputs[1], inputs[2], inputs[3], inputs[4], false, 0.1, float64(eps.f), false)
+	case "MaxPool":
+		return x.MustMaxPool2d(ints("kernel_shape"), ints("strides", 1, 1), ints("pads", 0, 0)[:2], []int64{1, 1}, false, false)
+	case "AveragePool":
+		return x.MustAvgPool2d(ints("kernel_shape"), ints("strides", 1, 1), ints("pads", 0, 0)[:2], false, false, nil, false)
+	case "GlobalAveragePool":
+		return x.MustAdaptiveAvgPool2d([]int64{1, 1}, false)
+	case "GlobalMaxPool":
+		return x.MustAmax([]int64{2, 3}, true, false)
+	case "Flatten":
+		axis, _ := n.attr("axis")
+		return x.MustFlatten(axis.i, -1, false)
+	case "Gemm":
+		w := inputs[1]
+		if transB, _ := n.attr("transB"); transB.i == 1 {
+			w = inputs[1].MustT(false)
+			defer w.MustDrop()
+		}
+		return x.MustMatmul(w, false).MustAdd(inputs[2], true)
+	case "Concat":
+		axis, _ := n.attr("axis")
+		tensors := make([]ts.Tensor, len(inputs))
+		for i, v := range inputs {
+			tensors[i] = *v
+		}
+		return ts.MustCat(tensors, axis.i)
+	case "Identity":
+		return x.MustShallowClone()
+	case "Relu":
+		return x.MustRelu(false)
+	case "Sigmoid":
+		return x.MustSigmoid(false)
+	case "Reciprocal":
+		return x.MustReciprocal(false)
+	case "Add":
+		return x.MustAdd(inputs[1], false)
+	case "Sub":
+		return x.MustSub(inputs[1], false)
+	case "Mul":
+		return x.MustMul(inputs[1], false)
+	case "Div":
+		return x.MustDiv(inputs[1], false)
+	case "Pow":
+		return x.MustPow(inputs[1], false)
+	case "Max":
+		return x.MustMaximum(inputs[1], false)
+	}
+	panic(fmt.Sprintf("unsupported operator %q", n.opType))
+}
@@ -0,0 +1,184 @@
+package model
+
+// Export of classification models to ONNX graphs for deployment.
+//
+// Modules are Go functions without a graph to trace, so the graph is rebuilt from the model
+// architecture and its variables. Supported backbones are ResNet, ResNeXt, Wide ResNet, SE-ResNet,
+// EfficientNet and DenseNet with any Head; other backbones are rejected (see ONNXSupported). The
+// graph is built in onnx-graph.go and run by LoadONNX (onnx-run.go), whose operators are tested
+// against the ONNX operator definitions. Golden graphs in testdata are validated with
+// onnx.checker and onnxruntime by testdata/check_onnx.py, which is the independent check of
+// exports: set LAB_REQUIRE_ONNXRUNTIME=1 to fail instead of skip when onnxruntime is missing.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+// ExportOptions holds preprocessing and labels of an exported model. They are stored as model
+// metadata "mean", "std" (JSON arrays), "class_names" (JSON array) and "normalized" ("true" if
+// normalization is part of the graph). Tasks of a multi-task head are stored as "tasks".
+type ExportOptions struct {
+	Mean       []float64         // input normalization mean per channel
+	Std        []float64         // input normalization standard deviation per channel
+	Normalize  bool              // apply Mean and Std in graph so that input is an image in [0, 1]. Default false.
+	ClassNames []string          // class names in output order
+	Metadata   map[string]string // additional metadata
+}
+
+type ExportOption func(*ExportOptions)
+
+func defaultExportOptions() *ExportOptions {
+	return &ExportOptions{
+		Metadata: make(map[string]string),
+	}
+}
+
+// WithNormalization sets input normalization of exported model, i.e. mean and standard
+// deviation of Normalize augment of valid transform.
+func WithNormalization(mean, std []float64) ExportOption {
+	return func(o *ExportOptions) {
+		o.Mean = mean
+		o.Std = std
+	}
+}
+
+// WithNormalizeInGraph sets whether normalization is applied in exported graph.
+func WithNormalizeInGraph(v bool) ExportOption {
+	return func(o *ExportOptions) {
+		o.Normalize = v
+	}
+}
+
+func WithClassNames(names []string) ExportOption {
+	return func(o *ExportOptions) {
+		o.ClassNames = names
+	}
+}
+
+func WithExportMetadata(metadata map[string]string) ExportOption {
+	return func(o *ExportOptions) {
+		for k, v := range metadata {
+			o.Metadata[k] = v
+		}
+	}
+}
+
+// ONNXSupported reports whether ExportONNX supports backbone: ResNet, ResNeXt, Wide ResNet,
+// SE-ResNet, EfficientNet and DenseNet. Graphs of other backbones (MobileNet, RegNet, VGG and
+// ConvNeXt) are not implemented.
+func ONNXSupported(backbone string) bool {
+	_, isResNeXt := resNeXtConfigs[backbone]
+	_, isSEResNet := seResNetConfigs[backbone]
+	_, isEffNet := effNetParams[strings.TrimSuffix(strings.TrimPrefix(backbone, "tf_"), "_ns")]
+	return strings.HasPrefix(backbone, "resnet") || strings.HasPrefix(backbone, "densenet") || isResNeXt || isSEResNet || isEffNet
+}
+
+// ExportONNX writes a classification model of backbone features and head to an ONNX file
+// (opset 13). Variables of vs are named as in Features and NewClassifierHead. Graph input
+// "input" is [N, 3, H, W] and output "output" is head output [N, head.OutputSize()] in
+// evaluation mode.
+func ExportONNX(file string, vs *nn.VarStore, backbone string, head *Head, opts ...ExportOption) error {
+	options := defaultExportOptions()
+	for _, o := range opts {
+		o(options)
+	}
+
+	if !ONNXSupported(backbone) {
+		err := fmt.Errorf("ExportONNX failed: unsupported backbone %q. Expected ResNet, ResNeXt, Wide ResNet, SE-ResNet, EfficientNet or DenseNet", backbone)
+		return err
+	}
+	if head.poolSize > 0 || head.norm != nil {
+		err := fmt.Errorf("ExportONNX failed: unsupported head of backbone %q", backbone)
+		return err
+	}
+	if options.Normalize && (len(options.Mean) != 3 || len(options.Std) != 3) {
+		err := fmt.Errorf("ExportONNX failed: normalization in graph requires 3 mean and std values, got %v and %v", options.Mean, options.Std)
+		return err
+	}
+
+	metadata := map[string]string{"backbone": backbone, "input_layout": "NCHW RGB"}
+	for k, v := range options.Metadata {
+		metadata[k] = v
+	}
+	metadata["normalized"] = fmt.Sprint(options.Normalize)
+	values := make(map[string]interface{})
+	if len(options.Mean) > 0 {
+		values["mean"] = options.Mean
+	}
+	if len(options.Std) > 0 {
+		values["std"] = options.Std
+	}
+	if len(options.ClassNames) > 0 {
+		values["class_names"] = options.ClassNames
+	}
+	if len(head.tasks) > 0 && head.tasks[0].Name != "" {
+		values["tasks"] = head.tasks
+	}
+	for k, v := range values {
+		b, err := json.Marshal(v)
+		if err != nil {
+			err = fmt.Errorf("ExportONNX failed: %w", err)
+			return err
+		}
+		metadata[k] = string(b)
+	}
+
+	spec := onnxHead{
+		pooling:     head.pooling,
+		gemName:     head.gemName,
+		hiddenNames: head.hiddenNames,
+		fcNames:     head.fcNames,
+		outputSize:  head.OutputSize(),
+	}
+	vars := make(map[string]onnxTensor)
+	for name, x := range vs.Variables() {
+		vars[name] = onnxTensor{name: name, dims: x.MustSize(), data: float32Data(&x)}
+	}
+	var mean, std []float32
+	if options.Normalize {
+		mean, std = float32s(options.Mean), float32s(options.Std)
+	}
+	m, err := buildONNX(backbone, vars, spec, mean, std, metadata)
+	if err != nil {
+		err = fmt.Errorf("ExportONNX failed: %w", err)
+		return err
+	}
+
+	tmpFile := file + ".tmp"
+	if err := os.WriteFile(tmpFile, m.encode(), 0644); err != nil {
+		err = fmt.Errorf("ExportONNX failed: %w", err)
+		return err
+	}
+	if err := os.Rename(tmpFile, file); err != nil {
+		err = fmt.Errorf("ExportONNX failed: %w", err)
+		return err
+	}
+
+	return nil
+}
+
+// float32Data returns values of x as float32 on CPU.
+func float32Data(x *ts.Tensor) []float32 {
+	detached := x.MustDetach(false)
+	cpu := detached.MustTo(gotch.CPU, true)
+	float := cpu.MustTotype(gotch.Float, true)
+	contiguous := float.MustContiguous(true)
+	values := contiguous.Vals().([]float32)
+	contiguous.MustDrop()
+	return values
+}
+
+func float32s(v []float64) []float32 {
+	out := make([]float32, len(v))
+	for i, e := range v {
+		out[i] = float32(e)
+	}
+	return out
+}
@@ -0,0 +1,442 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+// goldenONNX is a graph of a tiny SE-ResNet with GeM pooling, a hidden layer, two tasks and
+// normalization in graph, covering every operator written by ExportONNX.
+const goldenONNX = "testdata/resnet-tiny.onnx"
+
+// tinyValues returns n deterministic values in [lo, hi).
+func tinyValues(seed uint32, n int, lo, hi float32) []float32 {
+	values := make([]float32, n)
+	for i := range values {
+		seed = seed*1664525 + 1013904223
+		values[i] = lo + (hi-lo)*float32(seed>>8)/float32(1<<24)
+	}
+	return values
+}
+
+// tinyResNetONNX builds goldenONNX.
+func tinyResNetONNX() (*onnxModel, error) {
+	vars := make(map[string]onnxTensor)
+	seed := uint32(1)
+	add := func(name string, lo, hi float32, dims ...int64) {
+		seed++
+		vars[name] = onnxTensor{name: name, dims: dims, data: tinyValues(seed, int(numel(dims)), lo, hi)}
+	}
+	conv := func(prefix string, cOut, cIn, k int64) {
+		add(prefix+".weight", -0.3, 0.3, cOut, cIn, k, k)
+	}
+	bn := func(prefix string, c int64) {
+		add(prefix+".weight", 0.5, 1.5, c)
+		add(prefix+".bias", -0.1, 0.1, c)
+		add(prefix+".running_mean", -0.1, 0.1, c)
+		add(prefix+".running_var", 0.5, 1.5, c)
+	}
+
+	conv("conv1", 8, 3, 7)
+	bn("bn1", 8)
+	// Basic block and strided basic block of squeeze-excitation with downsample.
+	conv("layer1.0.conv1", 8, 8, 3)
+	bn("layer1.0.bn1", 8)
+	conv("layer1.0.conv2", 8, 8, 3)
+	bn("layer1.0.bn2", 8)
+	conv("layer2.0.conv1", 16, 8, 3)
+	bn("layer2.0.bn1", 16)
+	conv("layer2.0.conv2", 16, 16, 3)
+	bn("layer2.0.bn2", 16)
+	conv("layer2.0.se.fc1", 4, 16, 1)
+	add("layer2.0.se.fc1.bias", -0.1, 0.1, 4)
+	conv("layer2.0.se.fc2", 16, 4, 1)
+	add("layer2.0.se.fc2.bias", -0.1, 0.1, 16)
+	conv("layer2.0.downsample.0", 16, 8, 1)
+	bn("layer2.0.downsample.1", 16)
+
+	add("head.gem.p", 3, 3, 1)
+	add("head.hidden.0.weight", -0.3, 0.3, 8, 16)
+	add("head.hidden.0.bias", -0.1, 0.1, 8)
+	add("head.fc.0.weight", -0.3, 0.3, 3, 8)
+	add("head.fc.0.bias", -0.1, 0.1, 3)
+	add("head.fc.1.weight", -0.3, 0.3, 2, 8)
+	add("head.fc.1.bias", -0.1, 0.1, 2)
+
+	head := onnxHead{
+		pooling:     "gem",
+		gemName:     "head.gem.p",
+		hiddenNames: []string{"head.hidden.0"},
+		fcNames:     []string{"head.fc.0", "head.fc.1"},
+		outputSize:  5,
+	}
+	mean := []float32{0.485, 0.456, 0.406}
+	std := []float32{0.229, 0.224, 0.225}
+	return buildONNX("seresnet-tiny", vars, head, mean, std, map[string]string{"normalized": "true"})
+}
+
+func TestONNXGolden(t *testing.T) {
+	m, err := tinyResNetONNX()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := m.encode()
+	if *updateGolden {
+		if err := os.WriteFile(goldenONNX, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(goldenONNX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Want graph equal to %s. Validate changes with testdata/check_onnx.py and update with -update\n", goldenONNX)
+	}
+
+	// Output of golden graph with libtorch ops is compared to onnxruntime. LoadONNX operators
+	// are checked against the ONNX operator definitions by TestONNXOperators.
+	om, err := LoadONNX(goldenONNX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer om.Drop()
+	dims := []int64{2, 3, 32, 32}
+	input := tinyValues(0, 2*3*32*32, 0, 1)
+	x := ts.MustOfSlice(input).MustView(dims, true)
+	defer x.MustDrop()
+	y, err := om.Forward(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer y.MustDrop()
+	checkONNX(t, goldenONNX, writeReference(t, input, dims, y))
+}
+
+func TestExportONNX(t *testing.T) {
+	vs := nn.NewVarStore(gotch.CPU)
+	features, channels, err := Features(vs.Root(), "seresnet18")
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := NewHead(vs.Root().Sub("head"), channels, 0,
+		WithPooling("gem"),
+		WithHiddenLayers([]int64{32}),
+		WithTasks(Task{Name: "color", NumClasses: 3}, Task{Name: "shape", NumClasses: 2}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	module := Classifier(features, head)
+	mean, std := []float64{0.485, 0.456, 0.406}, []float64{0.229, 0.224, 0.225}
+
+	// maxDiff returns max absolute difference of ONNX and model outputs.
+	maxDiff := func(file string, x, normalized *ts.Tensor) float64 {
+		om, err := LoadONNX(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer om.Drop()
+		var diff float64
+		ts.NoGrad(func() {
+			got, err := om.Forward(x)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := module.ForwardT(normalized, false)
+			if !reflect.DeepEqual(got.MustSize(), []int64{2, 5}) {
+				t.Errorf("Want output shape [2 5], got %v\n", got.MustSize())
+			}
+			diff = got.MustSub(want, true).MustAbs(true).MustMax(true).Float64Values()[0]
+			want.MustDrop()
+		})
+		return diff
+	}
+
+	x := ts.MustRand([]int64{2, 3, 64, 64}, gotch.Float, gotch.CPU)
+	meanTs := ts.MustOfSlice([]float32{0.485, 0.456, 0.406}).MustView([]int64{1, 3, 1, 1}, true)
+	stdTs := ts.MustOfSlice([]float32{0.229, 0.224, 0.225}).MustView([]int64{1, 3, 1, 1}, true)
+	normalized := x.MustSub(meanTs, false).MustDiv(stdTs, true)
+
+	file := filepath.Join(t.TempDir(), "model.onnx")
+	classes := []string{"red", "green", "blue", "round", "square"}
+	if err := ExportONNX(file, vs, "seresnet18", head, WithNormalization(mean, std), WithClassNames(classes)); err != nil {
+		t.Fatal(err)
+	}
+	if diff := maxDiff(file, normalized, normalized); diff > 1e-4 {
+		t.Errorf("Want ONNX output equal to model output, got max difference %v\n", diff)
+	}
+	om, err := LoadONNX(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	om.Drop()
+	wantMeta := map[string]string{
+		"backbone":    "seresnet18",
+		"class_names": `["red","green","blue","round","square"]`,
+		"mean":        "[0.485,0.456,0.406]",
+		"std":         "[0.229,0.224,0.225]",
+		"normalized":  "false",
+		"tasks":       `[{"Name":"color","NumClasses":3},{"Name":"shape","NumClasses":2}]`,
+	}
+	for k, want := range wantMeta {
+		if got := om.Metadata[k]; got != want {
+			t.Errorf("Want metadata %q = %s, got %s\n", k, want, got)
+		}
+	}
+
+	// Normalization in graph.
+	if err := ExportONNX(file, vs, "seresnet18", head, WithNormalization(mean, std), WithNormalizeInGraph(true)); err != nil {
+		t.Fatal(err)
+	}
+	if diff := maxDiff(file, x, normalized); diff > 1e-4 {
+		t.Errorf("Want ONNX output on unnormalized input equal to model output, got max difference %v\n", diff)
+	}
+
+	if err := ExportONNX(file, vs, "mobilenet_v2", head); err == nil {
+		t.Errorf("Want error for unsupported backbone\n")
+	}
+}
+
+// TestONNXBackbones exports EfficientNet and DenseNet classifiers and compares onnxruntime
+// output to output of the Go model, independently of LoadONNX.
+func TestONNXBackbones(t *testing.T) {
+	for _, backbone := range []string{"efficientnet_b0", "densenet121"} {
+		backbone := backbone
+		t.Run(backbone, func(t *testing.T) {
+			vs := nn.NewVarStore(gotch.CPU)
+			features, channels, err := Features(vs.Root(), backbone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			head, err := NewHead(vs.Root().Sub("head"), channels, 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file := filepath.Join(t.TempDir(), backbone+".onnx")
+			if err := ExportONNX(file, vs, backbone, head); err != nil {
+				t.Fatal(err)
+			}
+
+			dims := []int64{2, 3, 64, 64}
+			input := tinyValues(0, 2*3*64*64, -1, 1)
+			x := ts.MustOfSlice(input).MustView(dims, true)
+			defer x.MustDrop()
+			var want *ts.Tensor
+			ts.NoGrad(func() {
+				want = Classifier(features, head).ForwardT(x, false)
+			})
+			defer want.MustDrop()
+
+			om, err := LoadONNX(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := om.Forward(x)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := got.MustSub(want, false).MustAbs(true).MustMax(true).Float64Values()[0]; diff > 1e-4 {
+				t.Errorf("Want %s ONNX output equal to model output, got max difference %v\n", backbone, diff)
+			}
+			got.MustDrop()
+			om.Drop()
+
+			checkONNX(t, file, writeReference(t, input, dims, want))
+		})
+	}
+}
+
+// TestONNXOperators checks operators of LoadONNX against values computed by hand from the ONNX
+// operator definitions, e.g. SAME_UPPER padding at the end and averages excluding padding.
+func TestONNXOperators(t *testing.T) {
+	grid := func(n int) []float32 {
+		values := make([]float32, n*n)
+		for i := range values {
+			values[i] = float32(i + 1)
+		}
+		return values
+	}
+	ones := []float32{1, 1, 1, 1, 1, 1, 1, 1, 1}
+	type input struct {
+		dims []int64
+		data []float32
+	}
+	tests := []struct {
+		name     string
+		node     onnxNode
+		inputs   []input
+		wantDims []int64
+		want     []float64
+	}{
+		{
+			name:     "Conv pads",
+			node:     onnxNode{opType: "Conv", attrs: []onnxAttr{intsAttr("strides", 2, 2), intsAttr("pads", 1, 1, 1, 1), intAttr("group", 1)}},
+			inputs:   []input{{[]int64{1, 1, 3, 3}, grid(3)}, {[]int64{1, 1, 3, 3}, ones}},
+			wantDims: []int64{1, 1, 2, 2},
+			want:     []float64{12, 16, 24, 28},
+		},
+		{
+			name:     "Conv SAME_UPPER",
+			node:     onnxNode{opType: "Conv", attrs: []onnxAttr{stringAttr("auto_pad", "SAME_UPPER"), intsAttr("strides", 2, 2), intAttr("group", 1)}},
+			inputs:   []input{{[]int64{1, 1, 4, 4}, grid(4)}, {[]int64{1, 1, 3, 3}, ones}, {[]int64{1}, []float32{0.5}}},
+			wantDims: []int64{1, 1, 2, 2},
+			want:     []float64{54.5, 45.5, 72.5, 54.5},
+		},
+		{
+			name:     "Conv group",
+			node:     onnxNode{opType: "Conv", attrs: []onnxAttr{intAttr("group", 2)}},
+			inputs:   []input{{[]int64{1, 2, 1, 1}, []float32{1, 2}}, {[]int64{2, 1, 1, 1}, []float32{3, 4}}},
+			wantDims: []int64{1, 2, 1, 1},
+			want:     []float64{3, 8},
+		},
+		{
+			name:     "MaxPool",
+			node:     onnxNode{opType: "MaxPool", attrs: []onnxAttr{intsAttr("kernel_shape", 3, 3), intsAttr("strides", 2, 2), intsAttr("pads", 1, 1, 1, 1)}},
+			inputs:   []input{{[]int64{1, 1, 4, 4}, grid(4)}},
+			wantDims: []int64{1, 1, 2, 2},
+			want:     []float64{6, 8, 14, 16},
+		},
+		{
+			name:     "AveragePool",
+			node:     onnxNode{opType: "AveragePool", attrs: []onnxAttr{intsAttr("kernel_shape", 2, 2), intsAttr("strides", 2, 2)}},
+			inputs:   []input{{[]int64{1, 1, 4, 4}, grid(4)}},
+			wantDims: []int64{1, 1, 2, 2},
+			want:     []float64{3.5, 5.5, 11.5, 13.5},
+		},
+		{
+			name:     "AveragePool pads",
+			node:     onnxNode{opType: "AveragePool", attrs: []onnxAttr{intsAttr("kernel_shape", 3, 3), intsAttr("strides", 2, 2), intsAttr("pads", 1, 1, 1, 1)}},
+			inputs:   []input{{[]int64{1, 1, 4, 4}, grid(4)}},
+			wantDims: []int64{1, 1, 2, 2},
+			want:     []float64{3.5, 5, 9.5, 11},
+		},
+		{
+			name:     "GlobalAveragePool",
+			node:     onnxNode{opType: "GlobalAveragePool"},
+			inputs:   []input{{[]int64{1, 1, 3, 3}, grid(3)}},
+			wantDims: []int64{1, 1, 1, 1},
+			want:     []float64{5},
+		},
+		{
+			name:     "GlobalMaxPool",
+			node:     onnxNode{opType: "GlobalMaxPool"},
+			inputs:   []input{{[]int64{1, 1, 3, 3}, grid(3)}},
+			wantDims: []int64{1, 1, 1, 1},
+			want:     []float64{9},
+		},
+		{
+			name: "BatchNormalization",
+			node: onnxNode{opType: "BatchNormalization", attrs: []onnxAttr{floatAttr("epsilon", 1)}},
+			inputs: []input{
+				{[]int64{1, 2, 1, 1}, []float32{1, 2}},
+				{[]int64{2}, []float32{2, 3}},
+				{[]int64{2}, []float32{0.5, 0}},
+				{[]int64{2}, []float32{0, 1}},
+				{[]int64{2}, []float32{3, 0}},
+			},
+			wantDims: []int64{1, 2, 1, 1},
+			want:     []float64{1.5, 3},
+		},
+		{
+			name: "Gemm transB",
+			node: onnxNode{opType: "Gemm", attrs: []onnxAttr{intAttr("transB", 1)}},
+			inputs: []input{
+				{[]int64{1, 2}, []float32{1, 2}},
+				{[]int64{3, 2}, []float32{1, 0, 0, 1, 1, 1}},
+				{[]int64{3}, []float32{0.5, 0.5, 0.5}},
+			},
+			wantDims: []int64{1, 3},
+			want:     []float64{1.5, 2.5, 3.5},
+		},
+		{
+			name:     "Flatten",
+			node:     onnxNode{opType: "Flatten", attrs: []onnxAttr{intAttr("axis", 1)}},
+			inputs:   []input{{[]int64{1, 2, 1, 2}, []float32{1, 2, 3, 4}}},
+			wantDims: []int64{1, 4},
+			want:     []float64{1, 2, 3, 4},
+		},
+		{
+			name:     "Concat",
+			node:     onnxNode{opType: "Concat", attrs: []onnxAttr{intAttr("axis", 1)}},
+			inputs:   []input{{[]int64{1, 1, 1, 1}, []float32{1}}, {[]int64{1, 2, 1, 1}, []float32{2, 3}}},
+			wantDims: []int64{1, 3, 1, 1},
+			want:     []float64{1, 2, 3},
+		},
+	}
+
+	for _, tt := range tests {
+		inputs := make([]*ts.Tensor, len(tt.inputs))
+		for i, in := range tt.inputs {
+			inputs[i] = ts.MustOfSlice(in.data).MustView(in.dims, true)
+		}
+		y := runONNXNode(&tt.node, inputs)
+		if got := y.MustSize(); !reflect.DeepEqual(got, tt.wantDims) {
+			t.Errorf("%s: want shape %v, got %v\n", tt.name, tt.wantDims, got)
+		} else {
+			got := y.Float64Values()
+			for i := range got {
+				if math.Abs(got[i]-tt.want[i]) > 1e-5 {
+					t.Errorf("%s: want %v, got %v\n", tt.name, tt.want, got)
+					break
+				}
+			}
+		}
+		y.MustDrop()
+		for _, x := range inputs {
+			x.MustDrop()
+		}
+	}
+}
+
+// writeReference writes input and output y to a JSON reference file of testdata/check_onnx.py.
+func writeReference(t *testing.T, input []float32, dims []int64, y *ts.Tensor) string {
+	reference, err := json.Marshal(map[string]interface{}{
+		"input":  map[string]interface{}{"dims": dims, "data": input},
+		"output": map[string]interface{}{"dims": y.MustSize(), "data": y.Float64Values()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "reference.json")
+	if err := os.WriteFile(file, reference, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// checkONNX validates ONNX file with testdata/check_onnx.py, comparing onnxruntime output with
+// reference. It skips if python3, onnx or onnxruntime is not installed, unless
+// LAB_REQUIRE_ONNXRUNTIME is set to make onnxruntime a required check, e.g. in CI.
+func checkONNX(t *testing.T, file, reference string) {
+	skip := t.Skipf
+	if os.Getenv("LAB_REQUIRE_ONNXRUNTIME") != "" {
+		skip = t.Fatalf
+	}
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		skip("python3 not found")
+	}
+	out, err := exec.Command(python, filepath.Join("testdata", "check_onnx.py"), file, reference).CombinedOutput()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 2:
+		skip("onnx or onnxruntime not installed: %s", out)
+	case err != nil:
+		t.Errorf("Want %s valid in onnxruntime, got %v:\n%s", file, err, out)
+	}
+}
@@ -1,114 +1,338 @@
 package model
 
 import (
+	"bufio"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
-type resource struct{
-	file string
-	url string
-}
-
-// Pretrained
-var PretrainedModels map[string]resource = map[string]resource{
-	"efficientnet_b4": {
-		file: "efficientnet_b4.bin",
-		url: "https://PRETRAINED_MODEL_FILES",
-	},
-	"efficientnet_b6": {
-		file: "efficientnet_b6.bin",
-		url: "https://PRETRAINED_MODEL_FILES",
-	},
-	"efficientnet_b7": {
-		file: "efficientnet_b7.bin",
-		url: "https://PRETRAINED_MODEL_FILES",
-	},
-
-
-	"tf_efficientnet_b6_ns": {
-		file: "tf_efficientnet_b6_ns.bin",
-		url: "https://PRETRAINED_MODEL_FILES",
-	},
-	"tf_efficientnet_b7_ns": {
-		file: "tf_efficientnet_b7_ns.bin",
-		url: "https://PRETRAINED_MODEL_FILES",
-	},
-
-	"resnet34_unet": {
-		file: "resnet34.bin",
-		url: "https://PRETRAINED_MODEL_FILES",
-	},
-
-	"resnet34": {
-		file: "resnet34.bin",
-		url: "https://PRETRAINED_MODEL_FILES",
-	},
-	"resnet50": {
-		file: "resnet50.bin",
-		url: "https://PRETRAINED_MODEL_FILES",
-	},
-
-	"densenet121": {
-		file: "densenet121.bin",
-		url: "https://PRETRAINED_MODEL_FILES",
-	},
-}
-
-type PretrainedOptions struct{
-	Path string
-	URL string
+// Resource is a pretrained weight file of the registry.
+type Resource struct {
+	File   string // file name at mirror and in cache directory
+	URL    string // full URL overriding mirror. Optional.
+	SHA256 string // hex SHA-256 of file. Not verified if empty, see WithVerify.
+	Size   int64  // file size in bytes. Not verified if 0.
+}
+
+// PretrainedModels is the registry of pretrained weights by backbone name. Files are
+// downloaded from mirror (default `BucketPrefix`) unless resource URL is set.
+//
+// Checksums and sizes of entries are loaded from the embedded manifest "pretrained.sha256",
+// generated from mirror files with `go run ./model/cmd/checksums`. Until the manifest lists all
+// registered files, PretrainedFile fetches files missing in it without checksum and logs a warning;
+// WithVerify rejects them. Load checksums of another mirror with LoadChecksums, or register complete
+// entries with RegisterPretrained.
+//
+// Files of all backbones are produced from torchvision, timm or lukemelas weights by
+// `go run ./model/cmd/convert` (see NewNameMapper).
+var PretrainedModels map[string]Resource = pretrainedResources(
+	"efficientnet_b0", "efficientnet_b1", "efficientnet_b2", "efficientnet_b3",
+	"efficientnet_b4", "efficientnet_b5", "efficientnet_b6", "efficientnet_b7",
+	"tf_efficientnet_b0_ns", "tf_efficientnet_b1_ns", "tf_efficientnet_b2_ns", "tf_efficientnet_b3_ns",
+	"tf_efficientnet_b4_ns", "tf_efficientnet_b5_ns", "tf_efficientnet_b6_ns", "tf_efficientnet_b7_ns",
+	"resnet18", "resnet34", "resnet50", "resnet101", "resnet152",
+	"resnext50_32x4d", "resnext101_32x8d", "resnext101_64x4d", "wide_resnet50_2", "wide_resnet101_2",
+	"seresnet18", "seresnet34", "seresnet50", "seresnet101", "seresnet152",
+	"seresnext50_32x4d", "seresnext101_32x8d",
+	"densenet121", "densenet161", "densenet169", "densenet201",
+	"mobilenet_v2", "mobilenet_v3_large", "mobilenet_v3_small",
+	"vgg11", "vgg11_bn", "vgg13", "vgg13_bn", "vgg16", "vgg16_bn", "vgg19", "vgg19_bn",
+	"regnet_y_400mf", "regnet_y_800mf", "regnet_y_1_6gf", "regnet_y_3_2gf", "regnet_y_8gf", "regnet_y_16gf", "regnet_y_32gf",
+	"regnet_x_400mf", "regnet_x_800mf", "regnet_x_1_6gf", "regnet_x_3_2gf", "regnet_x_8gf", "regnet_x_16gf", "regnet_x_32gf",
+	"convnext_tiny", "convnext_small", "convnext_base", "convnext_large",
+)
+
+// pretrainedResources creates registry entries of files "<name>.bin".
+func pretrainedResources(names ...string) map[string]Resource {
+	resources := make(map[string]Resource, len(names)+1)
+	for _, name := range names {
+		resources[name] = Resource{File: name + ".bin"}
+	}
+	// UNet encoder uses ResNet34 weights.
+	resources["resnet34_unet"] = Resource{File: "resnet34.bin"}
+
+	return resources
+}
+
+//go:embed pretrained.sha256
+var pretrainedChecksums string
+
+func init() {
+	if _, err := LoadChecksums(strings.NewReader(pretrainedChecksums)); err != nil {
+		log.Fatalf("Invalid embedded pretrained checksums: %v\n", err)
+	}
+}
+
+// RegisterPretrained adds or replaces a registry entry.
+func RegisterPretrained(name string, r Resource) {
+	PretrainedModels[name] = r
+}
+
+// LoadChecksums reads a checksum file in `sha256sum` format ("<hex>  <file>" lines), optionally
+// with file size ("<hex>  <size>  <file>" lines), and sets SHA-256 and size of registry entries
+// with matching file names. It returns number of entries updated.
+func LoadChecksums(r io.Reader) (int, error) {
+	sums := make(map[string]Resource)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if (len(fields) != 2 && len(fields) != 3) || len(fields[0]) != 2*sha256.Size {
+			err := fmt.Errorf("LoadChecksums failed: invalid line %q", line)
+			return 0, err
+		}
+		var size int64
+		if len(fields) == 3 {
+			n, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil || n <= 0 {
+				err := fmt.Errorf("LoadChecksums failed: invalid size in line %q", line)
+				return 0, err
+			}
+			size = n
+		}
+		// "*" marks binary mode in sha256sum output.
+		file := strings.TrimPrefix(fields[len(fields)-1], "*")
+		sums[file] = Resource{SHA256: strings.ToLower(fields[0]), Size: size}
+	}
+	if err := scanner.Err(); err != nil {
+		err = fmt.Errorf("LoadChecksums failed: %w", err)
+		return 0, err
+	}
+
+	n := 0
+	for name, res := range PretrainedModels {
+		if sum, ok := sums[res.File]; ok {
+			res.SHA256 = sum.SHA256
+			if sum.Size > 0 {
+				res.Size = sum.Size
+			}
+			PretrainedModels[name] = res
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+type PretrainedOptions struct {
+	Path     string
+	URL      string
+	Mirror   string
 	CacheDir string
+	Verify   bool // reject fetching files without registered SHA-256
 }
 
 type PretrainedOption func(*PretrainedOptions)
 
-func defaultPretrainedOptions() *PretrainedOptions{
+// defaultPretrainedOptions uses mirror from `LAB_PRETRAINED_MIRROR` environment variable if set.
+func defaultPretrainedOptions() *PretrainedOptions {
+	mirror := os.Getenv("LAB_PRETRAINED_MIRROR")
+	if mirror == "" {
+		mirror = BucketPrefix
+	}
 	return &PretrainedOptions{
-		Path: "",
-		URL: "",
+		Path:     "",
+		URL:      "",
+		Mirror:   mirror,
 		CacheDir: DefaultCachePath,
+		Verify:   false,
 	}
 }
 
 // WithPath set path to model file
-func WithPath(p string) PretrainedOption{
-	return func(o *PretrainedOptions){
+func WithPath(p string) PretrainedOption {
+	return func(o *PretrainedOptions) {
 		o.Path = p
 	}
 }
 
 // WithURL set URL to retrieve model file from remote resource.
-func WithURL(url string) PretrainedOption{
-	return func(o *PretrainedOptions){
+func WithURL(url string) PretrainedOption {
+	return func(o *PretrainedOptions) {
 		o.URL = url
 	}
 }
 
+// WithMirror sets base location of registry files: an HTTP(S) URL, a `file://` URL or a local
+// directory such as a mounted file share. Empty keeps default.
+func WithMirror(mirror string) PretrainedOption {
+	return func(o *PretrainedOptions) {
+		if mirror != "" {
+			o.Mirror = mirror
+		}
+	}
+}
+
 // WithCacheDir set custom cache directory to cache model.
 // Default cache directory is at `$HOME/.cache/lab`
-func WithCacheDir(dir string) PretrainedOption{
-	return func(o *PretrainedOptions){
+func WithCacheDir(dir string) PretrainedOption {
+	return func(o *PretrainedOptions) {
 		o.CacheDir = dir
 	}
 }
 
+// WithVerify rejects fetching files of registry entries without SHA-256, i.e. of a mirror whose
+// checksums are not loaded. Files with registered SHA-256 are always verified.
+func WithVerify(v bool) PretrainedOption {
+	return func(o *PretrainedOptions) {
+		o.Verify = v
+	}
+}
+
 // PretrainedFile returns corresponding pretrained model file from cached file.
 // If cached file does not exist it will download from resource first.
-func PretrainedFile(modelName string, opts ...PretrainedOption) (string, error){
+//
+// A local file in directory `Path` if set (or file of the same name with ".safetensors"
+// extension if registered file does not exist) is used in place. Otherwise, or if it is missing,
+// file is fetched to the cache directory from `URL` if set, URL of registry entry if set, then
+// mirror. Downloads are resumed from partial files.
+//
+// Local, cached and fetched files are verified against registered size and SHA-256. A cached
+// file failing verification is fetched again. A file without registered SHA-256 is used with a
+// warning, or fetching it fails if WithVerify is set.
+func PretrainedFile(modelName string, opts ...PretrainedOption) (string, error) {
 	options := defaultPretrainedOptions()
-	for _, o := range opts{
+	for _, o := range opts {
 		o(options)
 	}
 
-	DefaultCachePath = options.CacheDir
-	resource, ok := PretrainedModels[modelName] 
-	if !ok{
+	res, ok := PretrainedModels[modelName]
+	if !ok {
 		err := fmt.Errorf("Unsupported model name: %s\n", modelName)
 		return "", err
 	}
 
-	// For now just load from local
-	fullPath := fmt.Sprintf("%s/%s", options.Path, resource.file)
-	return CachedPath(fullPath)
+	if options.Path != "" {
+		local := localPretrained(options.Path, res.File)
+		if _, err := os.Stat(local); err == nil {
+			// Registry has no checksum of the ".safetensors" variant.
+			size, sum := res.Size, res.SHA256
+			if filepath.Base(local) != res.File {
+				size, sum = 0, ""
+			}
+			if sum == "" {
+				log.Printf("WARNING: pretrained file %q is not verified: no SHA-256 registered for %q\n", local, modelName)
+			}
+			if err := VerifyFile(local, size, sum); err != nil {
+				err = fmt.Errorf("PretrainedFile failed: %w", err)
+				return "", err
+			}
+			return local, nil
+		}
+	}
+
+	if res.SHA256 == "" {
+		if options.Verify {
+			err := fmt.Errorf("PretrainedFile failed: no SHA-256 registered for %q (%s). Load checksums of mirror files (LoadChecksums)", modelName, res.File)
+			return "", err
+		}
+		log.Printf("WARNING: pretrained file %q is not verified: no SHA-256 registered for %q\n", res.File, modelName)
+	}
+
+	src := options.URL
+	if src == "" {
+		src = res.URL
+	}
+	if src == "" {
+		src = mirrorLocation(options.Mirror, res.File)
+	}
+
+	if err := os.MkdirAll(options.CacheDir, 0755); err != nil {
+		err = fmt.Errorf("PretrainedFile failed: %w", err)
+		return "", err
+	}
+	cached := filepath.Join(options.CacheDir, res.File)
+	if _, err := os.Stat(cached); err == nil {
+		if err := VerifyFile(cached, res.Size, res.SHA256); err == nil {
+			return cached, nil
+		}
+		// Corrupt or outdated cached file is fetched again.
+		if err := os.Remove(cached); err != nil {
+			err = fmt.Errorf("PretrainedFile failed: %w", err)
+			return "", err
+		}
+	}
+
+	var err error
+	if isValidURL(src) {
+		err = downloadFile(src, cached, res.Size, res.SHA256)
+	} else {
+		err = copyVerified(strings.TrimPrefix(src, "file://"), cached, res.Size, res.SHA256)
+	}
+	if err != nil {
+		err = fmt.Errorf("PretrainedFile failed to fetch %q: %w", src, err)
+		return "", err
+	}
+
+	return cached, nil
+}
+
+// localPretrained returns path of file in directory dir, or of its ".safetensors" variant if
+// only that exists.
+func localPretrained(dir, file string) string {
+	p := filepath.Join(dir, file)
+	if _, err := os.Stat(p); err == nil || IsSafetensors(file) {
+		return p
+	}
+	alt := strings.TrimSuffix(p, filepath.Ext(p)) + ".safetensors"
+	if _, err := os.Stat(alt); err == nil {
+		return alt
+	}
+	return p
+}
+
+// mirrorLocation joins mirror URL or directory and file name.
+func mirrorLocation(mirror, file string) string {
+	if strings.Contains(mirror, "://") {
+		return strings.TrimSuffix(mirror, "/") + "/" + url.PathEscape(file)
+	}
+	return filepath.Join(mirror, file)
 }
 
+// VerifyFile checks size (if positive) and hex SHA-256 (if not empty) of file.
+func VerifyFile(file string, size int64, sum string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if size > 0 && n != size {
+		err := fmt.Errorf("size mismatch of %q: expected %d bytes, got %d", file, size, n)
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); sum != "" && !strings.EqualFold(got, sum) {
+		err := fmt.Errorf("checksum mismatch of %q: expected sha256 %s, got %s", file, sum, got)
+		return err
+	}
+
+	return nil
+}
+
+// copyVerified copies local file src to dst through a temporary file, verifying it first.
+func copyVerified(src, dst string, size int64, sum string) error {
+	if err := VerifyFile(src, size, sum); err != nil {
+		return err
+	}
+	if err := copyFile(src, dst+".tmp"); err != nil {
+		return err
+	}
+	return os.Rename(dst+".tmp", dst)
+}
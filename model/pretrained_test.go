@@ -0,0 +1,144 @@
+package model
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPretrainedFileMirror(t *testing.T) {
+	data := bytes.Repeat([]byte("weights"), 1000)
+	sum := sha256.Sum256(data)
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.ServeContent(w, r, "weights", time.Now(), bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	old := PretrainedModels["resnet18"]
+	defer RegisterPretrained("resnet18", old)
+	RegisterPretrained("resnet18", Resource{File: "resnet18.bin", SHA256: hex.EncodeToString(sum[:]), Size: int64(len(data))})
+
+	// Resume from a partial download.
+	cacheDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(cacheDir, "resnet18.bin.tmp"), data[:100], 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := PretrainedFile("resnet18", WithMirror(srv.URL), WithCacheDir(cacheDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, got) {
+		t.Errorf("Want cached file equal to served file\n")
+	}
+
+	// Corrupt cached file is downloaded again.
+	if err := os.WriteFile(file, data[:len(data)-1], 0644); err != nil {
+		t.Fatal(err)
+	}
+	requests = 0
+	if _, err := PretrainedFile("resnet18", WithMirror(srv.URL), WithCacheDir(cacheDir)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(file); err != nil || !bytes.Equal(data, got) || requests == 0 {
+		t.Errorf("Want corrupt cached file downloaded again (%d requests)\n", requests)
+	}
+
+	// Missing local file falls back to mirror, and a corrupt local file is rejected.
+	localDir := t.TempDir()
+	file, err = PretrainedFile("resnet18", WithPath(localDir), WithMirror(srv.URL), WithCacheDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(file); err != nil || !bytes.Equal(data, got) {
+		t.Errorf("Want missing local file fetched from mirror\n")
+	}
+	if err := os.WriteFile(filepath.Join(localDir, "resnet18.bin"), data[1:], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PretrainedFile("resnet18", WithPath(localDir), WithMirror(srv.URL)); err == nil {
+		t.Errorf("Want error for corrupt local file\n")
+	}
+
+	// Entry without checksum is fetched unless verification is required.
+	RegisterPretrained("resnet18", Resource{File: "resnet18.bin"})
+	if _, err := PretrainedFile("resnet18", WithMirror(srv.URL), WithCacheDir(t.TempDir())); err != nil {
+		t.Errorf("Want unverified file fetched, got %v\n", err)
+	}
+	if _, err := PretrainedFile("resnet18", WithMirror(srv.URL), WithCacheDir(t.TempDir()), WithVerify(true)); err == nil {
+		t.Errorf("Want error for entry without checksum\n")
+	}
+
+	// Checksum mismatch.
+	RegisterPretrained("resnet18", Resource{File: "resnet18.bin", SHA256: hex.EncodeToString(make([]byte, 32))})
+	_, err = PretrainedFile("resnet18", WithMirror(srv.URL), WithCacheDir(t.TempDir()))
+	if err == nil {
+		t.Errorf("Want error for checksum mismatch\n")
+	}
+}
+
+func TestPretrainedRegistryCoverage(t *testing.T) {
+	for backbone, res := range PretrainedModels {
+		if res.File == "" {
+			t.Errorf("Missing file of registry entry %q\n", backbone)
+		}
+		// Registered files are converted from torchvision weights.
+		if _, err := NewNameMapper(backbone, "torchvision"); err != nil {
+			t.Errorf("No converter of registered backbone %q: %v\n", backbone, err)
+		}
+	}
+}
+
+func TestLoadChecksums(t *testing.T) {
+	if _, err := LoadChecksums(strings.NewReader(pretrainedChecksums)); err != nil {
+		t.Fatalf("Invalid embedded manifest: %v\n", err)
+	}
+
+	old := PretrainedModels["resnet18"]
+	defer RegisterPretrained("resnet18", old)
+	sum := strings.Repeat("ab", sha256.Size)
+	n, err := LoadChecksums(strings.NewReader(sum + "  1234  resnet18.bin\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := PretrainedModels["resnet18"]; n != 1 || res.SHA256 != sum || res.Size != 1234 {
+		t.Errorf("Want 1 entry updated with checksum and size, got %d: %+v\n", n, res)
+	}
+
+	if _, err := LoadChecksums(strings.NewReader(sum + "  x  resnet18.bin\n")); err == nil {
+		t.Errorf("Want error for invalid size\n")
+	}
+}
+
+// TestPretrainedManifest checks that the embedded manifest has checksum and size of every
+// registered file. It is skipped until the manifest is generated from mirror files.
+func TestPretrainedManifest(t *testing.T) {
+	entries := 0
+	for _, line := range strings.Split(pretrainedChecksums, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			entries++
+		}
+	}
+	if entries == 0 {
+		t.Skip("pretrained.sha256 has no entries. Generate it with `go run ./model/cmd/checksums`")
+	}
+
+	for name, res := range PretrainedModels {
+		if res.SHA256 == "" || res.Size <= 0 {
+			t.Errorf("%s (%s): want SHA-256 and size in pretrained.sha256, got %q and %d\n", name, res.File, res.SHA256, res.Size)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package model
+
+// Pruning of convolution and linear weights with masks.
+//
+// See "Learning both Weights and Connections for Efficient Neural Networks", Han et al 2015
+// (https://arxiv.org/abs/1506.02626) for magnitude pruning, and "Pruning Filters for Efficient
+// ConvNets", Li et al 2016 (https://arxiv.org/abs/1608.08710) for selecting filters by L1 norm.
+//
+// Pruning only masks weights: pruned weights and channels are set to zero and kept in
+// variables, so models are neither smaller nor faster. Channels are not removed from layers.
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+// PruneMethods are pruning methods of Prune.
+var PruneMethods []string = []string{"magnitude", "channel"}
+
+type PruneOptions struct {
+	Method  string   // "magnitude" (default) or "channel"
+	Exclude []string // variable path prefixes not pruned. Default none.
+}
+
+type PruneOption func(*PruneOptions)
+
+func defaultPruneOptions() *PruneOptions {
+	return &PruneOptions{
+		Method: "magnitude",
+	}
+}
+
+func WithPruneMethod(method string) PruneOption {
+	return func(o *PruneOptions) {
+		if method != "" {
+			o.Method = method
+		}
+	}
+}
+
+func WithPruneExclude(prefixes ...string) PruneOption {
+	return func(o *PruneOptions) {
+		o.Exclude = prefixes
+	}
+}
+
+// Pruner holds masks of pruned variables. Masks are re-applied by Apply, e.g. after each
+// optimizer step when fine-tuning so that pruned weights stay zero.
+type Pruner struct {
+	Method   string
+	Sparsity float64 // requested fraction of pruned weights or channels
+	vars     map[string]ts.Tensor
+	masks    map[string]*ts.Tensor
+}
+
+// Prune zeroes a fraction sparsity in [0, 1) of weights of convolution and linear layers of vs
+// and returns masks of pruned variables.
+//
+// Method "magnitude" zeroes weights of smallest absolute value with a threshold global to all
+// layers. Method "channel" zeroes output channels of each convolution with smallest L1 norm of
+// filter, with bias and following batch norm weight and bias so that channels output zero.
+// Channels are zeroed, not removed, so layer shapes, size and compute are unchanged.
+func Prune(vs *nn.VarStore, sparsity float64, opts ...PruneOption) (*Pruner, error) {
+	options := defaultPruneOptions()
+	for _, o := range opts {
+		o(options)
+	}
+	if !contains(PruneMethods, options.Method) {
+		err := fmt.Errorf("Prune failed: invalid method %q. Expected one of %s", options.Method, strings.Join(PruneMethods, ", "))
+		return nil, err
+	}
+	if sparsity < 0 || sparsity >= 1 {
+		err := fmt.Errorf("Prune failed: expected sparsity in range [0, 1), got %v", sparsity)
+		return nil, err
+	}
+
+	p := &Pruner{
+		Method:   options.Method,
+		Sparsity: sparsity,
+		vars:     vs.Variables(),
+		masks:    make(map[string]*ts.Tensor),
+	}
+	var names []string
+	for name, x := range p.vars {
+		if !strings.HasSuffix(name, ".weight") || IsBuffer(name) || excluded(name, options.Exclude) {
+			continue
+		}
+		if dim := x.Dim(); dim == 2 || dim == 4 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	ts.NoGrad(func() {
+		switch options.Method {
+		case "magnitude":
+			p.pruneMagnitude(names)
+		case "channel":
+			p.pruneChannels(names)
+		}
+	})
+	p.Apply()
+
+	return p, nil
+}
+
+// pruneMagnitude masks weights of absolute value not above global k-th smallest value.
+func (p *Pruner) pruneMagnitude(names []string) {
+	var (
+		flat []ts.Tensor
+		n    int64
+	)
+	for _, name := range names {
+		x := p.vars[name]
+		flat = append(flat, *x.MustAbs(false).MustFlatten(0, -1, true))
+		n += numel(x.MustSize())
+	}
+	k := int64(math.Round(p.Sparsity * float64(n)))
+	if k == 0 {
+		for i := range flat {
+			flat[i].MustDrop()
+		}
+		return
+	}
+
+	all := ts.MustCat(flat, 0)
+	for i := range flat {
+		flat[i].MustDrop()
+	}
+	kth, idx := all.MustKthvalue(k, 0, false, true)
+	idx.MustDrop()
+	threshold := kth.Float64Values()[0]
+	kth.MustDrop()
+
+	for _, name := range names {
+		x := p.vars[name]
+		p.masks[name] = x.MustAbs(false).MustGt(ts.FloatScalar(threshold), true).MustTotype(x.DType(), true)
+	}
+}
+
+// pruneChannels masks output channels of convolutions of smallest L1 norm in each layer.
+func (p *Pruner) pruneChannels(names []string) {
+	for _, name := range names {
+		x := p.vars[name]
+		size := x.MustSize()
+		k := int64(p.Sparsity * float64(size[0]))
+		if len(size) != 4 || k == 0 {
+			continue
+		}
+
+		norms := x.MustAbs(false).MustSumDimIntlist([]int64{1, 2, 3}, false, x.DType(), true)
+		kth, idx := norms.MustKthvalue(k, 0, false, false)
+		idx.MustDrop()
+		channels := norms.MustGtTensor(kth, true).MustTotype(x.DType(), true)
+		kth.MustDrop()
+
+		p.masks[name] = channels.MustView([]int64{size[0], 1, 1, 1}, false)
+		prefix := strings.TrimSuffix(name, ".weight")
+		vars := []string{prefix + ".bias"}
+		if bn := batchNormOf(prefix); bn != "" {
+			vars = append(vars, bn+".weight", bn+".bias")
+		}
+		for _, v := range vars {
+			if _, ok := p.vars[v]; ok {
+				p.masks[v] = channels.MustShallowClone()
+			}
+		}
+		channels.MustDrop()
+	}
+}
+
+// batchNormOf returns path of batch norm applied to output of convolution at path conv by
+// naming convention, or "" if unknown.
+func batchNormOf(conv string) string {
+	i := strings.LastIndex(conv, ".")
+	parent, name := conv[:i+1], conv[i+1:]
+	switch name {
+	case "0": // Conv2dNormActivation, downsample
+		return parent + "1"
+	case "_conv_stem", "_expand_conv":
+		return parent + "_bn0"
+	case "_depthwise_conv", "_conv_head":
+		return parent + "_bn1"
+	case "_project_conv":
+		return parent + "_bn2"
+	}
+	if strings.HasPrefix(name, "conv") && len(name) > 4 {
+		return parent + "bn" + name[4:]
+	}
+	return ""
+}
+
+// Apply multiplies pruned variables by their masks in place.
+func (p *Pruner) Apply() {
+	ts.NoGrad(func() {
+		for name, mask := range p.masks {
+			x := p.vars[name]
+			x.MustMul_(mask)
+		}
+	})
+}
+
+// Zeros returns number of zero elements and total number of elements of pruned weights.
+func (p *Pruner) Zeros() (zeros, total int64) {
+	for name := range p.masks {
+		if !strings.HasSuffix(name, ".weight") {
+			continue
+		}
+		x := p.vars[name]
+		n := x.MustEq(ts.FloatScalar(0), false).MustSum(gotch.Int64, true)
+		zeros += n.Int64Values()[0]
+		total += numel(x.MustSize())
+		n.MustDrop()
+	}
+	return zeros, total
+}
+
+// String reports pruning method and sparsity of pruned weights.
+func (p *Pruner) String() string {
+	zeros, total := p.Zeros()
+	sparsity := 0.0
+	if total > 0 {
+		sparsity = float64(zeros) / float64(total)
+	}
+	return fmt.Sprintf("Pruned (%s): %d of %d weights are zero (%.1f%%), masked in place: model size and latency are unchanged\n", p.Method, zeros, total, 100*sparsity)
+}
+
+// Drop deletes masks.
+func (p *Pruner) Drop() {
+	for _, mask := range p.masks {
+		mask.MustDrop()
+	}
+	p.masks = make(map[string]*ts.Tensor)
+}
+
+func excluded(name string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if name == p || strings.HasPrefix(name, p+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(vals []string, v string) bool {
+	for _, e := range vals {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,287 @@
+package model
+
+// Post-training int8 quantization for CPU inference.
+//
+// Dynamic quantization runs linear layers of Head on fbgemm int8 kernels of libtorch (x86 CPUs
+// with AVX2), with weights quantized ahead and activations quantized per batch; backbone layers
+// stay float. A quantized head is saved with int8 weights by SaveQuantized and loaded back by
+// LoadQuantized. gotch does not expose quantized convolutions, so backbones are not quantized
+// and static quantization is not supported.
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+// quantizedScaleSuffix is suffix of scale of int8 weights in files of SaveQuantized.
+const quantizedScaleSuffix = ".weight_scale"
+
+// QuantizedLinear is a linear layer of int8 weight, quantized symmetrically per tensor, run on
+// fbgemm kernels with activations quantized dynamically. CPU only.
+type QuantizedLinear struct {
+	weight     *ts.Tensor // int8 [out, in]
+	packed     *ts.Tensor // fbgemm packed weight
+	colOffsets *ts.Tensor // int32 sums of weight rows
+	bias       *ts.Tensor
+	scale      float64
+}
+
+// NewQuantizedLinear quantizes weight of linear layer l on CPU.
+func NewQuantizedLinear(l *nn.Linear) (q *QuantizedLinear, err error) {
+	if device := l.Ws.MustDevice(); device != gotch.CPU {
+		err = fmt.Errorf("NewQuantizedLinear failed: expected layer on CPU, got %v", device)
+		return nil, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("NewQuantizedLinear failed: %v", r)
+		}
+	}()
+
+	ts.NoGrad(func() {
+		w := l.Ws.MustT(false).MustContiguous(true) // [out, in]
+		absMax := w.MustAbs(false).MustMax(true)
+		scale := absMax.Float64Values()[0] / 127
+		absMax.MustDrop()
+		if scale == 0 {
+			scale = 1
+		}
+		weight := w.MustDivScalar(ts.FloatScalar(scale), true).MustRound(true).MustClamp(ts.FloatScalar(-128), ts.FloatScalar(127), true).MustTotype(gotch.Int8, true)
+		q = newQuantizedLinear(weight, scale, l.Bs.MustDetach(false))
+	})
+
+	return q, nil
+}
+
+// newQuantizedLinear packs int8 weight [out, in] of given scale. It takes ownership of weight
+// and bias.
+func newQuantizedLinear(weight *ts.Tensor, scale float64, bias *ts.Tensor) *QuantizedLinear {
+	return &QuantizedLinear{
+		weight:     weight,
+		packed:     ts.MustFbgemmPackQuantizedMatrix(weight),
+		colOffsets: weight.MustSumDimIntlist([]int64{1}, false, gotch.Int, false),
+		bias:       bias,
+		scale:      scale,
+	}
+}
+
+// Forward implements Module for QuantizedLinear.
+func (l *QuantizedLinear) Forward(x *ts.Tensor) *ts.Tensor {
+	return ts.MustFbgemmLinearInt8WeightFp32Activation(x, l.weight, l.packed, l.colOffsets, ts.FloatScalar(l.scale), ts.IntScalar(0), l.bias)
+}
+
+// Drop deletes tensors of l.
+func (l *QuantizedLinear) Drop() {
+	for _, x := range []*ts.Tensor{l.weight, l.packed, l.colOffsets, l.bias} {
+		x.MustDrop()
+	}
+}
+
+// QuantizeDynamic replaces linear layers of head with QuantizedLinear layers for CPU inference.
+// Quantized layers are not trainable and variables of head are left unchanged.
+func (h *Head) QuantizeDynamic() error {
+	if _, err := h.quantizeLinears(); err != nil {
+		err = fmt.Errorf("QuantizeDynamic failed: %w", err)
+		return err
+	}
+	return nil
+}
+
+// SimulateDynamicQuantization is QuantizeDynamic with a function restoring the float head.
+// Restore puts the linear layers back and deletes the quantized ones.
+func (h *Head) SimulateDynamicQuantization() (restore func(), err error) {
+	restore, err = h.quantizeLinears()
+	if err != nil {
+		err = fmt.Errorf("SimulateDynamicQuantization failed: %w", err)
+		return nil, err
+	}
+	return restore, nil
+}
+
+// quantizedLayers returns QuantizedLinear layers of head by VarStore path prefix.
+func (h *Head) quantizedLayers() map[string]*QuantizedLinear {
+	layers := make(map[string]*QuantizedLinear)
+	for i, l := range h.hidden {
+		if q, ok := l.(*QuantizedLinear); ok {
+			layers[h.hiddenNames[i]] = q
+		}
+	}
+	for i, l := range h.fcs {
+		if q, ok := l.(*QuantizedLinear); ok {
+			layers[h.fcNames[i]] = q
+		}
+	}
+	return layers
+}
+
+// quantizeLinears replaces linear layers of head with QuantizedLinear layers and returns a
+// function restoring them.
+func (h *Head) quantizeLinears() (restore func(), err error) {
+	var undo []func()
+	restore = func() {
+		for _, f := range undo {
+			f()
+		}
+		undo = nil
+	}
+	for _, layers := range [][]ts.Module{h.hidden, h.fcs} {
+		for i, l := range layers {
+			linear, ok := l.(*nn.Linear)
+			if !ok {
+				continue
+			}
+			q, err := NewQuantizedLinear(linear)
+			if err != nil {
+				restore()
+				return nil, err
+			}
+			layers, i := layers, i
+			layers[i] = q
+			undo = append(undo, func() {
+				layers[i] = linear
+				q.Drop()
+			})
+		}
+	}
+	return restore, nil
+}
+
+// SaveQuantized saves variables of vs to a safetensors file with int8 weights of linear layers
+// of head quantized by QuantizeDynamic. An int8 weight "<prefix>.weight" [out, in] has float
+// scale "<prefix>.weight_scale" [1]; other variables are float. Quantized prefixes are stored as
+// metadata "quantized" (JSON array).
+func SaveQuantized(file string, vs *nn.VarStore, head *Head, metadata map[string]string) error {
+	layers := head.quantizedLayers()
+	if len(layers) == 0 {
+		err := fmt.Errorf("SaveQuantized failed: head has no quantized layers. Call QuantizeDynamic first")
+		return err
+	}
+	if !IsSafetensors(file) {
+		err := fmt.Errorf("SaveQuantized failed: expected .safetensors file, got %q", file)
+		return err
+	}
+
+	variables := vs.Variables()
+	tensors := make(map[string]*ts.Tensor, len(variables)+len(layers))
+	for name := range variables {
+		x := variables[name]
+		tensors[name] = &x
+	}
+	var prefixes []string
+	for prefix, q := range layers {
+		scale := ts.MustOfSlice([]float32{float32(q.scale)})
+		defer scale.MustDrop()
+		tensors[prefix+".weight"] = q.weight
+		tensors[prefix+quantizedScaleSuffix] = scale
+		prefixes = append(prefixes, prefix)
+	}
+	b, err := json.Marshal(prefixes)
+	if err != nil {
+		err = fmt.Errorf("SaveQuantized failed: %w", err)
+		return err
+	}
+	meta := map[string]string{"quantized": string(b)}
+	for k, v := range metadata {
+		meta[k] = v
+	}
+
+	if err := WriteSafetensors(file, tensors, meta); err != nil {
+		err = fmt.Errorf("SaveQuantized failed: %w", err)
+		return err
+	}
+	return nil
+}
+
+// LoadQuantized loads a file of SaveQuantized into vs and head on CPU and returns its metadata.
+// Variables of int8 weights are set to their dequantized values and linear layers of head are
+// replaced with QuantizedLinear layers of the saved int8 weights.
+func LoadQuantized(file string, vs *nn.VarStore, head *Head) (map[string]string, error) {
+	tensors, metadata, err := ReadSafetensors(file)
+	if err != nil {
+		err = fmt.Errorf("LoadQuantized failed: %w", err)
+		return nil, err
+	}
+	defer func() {
+		for _, x := range tensors {
+			x.MustDrop()
+		}
+	}()
+
+	// int8 weights and scales by prefix.
+	weights := make(map[string]*ts.Tensor)
+	scales := make(map[string]float64)
+	for name, x := range tensors {
+		if !strings.HasSuffix(name, quantizedScaleSuffix) {
+			continue
+		}
+		prefix := strings.TrimSuffix(name, quantizedScaleSuffix)
+		w, ok := tensors[prefix+".weight"]
+		if !ok || w.DType() != gotch.Int8 {
+			err := fmt.Errorf("LoadQuantized failed: expected int8 weight of %q", prefix)
+			return nil, err
+		}
+		weights[prefix] = w
+		scales[prefix] = x.Float64Values()[0]
+		tensors[prefix+".weight"] = w.MustTotype(gotch.Float, false).MustMulScalar(ts.FloatScalar(scales[prefix]), true)
+		delete(tensors, name)
+		x.MustDrop()
+	}
+	dropWeights := func() {
+		for _, w := range weights {
+			w.MustDrop()
+		}
+	}
+	if len(weights) == 0 {
+		err := fmt.Errorf("LoadQuantized failed: no int8 weights in %q", file)
+		return nil, err
+	}
+	linears := make(map[string]bool)
+	for _, prefix := range append(append([]string{}, head.hiddenNames...), head.fcNames...) {
+		linears[prefix] = true
+	}
+	for prefix := range weights {
+		if !linears[prefix] {
+			dropWeights()
+			err := fmt.Errorf("LoadQuantized failed: int8 weight %q is not a linear layer of head", prefix)
+			return nil, err
+		}
+	}
+
+	namedTensors := make([]ts.NamedTensor, 0, len(tensors))
+	for name, x := range tensors {
+		namedTensors = append(namedTensors, ts.NamedTensor{Name: name, Tensor: x})
+	}
+	if err := vs.LoadWeights(namedTensors); err != nil {
+		dropWeights()
+		err = fmt.Errorf("LoadQuantized failed: %w", err)
+		return nil, err
+	}
+
+	for _, layers := range []struct {
+		modules []ts.Module
+		names   []string
+	}{{head.hidden, head.hiddenNames}, {head.fcs, head.fcNames}} {
+		for i, prefix := range layers.names {
+			w, ok := weights[prefix]
+			if !ok {
+				continue
+			}
+			var bias *ts.Tensor
+			switch l := layers.modules[i].(type) {
+			case *nn.Linear:
+				bias = l.Bs.MustDetach(false)
+			case *QuantizedLinear:
+				bias = l.bias.MustShallowClone()
+				l.Drop()
+			}
+			layers.modules[i] = newQuantizedLinear(w, scales[prefix], bias)
+		}
+	}
+
+	return metadata, nil
+}
@@ -0,0 +1,166 @@
+package model
+
+// RegNet implementation.
+//
+// See "Designing Network Design Spaces", Radosavovic et al 2020.
+// https://arxiv.org/abs/2003.13678
+
+import (
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+// regNetParams are parameters of RegNet design space generating widths and depths of stages.
+type regNetParams struct {
+	depth      int64
+	w0         float64
+	wa         float64
+	wm         float64
+	groupWidth int64
+	seRatio    float64
+}
+
+var regNetConfigs map[string]regNetParams = map[string]regNetParams{
+	"regnet_y_400mf": {16, 48, 27.89, 2.09, 8, 0.25},
+	"regnet_y_800mf": {14, 56, 38.84, 2.4, 16, 0.25},
+	"regnet_y_1_6gf": {27, 48, 20.71, 2.65, 24, 0.25},
+	"regnet_y_3_2gf": {21, 80, 42.63, 2.66, 24, 0.25},
+	"regnet_y_8gf":   {17, 192, 76.82, 2.19, 56, 0.25},
+	"regnet_y_16gf":  {18, 200, 106.23, 2.48, 112, 0.25},
+	"regnet_y_32gf":  {20, 232, 115.89, 2.53, 232, 0.25},
+	"regnet_x_400mf": {22, 24, 24.48, 2.54, 16, 0},
+	"regnet_x_800mf": {16, 56, 35.73, 2.28, 16, 0},
+	"regnet_x_1_6gf": {18, 80, 34.01, 2.25, 24, 0},
+	"regnet_x_3_2gf": {25, 88, 26.31, 2.25, 48, 0},
+	"regnet_x_8gf":   {23, 80, 49.56, 2.88, 120, 0},
+	"regnet_x_16gf":  {22, 216, 55.59, 2.1, 128, 0},
+	"regnet_x_32gf":  {23, 320, 69.86, 2.0, 168, 0},
+}
+
+// RegNet creates RegNet ModuleT.
+func RegNet(p *nn.Path, nclasses int64, backbone string) ts.ModuleT {
+	params, ok := regNetConfigs[backbone]
+	if !ok {
+		log.Fatalf("Invalid backbone type: %s\n", backbone)
+	}
+
+	return regNet(p, nclasses, params)
+}
+
+// stages returns widths, depths and group widths of stages.
+func (rp regNetParams) stages() (widths, depths, groupWidths []int64) {
+	const quant = 8
+
+	// Per-block widths are quantized to a piecewise linear function. Consecutive blocks of the
+	// same width form a stage.
+	var blockWidths []int64
+	for i := int64(0); i < rp.depth; i++ {
+		w := float64(i)*rp.wa + rp.w0
+		capacity := math.RoundToEven(math.Log(w/rp.w0) / math.Log(rp.wm))
+		blockWidths = append(blockWidths, int64(math.RoundToEven(rp.w0*math.Pow(rp.wm, capacity)/quant))*quant)
+	}
+	for i, w := range blockWidths {
+		if i == 0 || w != blockWidths[i-1] {
+			widths = append(widths, w)
+			depths = append(depths, 0)
+		}
+		depths[len(depths)-1]++
+	}
+
+	// Make widths compatible with group widths.
+	for i, w := range widths {
+		g := rp.groupWidth
+		if w < g {
+			g = w
+		}
+		groupWidths = append(groupWidths, g)
+		widths[i] = makeDivisible(float64(w), g)
+	}
+
+	return widths, depths, groupWidths
+}
+
+// resBottleneckBlock is RegNet residual bottleneck block with group convolution and
+// optional squeeze-excitation.
+type resBottleneckBlock struct {
+	Proj ts.ModuleT // nil if identity
+	F    ts.ModuleT
+}
+
+func newResBottleneckBlock(p *nn.Path, cIn, cOut, stride, groupWidth int64, seRatio float64) *resBottleneckBlock {
+	bnConfig := nn.DefaultBatchNormConfig()
+
+	var proj ts.ModuleT
+	if cIn != cOut || stride != 1 {
+		proj = convNormAct(p.Sub("proj"), cIn, cOut, 1, stride, 1, bnConfig, nil)
+	}
+
+	f := p.Sub("f")
+	seq := nn.SeqT()
+	seq.Add(convNormAct(f.Sub("a"), cIn, cOut, 1, 1, 1, bnConfig, relu))
+	seq.Add(convNormAct(f.Sub("b"), cOut, cOut, 3, stride, cOut/groupWidth, bnConfig, relu))
+	if seRatio > 0 {
+		cSqueeze := int64(math.RoundToEven(seRatio * float64(cIn)))
+		seq.Add(newSqueezeExcitation(f.Sub("se"), cOut, cSqueeze, relu, sigmoid))
+	}
+	seq.Add(convNormAct(f.Sub("c"), cOut, cOut, 1, 1, 1, bnConfig, nil))
+
+	return &resBottleneckBlock{proj, seq}
+}
+
+// ForwardT implements ModuleT for resBottleneckBlock.
+func (b *resBottleneckBlock) ForwardT(x *ts.Tensor, train bool) *ts.Tensor {
+	fx := b.F.ForwardT(x, train)
+	var add *ts.Tensor
+	if b.Proj != nil {
+		px := b.Proj.ForwardT(x, train)
+		add = px.MustAdd(fx, true)
+	} else {
+		add = x.MustAdd(fx, false)
+	}
+	fx.MustDrop()
+
+	return add.MustRelu(true)
+}
+
+// regNetFeatures creates RegNet stem and trunk and returns their output channels.
+func regNetFeatures(p *nn.Path, params regNetParams) (Layers, int64) {
+	const stemWidth = 32
+
+	var seq Layers
+	seq.add(p.Sub("stem"), convNormAct(p.Sub("stem"), 3, stemWidth, 3, 2, 1, nn.DefaultBatchNormConfig(), relu))
+
+	trunk := p.Sub("trunk_output")
+	widths, depths, groupWidths := params.stages()
+	cIn := int64(stemWidth)
+	for i := range widths {
+		name := fmt.Sprintf("block%d", i+1)
+		stage := trunk.Sub(name)
+		for j := int64(0); j < depths[i]; j++ {
+			stride := int64(2)
+			if j > 0 {
+				stride = 1
+			}
+			path := stage.Sub(fmt.Sprintf("%s-%d", name, j))
+			seq.add(path, newResBottleneckBlock(path, cIn, widths[i], stride, groupWidths[i], params.seRatio))
+			cIn = widths[i]
+		}
+	}
+
+	return seq, cIn
+}
+
+// regNet creates a RegNet model. Without final fully connected layer if nclasses is 0.
+func regNet(p *nn.Path, nclasses int64, params regNetParams) ts.ModuleT {
+	seq, cIn := regNetFeatures(p, params)
+	if nclasses <= 0 {
+		return pooledClassifier(seq, nil)
+	}
+
+	fc := nn.NewLinear(p.Sub("fc"), cIn, nclasses, nn.DefaultLinearConfig())
+	return pooledClassifier(seq, fc)
+}
@@ -0,0 +1,194 @@
+package model
+
+// ResNeXt, Wide ResNet and SE-ResNet implementation.
+//
+// See "Aggregated Residual Transformations for Deep Neural Networks", Xie et al 2016.
+// https://arxiv.org/abs/1611.05431
+// "Wide Residual Networks", Zagoruyko et al 2016.
+// https://arxiv.org/abs/1605.07146
+// and "Squeeze-and-Excitation Networks", Hu et al 2017.
+// https://arxiv.org/abs/1709.01507
+//
+// Variable names follow torchvision ResNet. Squeeze-excitation blocks are named "se.fc1" and
+// "se.fc2" as in timm.
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+// resNetConfig holds settings of ResNet variants.
+type resNetConfig struct {
+	layers     [4]int64
+	bottleneck bool
+	groups     int64
+	baseWidth  int64
+	se         bool
+}
+
+var resNeXtConfigs map[string]resNetConfig = map[string]resNetConfig{
+	"resnext50_32x4d":  {[4]int64{3, 4, 6, 3}, true, 32, 4, false},
+	"resnext101_32x8d": {[4]int64{3, 4, 23, 3}, true, 32, 8, false},
+	"resnext101_64x4d": {[4]int64{3, 4, 23, 3}, true, 64, 4, false},
+	"wide_resnet50_2":  {[4]int64{3, 4, 6, 3}, true, 1, 128, false},
+	"wide_resnet101_2": {[4]int64{3, 4, 23, 3}, true, 1, 128, false},
+}
+
+var seResNetConfigs map[string]resNetConfig = map[string]resNetConfig{
+	"seresnet18":         {[4]int64{2, 2, 2, 2}, false, 1, 64, true},
+	"seresnet34":         {[4]int64{3, 4, 6, 3}, false, 1, 64, true},
+	"seresnet50":         {[4]int64{3, 4, 6, 3}, true, 1, 64, true},
+	"seresnet101":        {[4]int64{3, 4, 23, 3}, true, 1, 64, true},
+	"seresnet152":        {[4]int64{3, 8, 36, 3}, true, 1, 64, true},
+	"seresnext50_32x4d":  {[4]int64{3, 4, 6, 3}, true, 32, 4, true},
+	"seresnext101_32x8d": {[4]int64{3, 4, 23, 3}, true, 32, 8, true},
+}
+
+// ResNeXt creates ResNeXt or Wide ResNet ModuleT.
+func ResNeXt(p *nn.Path, nclasses int64, backbone string) ts.ModuleT {
+	config, ok := resNeXtConfigs[backbone]
+	if !ok {
+		log.Fatalf("Invalid backbone type: %s\n", backbone)
+	}
+
+	return resNetV(p, nclasses, config)
+}
+
+// SEResNet creates SE-ResNet or SE-ResNeXt ModuleT.
+func SEResNet(p *nn.Path, nclasses int64, backbone string) ts.ModuleT {
+	config, ok := seResNetConfigs[backbone]
+	if !ok {
+		log.Fatalf("Invalid backbone type: %s\n", backbone)
+	}
+
+	return resNetV(p, nclasses, config)
+}
+
+// resBlock is a basic or bottleneck residual block with group convolution and optional
+// squeeze-excitation applied before adding shortcut.
+type resBlock struct {
+	Convs      []*nn.Conv2D
+	Bns        []*nn.BatchNorm
+	SE         ts.ModuleT // nil if none
+	Downsample ts.ModuleT
+}
+
+func newResBlock(p *nn.Path, cIn, planes, stride int64, config resNetConfig) *resBlock {
+	bnConfig := nn.DefaultBatchNormConfig()
+
+	var (
+		convs []*nn.Conv2D
+		cOut  int64
+	)
+	if config.bottleneck {
+		width := (planes * config.baseWidth / 64) * config.groups
+		cOut = 4 * planes
+		convs = []*nn.Conv2D{
+			groupConv2d(p.Sub("conv1"), cIn, width, 1, 1, 1, false),
+			groupConv2d(p.Sub("conv2"), width, width, 3, stride, config.groups, false),
+			groupConv2d(p.Sub("conv3"), width, cOut, 1, 1, 1, false),
+		}
+	} else {
+		cOut = planes
+		convs = []*nn.Conv2D{
+			groupConv2d(p.Sub("conv1"), cIn, planes, 3, stride, 1, false),
+			groupConv2d(p.Sub("conv2"), planes, planes, 3, 1, 1, false),
+		}
+	}
+
+	var bns []*nn.BatchNorm
+	for i, conv := range convs {
+		bns = append(bns, nn.BatchNorm2D(p.Sub(fmt.Sprintf("bn%d", i+1)), conv.Ws.MustSize()[0], bnConfig))
+	}
+
+	var se ts.ModuleT
+	if config.se {
+		// timm SEModule: reduction ratio 1/16, rounded to multiple of 8.
+		cSqueeze := (cOut/16 + 4) / 8 * 8
+		if cSqueeze < 8 {
+			cSqueeze = 8
+		}
+		se = newSqueezeExcitation(p.Sub("se"), cOut, cSqueeze, relu, sigmoid)
+	}
+
+	return &resBlock{
+		Convs:      convs,
+		Bns:        bns,
+		SE:         se,
+		Downsample: downSample(p.Sub("downsample"), cIn, cOut, stride),
+	}
+}
+
+// ForwardT implements ModuleT for resBlock.
+func (b *resBlock) ForwardT(x *ts.Tensor, train bool) *ts.Tensor {
+	out := x.MustShallowClone()
+	for i, conv := range b.Convs {
+		c := conv.ForwardT(out, train)
+		out.MustDrop()
+		out = b.Bns[i].ForwardT(c, train)
+		c.MustDrop()
+		if i < len(b.Convs)-1 {
+			out = out.MustRelu(true)
+		}
+	}
+	if b.SE != nil {
+		se := b.SE.ForwardT(out, train)
+		out.MustDrop()
+		out = se
+	}
+
+	dsl := b.Downsample.ForwardT(x, train)
+	add := dsl.MustAdd(out, true)
+	out.MustDrop()
+
+	return add.MustRelu(true)
+}
+
+// resNetV creates a ResNet variant. Without final fully connected layer if nclasses is 0.
+func resNetV(p *nn.Path, nclasses int64, config resNetConfig) ts.ModuleT {
+	seq := nn.SeqT()
+	seq.Add(layerZero(p))
+
+	cIn := int64(64)
+	for i := range config.layers {
+		var layer ts.ModuleT
+		layer, cIn = resNetVLayer(p, i, cIn, config)
+		seq.Add(layer)
+	}
+
+	if nclasses <= 0 {
+		return pooledClassifier(seq, nil)
+	}
+
+	fc := nn.NewLinear(p.Sub("fc"), cIn, nclasses, nn.DefaultLinearConfig())
+	return pooledClassifier(seq, fc)
+}
+
+// resNetVLayer creates i-th layer ("layer{i+1}") of a ResNet variant. It returns the layer and
+// its output channels.
+func resNetVLayer(p *nn.Path, i int, cIn int64, config resNetConfig) (*nn.SequentialT, int64) {
+	expansion := int64(1)
+	if config.bottleneck {
+		expansion = 4
+	}
+	planes := int64(64) << i
+	stride := int64(2)
+	if i == 0 {
+		stride = 1
+	}
+
+	layer := nn.SeqT()
+	path := p.Sub(fmt.Sprintf("layer%d", i+1))
+	for j := int64(0); j < config.layers[i]; j++ {
+		if j > 0 {
+			stride = 1
+		}
+		layer.Add(newResBlock(path.Sub(fmt.Sprint(j)), cIn, planes, stride, config))
+		cIn = planes * expansion
+	}
+
+	return layer, cIn
+}
@@ -0,0 +1,406 @@
+package model
+
+// Safetensors format.
+//
+// A file is an 8-byte little-endian header size N, a JSON header of N bytes and a data buffer.
+// The header maps tensor names to dtype, shape and [begin, end) byte offsets in the data
+// buffer, and optional "__metadata__" to string values.
+// See https://github.com/huggingface/safetensors
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/pickle"
+	"github.com/sugarme/gotch/ts"
+)
+
+// safetensorsInfo is header entry of a tensor.
+type safetensorsInfo struct {
+	DType       string   `json:"dtype"`
+	Shape       []int64  `json:"shape"`
+	DataOffsets [2]int64 `json:"data_offsets"`
+}
+
+// maxSafetensorsHeader limits header size against corrupted files.
+const maxSafetensorsHeader = 100 << 20
+
+// ReadSafetensors reads tensors and metadata from a safetensors file. Half precision tensors
+// are converted to float32. Caller owns returned tensors.
+func ReadSafetensors(file string) (map[string]*ts.Tensor, map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		err = fmt.Errorf("ReadSafetensors failed: %w", err)
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var n uint64
+	if err := binary.Read(f, binary.LittleEndian, &n); err != nil {
+		err = fmt.Errorf("ReadSafetensors failed: reading header size: %w", err)
+		return nil, nil, err
+	}
+	if n > maxSafetensorsHeader {
+		err := fmt.Errorf("ReadSafetensors failed: header size %d too large", n)
+		return nil, nil, err
+	}
+	headerBytes := make([]byte, n)
+	if _, err := io.ReadFull(f, headerBytes); err != nil {
+		err = fmt.Errorf("ReadSafetensors failed: reading header: %w", err)
+		return nil, nil, err
+	}
+
+	var header map[string]json.RawMessage
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		err = fmt.Errorf("ReadSafetensors failed: invalid header: %w", err)
+		return nil, nil, err
+	}
+
+	var metadata map[string]string
+	infos := make(map[string]safetensorsInfo, len(header))
+	for name, raw := range header {
+		if name == "__metadata__" {
+			if err := json.Unmarshal(raw, &metadata); err != nil {
+				err = fmt.Errorf("ReadSafetensors failed: invalid metadata: %w", err)
+				return nil, nil, err
+			}
+			continue
+		}
+		var info safetensorsInfo
+		if err := json.Unmarshal(raw, &info); err != nil {
+			err = fmt.Errorf("ReadSafetensors failed: invalid entry %q: %w", name, err)
+			return nil, nil, err
+		}
+		infos[name] = info
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		err = fmt.Errorf("ReadSafetensors failed: reading data: %w", err)
+		return nil, nil, err
+	}
+
+	tensors := make(map[string]*ts.Tensor, len(infos))
+	for name, info := range infos {
+		begin, end := info.DataOffsets[0], info.DataOffsets[1]
+		if begin < 0 || end < begin || end > int64(len(data)) {
+			err := fmt.Errorf("ReadSafetensors failed: invalid data offsets %v of %q", info.DataOffsets, name)
+			return nil, nil, err
+		}
+		values, err := decodeSafetensorsData(info.DType, data[begin:end])
+		if err != nil {
+			err = fmt.Errorf("ReadSafetensors failed: tensor %q: %w", name, err)
+			return nil, nil, err
+		}
+		numel := int64(1)
+		for _, d := range info.Shape {
+			numel *= d
+		}
+		if l := int64(reflect.ValueOf(values).Len()); l != numel {
+			err := fmt.Errorf("ReadSafetensors failed: tensor %q of shape %v has %d elements", name, info.Shape, l)
+			return nil, nil, err
+		}
+		tensors[name] = ts.MustOfSlice(values).MustView(info.Shape, true)
+	}
+
+	return tensors, metadata, nil
+}
+
+// decodeSafetensorsData decodes little-endian data of given safetensors dtype to a slice
+// accepted by ts.OfSlice.
+func decodeSafetensorsData(dtype string, b []byte) (interface{}, error) {
+	size := map[string]int{
+		"F64": 8, "F32": 4, "F16": 2, "BF16": 2,
+		"I64": 8, "I32": 4, "I16": 2, "I8": 1, "U8": 1, "BOOL": 1,
+	}[dtype]
+	if size == 0 {
+		err := fmt.Errorf("unsupported dtype %q", dtype)
+		return nil, err
+	}
+	if len(b)%size != 0 {
+		err := fmt.Errorf("data length %d is not a multiple of %s size %d", len(b), dtype, size)
+		return nil, err
+	}
+	n := len(b) / size
+	le := binary.LittleEndian
+
+	switch dtype {
+	case "F64":
+		v := make([]float64, n)
+		for i := range v {
+			v[i] = math.Float64frombits(le.Uint64(b[8*i:]))
+		}
+		return v, nil
+	case "F32":
+		v := make([]float32, n)
+		for i := range v {
+			v[i] = math.Float32frombits(le.Uint32(b[4*i:]))
+		}
+		return v, nil
+	case "F16":
+		v := make([]float32, n)
+		for i := range v {
+			v[i] = math.Float32frombits(pickle.FloatBits16to32(le.Uint16(b[2*i:])))
+		}
+		return v, nil
+	case "BF16":
+		v := make([]float32, n)
+		for i := range v {
+			v[i] = math.Float32frombits(uint32(le.Uint16(b[2*i:])) << 16)
+		}
+		return v, nil
+	case "I64":
+		v := make([]int64, n)
+		for i := range v {
+			v[i] = int64(le.Uint64(b[8*i:]))
+		}
+		return v, nil
+	case "I32":
+		v := make([]int32, n)
+		for i := range v {
+			v[i] = int32(le.Uint32(b[4*i:]))
+		}
+		return v, nil
+	case "I16":
+		v := make([]int16, n)
+		for i := range v {
+			v[i] = int16(le.Uint16(b[2*i:]))
+		}
+		return v, nil
+	case "I8":
+		v := make([]int8, n)
+		for i := range v {
+			v[i] = int8(b[i])
+		}
+		return v, nil
+	case "U8":
+		v := make([]uint8, n)
+		copy(v, b)
+		return v, nil
+	default: // BOOL
+		v := make([]bool, n)
+		for i := range v {
+			v[i] = b[i] != 0
+		}
+		return v, nil
+	}
+}
+
+// WriteSafetensors writes tensors and string metadata to a safetensors file. The file is
+// written to a temporary file first and renamed into place.
+func WriteSafetensors(file string, tensors map[string]*ts.Tensor, metadata map[string]string) error {
+	names := make([]string, 0, len(tensors))
+	for name := range tensors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	header := make(map[string]interface{}, len(tensors)+1)
+	if len(metadata) > 0 {
+		header["__metadata__"] = metadata
+	}
+	var (
+		data   []byte
+		offset int64
+	)
+	for _, name := range names {
+		x := tensors[name]
+		dtype, b, err := encodeSafetensorsData(x)
+		if err != nil {
+			err = fmt.Errorf("WriteSafetensors failed: tensor %q: %w", name, err)
+			return err
+		}
+		header[name] = safetensorsInfo{
+			DType:       dtype,
+			Shape:       x.MustSize(),
+			DataOffsets: [2]int64{offset, offset + int64(len(b))},
+		}
+		data = append(data, b...)
+		offset += int64(len(b))
+	}
+
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		err = fmt.Errorf("WriteSafetensors failed: %w", err)
+		return err
+	}
+	// Pad header with spaces so that data buffer is 8-byte aligned.
+	if r := len(headerBytes) % 8; r != 0 {
+		headerBytes = append(headerBytes, []byte(strings.Repeat(" ", 8-r))...)
+	}
+
+	tmpFile := file + ".tmp"
+	f, err := os.Create(tmpFile)
+	if err != nil {
+		err = fmt.Errorf("WriteSafetensors failed: %w", err)
+		return err
+	}
+	err = binary.Write(f, binary.LittleEndian, uint64(len(headerBytes)))
+	if err == nil {
+		_, err = f.Write(headerBytes)
+	}
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile, file)
+	}
+	if err != nil {
+		os.Remove(tmpFile)
+		err = fmt.Errorf("WriteSafetensors failed: %w", err)
+		return err
+	}
+
+	return nil
+}
+
+// encodeSafetensorsData returns safetensors dtype and little-endian data of tensor x.
+func encodeSafetensorsData(x *ts.Tensor) (string, []byte, error) {
+	detached := x.MustDetach(false)
+	cpu := detached.MustTo(gotch.CPU, true)
+	contiguous := cpu.MustContiguous(true)
+	values := contiguous.Vals()
+	contiguous.MustDrop()
+
+	le := binary.LittleEndian
+	switch v := values.(type) {
+	case []float64:
+		b := make([]byte, 8*len(v))
+		for i, e := range v {
+			le.PutUint64(b[8*i:], math.Float64bits(e))
+		}
+		return "F64", b, nil
+	case []float32:
+		b := make([]byte, 4*len(v))
+		for i, e := range v {
+			le.PutUint32(b[4*i:], math.Float32bits(e))
+		}
+		return "F32", b, nil
+	case []int64:
+		b := make([]byte, 8*len(v))
+		for i, e := range v {
+			le.PutUint64(b[8*i:], uint64(e))
+		}
+		return "I64", b, nil
+	case []int32:
+		b := make([]byte, 4*len(v))
+		for i, e := range v {
+			le.PutUint32(b[4*i:], uint32(e))
+		}
+		return "I32", b, nil
+	case []int16:
+		b := make([]byte, 2*len(v))
+		for i, e := range v {
+			le.PutUint16(b[2*i:], uint16(e))
+		}
+		return "I16", b, nil
+	case []int8:
+		b := make([]byte, len(v))
+		for i, e := range v {
+			b[i] = byte(e)
+		}
+		return "I8", b, nil
+	case []uint8:
+		b := make([]byte, len(v))
+		copy(b, v)
+		return "U8", b, nil
+	case []bool:
+		b := make([]byte, len(v))
+		for i, e := range v {
+			if e {
+				b[i] = 1
+			}
+		}
+		return "BOOL", b, nil
+	default:
+		err := fmt.Errorf("unsupported dtype %v", x.DType())
+		return "", nil, err
+	}
+}
+
+// IsSafetensors reports whether file has ".safetensors" extension.
+func IsSafetensors(file string) bool {
+	return strings.EqualFold(filepath.Ext(file), ".safetensors")
+}
+
+// SaveWeights saves all variables of vs to file, in safetensors format with metadata if file
+// has ".safetensors" extension, otherwise in gotch format. Metadata is not saved in gotch format.
+func SaveWeights(vs *nn.VarStore, file string, metadata map[string]string) error {
+	if !IsSafetensors(file) {
+		return vs.Save(file)
+	}
+
+	variables := vs.Variables()
+	tensors := make(map[string]*ts.Tensor, len(variables))
+	for name := range variables {
+		x := variables[name]
+		tensors[name] = &x
+	}
+	return WriteSafetensors(file, tensors, metadata)
+}
+
+// LoadWeights loads values of all variables of vs from a gotch or safetensors file and returns
+// safetensors metadata if any. It returns error if a variable is missing or has different
+// shape.
+func LoadWeights(vs *nn.VarStore, file string) (map[string]string, error) {
+	if !IsSafetensors(file) {
+		return nil, vs.Load(file)
+	}
+
+	namedTensors, metadata, err := readNamedTensors(file)
+	if err != nil {
+		return nil, err
+	}
+	defer dropNamedTensors(namedTensors)
+
+	return metadata, vs.LoadWeights(namedTensors)
+}
+
+// LoadWeightsPartial loads values of variables of vs found in a gotch or safetensors file with
+// the same shape. It returns names of variables not loaded and safetensors metadata if any.
+func LoadWeightsPartial(vs *nn.VarStore, file string) ([]string, map[string]string, error) {
+	if !IsSafetensors(file) {
+		missing, err := vs.LoadPartial(file)
+		return missing, nil, err
+	}
+
+	namedTensors, metadata, err := readNamedTensors(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer dropNamedTensors(namedTensors)
+
+	missing, err := vs.LoadWeightsPartial(namedTensors)
+	return missing, metadata, err
+}
+
+func readNamedTensors(file string) ([]ts.NamedTensor, map[string]string, error) {
+	tensors, metadata, err := ReadSafetensors(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	namedTensors := make([]ts.NamedTensor, 0, len(tensors))
+	for name, x := range tensors {
+		namedTensors = append(namedTensors, ts.NamedTensor{Name: name, Tensor: x})
+	}
+	return namedTensors, metadata, nil
+}
+
+func dropNamedTensors(namedTensors []ts.NamedTensor) {
+	for _, x := range namedTensors {
+		x.Tensor.MustDrop()
+	}
+}
@@ -0,0 +1,56 @@
+package model
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/nn"
+)
+
+func TestSafetensorsWeights(t *testing.T) {
+	vs := nn.NewVarStore(gotch.CPU)
+	linear := nn.NewLinear(vs.Root().Sub("fc"), 4, 3, nn.DefaultLinearConfig())
+	file := filepath.Join(t.TempDir(), "weights.safetensors")
+	metadata := map[string]string{"epoch": "2", "config_hash": "abc"}
+	if err := SaveWeights(vs, file, metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	// Data buffer starts 8-byte aligned.
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := binary.LittleEndian.Uint64(buf); n%8 != 0 {
+		t.Errorf("Want header size multiple of 8, got %d\n", n)
+	}
+
+	vs1 := nn.NewVarStore(gotch.CPU)
+	linear1 := nn.NewLinear(vs1.Root().Sub("fc"), 4, 3, nn.DefaultLinearConfig())
+	got, err := LoadWeights(vs1, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(metadata, got) {
+		t.Errorf("Want metadata %v, got %v\n", metadata, got)
+	}
+	if !reflect.DeepEqual(linear.Ws.Float64Values(), linear1.Ws.Float64Values()) || !reflect.DeepEqual(linear.Bs.Float64Values(), linear1.Bs.Float64Values()) {
+		t.Errorf("Want loaded weights equal to saved weights\n")
+	}
+
+	// Partial load skips variables not in file.
+	vs2 := nn.NewVarStore(gotch.CPU)
+	nn.NewLinear(vs2.Root().Sub("fc"), 4, 3, nn.DefaultLinearConfig())
+	nn.NewLinear(vs2.Root().Sub("head"), 3, 2, nn.DefaultLinearConfig())
+	missing, _, err := LoadWeightsPartial(vs2, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 2 {
+		t.Errorf("Want 2 missing variables, got %v\n", missing)
+	}
+}
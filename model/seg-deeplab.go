@@ -0,0 +1,144 @@
+package model
+
+// DeepLabV3+ decoder.
+//
+// See "Encoder-Decoder with Atrous Separable Convolution for Semantic Image Segmentation",
+// Chen et al 2018. https://arxiv.org/abs/1802.02611
+//
+// NOTE. Encoders are not dilated: ASPP runs on stride 32 encoder output which is upsampled
+// by 8 to stride 4 of high resolution features.
+
+import (
+	"fmt"
+
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+// separableConv creates depthwise ("0") and pointwise ("1") convolutions without bias.
+func separableConv(p *nn.Path, cIn, cOut, ksize, dilation int64) *nn.SequentialT {
+	config := nn.DefaultConv2DConfig()
+	config.Padding = []int64{dilation * (ksize - 1) / 2, dilation * (ksize - 1) / 2}
+	config.Dilation = []int64{dilation, dilation}
+	config.Groups = cIn
+	config.Bias = false
+
+	seq := nn.SeqT()
+	seq.Add(nn.NewConv2D(p.Sub("0"), cIn, cIn, ksize, config))
+	seq.Add(groupConv2d(p.Sub("1"), cIn, cOut, 1, 1, 1, false))
+
+	return seq
+}
+
+// aspp is Atrous Spatial Pyramid Pooling: 1x1 convolution, separable atrous convolutions
+// and image pooling branches, concatenated and projected.
+type aspp struct {
+	Convs   []*nn.SequentialT
+	Pool    *nn.SequentialT
+	Project *nn.SequentialT
+}
+
+func newASPP(p *nn.Path, cIn, cOut int64, rates []int64) *aspp {
+	bnConfig := nn.DefaultBatchNormConfig()
+	cp := p.Sub("convs")
+
+	a := &aspp{}
+	a.Convs = append(a.Convs, convNormAct(cp.Sub("0"), cIn, cOut, 1, 1, 1, bnConfig, relu))
+	for i, rate := range rates {
+		bp := cp.Sub(fmt.Sprint(i + 1))
+		seq := nn.SeqT()
+		seq.Add(separableConv(bp.Sub("0"), cIn, cOut, 3, rate))
+		seq.Add(nn.BatchNorm2D(bp.Sub("1"), cOut, bnConfig))
+		seq.AddFn(nn.NewFunc(relu))
+		a.Convs = append(a.Convs, seq)
+	}
+
+	pp := cp.Sub(fmt.Sprint(len(rates) + 1))
+	a.Pool = nn.SeqT()
+	a.Pool.AddFn(nn.NewFunc(func(x *ts.Tensor) *ts.Tensor {
+		return x.MustAdaptiveAvgPool2d([]int64{1, 1}, false)
+	}))
+	a.Pool.Add(groupConv2d(pp.Sub("1"), cIn, cOut, 1, 1, 1, false))
+	a.Pool.Add(nn.BatchNorm2D(pp.Sub("2"), cOut, bnConfig))
+	a.Pool.AddFn(nn.NewFunc(relu))
+
+	nBranches := int64(len(a.Convs) + 1)
+	a.Project = convNormAct(p.Sub("project"), nBranches*cOut, cOut, 1, 1, 1, bnConfig, relu)
+	a.Project.Add(dropout(0.5))
+
+	return a
+}
+
+// ForwardT implements ModuleT for aspp.
+func (a *aspp) ForwardT(x *ts.Tensor, train bool) *ts.Tensor {
+	size := x.MustSize()
+	var branches []ts.Tensor
+	for _, conv := range a.Convs {
+		branches = append(branches, *conv.ForwardT(x, train))
+	}
+	pool := a.Pool.ForwardT(x, train)
+	branches = append(branches, *resize(pool, size[2], size[3], false))
+
+	cat := ts.MustCat(branches, 1)
+	for i := range branches {
+		branches[i].MustDrop()
+	}
+	out := a.Project.ForwardT(cat, train)
+	cat.MustDrop()
+
+	return out
+}
+
+type deepLabV3PlusDecoder struct {
+	ASPP   *nn.SequentialT
+	Block1 *nn.SequentialT
+	Block2 *nn.SequentialT
+}
+
+func newDeepLabV3PlusDecoder(p *nn.Path, encoderChannels, decoderChannels []int64) (*deepLabV3PlusDecoder, error) {
+	if len(decoderChannels) != 1 {
+		err := fmt.Errorf("newDeepLabV3PlusDecoder failed: expected 1 decoder channel (ASPP), got %v", decoderChannels)
+		return nil, err
+	}
+	if len(encoderChannels) < 4 {
+		err := fmt.Errorf("newDeepLabV3PlusDecoder failed: expected at least 4 encoder stages, got %d", len(encoderChannels))
+		return nil, err
+	}
+	bnConfig := nn.DefaultBatchNormConfig()
+	cOut := decoderChannels[0]
+	const highResC = 48
+
+	ap := p.Sub("aspp")
+	asppSeq := nn.SeqT()
+	asppSeq.Add(newASPP(ap.Sub("0"), encoderChannels[len(encoderChannels)-1], cOut, []int64{12, 24, 36}))
+	asppSeq.Add(separableConv(ap.Sub("1"), cOut, cOut, 3, 1))
+	asppSeq.Add(nn.BatchNorm2D(ap.Sub("2"), cOut, bnConfig))
+	asppSeq.AddFn(nn.NewFunc(relu))
+
+	// High resolution features of stride 4.
+	block1 := convNormAct(p.Sub("block1"), encoderChannels[len(encoderChannels)-4], highResC, 1, 1, 1, bnConfig, relu)
+
+	bp := p.Sub("block2")
+	block2 := nn.SeqT()
+	block2.Add(separableConv(bp.Sub("0"), highResC+cOut, cOut, 3, 1))
+	block2.Add(nn.BatchNorm2D(bp.Sub("1"), cOut, bnConfig))
+	block2.AddFn(nn.NewFunc(relu))
+
+	return &deepLabV3PlusDecoder{asppSeq, block1, block2}, nil
+}
+
+func (d *deepLabV3PlusDecoder) forward(features []*ts.Tensor, train bool) *ts.Tensor {
+	n := len(features)
+	aspp := d.ASPP.ForwardT(features[n-1], train)
+	highRes := d.Block1.ForwardT(features[n-4], train)
+	size := highRes.MustSize()
+	up := resize(aspp, size[2], size[3], true)
+
+	cat := ts.MustCat([]ts.Tensor{*up, *highRes}, 1)
+	up.MustDrop()
+	highRes.MustDrop()
+	out := d.Block2.ForwardT(cat, train)
+	cat.MustDrop()
+
+	return out
+}
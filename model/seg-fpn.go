@@ -0,0 +1,102 @@
+package model
+
+// FPN decoder.
+//
+// See "Feature Pyramid Networks for Object Detection", Lin et al 2016.
+// https://arxiv.org/abs/1612.03144
+// and "Panoptic Feature Pyramid Networks", Kirillov et al 2019.
+// https://arxiv.org/abs/1901.02446
+
+import (
+	"fmt"
+
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+// fpnDecoder builds a feature pyramid of encoder stages with strides 4 to 32, upsamples each
+// level to stride 4 with segmentation blocks and sums them.
+type fpnDecoder struct {
+	P5        *nn.Conv2D
+	Skips     []*nn.Conv2D // lateral convolutions of p4, p3, p2
+	SegBlocks []*nn.SequentialT
+}
+
+func newFPNDecoder(p *nn.Path, encoderChannels, decoderChannels []int64) (*fpnDecoder, error) {
+	if len(decoderChannels) != 2 {
+		err := fmt.Errorf("newFPNDecoder failed: expected 2 decoder channels (pyramid, segmentation), got %v", decoderChannels)
+		return nil, err
+	}
+	if len(encoderChannels) < 4 {
+		err := fmt.Errorf("newFPNDecoder failed: expected at least 4 encoder stages, got %d", len(encoderChannels))
+		return nil, err
+	}
+	pyramidC, segC := decoderChannels[0], decoderChannels[1]
+	encC := reversed(encoderChannels)
+
+	d := &fpnDecoder{
+		P5: groupConv2d(p.Sub("p5"), encC[0], pyramidC, 1, 1, 1, true),
+	}
+	for i, name := range []string{"p4", "p3", "p2"} {
+		d.Skips = append(d.Skips, groupConv2d(p.Sub(name).Sub("skip_conv"), encC[i+1], pyramidC, 1, 1, 1, true))
+	}
+
+	// Segmentation block of level p5 to p2 upsamples 3 to 0 times.
+	for i, nUpsamples := range []int{3, 2, 1, 0} {
+		bp := p.Sub("seg_blocks").Sub(fmt.Sprint(i))
+		seq := nn.SeqT()
+		seq.Add(conv3x3GNReLU(bp.Sub("block").Sub("0"), pyramidC, segC, nUpsamples > 0))
+		for j := 1; j < nUpsamples; j++ {
+			seq.Add(conv3x3GNReLU(bp.Sub("block").Sub(fmt.Sprint(j)), segC, segC, true))
+		}
+		d.SegBlocks = append(d.SegBlocks, seq)
+	}
+
+	return d, nil
+}
+
+// conv3x3GNReLU applies 3x3 convolution, group norm and ReLU, optionally followed by bilinear
+// upsampling by 2.
+func conv3x3GNReLU(p *nn.Path, cIn, cOut int64, up bool) *nn.SequentialT {
+	bp := p.Sub("block")
+	seq := nn.SeqT()
+	seq.Add(groupConv2d(bp.Sub("0"), cIn, cOut, 3, 1, 1, false))
+	seq.Add(newGroupNorm(bp.Sub("1"), 32, cOut))
+	seq.AddFn(nn.NewFunc(relu))
+	if up {
+		seq.AddFn(nn.NewFunc(func(x *ts.Tensor) *ts.Tensor {
+			return upsample(x.MustShallowClone(), 2, true, true)
+		}))
+	}
+
+	return seq
+}
+
+func (d *fpnDecoder) forward(features []*ts.Tensor, train bool) *ts.Tensor {
+	n := len(features)
+	pyramid := []*ts.Tensor{d.P5.Forward(features[n-1])}
+	for i, skip := range d.Skips {
+		top := upsample(pyramid[i].MustShallowClone(), 2, false, false)
+		lateral := skip.Forward(features[n-2-i])
+		pyramid = append(pyramid, top.MustAdd(lateral, true))
+		lateral.MustDrop()
+	}
+
+	var out *ts.Tensor
+	for i, p := range pyramid {
+		seg := d.SegBlocks[i].ForwardT(p, train)
+		p.MustDrop()
+		if out == nil {
+			out = seg
+			continue
+		}
+		out = out.MustAdd(seg, true)
+		seg.MustDrop()
+	}
+
+	// Channel-wise dropout.
+	dropped := ts.MustFeatureDropout(out, 0.2, train)
+	out.MustDrop()
+
+	return dropped
+}
@@ -0,0 +1,77 @@
+package model
+
+// LinkNet decoder.
+//
+// See "LinkNet: Exploiting Encoder Representations for Efficient Semantic Segmentation",
+// Chaurasia et al 2017. https://arxiv.org/abs/1707.03718
+
+import (
+	"fmt"
+
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+// linkNetBlock reduces channels by 4, upsamples by 2 with transposed convolution and
+// projects to output channels. Skip features are added to output.
+func linkNetBlock(p *nn.Path, cIn, cOut int64) *nn.SequentialT {
+	bnConfig := nn.DefaultBatchNormConfig()
+	bp := p.Sub("block")
+	cMid := cIn / 4
+
+	transposeConfig := &nn.ConvTranspose2DConfig{
+		Stride:        []int64{2, 2},
+		Padding:       []int64{1, 1},
+		OutputPadding: []int64{0, 0},
+		Dilation:      []int64{1, 1},
+		Groups:        1,
+		Bias:          true,
+		WsInit:        nn.NewKaimingUniformInit(),
+		BsInit:        nn.NewConstInit(0.0),
+	}
+	transpose := nn.NewConvTranspose2D(bp.Sub("1").Sub("0"), cMid, cMid, []int64{4, 4}, transposeConfig)
+
+	seq := nn.SeqT()
+	seq.Add(convNormAct(bp.Sub("0"), cIn, cMid, 1, 1, 1, bnConfig, relu))
+	seq.AddFn(nn.NewFunc(transpose.Forward))
+	seq.Add(nn.BatchNorm2D(bp.Sub("1").Sub("1"), cMid, bnConfig))
+	seq.AddFn(nn.NewFunc(relu))
+	seq.Add(convNormAct(bp.Sub("2"), cMid, cOut, 1, 1, 1, bnConfig, relu))
+
+	return seq
+}
+
+// linkNetDecoder has a block per encoder stage, from the deepest, each upsampling by 2.
+type linkNetDecoder struct {
+	blocks []*nn.SequentialT
+}
+
+func newLinkNetDecoder(p *nn.Path, encoderChannels, decoderChannels []int64) (*linkNetDecoder, error) {
+	if len(decoderChannels) != 1 {
+		err := fmt.Errorf("newLinkNetDecoder failed: expected 1 decoder channel (last block), got %v", decoderChannels)
+		return nil, err
+	}
+
+	// Each block outputs channels of next skip features.
+	channels := append(reversed(encoderChannels), decoderChannels[0])
+	var blocks []*nn.SequentialT
+	for i := 0; i < len(channels)-1; i++ {
+		blocks = append(blocks, linkNetBlock(p.Sub("blocks").Sub(fmt.Sprint(i)), channels[i], channels[i+1]))
+	}
+
+	return &linkNetDecoder{blocks}, nil
+}
+
+func (d *linkNetDecoder) forward(features []*ts.Tensor, train bool) *ts.Tensor {
+	x := features[len(features)-1].MustShallowClone()
+	for i, b := range d.blocks {
+		out := b.ForwardT(x, train)
+		x.MustDrop()
+		if j := len(features) - 2 - i; j >= 0 {
+			out = out.MustAdd(features[j], true)
+		}
+		x = out
+	}
+
+	return x
+}
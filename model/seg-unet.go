@@ -0,0 +1,172 @@
+package model
+
+// UNet and UNet++ decoders.
+//
+// See "U-Net: Convolutional Networks for Biomedical Image Segmentation", Ronneberger et al 2015.
+// https://arxiv.org/abs/1505.04597
+// and "UNet++: A Nested U-Net Architecture for Medical Image Segmentation", Zhou et al 2018.
+// https://arxiv.org/abs/1807.10165
+
+import (
+	"fmt"
+
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+// unetBlock upsamples input by 2, concatenates skip features if any and applies two
+// 3x3 convolutions.
+type unetBlock struct {
+	Conv1 *nn.SequentialT
+	Conv2 *nn.SequentialT
+}
+
+func newUNetBlock(p *nn.Path, cIn, cSkip, cOut int64) *unetBlock {
+	bnConfig := nn.DefaultBatchNormConfig()
+	return &unetBlock{
+		Conv1: convNormAct(p.Sub("conv1"), cIn+cSkip, cOut, 3, 1, 1, bnConfig, relu),
+		Conv2: convNormAct(p.Sub("conv2"), cOut, cOut, 3, 1, 1, bnConfig, relu),
+	}
+}
+
+// forward applies block to x and optional skip features. It does not delete inputs.
+func (b *unetBlock) forward(x, skip *ts.Tensor, train bool) *ts.Tensor {
+	up := upsample(x.MustShallowClone(), 2, false, false)
+	if skip != nil {
+		cat := ts.MustCat([]ts.Tensor{*up, *skip}, 1)
+		up.MustDrop()
+		up = cat
+	}
+	c1 := b.Conv1.ForwardT(up, train)
+	up.MustDrop()
+	out := b.Conv2.ForwardT(c1, train)
+	c1.MustDrop()
+
+	return out
+}
+
+// checkUNetChannels checks that there is one decoder block for each encoder stage.
+func checkUNetChannels(encoderChannels, decoderChannels []int64) error {
+	if len(decoderChannels) != len(encoderChannels) {
+		err := fmt.Errorf("expected %d decoder channels, got %v", len(encoderChannels), decoderChannels)
+		return err
+	}
+	return nil
+}
+
+type unetDecoder struct {
+	blocks []*unetBlock
+}
+
+func newUNetDecoder(p *nn.Path, encoderChannels, decoderChannels []int64) (*unetDecoder, error) {
+	if err := checkUNetChannels(encoderChannels, decoderChannels); err != nil {
+		err = fmt.Errorf("newUNetDecoder failed: %w", err)
+		return nil, err
+	}
+
+	// Blocks start from the deepest encoder stage. The last block has no skip.
+	encC := reversed(encoderChannels)
+	inC := append([]int64{encC[0]}, decoderChannels[:len(decoderChannels)-1]...)
+	skipC := append(encC[1:], 0)
+
+	var blocks []*unetBlock
+	for i := range decoderChannels {
+		blocks = append(blocks, newUNetBlock(p.Sub("blocks").Sub(fmt.Sprint(i)), inC[i], skipC[i], decoderChannels[i]))
+	}
+
+	return &unetDecoder{blocks}, nil
+}
+
+func (d *unetDecoder) forward(features []*ts.Tensor, train bool) *ts.Tensor {
+	x := features[len(features)-1].MustShallowClone()
+	for i, b := range d.blocks {
+		var skip *ts.Tensor
+		if j := len(features) - 2 - i; j >= 0 {
+			skip = features[j]
+		}
+		out := b.forward(x, skip, train)
+		x.MustDrop()
+		x = out
+	}
+
+	return x
+}
+
+// unetPlusPlusDecoder has nested blocks "x_{depth}_{layer}". Block x_d_l takes block x_d_(l-1)
+// (or encoder features) as input and concatenates outputs of blocks x_i_l (i > d) and encoder
+// features as skip.
+type unetPlusPlusDecoder struct {
+	blocks map[string]*unetBlock
+	depth  int
+}
+
+func newUNetPlusPlusDecoder(p *nn.Path, encoderChannels, decoderChannels []int64) (*unetPlusPlusDecoder, error) {
+	if err := checkUNetChannels(encoderChannels, decoderChannels); err != nil {
+		err = fmt.Errorf("newUNetPlusPlusDecoder failed: %w", err)
+		return nil, err
+	}
+
+	encC := reversed(encoderChannels)
+	inC := append([]int64{encC[0]}, decoderChannels[:len(decoderChannels)-1]...)
+	skipC := append(encC[1:], 0)
+	depth := len(inC) - 1
+
+	blocks := make(map[string]*unetBlock)
+	bp := p.Sub("blocks")
+	for l := 0; l < depth; l++ {
+		for d := 0; d <= l; d++ {
+			var cIn, cSkip, cOut int64
+			if d == 0 {
+				cIn = inC[l]
+				cSkip = skipC[l] * int64(l+1)
+				cOut = decoderChannels[l]
+			} else {
+				cIn = skipC[l-1]
+				cSkip = skipC[l] * int64(l+1-d)
+				cOut = skipC[l]
+			}
+			name := fmt.Sprintf("x_%d_%d", d, l)
+			blocks[name] = newUNetBlock(bp.Sub(name), cIn, cSkip, cOut)
+		}
+	}
+	name := fmt.Sprintf("x_0_%d", depth)
+	blocks[name] = newUNetBlock(bp.Sub(name), inC[depth], 0, decoderChannels[depth])
+
+	return &unetPlusPlusDecoder{blocks, depth}, nil
+}
+
+func (d *unetPlusPlusDecoder) forward(features []*ts.Tensor, train bool) *ts.Tensor {
+	// feats[0] is the deepest encoder output.
+	feats := make([]*ts.Tensor, len(features))
+	for i, f := range features {
+		feats[len(features)-1-i] = f
+	}
+
+	dense := make(map[string]*ts.Tensor)
+	key := func(depth, layer int) string {
+		return fmt.Sprintf("x_%d_%d", depth, layer)
+	}
+	for l := 0; l < d.depth; l++ {
+		for i := 0; i < d.depth-l; i++ {
+			if l == 0 {
+				dense[key(i, i)] = d.blocks[key(i, i)].forward(feats[i], feats[i+1], train)
+				continue
+			}
+			denseL := i + l
+			var cat []ts.Tensor
+			for idx := i + 1; idx <= denseL; idx++ {
+				cat = append(cat, *dense[key(idx, denseL)])
+			}
+			cat = append(cat, *feats[denseL+1])
+			skip := ts.MustCat(cat, 1)
+			dense[key(i, denseL)] = d.blocks[key(i, denseL)].forward(dense[key(i, denseL-1)], skip, train)
+			skip.MustDrop()
+		}
+	}
+	out := d.blocks[key(0, d.depth)].forward(dense[key(0, d.depth-1)], nil, train)
+	for _, x := range dense {
+		x.MustDrop()
+	}
+
+	return out
+}
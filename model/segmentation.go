@@ -0,0 +1,219 @@
+package model
+
+// Segmentation models of a decoder on a backbone encoder.
+//
+// Variables are named as in segmentation_models_pytorch except that encoder variables
+// are not prefixed with "encoder." so that pretrained classification weights of backbone
+// load directly. Decoder variables are prefixed with "decoder." and output convolution
+// is "segmentation_head.0". Input height and width should be divisible by 32.
+
+import (
+	"fmt"
+
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+// SegDecoders are supported segmentation decoders.
+var SegDecoders []string = []string{"UNet", "UNetPlusPlus", "FPN", "LinkNet", "DeepLabV3Plus"}
+
+type SegOptions struct {
+	// DecoderChannels are channels of decoder blocks. Default (by decoder):
+	// - UNet, UNetPlusPlus: [256, 128, 64, 32, 16], one per decoder block.
+	// - FPN: [256, 128], channels of feature pyramid and of segmentation blocks.
+	// - LinkNet: [32], channels of last decoder block.
+	// - DeepLabV3Plus: [256], channels of ASPP.
+	DecoderChannels []int64
+
+	// Activation is output activation: "none", "sigmoid" or "softmax" (over classes).
+	// Default "none" outputs logits as expected by losses.
+	Activation string
+}
+
+type SegOption func(*SegOptions)
+
+func defaultSegOptions() *SegOptions {
+	return &SegOptions{
+		Activation: "none",
+	}
+}
+
+func WithDecoderChannels(channels []int64) SegOption {
+	return func(o *SegOptions) {
+		o.DecoderChannels = channels
+	}
+}
+
+func WithActivation(name string) SegOption {
+	return func(o *SegOptions) {
+		o.Activation = name
+	}
+}
+
+// segDecoder maps backbone features to a decoded feature map.
+type segDecoder interface {
+	forward(features []*ts.Tensor, train bool) *ts.Tensor
+}
+
+// segModel is a segmentation model. It outputs [N, nclasses, H, W] tensor.
+type segModel struct {
+	encoder Backbone
+	decoder segDecoder
+	head    *nn.Conv2D
+	upscale int64
+	act     activation // nil if none
+}
+
+// SegModel creates a segmentation model of given decoder (one of SegDecoders) on backbone
+// encoder (see NewBackbone).
+func SegModel(p *nn.Path, decoder, encoder string, nclasses int64, opts ...SegOption) (ts.ModuleT, error) {
+	options := defaultSegOptions()
+	for _, o := range opts {
+		o(options)
+	}
+
+	if nclasses < 1 {
+		err := fmt.Errorf("SegModel failed: invalid number of classes %d", nclasses)
+		return nil, err
+	}
+
+	var act activation
+	switch options.Activation {
+	case "", "none":
+	case "sigmoid":
+		act = sigmoid
+	case "softmax":
+		act = func(x *ts.Tensor) *ts.Tensor {
+			return x.MustSoftmax(1, x.DType(), false)
+		}
+	default:
+		err := fmt.Errorf("SegModel failed: invalid activation %q. Expected 'none', 'sigmoid' or 'softmax'", options.Activation)
+		return nil, err
+	}
+
+	backbone, err := NewBackbone(p, encoder)
+	if err != nil {
+		err = fmt.Errorf("SegModel failed: %w", err)
+		return nil, err
+	}
+
+	channels := options.DecoderChannels
+	withDefault := func(defaultChannels []int64) []int64 {
+		if len(channels) == 0 {
+			return defaultChannels
+		}
+		return channels
+	}
+
+	var (
+		dec     segDecoder
+		outC    int64
+		ksize   int64 = 1
+		upscale int64 = 1
+	)
+	dp := p.Sub("decoder")
+	encC := backbone.Channels()
+	switch decoder {
+	case "UNet":
+		channels = withDefault([]int64{256, 128, 64, 32, 16})
+		dec, err = newUNetDecoder(dp, encC, channels)
+		ksize = 3
+	case "UNetPlusPlus":
+		channels = withDefault([]int64{256, 128, 64, 32, 16})
+		dec, err = newUNetPlusPlusDecoder(dp, encC, channels)
+		ksize = 3
+	case "FPN":
+		channels = withDefault([]int64{256, 128})
+		dec, err = newFPNDecoder(dp, encC, channels)
+		upscale = 4
+	case "LinkNet":
+		channels = withDefault([]int64{32})
+		dec, err = newLinkNetDecoder(dp, encC, channels)
+	case "DeepLabV3Plus":
+		channels = withDefault([]int64{256})
+		dec, err = newDeepLabV3PlusDecoder(dp, encC, channels)
+		upscale = 4
+	default:
+		err = fmt.Errorf("invalid decoder %q. Expected one of %v", decoder, SegDecoders)
+	}
+	if err != nil {
+		err = fmt.Errorf("SegModel failed: %w", err)
+		return nil, err
+	}
+	outC = channels[len(channels)-1]
+
+	return &segModel{
+		encoder: backbone,
+		decoder: dec,
+		head:    groupConv2d(p.Sub("segmentation_head").Sub("0"), outC, nclasses, ksize, 1, 1, true),
+		upscale: upscale,
+		act:     act,
+	}, nil
+}
+
+// ForwardT implements ModuleT for segModel.
+func (m *segModel) ForwardT(x *ts.Tensor, train bool) *ts.Tensor {
+	features := m.encoder.ForwardFeatures(x, train)
+	decoded := m.decoder.forward(features, train)
+	for _, f := range features {
+		f.MustDrop()
+	}
+
+	out := m.head.Forward(decoded)
+	decoded.MustDrop()
+	if m.upscale > 1 {
+		out = upsample(out, m.upscale, true, true)
+	}
+	if m.act != nil {
+		y := m.act(out)
+		out.MustDrop()
+		out = y
+	}
+
+	return out
+}
+
+// upsample scales height and width of x by scale with nearest or bilinear interpolation.
+// It deletes x.
+func upsample(x *ts.Tensor, scale int64, bilinear, alignCorners bool) *ts.Tensor {
+	size := x.MustSize()
+	outSize := []int64{size[2] * scale, size[3] * scale}
+	if bilinear {
+		return x.MustUpsampleBilinear2d(outSize, alignCorners, nil, nil, true)
+	}
+	return x.MustUpsampleNearest2d(outSize, nil, nil, true)
+}
+
+// resize resizes height and width of x to given size with bilinear interpolation. It
+// deletes x.
+func resize(x *ts.Tensor, h, w int64, alignCorners bool) *ts.Tensor {
+	return x.MustUpsampleBilinear2d([]int64{h, w}, alignCorners, nil, nil, true)
+}
+
+// reversed returns a reversed copy of xs.
+func reversed(xs []int64) []int64 {
+	out := make([]int64, len(xs))
+	for i, x := range xs {
+		out[len(xs)-1-i] = x
+	}
+	return out
+}
+
+// groupNorm is group normalization layer.
+type groupNorm struct {
+	Ws      *ts.Tensor
+	Bs      *ts.Tensor
+	nGroups int64
+}
+
+func newGroupNorm(p *nn.Path, nGroups, c int64) *groupNorm {
+	ws := p.MustNewVar("weight", []int64{c}, nn.NewConstInit(1.0))
+	bs := p.MustNewVar("bias", []int64{c}, nn.NewConstInit(0.0))
+
+	return &groupNorm{ws, bs, nGroups}
+}
+
+// ForwardT implements ModuleT for groupNorm.
+func (gn *groupNorm) ForwardT(x *ts.Tensor, train bool) *ts.Tensor {
+	return ts.MustGroupNorm(x, gn.nGroups, gn.Ws, gn.Bs, 1e-5, true)
+}
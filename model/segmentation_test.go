@@ -0,0 +1,81 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+func TestSegModelForwardShape(t *testing.T) {
+	const nclasses = 3
+	// kernel size of segmentation head and channels of last decoder block by default.
+	heads := map[string][2]int64{
+		"UNet":          {3, 16},
+		"UNetPlusPlus":  {3, 16},
+		"FPN":           {1, 128},
+		"LinkNet":       {1, 32},
+		"DeepLabV3Plus": {1, 256},
+	}
+
+	encoder := nn.NewVarStore(gotch.CPU)
+	ResNet18NoFinalLayer(encoder.Root())
+	encoderVars := encoder.Variables()
+
+	x := ts.MustRandn([]int64{2, 3, 64, 96}, gotch.Float, gotch.CPU)
+	defer x.MustDrop()
+	for _, decoder := range SegDecoders {
+		vs := nn.NewVarStore(gotch.CPU)
+		m, err := SegModel(vs.Root(), decoder, "resnet18", nclasses)
+		if err != nil {
+			t.Fatal(err)
+		}
+		y := m.ForwardT(x, false)
+		if got, want := y.MustSize(), []int64{2, nclasses, 64, 96}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: want output shape %v, got %v\n", decoder, want, got)
+		}
+		y.MustDrop()
+
+		// Encoder variables keep classification names, others are under "decoder." and
+		// "segmentation_head.0".
+		vars := vs.Variables()
+		head := heads[decoder]
+		w, ok := vars["segmentation_head.0.weight"]
+		if want := []int64{nclasses, head[1], head[0], head[0]}; !ok || !reflect.DeepEqual(w.MustSize(), want) {
+			t.Errorf("%s: want segmentation_head.0.weight of shape %v\n", decoder, want)
+		}
+		if _, ok := vars["segmentation_head.0.bias"]; !ok {
+			t.Errorf("%s: want segmentation_head.0.bias\n", decoder)
+		}
+		decoderVars := 0
+		for name, v := range vars {
+			switch {
+			case strings.HasPrefix(name, "decoder."):
+				decoderVars++
+			case strings.HasPrefix(name, "segmentation_head.0."):
+			default:
+				ev, ok := encoderVars[name]
+				if !ok {
+					t.Errorf("%s: unexpected variable %q\n", decoder, name)
+				} else if !reflect.DeepEqual(v.MustSize(), ev.MustSize()) {
+					t.Errorf("%s: want encoder variable %q of shape %v, got %v\n", decoder, name, ev.MustSize(), v.MustSize())
+				}
+			}
+		}
+		if decoderVars == 0 {
+			t.Errorf("%s: want decoder variables under \"decoder.\"\n", decoder)
+		}
+		for name := range encoderVars {
+			if _, ok := vars[name]; !ok && !strings.HasPrefix(name, "fc.") {
+				t.Errorf("%s: missing encoder variable %q\n", decoder, name)
+			}
+		}
+	}
+
+	if _, err := SegModel(nn.NewVarStore(gotch.CPU).Root(), "PSPNet", "resnet18", nclasses); err == nil {
+		t.Errorf("Want error for unsupported decoder\n")
+	}
+}
@@ -0,0 +1,268 @@
+package model
+
+// Model summary of a forward pass: output shape, parameters and estimated MACs of each layer.
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+// LayerSummary summarizes a layer of Summary.
+type LayerSummary struct {
+	Name        string
+	OutputShape []int64
+	Params      int64 // number of parameters, trainable and frozen
+	Frozen      int64 // number of parameters not requiring gradient
+	MACs        int64 // estimated multiply-accumulate operations
+}
+
+// Summary summarizes a model of named layers.
+//
+// MACs are estimated from weights of convolution and linear layers: a convolution weight
+// [Cout, Cin/groups, kH, kW] counts its size times output height and width of its layer, input
+// height and width of its layer for DenseNet transition convolutions applied before pooling, or
+// 1 for squeeze-excitation convolutions on pooled input. Stems of NewBackbone end before max
+// pooling, so their convolutions are counted at full resolution.
+//
+// NOTE. Other convolutions before a strided convolution or pooling of the same layer, e.g. the
+// 1x1 reduction of the first bottleneck of a ResNet stage or the expansion of a strided
+// inverted residual block, are counted at the lower output resolution and under-counted.
+type Summary struct {
+	InputShape  []int64
+	Layers      []LayerSummary
+	Params      int64 // number of parameters, trainable and frozen
+	Frozen      int64 // number of parameters not requiring gradient
+	Unassigned  int64 // number of parameters not under any layer prefix
+	Buffers     int64 // number of buffer elements, e.g. batch norm running stats
+	MACs        int64
+	WeightBytes int64 // memory of parameters and buffers
+	InputBytes  int64 // memory of input
+	OutputBytes int64 // memory of layer outputs in forward pass
+}
+
+// Trainable returns number of trainable parameters.
+func (s *Summary) Trainable() int64 {
+	return s.Params - s.Frozen
+}
+
+// FLOPs returns estimated floating point operations, 2 per MAC.
+func (s *Summary) FLOPs() int64 {
+	return 2 * s.MACs
+}
+
+// String formats summary as a layer table followed by totals.
+func (s *Summary) String() string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Layer\tOutput shape\tParams\tFrozen\tMACs")
+	for _, l := range s.Layers {
+		fmt.Fprintf(w, "%s\t%v\t%d\t%d\t%d\n", l.Name, l.OutputShape, l.Params, l.Frozen, l.MACs)
+	}
+	w.Flush()
+
+	const mb = 1 << 20
+	fmt.Fprintf(&b, "Input shape: %v\n", s.InputShape)
+	fmt.Fprintf(&b, "Params: %d total - %d trainable - %d frozen\n", s.Params, s.Trainable(), s.Frozen)
+	if s.Unassigned > 0 {
+		fmt.Fprintf(&b, "Params not in any layer: %d\n", s.Unassigned)
+	}
+	fmt.Fprintf(&b, "MACs: %.3fG - FLOPs: %.3fG\n", float64(s.MACs)/1e9, float64(s.FLOPs())/1e9)
+	fmt.Fprintf(&b, "Memory: weights %.2fMB - input %.2fMB - layer outputs %.2fMB\n",
+		float64(s.WeightBytes)/mb, float64(s.InputBytes)/mb, float64(s.OutputBytes)/mb)
+
+	return b.String()
+}
+
+// Summarize runs layers in evaluation mode on a zero input of inputShape [N, C, H, W] and
+// summarizes output shapes, parameters of vs under layer prefixes and estimated MACs.
+func Summarize(vs *nn.VarStore, layers Layers, inputShape []int64) (summary *Summary, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Summarize failed: forward pass on input %v: %v", inputShape, r)
+		}
+	}()
+
+	s := &Summary{InputShape: inputShape, InputBytes: numel(inputShape) * 4}
+	shapes := make([][]int64, len(layers))
+	x := ts.MustZeros(inputShape, gotch.Float, vs.Device())
+	ts.NoGrad(func() {
+		in := x
+		for i, l := range layers {
+			out := l.Module.ForwardT(in, false)
+			if in != x {
+				in.MustDrop()
+			}
+			shapes[i] = out.MustSize()
+			s.OutputBytes += numel(shapes[i]) * elementSize(out)
+			in = out
+		}
+		if in != x {
+			in.MustDrop()
+		}
+	})
+	x.MustDrop()
+
+	s.Layers = make([]LayerSummary, len(layers))
+	for i, l := range layers {
+		s.Layers[i] = LayerSummary{Name: l.Name, OutputShape: shapes[i]}
+	}
+	for name, v := range vs.Variables() {
+		size := v.MustSize()
+		n := numel(size)
+		s.WeightBytes += n * elementSize(&v)
+		if IsBuffer(name) {
+			s.Buffers += n
+			continue
+		}
+		frozen := !v.MustRequiresGrad()
+		s.Params += n
+		if frozen {
+			s.Frozen += n
+		}
+
+		i := layerIndex(layers, name)
+		if i < 0 {
+			s.Unassigned += n
+			continue
+		}
+		l := &s.Layers[i]
+		l.Params += n
+		if frozen {
+			l.Frozen += n
+		}
+		inShape := inputShape
+		if i > 0 {
+			inShape = shapes[i-1]
+		}
+		l.MACs += weightMACs(name, size, inShape, shapes[i])
+	}
+	for _, l := range s.Layers {
+		s.MACs += l.MACs
+	}
+
+	return s, nil
+}
+
+// layerIndex returns index of the first layer whose prefixes include variable name, or -1.
+func layerIndex(layers Layers, name string) int {
+	for i, l := range layers {
+		for _, p := range l.Prefixes {
+			if name == p || strings.HasPrefix(name, p+".") {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// weightMACs estimates MACs of a convolution or linear weight in a layer of given input and
+// output shape.
+func weightMACs(name string, size, inShape, outShape []int64) int64 {
+	if !strings.HasSuffix(name, "weight") || (len(size) != 2 && len(size) != 4) || len(outShape) == 0 {
+		return 0
+	}
+	macs := numel(size) * outShape[0]
+	switch {
+	case len(outShape) != 4 || onPooledInput(name):
+	case beforePooling(name) && len(inShape) == 4:
+		macs *= inShape[2] * inShape[3]
+	default:
+		macs *= outShape[2] * outShape[3]
+	}
+	return macs
+}
+
+// beforePooling reports whether variable belongs to a DenseNet transition convolution, applied
+// at input resolution of its layer before average pooling.
+func beforePooling(name string) bool {
+	parts := strings.Split(name, ".")
+	for i := 0; i+1 < len(parts); i++ {
+		if strings.HasPrefix(parts[i], "transition") && parts[i+1] == "conv" {
+			return true
+		}
+	}
+	return false
+}
+
+// onPooledInput reports whether variable belongs to a squeeze-excitation convolution applied
+// to globally pooled input.
+func onPooledInput(name string) bool {
+	for _, part := range strings.Split(name, ".") {
+		switch part {
+		case "fc1", "fc2", "_se_reduce", "_se_expand":
+			return true
+		}
+	}
+	return false
+}
+
+// IsBuffer reports whether variable name is a buffer rather than a parameter, i.e. running
+// statistics of batch norm.
+func IsBuffer(name string) bool {
+	i := strings.LastIndex(name, ".")
+	switch name[i+1:] {
+	case "running_mean", "running_var", "num_batches_tracked":
+		return true
+	}
+	return false
+}
+
+func numel(shape []int64) int64 {
+	n := int64(1)
+	for _, d := range shape {
+		n *= d
+	}
+	return n
+}
+
+func elementSize(x *ts.Tensor) int64 {
+	size, err := gotch.DTypeSize(x.DType())
+	if err != nil {
+		return 4
+	}
+	return int64(size)
+}
+
+// ReadWeights reads tensors of a gotch VarStore file or a safetensors file to CPU. Caller
+// owns returned tensors.
+func ReadWeights(file string) (map[string]*ts.Tensor, error) {
+	if IsSafetensors(file) {
+		tensors, _, err := ReadSafetensors(file)
+		return tensors, err
+	}
+
+	namedTensors, err := ts.LoadMultiWithDevice(file, gotch.CPU)
+	if err != nil {
+		err = fmt.Errorf("ReadWeights failed: %w", err)
+		return nil, err
+	}
+	tensors := make(map[string]*ts.Tensor, len(namedTensors))
+	for _, x := range namedTensors {
+		tensors[x.Name] = x.Tensor
+	}
+	return tensors, nil
+}
+
+// DiffWeights compares variables of vs with tensors of a weight file without loading them.
+// Report lists variables that would be loaded by LoadWeightsPartial, missing variables, file
+// tensors without variable and shape mismatches.
+func DiffWeights(vs *nn.VarStore, file string) (*ConvertReport, error) {
+	tensors, err := ReadWeights(file)
+	if err != nil {
+		err = fmt.Errorf("DiffWeights failed: %w", err)
+		return nil, err
+	}
+	defer func() {
+		for _, x := range tensors {
+			x.MustDrop()
+		}
+	}()
+
+	identity := func(name string) (string, bool) { return name, true }
+	return matchStateDict(vs, tensors, identity, false), nil
+}
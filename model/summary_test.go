@@ -0,0 +1,92 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/nn"
+)
+
+// classifierSummary summarizes classification model of backbone on input of size
+// [1, 3, inputSize, inputSize], with parameters of frozen layers not requiring gradient.
+func classifierSummary(t *testing.T, backbone string, nclasses, inputSize int64, frozen ...string) *Summary {
+	vs := nn.NewVarStore(gotch.CPU)
+	features, channels, err := Features(vs.Root(), backbone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := NewClassifierHead(vs.Root(), backbone, channels, nclasses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers := append(features, Layer{Name: "head", Prefixes: head.Prefixes(), Module: head})
+
+	for _, l := range layers {
+		for _, name := range frozen {
+			if l.Name != name {
+				continue
+			}
+			for v, x := range vs.Variables() {
+				for _, p := range l.Prefixes {
+					if v == p || strings.HasPrefix(v, p+".") {
+						x.MustRequiresGrad_(false)
+					}
+				}
+			}
+		}
+	}
+
+	s, err := Summarize(vs, layers, []int64{1, 3, inputSize, inputSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestModelSummary(t *testing.T) {
+	s := classifierSummary(t, "resnet18", 1000, 224, "stem", "layer1")
+
+	names := []string{"stem", "layer1", "layer2", "layer3", "layer4", "head"}
+	if len(s.Layers) != len(names) {
+		t.Fatalf("Want %d layers, got %d\n", len(names), len(s.Layers))
+	}
+	for i, name := range names {
+		if s.Layers[i].Name != name {
+			t.Errorf("Want layer %d named %q, got %q\n", i, name, s.Layers[i].Name)
+		}
+	}
+	if want := []int64{1, 512, 7, 7}; !reflect.DeepEqual(want, s.Layers[4].OutputShape) {
+		t.Errorf("Want layer4 output shape %v, got %v\n", want, s.Layers[4].OutputShape)
+	}
+	if want := []int64{1, 1000}; !reflect.DeepEqual(want, s.Layers[5].OutputShape) {
+		t.Errorf("Want head output shape %v, got %v\n", want, s.Layers[5].OutputShape)
+	}
+
+	// torchvision resnet18: 11,689,512 parameters and 1.814G MACs.
+	if s.Params != 11689512 || s.Unassigned != 0 {
+		t.Errorf("Want 11689512 params all in layers, got %d (%d unassigned)\n", s.Params, s.Unassigned)
+	}
+	if want := int64(9408 + 128 + 147968); s.Frozen != want {
+		t.Errorf("Want %d frozen params of stem and layer1, got %d\n", want, s.Frozen)
+	}
+	// Stem convolution at 112x112 before max pooling.
+	if want := int64(64 * 3 * 7 * 7 * 112 * 112); s.Layers[0].MACs != want {
+		t.Errorf("Want %d stem MACs, got %d\n", want, s.Layers[0].MACs)
+	}
+	if want := int64(1814073344); s.MACs != want {
+		t.Errorf("Want %d MACs, got %d\n", want, s.MACs)
+	}
+
+	// DenseNet transition convolutions are counted before pooling.
+	s = classifierSummary(t, "densenet121", 1000, 224)
+	// transition1: 1x1 conv 256 -> 128 at 56x56, denseblock2: 12 layers at 28x28.
+	var block int64
+	for j := int64(0); j < 12; j++ {
+		block += (128+32*j)*128 + 128*32*9
+	}
+	if want := 256*128*56*56 + block*28*28; s.Layers[2].MACs != want {
+		t.Errorf("Want %d MACs of denseblock2, got %d\n", want, s.Layers[2].MACs)
+	}
+}
@@ -0,0 +1,66 @@
+#!/usr/bin/env python3
+"""Validates an ONNX file written by ExportONNX with onnx.checker and onnxruntime.
+
+Usage: check_onnx.py MODEL [REFERENCE]
+
+The model is checked with full shape inference and run by onnxruntime. REFERENCE is a JSON
+file {"input": {"dims": [...], "data": [...]}, "output": {"dims": [...], "data": [...]}};
+onnxruntime output of input must match output. Without REFERENCE a random [2, 3, 64, 64]
+input is run and the output must be finite.
+
+Exit status is 0 if valid, 1 if not and 2 if onnx, onnxruntime or numpy is not installed.
+"""
+
+import json
+import sys
+
+try:
+    import numpy as np
+    import onnx
+    import onnxruntime as ort
+except ImportError as e:
+    print(e, file=sys.stderr)
+    sys.exit(2)
+
+
+def main(argv):
+    if len(argv) not in (2, 3):
+        print(__doc__, file=sys.stderr)
+        return 1
+
+    model = onnx.load(argv[1])
+    onnx.checker.check_model(model, full_check=True)
+
+    sess = ort.InferenceSession(argv[1], providers=["CPUExecutionProvider"])
+    inputs, outputs = sess.get_inputs(), sess.get_outputs()
+    if [i.name for i in inputs] != ["input"] or [o.name for o in outputs] != ["output"]:
+        print("want graph input 'input' and output 'output'", file=sys.stderr)
+        return 1
+
+    if len(argv) == 3:
+        with open(argv[2]) as f:
+            ref = json.load(f)
+        x = np.array(ref["input"]["data"], dtype=np.float32).reshape(ref["input"]["dims"])
+        want = np.array(ref["output"]["data"], dtype=np.float32).reshape(ref["output"]["dims"])
+    else:
+        x = np.random.default_rng(0).random((2, 3, 64, 64), dtype=np.float32)
+        want = None
+
+    (got,) = sess.run(["output"], {"input": x})
+    if not np.all(np.isfinite(got)):
+        print("output is not finite", file=sys.stderr)
+        return 1
+    if want is not None:
+        if got.shape != want.shape:
+            print(f"want output shape {want.shape}, got {got.shape}", file=sys.stderr)
+            return 1
+        diff = float(np.max(np.abs(got - want)))
+        if diff > 1e-4:
+            print(f"want output equal to reference, got max difference {diff}", file=sys.stderr)
+            return 1
+    print(f"{argv[1]}: ok, output shape {got.shape}")
+    return 0
+
+
+if __name__ == "__main__":
+    sys.exit(main(sys.argv))
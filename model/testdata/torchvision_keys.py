@@ -0,0 +1,51 @@
+#!/usr/bin/env python3
+"""Writes state_dict keys and shapes of torchvision models to torchvision-keys.json.
+
+Usage: torchvision_keys.py [OUTPUT]
+
+Keys "num_batches_tracked" of batch norms are left out as VarStore has no such variable.
+Exit status is 2 if torchvision is not installed.
+"""
+
+import json
+import sys
+
+try:
+    import torchvision
+except ImportError as e:
+    print(e, file=sys.stderr)
+    sys.exit(2)
+
+MODELS = [
+    "convnext_tiny",
+    "mobilenet_v2",
+    "mobilenet_v3_large",
+    "mobilenet_v3_small",
+    "regnet_x_400mf",
+    "regnet_y_400mf",
+    "resnext50_32x4d",
+    "vgg11",
+    "vgg11_bn",
+    "wide_resnet50_2",
+]
+
+
+def main(argv):
+    output = argv[1] if len(argv) > 1 else "torchvision-keys.json"
+    lines = ["{"]
+    for i, name in enumerate(MODELS):
+        state = getattr(torchvision.models, name)().state_dict()
+        keys = sorted(k for k in state if not k.endswith("num_batches_tracked"))
+        lines.append(" %s: {" % json.dumps(name))
+        for j, k in enumerate(keys):
+            sep = "," if j < len(keys) - 1 else ""
+            lines.append("  %s: %s%s" % (json.dumps(k), json.dumps(list(state[k].shape)), sep))
+        lines.append(" }" + ("," if i < len(MODELS) - 1 else ""))
+    lines.append("}")
+    with open(output, "w") as f:
+        f.write("\n".join(lines) + "\n")
+    return 0
+
+
+if __name__ == "__main__":
+    sys.exit(main(sys.argv))
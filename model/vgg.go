@@ -0,0 +1,93 @@
+package model
+
+// VGG implementation.
+//
+// See "Very Deep Convolutional Networks for Large-Scale Image Recognition", Simonyan et al 2014.
+// https://arxiv.org/abs/1409.1556
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+// vggConfigs are numbers of output channels of 3x3 convolutions. Zero is a max pooling layer.
+var vggConfigs map[string][]int64 = map[string][]int64{
+	"vgg11": {64, 0, 128, 0, 256, 256, 0, 512, 512, 0, 512, 512, 0},
+	"vgg13": {64, 64, 0, 128, 128, 0, 256, 256, 0, 512, 512, 0, 512, 512, 0},
+	"vgg16": {64, 64, 0, 128, 128, 0, 256, 256, 256, 0, 512, 512, 512, 0, 512, 512, 512, 0},
+	"vgg19": {64, 64, 0, 128, 128, 0, 256, 256, 256, 256, 0, 512, 512, 512, 512, 0, 512, 512, 512, 512, 0},
+}
+
+// VGG creates VGG ModuleT.
+func VGG(p *nn.Path, nclasses int64, backbone string) ts.ModuleT {
+	var m ts.ModuleT
+	switch backbone {
+	case "vgg11", "vgg13", "vgg16", "vgg19":
+		m = vgg(p, nclasses, vggConfigs[backbone], false)
+	case "vgg11_bn", "vgg13_bn", "vgg16_bn", "vgg19_bn":
+		m = vgg(p, nclasses, vggConfigs[backbone[:len(backbone)-3]], true)
+	default:
+		log.Fatalf("Invalid backbone type: %s\n", backbone)
+	}
+
+	return m
+}
+
+func vggFeatures(p *nn.Path, config []int64, batchNorm bool) Layers {
+	var seq Layers
+	cIn := int64(3)
+	idx := 0
+	for _, cOut := range config {
+		if cOut == 0 {
+			seq.add(p.Sub(fmt.Sprint(idx)), nn.NewFunc(func(xs *ts.Tensor) *ts.Tensor {
+				return xs.MustMaxPool2d([]int64{2, 2}, []int64{2, 2}, []int64{0, 0}, []int64{1, 1}, false, false)
+			}))
+			idx++
+			continue
+		}
+
+		seq.add(p.Sub(fmt.Sprint(idx)), groupConv2d(p.Sub(fmt.Sprint(idx)), cIn, cOut, 3, 1, 1, true))
+		idx++
+		if batchNorm {
+			seq.add(p.Sub(fmt.Sprint(idx)), nn.BatchNorm2D(p.Sub(fmt.Sprint(idx)), cOut, nn.DefaultBatchNormConfig()))
+			idx++
+		}
+		seq.add(p.Sub(fmt.Sprint(idx)), nn.NewFunc(relu))
+		idx++
+		cIn = cOut
+	}
+
+	return seq
+}
+
+// vgg creates a VGG model. If nclasses is 0, the final linear layer is skipped and the model
+// outputs 4096 features.
+func vgg(p *nn.Path, nclasses int64, config []int64, batchNorm bool) ts.ModuleT {
+	features := vggFeatures(p.Sub("features"), config, batchNorm)
+	classifier := p.Sub("classifier")
+
+	head := nn.SeqT()
+	head.Add(nn.NewLinear(classifier.Sub("0"), 512*7*7, 4096, nn.DefaultLinearConfig()))
+	head.AddFn(nn.NewFunc(relu))
+	head.Add(dropout(0.5))
+	head.Add(nn.NewLinear(classifier.Sub("3"), 4096, 4096, nn.DefaultLinearConfig()))
+	head.AddFn(nn.NewFunc(relu))
+	head.Add(dropout(0.5))
+	if nclasses > 0 {
+		head.Add(nn.NewLinear(classifier.Sub("6"), 4096, nclasses, nn.DefaultLinearConfig()))
+	}
+
+	return nn.NewFuncT(func(x *ts.Tensor, train bool) *ts.Tensor {
+		output := features.ForwardT(x, train)
+		avgpool := output.MustAdaptiveAvgPool2d([]int64{7, 7}, true)
+		fv := avgpool.FlatView()
+		avgpool.MustDrop()
+		retVal := head.ForwardT(fv, train)
+		fv.MustDrop()
+
+		return retVal
+	})
+}
@@ -0,0 +1,142 @@
+package model
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+// torchvisionKeys lists state_dict keys and shapes of torchvision models without batch norm
+// "num_batches_tracked". Regenerate with testdata/torchvision_keys.py.
+const torchvisionKeys = "testdata/torchvision-keys.json"
+
+// zooModel creates a classification model of the MobileNet, VGG, RegNet, ResNeXt, SE-ResNet
+// or ConvNeXt family.
+func zooModel(t *testing.T, p *nn.Path, nclasses int64, backbone string) ts.ModuleT {
+	switch {
+	case strings.HasPrefix(backbone, "mobilenet"):
+		return MobileNet(p, nclasses, backbone)
+	case strings.HasPrefix(backbone, "vgg"):
+		return VGG(p, nclasses, backbone)
+	case strings.HasPrefix(backbone, "regnet"):
+		return RegNet(p, nclasses, backbone)
+	case strings.HasPrefix(backbone, "resnext"), strings.HasPrefix(backbone, "wide_resnet"):
+		return ResNeXt(p, nclasses, backbone)
+	case strings.HasPrefix(backbone, "seresne"):
+		return SEResNet(p, nclasses, backbone)
+	case strings.HasPrefix(backbone, "convnext"):
+		return ConvNeXt(p, nclasses, backbone)
+	}
+	t.Fatalf("Unknown backbone %q\n", backbone)
+	return nil
+}
+
+func TestZooForwardShape(t *testing.T) {
+	tests := []struct {
+		backbone string
+		features int64 // output size without classifier
+	}{
+		{"mobilenet_v2", 1280},
+		{"mobilenet_v3_large", 960},
+		{"mobilenet_v3_small", 576},
+		{"vgg11_bn", 4096},
+		{"regnet_x_400mf", 400},
+		{"regnet_y_400mf", 440},
+		{"resnext50_32x4d", 2048},
+		{"seresnet18", 512},
+		{"seresnext50_32x4d", 2048},
+		{"convnext_tiny", 768},
+	}
+
+	x := ts.MustRandn([]int64{2, 3, 64, 64}, gotch.Float, gotch.CPU)
+	defer x.MustDrop()
+	for _, tt := range tests {
+		for _, nclasses := range []int64{5, 0} {
+			vs := nn.NewVarStore(gotch.CPU)
+			m := zooModel(t, vs.Root(), nclasses, tt.backbone)
+			y := m.ForwardT(x, false)
+			want := []int64{2, nclasses}
+			if nclasses == 0 {
+				want[1] = tt.features
+			}
+			if got := y.MustSize(); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s with %d classes: want output shape %v, got %v\n", tt.backbone, nclasses, want, got)
+			}
+			y.MustDrop()
+		}
+
+		channels := func() int64 {
+			vs := nn.NewVarStore(gotch.CPU)
+			_, c, err := Features(vs.Root(), tt.backbone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return c
+		}()
+		if !strings.HasPrefix(tt.backbone, "vgg") && channels != tt.features {
+			t.Errorf("%s: want %d feature channels, got %d\n", tt.backbone, tt.features, channels)
+		}
+	}
+}
+
+func TestTorchvisionVarNames(t *testing.T) {
+	buf, err := os.ReadFile(torchvisionKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var models map[string]map[string][]int64
+	if err := json.Unmarshal(buf, &models); err != nil {
+		t.Fatal(err)
+	}
+
+	backbones := make([]string, 0, len(models))
+	for k := range models {
+		backbones = append(backbones, k)
+	}
+	sort.Strings(backbones)
+	for _, backbone := range backbones {
+		want := models[backbone]
+		vs := nn.NewVarStore(gotch.CPU)
+		zooModel(t, vs.Root(), 1000, backbone)
+
+		var missing, unexpected, mismatched []string
+		got := vs.Variables()
+		for name, x := range got {
+			shape, ok := want[name]
+			switch {
+			case !ok:
+				unexpected = append(unexpected, name)
+			case !reflect.DeepEqual(x.MustSize(), shape):
+				mismatched = append(mismatched, name)
+			}
+		}
+		for name := range want {
+			if _, ok := got[name]; !ok {
+				missing = append(missing, name)
+			}
+		}
+		for _, diff := range []struct {
+			kind  string
+			names []string
+		}{{"missing", missing}, {"unexpected", unexpected}, {"shape-mismatched", mismatched}} {
+			if len(diff.names) > 0 {
+				sort.Strings(diff.names)
+				t.Errorf("%s: %d %s variables, e.g. %v\n", backbone, len(diff.names), diff.kind, diff.names[:minInt(len(diff.names), 5)])
+			}
+		}
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
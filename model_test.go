@@ -0,0 +1,136 @@
+package lab
+
+import (
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/ts"
+	lib "github.com/sugarme/lab/model"
+)
+
+func TestModelZooPretrained(t *testing.T) {
+	for backbone := range ModelZoo {
+		if _, ok := lib.PretrainedModels[backbone]; !ok {
+			t.Errorf("Missing pretrained registry entry of %q\n", backbone)
+		}
+	}
+}
+
+func TestBuildModelHead(t *testing.T) {
+	build := func(backbone string, hidden []int64) *Model {
+		cfg := &Config{}
+		cfg.Model.Params.Backbone = backbone
+		cfg.Model.Params.NumClasses = 3
+		cfg.Model.Params.HiddenLayers = hidden
+		m, err := NewBuilder(cfg).BuildModel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	// Default heads keep variable names of the original classifiers.
+	tests := []struct {
+		backbone string
+		want     []string
+	}{
+		{"resnet18", []string{"fc.weight", "fc.bias"}},
+		{"densenet121", []string{"classifier.weight"}},
+		{"efficientnet_b0", []string{"_fc.weight"}},
+		{"mobilenet_v3_small", []string{"classifier.0.weight", "classifier.3.weight"}},
+		{"vgg11", []string{"classifier.0.weight", "classifier.3.weight", "classifier.6.weight"}},
+		{"convnext_tiny", []string{"classifier.0.weight", "classifier.2.weight"}},
+	}
+	for _, tt := range tests {
+		m := build(tt.backbone, nil)
+		vars := m.Weights.Variables()
+		for _, name := range tt.want {
+			if _, ok := vars[name]; !ok {
+				t.Errorf("%s: want variable %q\n", tt.backbone, name)
+			}
+		}
+		for name := range vars {
+			if hasPrefix(name, "head") {
+				t.Errorf("%s: want no variable under head, got %q\n", tt.backbone, name)
+			}
+		}
+	}
+	w := build("vgg11", nil).Weights.Variables()["classifier.0.weight"]
+	if size := w.MustSize(); !reflect.DeepEqual(size, []int64{4096, 512 * 7 * 7}) {
+		t.Errorf("Want VGG classifier on 7x7 pooled features, got weight shape %v\n", size)
+	}
+
+	// Custom heads are under "head" and frozen by "head" stage alias.
+	m := build("resnet18", []int64{16})
+	if _, ok := m.Weights.Variables()["head.fc.weight"]; !ok {
+		t.Errorf("Want custom head variable %q\n", "head.fc.weight")
+	}
+	if err := m.FreezeStages("head"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"head"}; !reflect.DeepEqual(want, m.FrozenStages()) {
+		t.Errorf("Want frozen stages %v, got %v\n", want, m.FrozenStages())
+	}
+
+	// Multi-task head with loss averaged over tasks.
+	cfg := &Config{}
+	cfg.Model.Params.Backbone = "resnet18"
+	cfg.Model.Params.Tasks = []TaskConfig{{"label", 3}, {"severity", 2}}
+	cfg.Loss.Name = "CrossEntropyLoss"
+	b := NewBuilder(cfg)
+	m, err := b.BuildModel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"head.tasks.label.weight", "head.tasks.severity.weight"} {
+		if _, ok := m.Weights.Variables()[name]; !ok {
+			t.Errorf("Want task variable %q\n", name)
+		}
+	}
+	criterion, err := b.BuildLoss()
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := ts.MustRandn([]int64{2, 3, 32, 32}, gotch.Float, m.Weights.Device())
+	logits := m.Module.ForwardT(x, false)
+	if got := logits.MustSize(); !reflect.DeepEqual(got, []int64{2, 5}) {
+		t.Errorf("Want multi-task output shape [2 5], got %v\n", got)
+	}
+	target := ts.MustOfSlice([]int64{2, 1, 0, 0}).MustView([]int64{2, 2}, true).MustTo(logits.MustDevice(), true)
+	outputs := m.Head.Split(logits)
+	labelLoss := CrossEntropyLoss(outputs["label"], ts.MustOfSlice([]int64{2, 0}).MustTo(logits.MustDevice(), true))
+	severityLoss := CrossEntropyLoss(outputs["severity"], ts.MustOfSlice([]int64{1, 0}).MustTo(logits.MustDevice(), true))
+	want := (labelLoss.Float64Values()[0] + severityLoss.Float64Values()[0]) / 2
+	if got := TaskLoss(m.Head, criterion)(logits, target).Float64Values()[0]; math.Abs(got-want) > 1e-5 {
+		t.Errorf("Want multi-task loss %v, got %v\n", want, got)
+	}
+
+	cfg.Loss.Name = "BCELoss"
+	if _, err := b.BuildLoss(); err == nil {
+		t.Errorf("Want error for multi-task head with BCELoss\n")
+	}
+	cfg.Model.Params.Decoder = "UNet"
+	if _, err := b.BuildModel(); err == nil {
+		t.Errorf("Want error for multi-task head of segmentation model\n")
+	}
+}
+
+func TestExportModel(t *testing.T) {
+	transform := TransformConfig{AugmentOpts: []AugmentOpt{{Name: "Normalize", Params: map[string]interface{}{
+		"mean":  []interface{}{0.485, 0.456, 0.406},
+		"stdev": []interface{}{0.229, 0.224, 0.225},
+	}}}}
+	mean, std, ok := transform.Normalization()
+	if !ok || !reflect.DeepEqual(mean, []float64{0.485, 0.456, 0.406}) || !reflect.DeepEqual(std, []float64{0.229, 0.224, 0.225}) {
+		t.Errorf("Want Normalize augment mean and std, got %v %v (%v)\n", mean, std, ok)
+	}
+
+	cfg := &Config{}
+	cfg.Model.Params.Backbone = "mobilenet_v2"
+	if err := ExportModel(cfg, "", filepath.Join(t.TempDir(), "model.onnx"), nil); err == nil {
+		t.Errorf("Want ExportModel error for unsupported backbone\n")
+	}
+}
@@ -0,0 +1,153 @@
+package lab
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// WebhookSink posts notifications to an HTTP webhook.
+//
+// Supported payload formats:
+// - "slack": {"text": "..."}
+// - "discord": {"content": "..."} (truncated to 2000 characters)
+// - "teams": {"text": "..."} (Office 365 connector card)
+// - "json": {"notifications": [{"event": "...", "text": "...", "time": "..."}]}
+type WebhookSink struct {
+	URL     string
+	Format  string
+	Headers map[string]string
+	Client  *http.Client
+}
+
+// NewWebhookSink creates a new WebhookSink.
+func NewWebhookSink(url, format string) (*WebhookSink, error) {
+	switch format {
+	case "slack", "discord", "teams", "json":
+	default:
+		err := fmt.Errorf("NewWebhookSink failed: unsupported format %q. Expected one of 'slack', 'discord', 'teams' or 'json'", format)
+		return nil, err
+	}
+	if url == "" {
+		err := fmt.Errorf("NewWebhookSink failed: empty URL")
+		return nil, err
+	}
+
+	return &WebhookSink{
+		URL:     url,
+		Format:  format,
+		Headers: make(map[string]string),
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (s *WebhookSink) payload(batch []Notification) interface{} {
+	text := FormatNotifications(batch)
+	switch s.Format {
+	case "discord":
+		// Discord limits content to 2000 characters.
+		if runes := []rune(text); len(runes) > 2000 {
+			text = string(runes[:1997]) + "..."
+		}
+		return map[string]string{"content": text}
+	case "json":
+		return map[string]interface{}{"notifications": batch}
+	default: // slack, teams
+		return map[string]string{"text": text}
+	}
+}
+
+// Send implements Sink interface.
+func (s *WebhookSink) Send(batch []Notification) error {
+	body, err := json.Marshal(s.payload(batch))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err := fmt.Errorf("WebhookSink - %s webhook returned status %d: %s", s.Format, resp.StatusCode, strings.TrimSpace(string(msg)))
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+
+	return nil
+}
+
+// SMTPSink emails notifications using an SMTP server.
+type SMTPSink struct {
+	Addr     string // host:port
+	Username string // no authentication if empty
+	Password string
+	From     string
+	To       []string
+	Subject  string
+}
+
+// NewSMTPSink creates a new SMTPSink.
+func NewSMTPSink(addr, from string, to []string) (*SMTPSink, error) {
+	if addr == "" || from == "" || len(to) == 0 {
+		err := fmt.Errorf("NewSMTPSink failed: addr, from and to are required")
+		return nil, err
+	}
+
+	return &SMTPSink{
+		Addr:    addr,
+		From:    from,
+		To:      to,
+		Subject: "[lab] training notification",
+	}, nil
+}
+
+// Send implements Sink interface.
+func (s *SMTPSink) Send(batch []Notification) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host := s.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	subject := s.Subject
+	if len(batch) == 1 {
+		subject = fmt.Sprintf("%s: %s", subject, batch[0].Event)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(FormatNotifications(batch), "\n", "\r\n"))
+
+	err := smtp.SendMail(s.Addr, auth, s.From, s.To, msg.Bytes())
+	if err != nil {
+		err = fmt.Errorf("SMTPSink - Send mail failed: %w", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package lab
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notification events.
+const (
+	EventStart     = "start"      // training started
+	EventEnd       = "end"        // training completed
+	EventProgress  = "progress"   // periodic training progress
+	EventValid     = "valid"      // validation metrics
+	EventBest      = "best"       // new best valid metric
+	EventEarlyStop = "early_stop" // training stopped early
+	EventNaNLoss   = "nan_loss"   // loss is NaN or Inf
+	EventCrash     = "crash"      // training failed
+	EventInfo      = "info"       // other messages
+)
+
+// Notification is a message of an event.
+type Notification struct {
+	Event string    `json:"event"`
+	Text  string    `json:"text"`
+	Time  time.Time `json:"time"`
+}
+
+// Sink is a notification destination. i.e. Slack, webhook or email.
+type Sink interface {
+	// Send sends a batch of notifications.
+	Send(batch []Notification) error
+}
+
+// FormatNotifications formats a batch of notifications as plain text.
+func FormatNotifications(batch []Notification) string {
+	var sb strings.Builder
+	for _, n := range batch {
+		if n.Event != EventInfo && n.Event != "" {
+			fmt.Fprintf(&sb, "[%s] ", n.Event)
+		}
+		sb.WriteString(strings.TrimRight(n.Text, "\n"))
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// Notifier sends notifications to sinks asynchronously.
+//
+// Notifications are queued and sent in batches by a background goroutine so that
+// a slow or unavailable destination never blocks training. Sending is rate limited
+// to one batch per `MinInterval` and failed sends are retried with exponential backoff.
+// When the queue is full, new notifications are dropped.
+type Notifier struct {
+	sinks   []Sink
+	options *NotifierOptions
+
+	mu      sync.Mutex
+	queue   chan Notification
+	closed  bool
+	done    chan struct{}
+	dropped int
+	failed  int
+}
+
+type NotifierOptions struct {
+	Events        []string      // events to notify. Empty means all events.
+	QueueSize     int           // max number of pending notifications.
+	MaxBatch      int           // flush immediately when reaching this number of notifications unless rate limited.
+	FlushInterval time.Duration // max time a notification waits in queue.
+	MinInterval   time.Duration // min time between 2 sends (rate limit).
+	MaxRetries    int           // number of retries of a failed send.
+	RetryBackoff  time.Duration // wait before first retry. It doubles after each retry.
+	CloseTimeout  time.Duration // max time to wait for pending notifications on Close().
+	ErrorHandler  func(err error)
+}
+
+type NotifierOption func(*NotifierOptions)
+
+func defaultNotifierOptions() *NotifierOptions {
+	return &NotifierOptions{
+		Events:        nil,
+		QueueSize:     1000,
+		MaxBatch:      20,
+		FlushInterval: 2 * time.Second,
+		MinInterval:   1 * time.Second,
+		MaxRetries:    3,
+		RetryBackoff:  1 * time.Second,
+		CloseTimeout:  30 * time.Second,
+		ErrorHandler: func(err error) {
+			log.Printf("Notifier - %v\n", err)
+		},
+	}
+}
+
+func WithNotifierEvents(events ...string) NotifierOption {
+	return func(o *NotifierOptions) {
+		o.Events = events
+	}
+}
+
+func WithNotifierQueueSize(size int) NotifierOption {
+	return func(o *NotifierOptions) {
+		o.QueueSize = size
+	}
+}
+
+func WithNotifierMaxBatch(n int) NotifierOption {
+	return func(o *NotifierOptions) {
+		o.MaxBatch = n
+	}
+}
+
+func WithNotifierFlushInterval(d time.Duration) NotifierOption {
+	return func(o *NotifierOptions) {
+		o.FlushInterval = d
+	}
+}
+
+func WithNotifierMinInterval(d time.Duration) NotifierOption {
+	return func(o *NotifierOptions) {
+		o.MinInterval = d
+	}
+}
+
+func WithNotifierRetries(maxRetries int, backoff time.Duration) NotifierOption {
+	return func(o *NotifierOptions) {
+		o.MaxRetries = maxRetries
+		o.RetryBackoff = backoff
+	}
+}
+
+func WithNotifierCloseTimeout(d time.Duration) NotifierOption {
+	return func(o *NotifierOptions) {
+		o.CloseTimeout = d
+	}
+}
+
+func WithNotifierErrorHandler(fn func(err error)) NotifierOption {
+	return func(o *NotifierOptions) {
+		o.ErrorHandler = fn
+	}
+}
+
+// NewNotifier creates a Notifier and starts its background sender.
+func NewNotifier(sinks []Sink, opts ...NotifierOption) *Notifier {
+	options := defaultNotifierOptions()
+	for _, o := range opts {
+		o(options)
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = 1
+	}
+	if options.MaxBatch <= 0 {
+		options.MaxBatch = 1
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = time.Millisecond
+	}
+
+	n := &Notifier{
+		sinks:   sinks,
+		options: options,
+		queue:   make(chan Notification, options.QueueSize),
+		done:    make(chan struct{}),
+	}
+	go n.run()
+
+	return n
+}
+
+// Enabled returns whether event is subscribed.
+func (n *Notifier) Enabled(event string) bool {
+	if len(n.options.Events) == 0 {
+		return true
+	}
+	for _, e := range n.options.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify queues a notification of an event. It never blocks and is safe to call on nil Notifier.
+// It returns false if notification was not queued.
+func (n *Notifier) Notify(event, text string) bool {
+	if n == nil || !n.Enabled(event) {
+		return false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return false
+	}
+	select {
+	case n.queue <- Notification{Event: event, Text: text, Time: time.Now()}:
+		return true
+	default:
+		n.dropped += 1
+		return false
+	}
+}
+
+// Stats returns number of dropped notifications and failed sends.
+func (n *Notifier) Stats() (dropped, failed int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.dropped, n.failed
+}
+
+// Close sends pending notifications and stops background sender. It waits at most `CloseTimeout`.
+func (n *Notifier) Close() error {
+	if n == nil {
+		return nil
+	}
+
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mu.Unlock()
+
+	select {
+	case <-n.done:
+		return nil
+	case <-time.After(n.options.CloseTimeout):
+		err := fmt.Errorf("Notifier.Close - timed out after %v. Pending notifications may be lost.", n.options.CloseTimeout)
+		return err
+	}
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+
+	ticker := time.NewTicker(n.options.FlushInterval)
+	defer ticker.Stop()
+
+	var (
+		batch    []Notification
+		lastSend time.Time
+	)
+	canSend := func() bool {
+		return time.Since(lastSend) >= n.options.MinInterval
+	}
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		n.send(batch)
+		lastSend = time.Now()
+		batch = nil
+	}
+
+	for {
+		select {
+		case msg, ok := <-n.queue:
+			if !ok {
+				// Closing. Respect rate limit for the last batch.
+				if len(batch) > 0 && !canSend() {
+					time.Sleep(n.options.MinInterval - time.Since(lastSend))
+				}
+				flush()
+				return
+			}
+			batch = append(batch, msg)
+			if len(batch) >= n.options.MaxBatch && canSend() {
+				flush()
+			}
+		case <-ticker.C:
+			if canSend() {
+				flush()
+			}
+		}
+	}
+}
+
+// send sends batch to all sinks with retries.
+func (n *Notifier) send(batch []Notification) {
+	for _, sink := range n.sinks {
+		var err error
+		backoff := n.options.RetryBackoff
+		for attempt := 0; attempt <= n.options.MaxRetries; attempt++ {
+			if attempt > 0 {
+				time.Sleep(backoff)
+				backoff *= 2
+			}
+			err = sink.Send(batch)
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			n.mu.Lock()
+			n.failed += 1
+			n.mu.Unlock()
+			if n.options.ErrorHandler != nil {
+				n.options.ErrorHandler(fmt.Errorf("send %d notification(s) to %T failed after %d attempt(s): %w", len(batch), sink, n.options.MaxRetries+1, err))
+			}
+		}
+	}
+}
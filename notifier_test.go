@@ -0,0 +1,266 @@
+package lab
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+type mockSink struct {
+	mu      sync.Mutex
+	batches [][]Notification
+	times   []time.Time
+	fails   int // number of sends to fail
+}
+
+func (s *mockSink) Send(batch []Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fails > 0 {
+		s.fails -= 1
+		return errors.New("unavailable")
+	}
+	s.batches = append(s.batches, batch)
+	s.times = append(s.times, time.Now())
+	return nil
+}
+
+func TestNotifierBatchAndFilter(t *testing.T) {
+	sink := &mockSink{}
+	n := NewNotifier([]Sink{sink},
+		WithNotifierEvents(EventBest, EventCrash),
+		WithNotifierFlushInterval(10*time.Millisecond),
+		WithNotifierMinInterval(0),
+		WithNotifierMaxBatch(100),
+	)
+
+	if n.Notify(EventProgress, "step 1") {
+		t.Errorf("Unsubscribed event should not be queued")
+	}
+	n.Notify(EventBest, "best 0.9")
+	n.Notify(EventCrash, "boom")
+	if err := n.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n.Notify(EventBest, "after close") {
+		t.Errorf("Notify after Close should not be queued")
+	}
+
+	var got []string
+	for _, b := range sink.batches {
+		for _, m := range b {
+			got = append(got, m.Event)
+		}
+	}
+	if strings.Join(got, ",") != "best,crash" {
+		t.Errorf("Want best,crash, got %v\n", got)
+	}
+}
+
+func TestNotifierRateLimitAndRetry(t *testing.T) {
+	sink := &mockSink{fails: 2}
+	var handled []error
+	n := NewNotifier([]Sink{sink},
+		WithNotifierFlushInterval(5*time.Millisecond),
+		WithNotifierMinInterval(50*time.Millisecond),
+		WithNotifierMaxBatch(1),
+		WithNotifierRetries(2, time.Millisecond),
+		WithNotifierErrorHandler(func(err error) { handled = append(handled, err) }),
+	)
+
+	for i := 0; i < 3; i++ {
+		n.Notify(EventInfo, "msg")
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := n.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(handled) != 0 {
+		t.Errorf("Send should succeed after retries, got errors %v\n", handled)
+	}
+	var total int
+	for _, b := range sink.batches {
+		total += len(b)
+	}
+	if total != 3 {
+		t.Errorf("Want 3 notifications delivered, got %d\n", total)
+	}
+	for i := 1; i < len(sink.times); i++ {
+		if d := sink.times[i].Sub(sink.times[i-1]); d < 45*time.Millisecond {
+			t.Errorf("Sends %d and %d only %v apart\n", i-1, i, d)
+		}
+	}
+}
+
+func TestNotifierDropWhenFull(t *testing.T) {
+	block := make(chan struct{})
+	sink := sinkFunc(func(batch []Notification) error {
+		<-block
+		return nil
+	})
+	n := NewNotifier([]Sink{sink}, WithNotifierQueueSize(2), WithNotifierMaxBatch(1), WithNotifierMinInterval(0))
+
+	// First notification is taken by sender which then blocks.
+	n.Notify(EventInfo, "0")
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		n.Notify(EventInfo, "x")
+	}
+	close(block)
+	n.Close()
+
+	dropped, _ := n.Stats()
+	if dropped != 3 {
+		t.Errorf("Want 3 dropped, got %d\n", dropped)
+	}
+}
+
+type sinkFunc func(batch []Notification) error
+
+func (f sinkFunc) Send(batch []Notification) error { return f(batch) }
+
+func TestWebhookSink(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies = make(map[string]map[string]interface{})
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		bodies[r.URL.Path] = body
+		mu.Unlock()
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	batch := []Notification{{Event: EventBest, Text: "acc 0.9\n", Time: time.Now()}}
+	for _, format := range []string{"slack", "discord", "teams", "json"} {
+		sink, err := NewWebhookSink(srv.URL+"/"+format, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Send(batch); err != nil {
+			t.Errorf("%s: %v\n", format, err)
+		}
+	}
+
+	if got := bodies["/slack"]["text"]; got != "[best] acc 0.9\n" {
+		t.Errorf("Unexpected slack payload: %v\n", got)
+	}
+	if got := bodies["/discord"]["content"]; got != "[best] acc 0.9\n" {
+		t.Errorf("Unexpected discord payload: %v\n", got)
+	}
+	if _, ok := bodies["/json"]["notifications"].([]interface{}); !ok {
+		t.Errorf("Unexpected json payload: %v\n", bodies["/json"])
+	}
+
+	sink, _ := NewWebhookSink(srv.URL+"/fail", "slack")
+	if err := sink.Send(batch); err == nil {
+		t.Errorf("Want error on non-2xx status")
+	}
+}
+
+func TestWebhookSink_DiscordTruncate(t *testing.T) {
+	sink, err := NewWebhookSink("http://localhost/discord", "discord")
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := []Notification{{Event: EventInfo, Text: strings.Repeat("é", 2100)}}
+	content := sink.payload(batch).(map[string]string)["content"]
+	if !utf8.ValidString(content) {
+		t.Errorf("Want valid UTF-8 content\n")
+	}
+	if n := utf8.RuneCountInString(content); n != 2000 {
+		t.Errorf("Want 2000 characters, got %d\n", n)
+	}
+	if !strings.HasSuffix(content, "é...") {
+		t.Errorf("Want truncated content ending with \"...\"\n")
+	}
+}
+
+// smtpStandIn is a minimal SMTP server accepting one message.
+func smtpStandIn(t *testing.T) (addr string, received chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received = make(chan string, 1)
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		write := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+		write("220 localhost ESMTP")
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					received <- data.String()
+					write("250 OK")
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				write("250 localhost")
+			case strings.HasPrefix(cmd, "DATA"):
+				inData = true
+				write("354 End data with <CR><LF>.<CR><LF>")
+			case strings.HasPrefix(cmd, "QUIT"):
+				write("221 Bye")
+				return
+			default:
+				write("250 OK")
+			}
+		}
+	}()
+
+	return ln.Addr().String(), received
+}
+
+func TestSMTPSink(t *testing.T) {
+	addr, received := smtpStandIn(t)
+	sink, err := NewSMTPSink(addr, "lab@localhost", []string{"me@localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.Send([]Notification{{Event: EventEarlyStop, Text: "stopped", Time: time.Now()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-received:
+		if !strings.Contains(msg, "Subject: [lab] training notification: early_stop") || !strings.Contains(msg, "[early_stop] stopped") {
+			t.Errorf("Unexpected message:\n%s", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("No message received")
+	}
+}
@@ -0,0 +1,564 @@
+package lab
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+// Optimizer wraps nn.Optimizer with optimizers that are not natively supported
+// by libtorch C API (Adagrad, LAMB) and optimizer wrappers (Lookahead, SAM).
+//
+// Adagrad and LAMB are implemented as gradient transforms: the gradient of each parameter
+// is replaced by the optimizer update direction before a plain SGD step is applied.
+// Therefore learning rate schedulers built on the embedded nn.Optimizer work as usual.
+type Optimizer struct {
+	*nn.Optimizer
+	Name string
+
+	vs        *nn.VarStore
+	transform gradTransform // rewrites gradients before base step. Can be nil.
+	lookahead *lookahead    // can be nil.
+	sam       *sam          // can be nil.
+	gradNorm  float64       // norm of gradients of the last step before update.
+}
+
+// NewOptimizer creates a new Optimizer.
+func NewOptimizer(opt *nn.Optimizer, name string, vs *nn.VarStore) *Optimizer {
+	return &Optimizer{
+		Optimizer: opt,
+		Name:      name,
+		vs:        vs,
+	}
+}
+
+// IsTwoPass returns whether optimizer requires two forward-backward passes per step (i.e. SAM).
+// If so, BackwardStepTwoPass should be used instead of BackwardStep.
+func (o *Optimizer) IsTwoPass() bool {
+	return o.sam != nil
+}
+
+// Step updates model weights with gradients accumulated in parameters.
+func (o *Optimizer) Step() error {
+	params := o.params()
+	o.gradNorm = gradNorm(params)
+	if o.lookahead != nil {
+		ts.NoGrad(func() {
+			o.lookahead.init(params)
+		})
+	}
+	if o.transform != nil {
+		ts.NoGrad(func() {
+			o.transform.apply(params)
+		})
+	}
+
+	err := o.Optimizer.Step()
+	if err != nil {
+		return err
+	}
+
+	if o.lookahead != nil {
+		ts.NoGrad(func() {
+			o.lookahead.step(params)
+		})
+	}
+
+	return nil
+}
+
+// BackwardStep zeros gradients, runs backward pass on loss and updates model weights.
+func (o *Optimizer) BackwardStep(loss *ts.Tensor) error {
+	err := o.Optimizer.ZeroGrad()
+	if err != nil {
+		return err
+	}
+
+	loss.MustBackward()
+
+	return o.Step()
+}
+
+// BackwardStepTwoPass performs a Sharpness-Aware Minimization step. The first pass
+// computes gradient from input loss and moves weights to the local worst point.
+// The second pass recomputes loss there with closure, restores weights and updates
+// them with the gradient of the second pass.
+//
+// closure should run forward pass on the same batch and return a new loss tensor.
+func (o *Optimizer) BackwardStepTwoPass(loss *ts.Tensor, closure func() *ts.Tensor) error {
+	if o.sam == nil {
+		return o.BackwardStep(loss)
+	}
+
+	err := o.Optimizer.ZeroGrad()
+	if err != nil {
+		return err
+	}
+	loss.MustBackward()
+
+	params := o.params()
+	ts.NoGrad(func() {
+		o.sam.ascend(params)
+	})
+
+	err = o.Optimizer.ZeroGrad()
+	if err != nil {
+		return err
+	}
+	loss2 := closure()
+	loss2.MustBackward()
+	loss2.MustDrop()
+
+	ts.NoGrad(func() {
+		o.sam.descend(params)
+	})
+
+	return o.Step()
+}
+
+// GradNorm returns total L2 norm of gradients of all parameters at the last step, taken after
+// backward pass and before updating weights, i.e. before gradient transforms (Adagrad, LAMB)
+// replace gradients by update directions. For SAM, it is the norm of gradients at perturbed
+// weights which update weights.
+func (o *Optimizer) GradNorm() float64 {
+	return o.gradNorm
+}
+
+// gradNorm returns total L2 norm of gradients of parameters.
+func gradNorm(params map[string]*ts.Tensor) float64 {
+	var norms []ts.Tensor
+	for _, x := range params {
+		grad := x.MustGrad(false)
+		if !grad.MustDefined() {
+			grad.MustDrop()
+			continue
+		}
+		n := grad.MustNorm(true)
+		norms = append(norms, *n)
+	}
+	if len(norms) == 0 {
+		return 0
+	}
+
+	// Stack per-parameter norms so that only one value is copied from device.
+	total := ts.MustStack(norms, 0).MustNorm(true)
+	val := total.Float64Values()[0]
+	total.MustDrop()
+	for i := range norms {
+		norms[i].MustDrop()
+	}
+
+	return val
+}
+
+// params returns named parameters that have gradients.
+func (o *Optimizer) params() map[string]*ts.Tensor {
+	params := make(map[string]*ts.Tensor)
+	for name, x := range o.vs.Variables() {
+		x := x
+		if !x.MustRequiresGrad() {
+			continue
+		}
+		params[name] = &x
+	}
+
+	return params
+}
+
+// gradTransform rewrites gradients of parameters to the update direction
+// of a specific optimizer.
+type gradTransform interface {
+	apply(params map[string]*ts.Tensor)
+}
+
+// adagrad implements Adagrad gradient transform.
+//
+// Ref. "Adaptive Subgradient Methods for Online Learning and Stochastic Optimization", Duchi et al 2011.
+type adagrad struct {
+	eps     float64
+	wd      float64
+	initAcc float64
+	sums    map[string]*ts.Tensor
+}
+
+func (a *adagrad) apply(params map[string]*ts.Tensor) {
+	for name, x := range params {
+		grad := x.MustGrad(false)
+		if !grad.MustDefined() {
+			grad.MustDrop()
+			continue
+		}
+		if a.wd != 0 {
+			wd := x.MustMulScalar(ts.FloatScalar(a.wd), false)
+			grad.MustAdd_(wd)
+			wd.MustDrop()
+		}
+
+		sum, ok := a.sums[name]
+		if !ok {
+			sum = x.MustZerosLike(false)
+			if a.initAcc != 0 {
+				sum.MustAddScalar_(ts.FloatScalar(a.initAcc))
+			}
+			a.sums[name] = sum
+		}
+
+		// sum += g^2; g = g / (sqrt(sum) + eps)
+		sq := grad.MustMul(grad, false)
+		sum.MustAdd_(sq)
+		sq.MustDrop()
+		denom := sum.MustSqrt(false).MustAddScalar(ts.FloatScalar(a.eps), true)
+		grad.MustDiv_(denom)
+		denom.MustDrop()
+		grad.MustDrop()
+	}
+}
+
+// lamb implements LAMB gradient transform for large-batch training.
+//
+// Ref. "Large Batch Optimization for Deep Learning: Training BERT in 76 minutes", You et al 2019.
+type lamb struct {
+	beta1 float64
+	beta2 float64
+	eps   float64
+	wd    float64
+	step  int
+	m     map[string]*ts.Tensor
+	v     map[string]*ts.Tensor
+}
+
+func (l *lamb) apply(params map[string]*ts.Tensor) {
+	l.step += 1
+	bc1 := 1 - math.Pow(l.beta1, float64(l.step))
+	bc2 := 1 - math.Pow(l.beta2, float64(l.step))
+	for name, x := range params {
+		grad := x.MustGrad(false)
+		if !grad.MustDefined() {
+			grad.MustDrop()
+			continue
+		}
+
+		m, ok := l.m[name]
+		if !ok {
+			m = x.MustZerosLike(false)
+			l.m[name] = m
+		}
+		v, ok := l.v[name]
+		if !ok {
+			v = x.MustZerosLike(false)
+			l.v[name] = v
+		}
+
+		// m = beta1*m + (1-beta1)*g; v = beta2*v + (1-beta2)*g^2
+		m.MustMulScalar_(ts.FloatScalar(l.beta1))
+		g1 := grad.MustMulScalar(ts.FloatScalar(1-l.beta1), false)
+		m.MustAdd_(g1)
+		g1.MustDrop()
+		v.MustMulScalar_(ts.FloatScalar(l.beta2))
+		g2 := grad.MustMul(grad, false).MustMulScalar(ts.FloatScalar(1-l.beta2), true)
+		v.MustAdd_(g2)
+		g2.MustDrop()
+
+		// r = m_hat/(sqrt(v_hat) + eps) + wd*x
+		mHat := m.MustDivScalar(ts.FloatScalar(bc1), false)
+		denom := v.MustDivScalar(ts.FloatScalar(bc2), false).MustSqrt(true).MustAddScalar(ts.FloatScalar(l.eps), true)
+		r := mHat.MustDiv(denom, true)
+		denom.MustDrop()
+		if l.wd != 0 {
+			wd := x.MustMulScalar(ts.FloatScalar(l.wd), false)
+			r.MustAdd_(wd)
+			wd.MustDrop()
+		}
+
+		// Layer-wise trust ratio ||x||/||r||
+		wNorm := normValue(x)
+		rNorm := normValue(r)
+		trust := 1.0
+		if wNorm > 0 && rNorm > 0 {
+			trust = wNorm / rNorm
+		}
+		r.MustMulScalar_(ts.FloatScalar(trust))
+		grad.Copy_(r)
+		r.MustDrop()
+		grad.MustDrop()
+	}
+}
+
+// lookahead implements Lookahead optimizer wrapper. Slow weights are updated toward
+// fast weights every k steps and fast weights are reset to slow weights.
+//
+// Ref. "Lookahead Optimizer: k steps forward, 1 step back", Zhang et al 2019.
+type lookahead struct {
+	k     int
+	alpha float64
+	count int
+	slow  map[string]*ts.Tensor
+}
+
+// init copies fast weights to slow weights of parameters that have no slow weights yet.
+func (l *lookahead) init(params map[string]*ts.Tensor) {
+	for name, x := range params {
+		if _, ok := l.slow[name]; !ok {
+			slow := x.MustZerosLike(false)
+			slow.Copy_(x)
+			l.slow[name] = slow
+		}
+	}
+}
+
+func (l *lookahead) step(params map[string]*ts.Tensor) {
+	l.count += 1
+	if l.count%l.k != 0 {
+		return
+	}
+
+	for name, x := range params {
+		slow := l.slow[name]
+		// slow += alpha * (fast - slow); fast = slow
+		diff := x.MustSub(slow, false).MustMulScalar(ts.FloatScalar(l.alpha), true)
+		slow.MustAdd_(diff)
+		diff.MustDrop()
+		x.Copy_(slow)
+	}
+}
+
+// sam implements Sharpness-Aware Minimization.
+//
+// Ref. "Sharpness-Aware Minimization for Efficiently Improving Generalization", Foret et al 2020.
+type sam struct {
+	rho      float64
+	adaptive bool // ASAM: scale perturbation by weight magnitude
+	eps      map[string]*ts.Tensor
+}
+
+// ascend moves weights to w + e(w) where e(w) = rho * g/||g||.
+func (s *sam) ascend(params map[string]*ts.Tensor) {
+	var sumSq float64
+	grads := make(map[string]*ts.Tensor)
+	for name, x := range params {
+		grad := x.MustGrad(false)
+		if !grad.MustDefined() {
+			grad.MustDrop()
+			continue
+		}
+		if s.adaptive {
+			abs := x.MustAbs(false)
+			grad = grad.MustMul(abs, true)
+			abs.MustDrop()
+		}
+		grads[name] = grad
+		n := normValue(grad)
+		sumSq += n * n
+	}
+	scale := s.rho / (math.Sqrt(sumSq) + 1e-12)
+
+	s.eps = make(map[string]*ts.Tensor)
+	for name, grad := range grads {
+		x := params[name]
+		e := grad.MustMulScalar(ts.FloatScalar(scale), false)
+		if s.adaptive {
+			// e = rho * w^2 * g/||w*g||
+			abs := x.MustAbs(false)
+			e = e.MustMul(abs, true)
+			abs.MustDrop()
+		}
+		grad.MustDrop()
+		x.MustAdd_(e)
+		s.eps[name] = e
+	}
+}
+
+// descend restores weights from w + e(w) to w.
+func (s *sam) descend(params map[string]*ts.Tensor) {
+	for name, e := range s.eps {
+		params[name].MustSub_(e)
+		e.MustDrop()
+	}
+	s.eps = nil
+}
+
+// normValue returns L2 norm of tensor.
+func normValue(x *ts.Tensor) float64 {
+	n := x.MustNorm(false)
+	val := n.Float64Values()[0]
+	n.MustDrop()
+	return val
+}
+
+// buildOptimizer builds optimizer of input name from config params.
+func buildOptimizer(vs *nn.VarStore, name string, lr float64, params map[string]interface{}) (*Optimizer, error) {
+	p := newParamReader(params)
+	switch name {
+	case "AdamW":
+		cfg := nn.DefaultAdamWConfig()
+		cfg.Beta1 = p.float("beta1", cfg.Beta1)
+		cfg.Beta2 = p.float("beta2", cfg.Beta2)
+		cfg.Wd = p.float("wd", cfg.Wd)
+		if p.err != nil {
+			err := fmt.Errorf("Build AdamW optimizer failed: %w", p.err)
+			return nil, err
+		}
+		opt, err := cfg.Build(vs, lr)
+		if err != nil {
+			err = fmt.Errorf("Build AdamW optimizer failed: %w", err)
+			return nil, err
+		}
+		return NewOptimizer(opt, name, vs), nil
+
+	case "Adam":
+		cfg := nn.DefaultAdamConfig()
+		cfg.Beta1 = p.float("beta1", cfg.Beta1)
+		cfg.Beta2 = p.float("beta2", cfg.Beta2)
+		cfg.Wd = p.float("wd", cfg.Wd)
+		if p.err != nil {
+			err := fmt.Errorf("Build Adam optimizer failed: %w", p.err)
+			return nil, err
+		}
+		opt, err := cfg.Build(vs, lr)
+		if err != nil {
+			err = fmt.Errorf("Build Adam optimizer failed: %w", err)
+			return nil, err
+		}
+		return NewOptimizer(opt, name, vs), nil
+
+	case "SGD":
+		cfg := nn.DefaultSGDConfig()
+		cfg.Dampening = p.float("dampening", cfg.Dampening)
+		cfg.Momentum = p.float("momentum", cfg.Momentum)
+		cfg.Wd = p.float("wd", cfg.Wd)
+		cfg.Nesterov = p.bool("nesterov", cfg.Nesterov)
+		if p.err != nil {
+			err := fmt.Errorf("Build SGD optimizer failed: %w", p.err)
+			return nil, err
+		}
+		opt, err := cfg.Build(vs, lr)
+		if err != nil {
+			err = fmt.Errorf("Build SGD optimizer failed: %w", err)
+			return nil, err
+		}
+		return NewOptimizer(opt, name, vs), nil
+
+	case "RMSprop":
+		cfg := nn.DefaultRMSPropConfig()
+		cfg.Alpha = p.float("alpha", cfg.Alpha)
+		cfg.Eps = p.float("eps", cfg.Eps)
+		cfg.Momentum = p.float("momentum", cfg.Momentum)
+		cfg.Wd = p.float("wd", cfg.Wd)
+		cfg.Centered = p.bool("centered", cfg.Centered)
+		if p.err != nil {
+			err := fmt.Errorf("Build RMSprop optimizer failed: %w", p.err)
+			return nil, err
+		}
+		opt, err := cfg.Build(vs, lr)
+		if err != nil {
+			err = fmt.Errorf("Build RMSprop optimizer failed: %w", err)
+			return nil, err
+		}
+		return NewOptimizer(opt, name, vs), nil
+
+	case "Adagrad":
+		a := &adagrad{
+			eps:     p.float("eps", 1e-10),
+			wd:      p.float("wd", 0),
+			initAcc: p.float("initial_accumulator_value", 0),
+			sums:    make(map[string]*ts.Tensor),
+		}
+		if p.err != nil {
+			err := fmt.Errorf("Build Adagrad optimizer failed: %w", p.err)
+			return nil, err
+		}
+		opt, err := nn.DefaultSGDConfig().Build(vs, lr)
+		if err != nil {
+			err = fmt.Errorf("Build Adagrad optimizer failed: %w", err)
+			return nil, err
+		}
+		o := NewOptimizer(opt, name, vs)
+		o.transform = a
+		return o, nil
+
+	case "LAMB":
+		l := &lamb{
+			beta1: p.float("beta1", 0.9),
+			beta2: p.float("beta2", 0.999),
+			eps:   p.float("eps", 1e-6),
+			wd:    p.float("wd", 0.01),
+			m:     make(map[string]*ts.Tensor),
+			v:     make(map[string]*ts.Tensor),
+		}
+		if p.err != nil {
+			err := fmt.Errorf("Build LAMB optimizer failed: %w", p.err)
+			return nil, err
+		}
+		opt, err := nn.DefaultSGDConfig().Build(vs, lr)
+		if err != nil {
+			err = fmt.Errorf("Build LAMB optimizer failed: %w", err)
+			return nil, err
+		}
+		o := NewOptimizer(opt, name, vs)
+		o.transform = l
+		return o, nil
+
+	case "Lookahead":
+		base := p.string("base", "Adam")
+		la := &lookahead{
+			k:     p.int("lookahead_k", 5),
+			alpha: p.float("lookahead_alpha", 0.5),
+			slow:  make(map[string]*ts.Tensor),
+		}
+		if p.err != nil {
+			err := fmt.Errorf("Build Lookahead optimizer failed: %w", p.err)
+			return nil, err
+		}
+		if la.k <= 0 {
+			err := fmt.Errorf("Build Lookahead optimizer failed: expected k > 0, got %d", la.k)
+			return nil, err
+		}
+		o, err := buildWrappedOptimizer(vs, name, base, lr, params)
+		if err != nil {
+			return nil, err
+		}
+		o.lookahead = la
+		return o, nil
+
+	case "SAM":
+		base := p.string("base", "SGD")
+		s := &sam{
+			rho:      p.float("rho", 0.05),
+			adaptive: p.bool("adaptive", false),
+		}
+		if p.err != nil {
+			err := fmt.Errorf("Build SAM optimizer failed: %w", p.err)
+			return nil, err
+		}
+		o, err := buildWrappedOptimizer(vs, name, base, lr, params)
+		if err != nil {
+			return nil, err
+		}
+		o.sam = s
+		return o, nil
+
+	default:
+		err := fmt.Errorf("Unsupported optimizer config %q\n", name)
+		return nil, err
+	}
+}
+
+// buildWrappedOptimizer builds base optimizer for a wrapper optimizer (Lookahead, SAM).
+func buildWrappedOptimizer(vs *nn.VarStore, name, base string, lr float64, params map[string]interface{}) (*Optimizer, error) {
+	switch base {
+	case "Lookahead", "SAM":
+		err := fmt.Errorf("Build %s optimizer failed: invalid base optimizer %q", name, base)
+		return nil, err
+	}
+
+	o, err := buildOptimizer(vs, base, lr, params)
+	if err != nil {
+		err = fmt.Errorf("Build %s optimizer failed: %w", name, err)
+		return nil, err
+	}
+	o.Name = fmt.Sprintf("%s(%s)", name, base)
+
+	return o, nil
+}
@@ -0,0 +1,87 @@
+package lab
+
+import (
+	"testing"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/nn"
+	"github.com/sugarme/gotch/ts"
+)
+
+func TestBuildOptimizer(t *testing.T) {
+	names := []string{"Adam", "AdamW", "SGD", "RMSprop", "Adagrad", "LAMB", "Lookahead", "SAM"}
+	for _, name := range names {
+		vs := nn.NewVarStore(gotch.CPU)
+		linear := nn.NewLinear(vs.Root(), 4, 2, nn.DefaultLinearConfig())
+		opt, err := buildOptimizer(vs, name, 0.1, map[string]interface{}{"lr": 0.1})
+		if err != nil {
+			t.Fatalf("Build %s optimizer failed: %v\n", name, err)
+		}
+
+		before := linear.Ws.Float64Values()
+		x := ts.MustOnes([]int64{3, 4}, gotch.Float, gotch.CPU)
+		closure := func() *ts.Tensor {
+			return x.Apply(linear).MustSum(gotch.Float, true)
+		}
+		loss := closure()
+		if opt.IsTwoPass() {
+			err = opt.BackwardStepTwoPass(loss, closure)
+		} else {
+			err = opt.BackwardStep(loss)
+		}
+		if err != nil {
+			t.Fatalf("%s step failed: %v\n", name, err)
+		}
+		after := linear.Ws.Float64Values()
+
+		changed := false
+		for i := range before {
+			if before[i] != after[i] {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			t.Errorf("%s: want weights updated after one step\n", name)
+		}
+	}
+}
+
+func TestBuildOptimizer_InvalidBase(t *testing.T) {
+	vs := nn.NewVarStore(gotch.CPU)
+	_ = nn.NewLinear(vs.Root(), 4, 2, nn.DefaultLinearConfig())
+	_, err := buildOptimizer(vs, "SAM", 0.1, map[string]interface{}{"base": "Lookahead"})
+	if err == nil {
+		t.Errorf("Want error for SAM wrapping Lookahead\n")
+	}
+}
+
+func TestBuildOptimizer_ParamTypes(t *testing.T) {
+	vs := nn.NewVarStore(gotch.CPU)
+	_ = nn.NewLinear(vs.Root(), 4, 2, nn.DefaultLinearConfig())
+	// YAML integers of float params.
+	o, err := buildOptimizer(vs, "Lookahead", 0.1, map[string]interface{}{"lookahead_alpha": 1, "lookahead_k": 3.0, "wd": 0})
+	if err != nil {
+		t.Fatalf("Want integer values of float params accepted, got %v\n", err)
+	}
+	if o.lookahead.alpha != 1 || o.lookahead.k != 3 {
+		t.Errorf("Want lookahead alpha 1 and k 3, got %v and %v\n", o.lookahead.alpha, o.lookahead.k)
+	}
+
+	invalid := []struct {
+		name   string
+		params map[string]interface{}
+	}{
+		{"SAM", map[string]interface{}{"adaptive": "yes"}},
+		{"SAM", map[string]interface{}{"rho": "0.05"}},
+		{"Lookahead", map[string]interface{}{"lookahead_k": 2.5}},
+		{"Adagrad", map[string]interface{}{"eps": true}},
+		{"LAMB", map[string]interface{}{"beta1": "0.9"}},
+		{"SGD", map[string]interface{}{"nesterov": 1}},
+	}
+	for _, tc := range invalid {
+		if _, err := buildOptimizer(vs, tc.name, 0.1, tc.params); err == nil {
+			t.Errorf("%s: want error for params %v\n", tc.name, tc.params)
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package lab
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/sugarme/gotch/dutil"
+)
+
+// Labeled is a dataset that provides class labels of its samples without loading them.
+type Labeled interface {
+	Labels() []int
+}
+
+// SampleWeighted is a dataset that provides per-sample weights for weighted sampling.
+type SampleWeighted interface {
+	SampleWeights() []float64
+}
+
+// ShuffleSampler shuffles all samples each epoch. It implements dutil.Sampler interface.
+type ShuffleSampler struct {
+	n         int
+	batchSize int
+	dropLast  bool
+	rng       *rand.Rand
+}
+
+// NewShuffleSampler creates a ShuffleSampler.
+func NewShuffleSampler(n, batchSize int, dropLast bool, seed int64) (*ShuffleSampler, error) {
+	if err := checkBatchSize(n, batchSize); err != nil {
+		return nil, err
+	}
+	return &ShuffleSampler{n: n, batchSize: batchSize, dropLast: dropLast, rng: rand.New(rand.NewSource(seed))}, nil
+}
+
+// Sample implements dutil.Sampler interface.
+func (s *ShuffleSampler) Sample() []int {
+	return truncateBatches(s.rng.Perm(s.n), s.batchSize, s.dropLast)
+}
+
+// BatchSize implements dutil.Sampler interface.
+func (s *ShuffleSampler) BatchSize() int {
+	return s.batchSize
+}
+
+// WeightedRandomSampler draws samples with probabilities proportional to their weights.
+// It implements dutil.Sampler interface.
+type WeightedRandomSampler struct {
+	weights     []float64
+	numSamples  int
+	replacement bool
+	batchSize   int
+	dropLast    bool
+	rng         *rand.Rand
+}
+
+// NewWeightedRandomSampler creates a WeightedRandomSampler drawing numSamples samples per epoch.
+// Without replacement, numSamples can't be greater than number of samples with positive weight.
+func NewWeightedRandomSampler(weights []float64, numSamples, batchSize int, replacement, dropLast bool, seed int64) (*WeightedRandomSampler, error) {
+	if err := checkBatchSize(numSamples, batchSize); err != nil {
+		return nil, err
+	}
+	npositive := 0
+	for i, w := range weights {
+		if w < 0 || math.IsNaN(w) || math.IsInf(w, 0) {
+			err := fmt.Errorf("NewWeightedRandomSampler failed: invalid weight %v at index %d", w, i)
+			return nil, err
+		}
+		if w > 0 {
+			npositive++
+		}
+	}
+	if npositive == 0 {
+		err := fmt.Errorf("NewWeightedRandomSampler failed: all weights are zero")
+		return nil, err
+	}
+	if !replacement && numSamples > npositive {
+		err := fmt.Errorf("NewWeightedRandomSampler failed: can't draw %d samples without replacement from %d samples of positive weight", numSamples, npositive)
+		return nil, err
+	}
+
+	return &WeightedRandomSampler{
+		weights:     weights,
+		numSamples:  numSamples,
+		replacement: replacement,
+		batchSize:   batchSize,
+		dropLast:    dropLast,
+		rng:         rand.New(rand.NewSource(seed)),
+	}, nil
+}
+
+// Sample implements dutil.Sampler interface.
+func (s *WeightedRandomSampler) Sample() []int {
+	indexes := make([]int, s.numSamples)
+	if s.replacement {
+		cum := make([]float64, len(s.weights))
+		var total float64
+		for i, w := range s.weights {
+			total += w
+			cum[i] = total
+		}
+		for i := range indexes {
+			r := s.rng.Float64() * total
+			idx := sort.SearchFloat64s(cum, r)
+			// skip zero-weight samples sharing cumulative value
+			for idx < len(cum)-1 && s.weights[idx] == 0 {
+				idx++
+			}
+			indexes[i] = idx
+		}
+	} else {
+		// Efraimidis-Spirakis: take samples of largest key u^(1/w).
+		type keyed struct {
+			idx int
+			key float64
+		}
+		keys := make([]keyed, 0, len(s.weights))
+		for i, w := range s.weights {
+			if w > 0 {
+				keys = append(keys, keyed{i, math.Log(s.rng.Float64()) / w})
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].key > keys[j].key })
+		for i := range indexes {
+			indexes[i] = keys[i].idx
+		}
+	}
+
+	return truncateBatches(indexes, s.batchSize, s.dropLast)
+}
+
+// BatchSize implements dutil.Sampler interface.
+func (s *WeightedRandomSampler) BatchSize() int {
+	return s.batchSize
+}
+
+// ClassBalancedSampler produces batches with equal number of samples of each class.
+// Samples of a class are drawn without replacement until exhausted, then reshuffled, so
+// minority classes are oversampled. It implements dutil.Sampler interface.
+type ClassBalancedSampler struct {
+	byClass    [][]int
+	pools      [][]int
+	batchSize  int
+	numBatches int
+	rng        *rand.Rand
+}
+
+// NewClassBalancedSampler creates a ClassBalancedSampler. If numBatches <= 0, it is set to
+// number of samples / batch size.
+func NewClassBalancedSampler(labels []int, batchSize, numBatches int, seed int64) (*ClassBalancedSampler, error) {
+	if err := checkBatchSize(len(labels), batchSize); err != nil {
+		return nil, err
+	}
+	byClass := groupByLabel(labels)
+	if batchSize < len(byClass) {
+		err := fmt.Errorf("NewClassBalancedSampler failed: batch size (%d) is less than number of classes (%d)", batchSize, len(byClass))
+		return nil, err
+	}
+	if numBatches <= 0 {
+		numBatches = len(labels) / batchSize
+	}
+
+	return &ClassBalancedSampler{
+		byClass:    byClass,
+		pools:      make([][]int, len(byClass)),
+		batchSize:  batchSize,
+		numBatches: numBatches,
+		rng:        rand.New(rand.NewSource(seed)),
+	}, nil
+}
+
+func (s *ClassBalancedSampler) next(class int) int {
+	if len(s.pools[class]) == 0 {
+		pool := append([]int(nil), s.byClass[class]...)
+		s.rng.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+		s.pools[class] = pool
+	}
+	idx := s.pools[class][0]
+	s.pools[class] = s.pools[class][1:]
+	return idx
+}
+
+// Sample implements dutil.Sampler interface.
+func (s *ClassBalancedSampler) Sample() []int {
+	nclasses := len(s.byClass)
+	perClass := s.batchSize / nclasses
+	extra := s.batchSize % nclasses
+
+	indexes := make([]int, 0, s.numBatches*s.batchSize)
+	for b := 0; b < s.numBatches; b++ {
+		batch := make([]int, 0, s.batchSize)
+		for c := 0; c < nclasses; c++ {
+			for i := 0; i < perClass; i++ {
+				batch = append(batch, s.next(c))
+			}
+		}
+		// Remaining slots go to randomly chosen distinct classes.
+		for _, c := range s.rng.Perm(nclasses)[:extra] {
+			batch = append(batch, s.next(c))
+		}
+		s.rng.Shuffle(len(batch), func(i, j int) { batch[i], batch[j] = batch[j], batch[i] })
+		indexes = append(indexes, batch...)
+	}
+
+	return indexes
+}
+
+// BatchSize implements dutil.Sampler interface.
+func (s *ClassBalancedSampler) BatchSize() int {
+	return s.batchSize
+}
+
+// StratifiedBatchSampler shuffles samples so that every batch has approximately the same class
+// proportions as the whole dataset. It implements dutil.Sampler interface.
+type StratifiedBatchSampler struct {
+	byClass   [][]int
+	n         int
+	batchSize int
+	dropLast  bool
+	rng       *rand.Rand
+}
+
+// NewStratifiedBatchSampler creates a StratifiedBatchSampler.
+func NewStratifiedBatchSampler(labels []int, batchSize int, dropLast bool, seed int64) (*StratifiedBatchSampler, error) {
+	if err := checkBatchSize(len(labels), batchSize); err != nil {
+		return nil, err
+	}
+	return &StratifiedBatchSampler{
+		byClass:   groupByLabel(labels),
+		n:         len(labels),
+		batchSize: batchSize,
+		dropLast:  dropLast,
+		rng:       rand.New(rand.NewSource(seed)),
+	}, nil
+}
+
+// Sample implements dutil.Sampler interface.
+func (s *StratifiedBatchSampler) Sample() []int {
+	// Spread samples of each class evenly over [0, 1) with random jitter then sort by position.
+	type positioned struct {
+		idx int
+		pos float64
+	}
+	all := make([]positioned, 0, s.n)
+	for _, idxs := range s.byClass {
+		pool := append([]int(nil), idxs...)
+		s.rng.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+		offset := s.rng.Float64()
+		for i, idx := range pool {
+			all = append(all, positioned{idx, (float64(i) + offset) / float64(len(pool))})
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].pos < all[j].pos })
+
+	indexes := make([]int, len(all))
+	for i, p := range all {
+		indexes[i] = p.idx
+	}
+	return truncateBatches(indexes, s.batchSize, s.dropLast)
+}
+
+// BatchSize implements dutil.Sampler interface.
+func (s *StratifiedBatchSampler) BatchSize() int {
+	return s.batchSize
+}
+
+// ClassSampleWeights returns per-sample weights from class weights, i.e. from ClassWeights().
+// Class weights are keyed by class name if classes is specified, otherwise by label number.
+func ClassSampleWeights(labels []int, classes []string, classWeights map[string]float64) ([]float64, error) {
+	weights := make([]float64, len(labels))
+	for i, l := range labels {
+		key := strconv.Itoa(l)
+		if l >= 0 && l < len(classes) {
+			key = classes[l]
+		}
+		w, ok := classWeights[key]
+		if !ok {
+			err := fmt.Errorf("ClassSampleWeights failed: no weight for class %q", key)
+			return nil, err
+		}
+		weights[i] = w
+	}
+	return weights, nil
+}
+
+// ClassFrequencies counts samples of each class keyed by class name (or label number if
+// classes is empty). Result can be input of ClassWeights().
+func ClassFrequencies(labels []int, classes []string) map[string]int {
+	counts := make(map[string]int)
+	for _, l := range labels {
+		key := strconv.Itoa(l)
+		if l >= 0 && l < len(classes) {
+			key = classes[l]
+		}
+		counts[key]++
+	}
+	return counts
+}
+
+// InverseFrequencyWeights returns class weights of 1/frequency.
+func InverseFrequencyWeights(freqs map[string]int) map[string]float64 {
+	weights := make(map[string]float64, len(freqs))
+	for k, n := range freqs {
+		weights[k] = 1.0 / float64(n)
+	}
+	return weights
+}
+
+// SamplerDataset iterates a dataset in the order of a sampler.
+//
+// dutil.DataLoader reads items at positions 0..n-1 and doesn't map them through its sampler
+// indexes. Wrapping a dataset in SamplerDataset makes the data loader follow the sampler. Indexes are
+// redrawn every time the data loader resets with shuffle, i.e. `loader.Reset(true)`.
+//
+// If dataset implements SeededDataset, items are loaded with ItemSeed(seed, epoch, idx) as
+// ParallelLoader does, where epoch counts redraws.
+type SamplerDataset struct {
+	dutil.Dataset
+	sampler dutil.Sampler
+	indexes []int
+	seed    int64
+	epoch   int
+}
+
+// NewSamplerDataLoader creates a data loader that iterates dataset in sampler order. Optional seed
+// (default 0) seeds items of SeededDataset.
+func NewSamplerDataLoader(data dutil.Dataset, sampler dutil.Sampler, seedOpt ...int64) (*dutil.DataLoader, error) {
+	ds := &SamplerDataset{Dataset: data, sampler: sampler, epoch: -1}
+	if len(seedOpt) > 0 {
+		ds.seed = seedOpt[0]
+	}
+	return dutil.NewDataLoader(ds, &samplerDatasetSampler{ds})
+}
+
+// Item implements dutil.Dataset interface.
+func (d *SamplerDataset) Item(idx int) (interface{}, error) {
+	if idx < 0 || idx >= len(d.indexes) {
+		err := fmt.Errorf("SamplerDataset.Item - index %d out of range [0, %d)", idx, len(d.indexes))
+		return nil, err
+	}
+	i := d.indexes[idx]
+	if seeded, ok := d.Dataset.(SeededDataset); ok {
+		return seeded.ItemSeeded(i, ItemSeed(d.seed, d.epoch, i))
+	}
+	return d.Dataset.Item(i)
+}
+
+// Len implements dutil.Dataset interface.
+func (d *SamplerDataset) Len() int {
+	return len(d.indexes)
+}
+
+// DType implements dutil.Dataset interface.
+func (d *SamplerDataset) DType() reflect.Type {
+	return d.Dataset.DType()
+}
+
+// Indexes returns current sample order.
+func (d *SamplerDataset) Indexes() []int {
+	return d.indexes
+}
+
+// samplerDatasetSampler redraws indexes of SamplerDataset and returns positions to data loader.
+type samplerDatasetSampler struct {
+	ds *SamplerDataset
+}
+
+func (s *samplerDatasetSampler) Sample() []int {
+	s.ds.indexes = s.ds.sampler.Sample()
+	s.ds.epoch++
+	positions := make([]int, len(s.ds.indexes))
+	for i := range positions {
+		positions[i] = i
+	}
+	return positions
+}
+
+func (s *samplerDatasetSampler) BatchSize() int {
+	return s.ds.sampler.BatchSize()
+}
+
+func checkBatchSize(n, batchSize int) error {
+	if batchSize < 1 || batchSize > n {
+		err := fmt.Errorf("Invalid batch size: batch size must be in range [1, %d]. Got %d", n, batchSize)
+		return err
+	}
+	return nil
+}
+
+// truncateBatches drops the last incomplete batch if dropLast is true.
+func truncateBatches(indexes []int, batchSize int, dropLast bool) []int {
+	if dropLast {
+		return indexes[:len(indexes)/batchSize*batchSize]
+	}
+	return indexes
+}
+
+// groupByLabel groups sample indexes by label in ascending label order.
+func groupByLabel(labels []int) [][]int {
+	m := make(map[int][]int)
+	for i, l := range labels {
+		m[l] = append(m[l], i)
+	}
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+
+	groups := make([][]int, len(keys))
+	for i, k := range keys {
+		groups[i] = m[k]
+	}
+	return groups
+}
@@ -0,0 +1,189 @@
+package lab
+
+import (
+	"reflect"
+	"testing"
+)
+
+// imbalancedLabels returns 90 samples of class 0, 9 of class 1 and 1 of class 2.
+func imbalancedLabels() []int {
+	labels := make([]int, 100)
+	for i := 90; i < 99; i++ {
+		labels[i] = 1
+	}
+	labels[99] = 2
+	return labels
+}
+
+func TestWeightedRandomSampler(t *testing.T) {
+	labels := imbalancedLabels()
+	classWeights := InverseFrequencyWeights(ClassFrequencies(labels, nil))
+	weights, err := ClassSampleWeights(labels, nil, classWeights)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewWeightedRandomSampler(weights, 3000, 10, true, true, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexes := s.Sample()
+	if len(indexes) != 3000 {
+		t.Fatalf("Want 3000 indexes, got %d\n", len(indexes))
+	}
+	counts := make([]int, 3)
+	for _, idx := range indexes {
+		counts[labels[idx]]++
+	}
+	for c, n := range counts {
+		if n < 800 || n > 1200 {
+			t.Errorf("Want about 1000 samples of class %d, got %d\n", c, n)
+		}
+	}
+
+	s2, _ := NewWeightedRandomSampler(weights, 3000, 10, true, true, 42)
+	if !reflect.DeepEqual(indexes, s2.Sample()) {
+		t.Errorf("Sampler is not deterministic for the same seed")
+	}
+
+	// Without replacement
+	s, err = NewWeightedRandomSampler(weights, 100, 10, false, true, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[int]bool)
+	for _, idx := range s.Sample() {
+		if seen[idx] {
+			t.Fatalf("Duplicated index %d without replacement\n", idx)
+		}
+		seen[idx] = true
+	}
+	if _, err := NewWeightedRandomSampler(weights, 101, 10, false, true, 1); err == nil {
+		t.Errorf("Want error for too many samples without replacement")
+	}
+}
+
+func TestClassBalancedSampler(t *testing.T) {
+	labels := imbalancedLabels()
+	s, err := NewClassBalancedSampler(labels, 9, 0, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexes := s.Sample()
+	if len(indexes) != 99 {
+		t.Fatalf("Want 11 batches of 9, got %d indexes\n", len(indexes))
+	}
+	for b := 0; b < len(indexes); b += 9 {
+		counts := make([]int, 3)
+		for _, idx := range indexes[b : b+9] {
+			counts[labels[idx]]++
+		}
+		if !reflect.DeepEqual(counts, []int{3, 3, 3}) {
+			t.Errorf("Want balanced batch, got class counts %v\n", counts)
+		}
+	}
+
+	if _, err := NewClassBalancedSampler(labels, 2, 0, 42); err == nil {
+		t.Errorf("Want error for batch size less than number of classes")
+	}
+}
+
+func TestStratifiedBatchSampler(t *testing.T) {
+	labels := make([]int, 100)
+	for i := 0; i < 20; i++ {
+		labels[i] = 1
+	}
+	s, err := NewStratifiedBatchSampler(labels, 10, true, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexes := s.Sample()
+	seen := make(map[int]bool)
+	for b := 0; b < len(indexes); b += 10 {
+		n := 0
+		for _, idx := range indexes[b : b+10] {
+			seen[idx] = true
+			n += labels[idx]
+		}
+		if n != 2 {
+			t.Errorf("Want 2 samples of class 1 in batch %d, got %d\n", b/10, n)
+		}
+	}
+	if len(seen) != 100 {
+		t.Errorf("Want all 100 samples, got %d\n", len(seen))
+	}
+}
+
+type intDataset []int
+
+func (d intDataset) Item(idx int) (interface{}, error) { return d[idx], nil }
+func (d intDataset) Len() int                          { return len(d) }
+func (d intDataset) DType() reflect.Type               { return reflect.TypeOf(d) }
+
+func TestSamplerDataLoader(t *testing.T) {
+	data := intDataset{10, 11, 12, 13, 14, 15}
+	sampler, err := NewShuffleSampler(data.Len(), 3, true, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loader, err := NewSamplerDataLoader(data, sampler)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	epoch := func() []int {
+		var items []int
+		for loader.HasNext() {
+			batch, err := loader.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			items = append(items, batch.([]int)...)
+		}
+		return items
+	}
+
+	want, _ := NewShuffleSampler(data.Len(), 3, true, 7)
+	for e := 0; e < 2; e++ {
+		var wantItems []int
+		for _, idx := range want.Sample() {
+			wantItems = append(wantItems, data[idx])
+		}
+		if got := epoch(); !reflect.DeepEqual(got, wantItems) {
+			t.Errorf("Epoch %d: want %v, got %v\n", e, wantItems, got)
+		}
+		loader.Reset(true)
+	}
+}
+
+func TestBuildSampler_Params(t *testing.T) {
+	data := intDataset{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	build := func(name string, params map[string]interface{}) error {
+		cfg := &Config{}
+		cfg.Train.Params.Sampler.Name = name
+		cfg.Train.Params.Sampler.Params = params
+		_, err := NewBuilder(cfg).BuildSampler(data, 4)
+		return err
+	}
+
+	if err := build("Random", map[string]interface{}{"seed": 3.0, "drop_last": false}); err != nil {
+		t.Errorf("Want no error, got %v\n", err)
+	}
+
+	invalid := []struct {
+		name   string
+		params map[string]interface{}
+	}{
+		{"Random", map[string]interface{}{"seed": "1"}},
+		{"Random", map[string]interface{}{"drop_last": "yes"}},
+		{"Stratified", map[string]interface{}{"drop_last": 1}},
+		{"WeightedRandom", map[string]interface{}{"num_samples": 10.5}},
+		{"WeightedRandom", map[string]interface{}{"replacement": "false"}},
+		{"ClassBalanced", map[string]interface{}{"num_batches": "2"}},
+	}
+	for _, tt := range invalid {
+		if err := build(tt.name, tt.params); err == nil {
+			t.Errorf("%s %v: want error\n", tt.name, tt.params)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package lab
 
 import (
+	"fmt"
+	"math"
+
 	"github.com/sugarme/gotch/nn"
 )
 
@@ -8,9 +11,117 @@ type Scheduler struct {
 	*nn.LRScheduler
 	Name string
 	Update   string // specify when to run Scheduler.Step() to update learning rate
+	WarmupSteps int // number of warmup steps before the scheduler (see WarmupLR). 0 if none.
 }
 
 func NewScheduler(scheduler *nn.LRScheduler, name string, update string) *Scheduler{
 
-	return &Scheduler{scheduler, name, update}
+	return &Scheduler{LRScheduler: scheduler, Name: name, Update: update}
+}
+
+// StepOn steps scheduler if input event matches its update event.
+// Event is one of "on_batch", "on_epoch" or "on_valid". It is safe to call on nil Scheduler.
+func (s *Scheduler) StepOn(event string, opts ...nn.SchedulerOption) {
+	if s == nil || s.LRScheduler == nil || s.Update != event {
+		return
+	}
+	s.Step(opts...)
+}
+
+// NoopLR is a scheduler that keeps learning rate unchanged.
+type NoopLR struct{}
+
+// NewNoopLR creates a new NoopLR.
+func NewNoopLR() *NoopLR {
+	return &NoopLR{}
+}
+
+// Build implements scheduler interface.
+func (n *NoopLR) Build() *nn.LRScheduler {
+	return nn.NewLRScheduler(n)
+}
+
+// SetLRs implements scheduler interface. It does nothing.
+func (n *NoopLR) SetLRs(opts ...nn.SchedulerOption) {}
+
+// WarmupLR warms up learning rate from `startFactor * baseLR` to `baseLR` over a number of steps
+// then hands over to a wrapped scheduler. baseLRs are initial learning rates of the wrapped
+// scheduler, i.e. learning rates of optimizer after the wrapped scheduler is built. Warmup steps are counted in the update unit of the
+// wrapped scheduler, i.e. batches for "on_batch" and epochs for "on_epoch" schedulers.
+type WarmupLR struct {
+	opt         *nn.Optimizer
+	scheduler   *nn.LRScheduler
+	baseLRs     []float64
+	warmupSteps int
+	mode        string  // either "linear" or "cosine"
+	startFactor float64 // default = 0.01
+	lastEpoch   int
+}
+
+// NewWarmupLR creates a new WarmupLR wrapping input scheduler. It should be created after the
+// wrapped scheduler is built as some schedulers (i.e. OneCycleLR, CyclicLR) set their initial
+// learning rates when being built.
+func NewWarmupLR(opt *nn.Optimizer, scheduler *nn.LRScheduler, warmupSteps int, mode string, startFactor float64) (*WarmupLR, error) {
+	switch mode {
+	case "linear", "cosine":
+	default:
+		err := fmt.Errorf("NewWarmupLR failed: invalid mode %q. Expected 'linear' or 'cosine'", mode)
+		return nil, err
+	}
+	if startFactor < 0 || startFactor > 1 {
+		err := fmt.Errorf("NewWarmupLR failed: expected start factor in range [0, 1], got %v", startFactor)
+		return nil, err
+	}
+
+	return &WarmupLR{
+		opt:         opt,
+		scheduler:   scheduler,
+		baseLRs:     opt.GetLRs(),
+		warmupSteps: warmupSteps,
+		mode:        mode,
+		startFactor: startFactor,
+		lastEpoch:   -1,
+	}, nil
+}
+
+// Build implements scheduler interface.
+func (w *WarmupLR) Build() *nn.LRScheduler {
+	s := nn.NewLRScheduler(w)
+	s.Step()
+	return s
+}
+
+// SetLRs implements scheduler interface by setting new LR for optimizer.
+func (w *WarmupLR) SetLRs(opts ...nn.SchedulerOption) {
+	w.lastEpoch += 1
+
+	switch {
+	case w.lastEpoch < w.warmupSteps:
+		factor := warmupFactor(w.mode, w.startFactor, w.lastEpoch, w.warmupSteps)
+		newLRs := make([]float64, len(w.baseLRs))
+		for i, baseLR := range w.baseLRs {
+			newLRs[i] = baseLR * factor
+		}
+		w.opt.SetLRs(newLRs)
+
+	case w.lastEpoch == w.warmupSteps:
+		// Warmup done. Restore initial LRs of the wrapped scheduler from which it continues.
+		w.opt.SetLRs(w.baseLRs)
+
+	default:
+		if w.scheduler != nil {
+			w.scheduler.Step(opts...)
+		}
+	}
+}
+
+// warmupFactor returns multiplicative factor of base LR at warmup step.
+func warmupFactor(mode string, startFactor float64, step, warmupSteps int) float64 {
+	r := float64(step) / float64(warmupSteps)
+	switch mode {
+	case "cosine":
+		return startFactor + (1-startFactor)*(1-math.Cos(math.Pi*r))/2
+	default: // linear
+		return startFactor + (1-startFactor)*r
+	}
 }
@@ -0,0 +1,210 @@
+package lab
+
+import (
+	"math"
+	"testing"
+
+	"github.com/sugarme/gotch"
+	"github.com/sugarme/gotch/nn"
+)
+
+func TestWarmupFactor(t *testing.T) {
+	tests := []struct {
+		mode string
+		step int
+		want float64
+	}{
+		{"linear", 0, 0.1},
+		{"linear", 5, 0.55},
+		{"linear", 10, 1.0},
+		{"cosine", 0, 0.1},
+		{"cosine", 5, 0.55},
+		{"cosine", 10, 1.0},
+	}
+
+	for _, tt := range tests {
+		got := warmupFactor(tt.mode, 0.1, tt.step, 10)
+		if math.Abs(tt.want-got) > 1e-9 {
+			t.Errorf("%s - step %d: Want %v - Got %v\n", tt.mode, tt.step, tt.want, got)
+		}
+	}
+
+	// cosine warms up slower than linear in the first half.
+	if warmupFactor("cosine", 0.1, 2, 10) >= warmupFactor("linear", 0.1, 2, 10) {
+		t.Errorf("Want cosine warmup factor less than linear at step 2\n")
+	}
+}
+
+func TestNewWarmupLR_InvalidMode(t *testing.T) {
+	_, err := NewWarmupLR(nil, nil, 10, "step", 0.1)
+	if err == nil {
+		t.Errorf("Want error for invalid warmup mode\n")
+	}
+}
+
+func TestScheduler_StepOnNil(t *testing.T) {
+	var s *Scheduler
+	// Should not panic.
+	s.StepOn("on_batch")
+}
+
+func TestWarmupLR_WrappedScheduler(t *testing.T) {
+	vs := nn.NewVarStore(gotch.CPU)
+	nn.NewLinear(vs.Root(), 4, 2, nn.DefaultLinearConfig())
+	opt, err := buildOptimizer(vs, "SGD", 0.1, map[string]interface{}{"lr": 0.1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{}
+	cfg.Scheduler.Name = "CyclicLR"
+	cfg.Scheduler.Params = map[string]interface{}{
+		"base_lr":             []interface{}{0.01},
+		"max_lr":              []interface{}{0.1},
+		"step_size_up":        2,
+		"warmup_steps":        4,
+		"warmup_start_factor": 0.5,
+	}
+	s, err := NewBuilder(cfg).BuildScheduler(opt, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "CyclicLR" || s.WarmupSteps != 4 {
+		t.Errorf("Want CyclicLR with 4 warmup steps, got %q with %d\n", s.Name, s.WarmupSteps)
+	}
+
+	// Warmup from half of CyclicLR base_lr (not optimizer lr) to base_lr, then CyclicLR takes over.
+	want := []float64{0.005, 0.00625, 0.0075, 0.00875, 0.01}
+	for i, w := range want {
+		if i > 0 {
+			s.Step()
+		}
+		if got := opt.GetLRs()[0]; math.Abs(got-w) > 1e-9 {
+			t.Errorf("Step %d: want lr %v, got %v\n", i, w, got)
+		}
+	}
+	s.Step()
+	if got := opt.GetLRs()[0]; got <= 0.01 {
+		t.Errorf("Want CyclicLR increasing lr after warmup, got %v\n", got)
+	}
+}
+
+func TestBuildScheduler_Params(t *testing.T) {
+	build := func(name string, params map[string]interface{}) (*Optimizer, *Scheduler, error) {
+		vs := nn.NewVarStore(gotch.CPU)
+		nn.NewLinear(vs.Root(), 4, 2, nn.DefaultLinearConfig())
+		opt, err := buildOptimizer(vs, "SGD", 0.1, map[string]interface{}{"lr": 0.1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := &Config{}
+		cfg.Scheduler.Name = name
+		cfg.Scheduler.Params = params
+		s, err := NewBuilder(cfg).BuildScheduler(opt, 10)
+		return opt, s, err
+	}
+
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+		want   []float64 // lr after each epoch
+	}{
+		{"LambdaLR", map[string]interface{}{"denominator": 4}, []float64{0.025, 0.05}},
+		{"MultiplicativeLR", map[string]interface{}{"factor": 0.5}, []float64{0.05, 0.025}},
+		{"MultiplicativeLR", nil, []float64{0.095, 0.09025}},
+	}
+	for _, tt := range tests {
+		opt, s, err := build(tt.name, tt.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, w := range tt.want {
+			s.Step()
+			if got := opt.GetLRs()[0]; math.Abs(got-w) > 1e-9 {
+				t.Errorf("%s %v - epoch %d: want lr %v, got %v\n", tt.name, tt.params, i+1, w, got)
+			}
+		}
+	}
+
+	// YAML integers of float params and floats of integer value.
+	valid := []struct {
+		name   string
+		params map[string]interface{}
+	}{
+		{"OneCycleLR", map[string]interface{}{"max_lr": 1, "div_factor": 25, "final_lr": 10000, "epochs": 2}},
+		{"CosineAnnealingLR", map[string]interface{}{"tmax": 10.0, "eta_min": 0}},
+		{"CosineAnnealingWarmRestarts", map[string]interface{}{"t0": 5.0}},
+		{"LambdaLR", map[string]interface{}{"denominator": 10.0}},
+	}
+	for _, tt := range valid {
+		if _, _, err := build(tt.name, tt.params); err != nil {
+			t.Errorf("%s %v: want no error, got %v\n", tt.name, tt.params, err)
+		}
+	}
+
+	invalid := []struct {
+		name   string
+		params map[string]interface{}
+	}{
+		{"CosineAnnealingLR", map[string]interface{}{"tmax": 10.5}},
+		{"OneCycleLR", map[string]interface{}{"max_lr": "0.1"}},
+		{"LambdaLR", map[string]interface{}{"denominator": 0}},
+		{"MultiplicativeLR", map[string]interface{}{"factor": 0}},
+		{"StepLR", map[string]interface{}{"update": 1}},
+		{"StepLR", map[string]interface{}{"warmup_steps": "10"}},
+	}
+	for _, tt := range invalid {
+		if _, _, err := build(tt.name, tt.params); err == nil {
+			t.Errorf("%s %v: want error\n", tt.name, tt.params)
+		}
+	}
+}
+
+func TestStepsPerEpoch(t *testing.T) {
+	data := intDataset{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	tests := []struct {
+		dropLast bool
+		want     int
+	}{
+		{true, 2},
+		{false, 3},
+	}
+	for _, tt := range tests {
+		sampler, err := NewShuffleSampler(data.Len(), 4, tt.dropLast, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loader, err := NewSamplerDataLoader(data, sampler)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := &Config{}
+		cfg.Train.BatchSize = 4
+		cfg.Train.Params.Sampler.Params = map[string]interface{}{"drop_last": tt.dropLast}
+		if got := StepsPerEpoch(cfg, loader); got != tt.want {
+			t.Errorf("drop_last %v: want %d steps, got %d\n", tt.dropLast, tt.want, got)
+		}
+	}
+
+	// Invalid drop_last falls back to default true.
+	sampler, err := NewShuffleSampler(data.Len(), 4, true, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loader, err := NewSamplerDataLoader(data, sampler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{}
+	cfg.Train.BatchSize = 4
+	cfg.Train.Params.Sampler.Params = map[string]interface{}{"drop_last": "no"}
+	if got := StepsPerEpoch(cfg, loader); got != 2 {
+		t.Errorf("Invalid drop_last: want 2 steps, got %d\n", got)
+	}
+
+	cfg = &Config{}
+	cfg.Train.Params.StepsPerEpoch = 7
+	if got := StepsPerEpoch(cfg, nil); got != 7 {
+		t.Errorf("Want configured 7 steps, got %d\n", got)
+	}
+}
@@ -0,0 +1,175 @@
+package lab
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LocalTracker writes run data to a local run directory:
+// - params.json: hyperparameters.
+// - tags.json: run name, ID and tags.
+// - metrics.jsonl: one JSON object per metric value.
+// - metrics.csv: long format `step,key,value,timestamp`.
+// - artifacts/: copies of logged artifacts.
+type LocalTracker struct {
+	Dir  string // run directory. i.e. `<root>/<run-id>`
+	Info RunInfo
+
+	mu     sync.Mutex
+	jsonl  *os.File
+	csv    *os.File
+	params map[string]interface{}
+	tags   map[string]string
+}
+
+type localMetric struct {
+	Step      int       `json:"step"`
+	Key       string    `json:"key"`
+	Value     jsonFloat `json:"value"`     // NaN and infinite values are encoded as strings
+	Timestamp int64     `json:"timestamp"` // milliseconds since epoch
+}
+
+// NewLocalTracker creates a LocalTracker writing to `<rootDir>/<run ID>`.
+func NewLocalTracker(rootDir string, info RunInfo) (*LocalTracker, error) {
+	dir := filepath.Join(rootDir, info.ID)
+	err := MakeDir(filepath.Join(dir, "artifacts"))
+	if err != nil {
+		err = fmt.Errorf("NewLocalTracker failed: %w", err)
+		return nil, err
+	}
+
+	jsonl, err := os.Create(filepath.Join(dir, "metrics.jsonl"))
+	if err != nil {
+		err = fmt.Errorf("NewLocalTracker - Create metrics file failed: %w", err)
+		return nil, err
+	}
+	csv, err := os.Create(filepath.Join(dir, "metrics.csv"))
+	if err != nil {
+		jsonl.Close()
+		err = fmt.Errorf("NewLocalTracker - Create metrics file failed: %w", err)
+		return nil, err
+	}
+	_, err = csv.WriteString("step,key,value,timestamp\n")
+	if err != nil {
+		return nil, err
+	}
+
+	t := &LocalTracker{
+		Dir:    dir,
+		Info:   info,
+		jsonl:  jsonl,
+		csv:    csv,
+		params: make(map[string]interface{}),
+		tags:   make(map[string]string),
+	}
+
+	tags := map[string]string{"run_id": info.ID, "run_name": info.Name}
+	for k, v := range info.Tags {
+		tags[k] = v
+	}
+	err = t.SetTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// LogParams implements Tracker interface.
+func (t *LocalTracker) LogParams(params map[string]interface{}) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k, v := range params {
+		t.params[k] = v
+	}
+	return writeJSON(filepath.Join(t.Dir, "params.json"), t.params)
+}
+
+// LogMetrics implements Tracker interface.
+func (t *LocalTracker) LogMetrics(metrics map[string]float64, step int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now().UnixMilli()
+	for _, k := range sortedKeys(metrics) {
+		m := localMetric{Step: step, Key: k, Value: jsonFloat(metrics[k]), Timestamp: now}
+		line, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		if _, err := t.jsonl.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(t.csv, "%d,%s,%v,%d\n", m.Step, m.Key, m.Value, m.Timestamp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LogArtifact implements Tracker interface.
+func (t *LocalTracker) LogArtifact(filePath string) error {
+	dst := filepath.Join(t.Dir, "artifacts", filepath.Base(filePath))
+	err := copyFile(filePath, dst)
+	if err != nil {
+		err = fmt.Errorf("LocalTracker - Log artifact %q failed: %w", filePath, err)
+		return err
+	}
+	return nil
+}
+
+// SetTags implements Tracker interface.
+func (t *LocalTracker) SetTags(tags map[string]string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k, v := range tags {
+		t.tags[k] = v
+	}
+	return writeJSON(filepath.Join(t.Dir, "tags.json"), t.tags)
+}
+
+// Close implements Tracker interface.
+func (t *LocalTracker) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	err1 := t.jsonl.Close()
+	err2 := t.csv.Close()
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+// LoadLocalMetrics loads metrics of a local run directory. It returns map of metric key
+// to values ordered by logging time.
+func LoadLocalMetrics(runDir string) (map[string][]float64, error) {
+	buf, err := os.ReadFile(filepath.Join(runDir, "metrics.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make(map[string][]float64)
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	for dec.More() {
+		var m localMetric
+		if err := dec.Decode(&m); err != nil {
+			err = fmt.Errorf("LoadLocalMetrics failed: %w", err)
+			return nil, err
+		}
+		metrics[m.Key] = append(metrics[m.Key], float64(m.Value))
+	}
+
+	return metrics, nil
+}
+
+func writeJSON(filePath string, v interface{}) error {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, buf, 0644)
+}
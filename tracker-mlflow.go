@@ -0,0 +1,364 @@
+package lab
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MLflowTracker logs runs to an MLflow tracking server using REST API 2.0.
+//
+// Metrics are buffered and sent in batches of `BatchSize` (default = 100) with
+// `runs/log-batch` by a background goroutine so that a slow tracking server never
+// blocks training. An error of a background send is returned by the next LogMetrics
+// or Close call. Pending metrics are sent on Close() which also marks run as FINISHED.
+type MLflowTracker struct {
+	URI          string // tracking server URI. i.e. "http://localhost:5000"
+	ExperimentID string
+	RunID        string
+	Info         RunInfo
+	BatchSize    int
+
+	mu      sync.Mutex // guards pending, err and closed
+	sendMu  sync.Mutex // serializes sending of metric batches
+	client  *http.Client
+	pending []mlflowMetric
+	err     error // error of background flush not yet returned
+	closed  bool
+	flushc  chan struct{}
+	done    chan struct{}
+}
+
+type mlflowMetric struct {
+	Key       string      `json:"key"`
+	Value     mlflowFloat `json:"value"`
+	Timestamp int64       `json:"timestamp"`
+	Step      int         `json:"step"`
+}
+
+// mlflowFloat is a metric value encoded as the tracking server's protobuf JSON, i.e.
+// NaN and infinite values as strings "NaN", "Infinity" and "-Infinity".
+type mlflowFloat float64
+
+// MarshalJSON implements json.Marshaler interface.
+func (f mlflowFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	switch {
+	case math.IsNaN(v):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(v, 1):
+		return []byte(`"Infinity"`), nil
+	case math.IsInf(v, -1):
+		return []byte(`"-Infinity"`), nil
+	}
+	return json.Marshal(v)
+}
+
+type mlflowParam struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type mlflowTag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// NewMLflowTracker creates a run in `experiment` of an MLflow tracking server.
+// Experiment is created if not existing.
+func NewMLflowTracker(uri, experiment string, info RunInfo) (*MLflowTracker, error) {
+	t := &MLflowTracker{
+		URI:       strings.TrimRight(uri, "/"),
+		Info:      info,
+		BatchSize: 100,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		pending:   make([]mlflowMetric, 0),
+		flushc:    make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+
+	expID, err := t.getOrCreateExperiment(experiment)
+	if err != nil {
+		err = fmt.Errorf("NewMLflowTracker failed: %w", err)
+		return nil, err
+	}
+	t.ExperimentID = expID
+
+	tags := []mlflowTag{{Key: "lab.run_id", Value: info.ID}}
+	for _, k := range sortedTagKeys(info.Tags) {
+		tags = append(tags, mlflowTag{Key: k, Value: info.Tags[k]})
+	}
+	req := map[string]interface{}{
+		"experiment_id": expID,
+		"run_name":      info.Name,
+		"start_time":    time.Now().UnixMilli(),
+		"tags":          tags,
+	}
+	var resp struct {
+		Run struct {
+			Info struct {
+				RunID string `json:"run_id"`
+			} `json:"info"`
+		} `json:"run"`
+	}
+	err = t.post("runs/create", req, &resp)
+	if err != nil {
+		err = fmt.Errorf("NewMLflowTracker - Create run failed: %w", err)
+		return nil, err
+	}
+	t.RunID = resp.Run.Info.RunID
+	go t.run()
+
+	return t, nil
+}
+
+// run sends pending metrics whenever LogMetrics signals a full batch.
+func (t *MLflowTracker) run() {
+	defer close(t.done)
+
+	for range t.flushc {
+		if err := t.Flush(); err != nil {
+			t.mu.Lock()
+			t.err = err
+			t.mu.Unlock()
+		}
+	}
+}
+
+func (t *MLflowTracker) getOrCreateExperiment(name string) (string, error) {
+	var getResp struct {
+		Experiment struct {
+			ExperimentID string `json:"experiment_id"`
+		} `json:"experiment"`
+	}
+	q := url.Values{"experiment_name": []string{name}}
+	err := t.get("experiments/get-by-name?"+q.Encode(), &getResp)
+	if err == nil && getResp.Experiment.ExperimentID != "" {
+		return getResp.Experiment.ExperimentID, nil
+	}
+
+	var createResp struct {
+		ExperimentID string `json:"experiment_id"`
+	}
+	err = t.post("experiments/create", map[string]interface{}{"name": name}, &createResp)
+	if err != nil {
+		return "", err
+	}
+	return createResp.ExperimentID, nil
+}
+
+// LogParams implements Tracker interface.
+func (t *MLflowTracker) LogParams(params map[string]interface{}) error {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ps := make([]mlflowParam, 0, len(keys))
+	for _, k := range keys {
+		ps = append(ps, mlflowParam{Key: k, Value: fmt.Sprint(params[k])})
+	}
+
+	// MLflow limits number of params per batch to 100.
+	for start := 0; start < len(ps); start += 100 {
+		end := start + 100
+		if end > len(ps) {
+			end = len(ps)
+		}
+		req := map[string]interface{}{"run_id": t.RunID, "params": ps[start:end]}
+		if err := t.post("runs/log-batch", req, nil); err != nil {
+			err = fmt.Errorf("MLflowTracker - Log params failed: %w", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// LogMetrics implements Tracker interface. It never waits for the tracking server.
+func (t *MLflowTracker) LogMetrics(metrics map[string]float64, step int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	for _, k := range sortedKeys(metrics) {
+		t.pending = append(t.pending, mlflowMetric{Key: k, Value: mlflowFloat(metrics[k]), Timestamp: now, Step: step})
+	}
+	if len(t.pending) >= t.BatchSize && !t.closed {
+		select {
+		case t.flushc <- struct{}{}:
+		default: // flush already signaled.
+		}
+	}
+
+	err := t.err
+	t.err = nil
+	return err
+}
+
+// Flush sends pending metrics to tracking server.
+func (t *MLflowTracker) Flush() error {
+	t.sendMu.Lock()
+	defer t.sendMu.Unlock()
+
+	t.mu.Lock()
+	batch := t.pending
+	t.pending = make([]mlflowMetric, 0)
+	t.mu.Unlock()
+
+	for len(batch) > 0 {
+		n := len(batch)
+		if n > 1000 { // MLflow limit of metrics per batch.
+			n = 1000
+		}
+		req := map[string]interface{}{"run_id": t.RunID, "metrics": batch[:n]}
+		err := t.post("runs/log-batch", req, nil)
+		// Drop the batch even if rejected so that it doesn't fail every later flush.
+		batch = batch[n:]
+		if err != nil {
+			t.mu.Lock()
+			t.pending = append(batch, t.pending...)
+			t.mu.Unlock()
+			err = fmt.Errorf("MLflowTracker - Log metrics failed: %w", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// LogArtifact implements Tracker interface. It uploads file via the tracking server's
+// artifact proxy (`mlflow server --serve-artifacts`).
+func (t *MLflowTracker) LogArtifact(filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		err = fmt.Errorf("MLflowTracker - Log artifact failed: %w", err)
+		return err
+	}
+	defer f.Close()
+
+	endpoint := fmt.Sprintf("%s/api/2.0/mlflow-artifacts/artifacts/%s/%s/artifacts/%s",
+		t.URI, t.ExperimentID, t.RunID, url.PathEscape(filepath.Base(filePath)))
+	req, err := http.NewRequest(http.MethodPut, endpoint, f)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		err = fmt.Errorf("MLflowTracker - Log artifact failed: %w", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	return checkMLflowResponse(resp)
+}
+
+// SetTags implements Tracker interface.
+func (t *MLflowTracker) SetTags(tags map[string]string) error {
+	mtags := make([]mlflowTag, 0, len(tags))
+	for _, k := range sortedTagKeys(tags) {
+		mtags = append(mtags, mlflowTag{Key: k, Value: tags[k]})
+	}
+	req := map[string]interface{}{"run_id": t.RunID, "tags": mtags}
+	if err := t.post("runs/log-batch", req, nil); err != nil {
+		err = fmt.Errorf("MLflowTracker - Set tags failed: %w", err)
+		return err
+	}
+	return nil
+}
+
+// Close implements Tracker interface. It stops background sending, flushes pending metrics
+// and marks run as FINISHED, also if flushing failed.
+func (t *MLflowTracker) Close() error {
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.flushc)
+	}
+	t.mu.Unlock()
+	<-t.done
+
+	flushErr := t.Flush()
+	t.mu.Lock()
+	if flushErr == nil {
+		flushErr = t.err
+	}
+	t.err = nil
+	t.mu.Unlock()
+
+	req := map[string]interface{}{
+		"run_id":   t.RunID,
+		"status":   "FINISHED",
+		"end_time": time.Now().UnixMilli(),
+	}
+	if err := t.post("runs/update", req, nil); err != nil {
+		err = fmt.Errorf("MLflowTracker - Update run failed: %w", err)
+		return err
+	}
+	return flushErr
+}
+
+func (t *MLflowTracker) get(endpoint string, out interface{}) error {
+	resp, err := t.client.Get(t.URI + "/" + path.Join("api/2.0/mlflow", endpoint))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := checkMLflowResponse(resp); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (t *MLflowTracker) post(endpoint string, body, out interface{}) error {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := t.client.Post(t.URI+"/"+path.Join("api/2.0/mlflow", endpoint), "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := checkMLflowResponse(resp); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func checkMLflowResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s %s: status %d: %s", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, strings.TrimSpace(string(msg)))
+}
+
+func sortedTagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,221 @@
+package lab
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// TensorBoardTracker writes scalar metrics to a TensorBoard event file
+// `<logDir>/<run ID>/events.out.tfevents.<timestamp>.<hostname>`.
+//
+// Event files are TFRecord files of serialized `tensorflow.Event` protobuf messages.
+// Hyperparameters, tags and artifacts are stored in `meta` sub-directory
+// in the same layout as LocalTracker.
+type TensorBoardTracker struct {
+	Dir  string
+	Info RunInfo
+
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	local  *LocalTracker // for params, tags and artifacts
+}
+
+// NewTensorBoardTracker creates a new TensorBoardTracker.
+func NewTensorBoardTracker(logDir string, info RunInfo) (*TensorBoardTracker, error) {
+	dir := filepath.Join(logDir, info.ID)
+	err := MakeDir(dir)
+	if err != nil {
+		err = fmt.Errorf("NewTensorBoardTracker failed: %w", err)
+		return nil, err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	now := time.Now()
+	fileName := fmt.Sprintf("events.out.tfevents.%d.%s", now.Unix(), hostname)
+	f, err := os.Create(filepath.Join(dir, fileName))
+	if err != nil {
+		err = fmt.Errorf("NewTensorBoardTracker - Create event file failed: %w", err)
+		return nil, err
+	}
+
+	t := &TensorBoardTracker{
+		Dir:    dir,
+		Info:   info,
+		file:   f,
+		writer: bufio.NewWriter(f),
+	}
+
+	// First event of file is file version.
+	err = t.writeRecord(encodeEvent(wallTime(now), 0, "brain.Event:2", nil))
+	if err != nil {
+		return nil, err
+	}
+
+	// Non-metric data is stored the same way as LocalTracker does
+	// in a sub-directory ignored by TensorBoard.
+	local, err := NewLocalTracker(dir, RunInfo{ID: "meta", Name: info.Name, Tags: info.Tags})
+	if err != nil {
+		return nil, err
+	}
+	t.local = local
+
+	return t, nil
+}
+
+// LogParams implements Tracker interface.
+func (t *TensorBoardTracker) LogParams(params map[string]interface{}) error {
+	return t.local.LogParams(params)
+}
+
+// LogMetrics implements Tracker interface.
+func (t *TensorBoardTracker) LogMetrics(metrics map[string]float64, step int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	keys := sortedKeys(metrics)
+	values := make([]tbValue, 0, len(keys))
+	for _, k := range keys {
+		values = append(values, tbValue{Tag: k, Value: float32(metrics[k])})
+	}
+
+	return t.writeRecord(encodeEvent(wallTime(time.Now()), int64(step), "", values))
+}
+
+// LogArtifact implements Tracker interface.
+func (t *TensorBoardTracker) LogArtifact(filePath string) error {
+	return t.local.LogArtifact(filePath)
+}
+
+// SetTags implements Tracker interface.
+func (t *TensorBoardTracker) SetTags(tags map[string]string) error {
+	return t.local.SetTags(tags)
+}
+
+// Flush writes buffered events to event file.
+func (t *TensorBoardTracker) Flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.writer.Flush()
+}
+
+// Close implements Tracker interface.
+func (t *TensorBoardTracker) Close() error {
+	if err := t.Flush(); err != nil {
+		return err
+	}
+	if err := t.file.Close(); err != nil {
+		return err
+	}
+	return t.local.Close()
+}
+
+// writeRecord writes data as a TFRecord:
+// uint64 length, uint32 masked crc of length, data, uint32 masked crc of data.
+func (t *TensorBoardTracker) writeRecord(data []byte) error {
+	header := make([]byte, 12)
+	binary.LittleEndian.PutUint64(header[0:8], uint64(len(data)))
+	binary.LittleEndian.PutUint32(header[8:12], maskedCRC(header[0:8]))
+	footer := make([]byte, 4)
+	binary.LittleEndian.PutUint32(footer, maskedCRC(data))
+
+	for _, b := range [][]byte{header, data, footer} {
+		if _, err := t.writer.Write(b); err != nil {
+			err = fmt.Errorf("TensorBoardTracker - Write record failed: %w", err)
+			return err
+		}
+	}
+	return nil
+}
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// maskedCRC returns masked CRC32-C checksum as used by TFRecord.
+func maskedCRC(data []byte) uint32 {
+	crc := crc32.Checksum(data, crc32c)
+	return ((crc >> 15) | (crc << 17)) + 0xa282ead8
+}
+
+func wallTime(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+// tbValue is a scalar summary value.
+type tbValue struct {
+	Tag   string
+	Value float32
+}
+
+// encodeEvent encodes a `tensorflow.Event` protobuf message:
+//
+//	message Event {
+//	  double wall_time = 1;
+//	  int64 step = 2;
+//	  oneof what {
+//	    string file_version = 3;
+//	    Summary summary = 5;
+//	  }
+//	}
+//
+//	message Summary { repeated Value value = 1; }
+//	message Value { string tag = 1; float simple_value = 2; }
+func encodeEvent(wallTime float64, step int64, fileVersion string, values []tbValue) []byte {
+	var buf []byte
+	buf = append(buf, 0x09) // field 1, fixed64
+	buf = appendFixed64(buf, math.Float64bits(wallTime))
+	if step != 0 {
+		buf = append(buf, 0x10) // field 2, varint
+		buf = appendUvarint(buf, uint64(step))
+	}
+	if fileVersion != "" {
+		buf = appendBytesField(buf, 3, []byte(fileVersion))
+	}
+	if len(values) > 0 {
+		var summary []byte
+		for _, v := range values {
+			var value []byte
+			value = appendBytesField(value, 1, []byte(v.Tag))
+			value = append(value, 0x15) // field 2, fixed32
+			value = appendFixed32(value, math.Float32bits(v.Value))
+			summary = appendBytesField(summary, 1, value)
+		}
+		buf = appendBytesField(buf, 5, summary)
+	}
+
+	return buf
+}
+
+// appendBytesField appends a length-delimited protobuf field.
+func appendBytesField(buf []byte, field int, data []byte) []byte {
+	buf = appendUvarint(buf, uint64(field<<3|2))
+	buf = appendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(tmp, v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendFixed64(buf []byte, v uint64) []byte {
+	tmp := make([]byte, 8)
+	binary.LittleEndian.PutUint64(tmp, v)
+	return append(buf, tmp...)
+}
+
+func appendFixed32(buf []byte, v uint32) []byte {
+	tmp := make([]byte, 4)
+	binary.LittleEndian.PutUint32(tmp, v)
+	return append(buf, tmp...)
+}
@@ -0,0 +1,108 @@
+package lab
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Tracker is an interface of experiment tracking backend.
+// Metrics, hyperparameters and artifacts of a training run are sent to
+// all trackers attached to Logger.
+type Tracker interface {
+	// LogParams logs hyperparameters of the run.
+	LogParams(params map[string]interface{}) error
+	// LogMetrics logs metrics at a step.
+	LogMetrics(metrics map[string]float64, step int) error
+	// LogArtifact logs a local file as artifact of the run.
+	LogArtifact(filePath string) error
+	// SetTags sets tags of the run.
+	SetTags(tags map[string]string) error
+	// Close flushes pending data and ends the run.
+	Close() error
+}
+
+// RunInfo identifies a tracking run.
+type RunInfo struct {
+	ID   string
+	Name string
+	Tags map[string]string
+}
+
+// NewRunID creates a unique run ID of format `20060102-150405-<random hex>`.
+func NewRunID() string {
+	buf := make([]byte, 4)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return time.Now().Format("20060102-150405.000000")
+	}
+
+	return fmt.Sprintf("%s-%s", time.Now().Format("20060102-150405"), hex.EncodeToString(buf))
+}
+
+// FlattenParams flattens nested params into a map of dot-separated keys to values.
+// i.e. {"optimizer": {"lr": 0.1}} => {"optimizer.lr": 0.1}
+func FlattenParams(params map[string]interface{}) map[string]interface{} {
+	flat := make(map[string]interface{})
+	flattenParams("", params, flat)
+	return flat
+}
+
+func flattenParams(prefix string, v interface{}, flat map[string]interface{}) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, x := range val {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			flattenParams(key, x, flat)
+		}
+	default:
+		flat[prefix] = v
+	}
+}
+
+// ConfigParams returns hyperparameters of training configuration for tracking.
+func ConfigParams(cfg *Config) map[string]interface{} {
+	params := map[string]interface{}{
+		"seed":                    cfg.Seed,
+		"model.name":              cfg.Model.Name,
+		"model.backbone":          cfg.Model.Params.Backbone,
+		"model.pretrained":        cfg.Model.Params.Pretrained,
+		"model.num_classes":       cfg.Model.Params.NumClasses,
+		"model.dropout":           cfg.Model.Params.Dropout,
+		"model.pooling":           cfg.Model.Params.Pooling,
+		"train.batch_size":        cfg.Train.BatchSize,
+		"train.num_epochs":        cfg.Train.Params.Epochs,
+		"train.start_epoch":       cfg.Train.StartEpoch,
+		"evaluation.batch_size":   cfg.Evaluation.BatchSize,
+		"evaluation.valid_metric": cfg.Evaluation.Params.ValidMetric,
+		"loss.name":               cfg.Loss.Name,
+		"optimizer.name":          cfg.Optimizer.Name,
+		"scheduler.name":          cfg.Scheduler.Name,
+	}
+	for k, v := range FlattenParams(cfg.Optimizer.Params) {
+		params["optimizer."+k] = v
+	}
+	for k, v := range FlattenParams(cfg.Scheduler.Params) {
+		params["scheduler."+k] = v
+	}
+	for k, v := range FlattenParams(cfg.Loss.Params) {
+		params["loss."+k] = v
+	}
+
+	return params
+}
+
+// sortedKeys returns sorted keys of a metric map.
+func sortedKeys(metrics map[string]float64) []string {
+	keys := make([]string, 0, len(metrics))
+	for k := range metrics {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
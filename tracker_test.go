@@ -0,0 +1,284 @@
+package lab
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLocalTracker(t *testing.T) {
+	root := t.TempDir()
+	info := RunInfo{ID: "run-1", Name: "baseline", Tags: map[string]string{"dataset": "skin"}}
+	tr, err := NewLocalTracker(root, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tr.LogParams(map[string]interface{}{"optimizer.lr": 0.001}); err != nil {
+		t.Fatal(err)
+	}
+	for step, loss := range []float64{1.5, 1.2, 0.9} {
+		if err := tr.LogMetrics(map[string]float64{"train/loss": loss}, step); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tr.LogMetrics(map[string]float64{"train/grad_norm": math.NaN()}, 3); err != nil {
+		t.Fatalf("Want NaN metric logged, got %v\n", err)
+	}
+	artifact := filepath.Join(root, "notes.txt")
+	if err := os.WriteFile(artifact, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.LogArtifact(artifact); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	runDir := filepath.Join(root, "run-1")
+	metrics, err := LoadLocalMetrics(runDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{1.5, 1.2, 0.9}; !reflect.DeepEqual(metrics["train/loss"], want) {
+		t.Errorf("Want %v, got %v\n", want, metrics["train/loss"])
+	}
+	if gn := metrics["train/grad_norm"]; len(gn) != 1 || !math.IsNaN(gn[0]) {
+		t.Errorf("Want NaN grad norm loaded, got %v\n", gn)
+	}
+
+	var tags map[string]string
+	buf, err := os.ReadFile(filepath.Join(runDir, "tags.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buf, &tags); err != nil {
+		t.Fatal(err)
+	}
+	if tags["run_name"] != "baseline" || tags["dataset"] != "skin" {
+		t.Errorf("Unexpected tags: %v\n", tags)
+	}
+
+	csv, err := os.ReadFile(filepath.Join(runDir, "metrics.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(csv)), "\n"); len(lines) != 5 {
+		t.Errorf("Want 5 csv lines, got %d\n", len(lines))
+	}
+
+	got, err := os.ReadFile(filepath.Join(runDir, "artifacts", "notes.txt"))
+	if err != nil || string(got) != "hello" {
+		t.Errorf("Artifact not copied: %q, %v\n", got, err)
+	}
+}
+
+func TestTensorBoardTracker(t *testing.T) {
+	root := t.TempDir()
+	tr, err := NewTensorBoardTracker(root, RunInfo{ID: "run-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.LogMetrics(map[string]float64{"train/loss": 0.5}, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(root, "run-1", "events.out.tfevents.*"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Want 1 event file, got %v (%v)\n", files, err)
+	}
+	buf, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Read back records and verify checksums.
+	var records [][]byte
+	for len(buf) > 0 {
+		if len(buf) < 12 {
+			t.Fatalf("Truncated record header")
+		}
+		n := binary.LittleEndian.Uint64(buf[0:8])
+		if got := binary.LittleEndian.Uint32(buf[8:12]); got != maskedCRC(buf[0:8]) {
+			t.Fatalf("Invalid length crc")
+		}
+		data := buf[12 : 12+n]
+		if got := binary.LittleEndian.Uint32(buf[12+n : 16+n]); got != maskedCRC(data) {
+			t.Fatalf("Invalid data crc")
+		}
+		records = append(records, data)
+		buf = buf[16+n:]
+	}
+	if len(records) != 2 {
+		t.Fatalf("Want 2 records, got %d\n", len(records))
+	}
+	if !strings.Contains(string(records[0]), "brain.Event:2") {
+		t.Errorf("First record is not file version event")
+	}
+
+	// Event: wall_time(9 bytes), step(0x10, 10), summary(0x2a, len, value...)
+	ev := records[1]
+	if ev[9] != 0x10 || ev[10] != 10 || ev[11] != 0x2a {
+		t.Fatalf("Unexpected event encoding: %x\n", ev)
+	}
+	if !strings.Contains(string(ev), "train/loss") {
+		t.Errorf("Missing metric tag")
+	}
+	value := math.Float32frombits(binary.LittleEndian.Uint32(ev[len(ev)-4:]))
+	if value != 0.5 {
+		t.Errorf("Want 0.5, got %v\n", value)
+	}
+}
+
+func TestMLflowTracker(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests = make(map[string][]map[string]interface{})
+		uploaded string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/api/2.0/mlflow/experiments/get-by-name":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error_code":"RESOURCE_DOES_NOT_EXIST"}`))
+			return
+		case strings.HasPrefix(r.URL.Path, "/api/2.0/mlflow-artifacts/artifacts/"):
+			buf, _ := io.ReadAll(r.Body)
+			uploaded = r.URL.Path + ":" + string(buf)
+			w.Write([]byte(`{}`))
+			return
+		}
+
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		endpoint := strings.TrimPrefix(r.URL.Path, "/api/2.0/mlflow/")
+		requests[endpoint] = append(requests[endpoint], body)
+
+		switch endpoint {
+		case "experiments/create":
+			w.Write([]byte(`{"experiment_id":"7"}`))
+		case "runs/create":
+			w.Write([]byte(`{"run":{"info":{"run_id":"abc"}}}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+
+	tr, err := NewMLflowTracker(srv.URL, "skin", RunInfo{ID: "run-1", Name: "baseline"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.ExperimentID != "7" || tr.RunID != "abc" {
+		t.Fatalf("Unexpected experiment/run ID: %q/%q\n", tr.ExperimentID, tr.RunID)
+	}
+	tr.BatchSize = 2
+
+	if err := tr.LogParams(map[string]interface{}{"lr": 0.1}); err != nil {
+		t.Fatal(err)
+	}
+	for step, loss := range []float64{0, math.NaN(), math.Inf(1)} {
+		if err := tr.LogMetrics(map[string]float64{"loss": loss}, step); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	artifact := filepath.Join(t.TempDir(), "steps.csv")
+	if err := os.WriteFile(artifact, []byte("a,b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.LogArtifact(artifact); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// params batch + metrics batch of 2 (flushed at batch size) + metrics batch of 1 (flushed on close)
+	var values []interface{}
+	for _, b := range requests["runs/log-batch"] {
+		if ms, ok := b["metrics"].([]interface{}); ok {
+			for _, m := range ms {
+				values = append(values, m.(map[string]interface{})["value"])
+			}
+		}
+	}
+	if want := []interface{}{0.0, "NaN", "Infinity"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("Want metric values %v logged, got %v\n", want, values)
+	}
+	if len(requests["runs/update"]) != 1 || requests["runs/update"][0]["status"] != "FINISHED" {
+		t.Errorf("Run was not finished: %v\n", requests["runs/update"])
+	}
+	if want := "/api/2.0/mlflow-artifacts/artifacts/7/abc/artifacts/steps.csv:a,b"; uploaded != want {
+		t.Errorf("Want uploaded %q, got %q\n", want, uploaded)
+	}
+}
+
+func TestMLflowTracker_RejectedBatch(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		batches int
+		status  string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		switch strings.TrimPrefix(r.URL.Path, "/api/2.0/mlflow/") {
+		case "experiments/get-by-name":
+			w.Write([]byte(`{"experiment":{"experiment_id":"7"}}`))
+		case "runs/create":
+			w.Write([]byte(`{"run":{"info":{"run_id":"abc"}}}`))
+		case "runs/log-batch":
+			batches++
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error_code":"INVALID_PARAMETER_VALUE"}`))
+		case "runs/update":
+			status, _ = body["status"].(string)
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+
+	tr, err := NewMLflowTracker(srv.URL, "skin", RunInfo{ID: "run-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.BatchSize = 1
+	if err := tr.LogMetrics(map[string]float64{"loss": 1}, 0); err != nil {
+		t.Errorf("Want batch sent in background, got %v\n", err)
+	}
+	if err := tr.Close(); err == nil {
+		t.Errorf("Want error of rejected background batch on close\n")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if batches != 1 {
+		t.Errorf("Want rejected batch sent once, got %d\n", batches)
+	}
+	if status != "FINISHED" {
+		t.Errorf("Want run FINISHED, got %q\n", status)
+	}
+}
@@ -0,0 +1,298 @@
+package lab
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// StepRecord holds metrics of a training step.
+type StepRecord struct {
+	Epoch      int       `json:"epoch"`
+	Step       int       `json:"step"`
+	Loss       float64   `json:"loss"`
+	LRs        []float64 `json:"lrs"`        // learning rate of each param group used at this step
+	GradNorm   float64   `json:"grad_norm"`  // total L2 norm of gradients
+	BatchSize  int       `json:"batch_size"` // number of samples
+	DataTime   float64   `json:"data_time"`  // data loading time in seconds
+	StepTime   float64   `json:"step_time"`  // compute (forward, backward, update) time in seconds
+	Throughput float64   `json:"throughput"` // samples per second
+}
+
+// Metrics returns step record as tracking metrics with "train/" prefix.
+func (r StepRecord) Metrics() map[string]float64 {
+	metrics := map[string]float64{
+		"train/loss":       r.Loss,
+		"train/grad_norm":  r.GradNorm,
+		"train/throughput": r.Throughput,
+	}
+	for i, lr := range r.LRs {
+		metrics[fmt.Sprintf("train/lr_%d", i)] = lr
+	}
+	return metrics
+}
+
+// stepRecordJSON is JSON encoding of StepRecord.
+type stepRecordJSON struct {
+	Epoch      int         `json:"epoch"`
+	Step       int         `json:"step"`
+	Loss       jsonFloat   `json:"loss"`
+	LRs        []jsonFloat `json:"lrs"`
+	GradNorm   jsonFloat   `json:"grad_norm"`
+	BatchSize  int         `json:"batch_size"`
+	DataTime   jsonFloat   `json:"data_time"`
+	StepTime   jsonFloat   `json:"step_time"`
+	Throughput jsonFloat   `json:"throughput"`
+}
+
+// MarshalJSON implements json.Marshaler interface. Non-finite values, e.g. NaN loss or
+// infinite gradient norm, are encoded as strings "NaN", "+Inf" and "-Inf".
+func (r StepRecord) MarshalJSON() ([]byte, error) {
+	lrs := make([]jsonFloat, len(r.LRs))
+	for i, lr := range r.LRs {
+		lrs[i] = jsonFloat(lr)
+	}
+	return json.Marshal(stepRecordJSON{
+		Epoch:      r.Epoch,
+		Step:       r.Step,
+		Loss:       jsonFloat(r.Loss),
+		LRs:        lrs,
+		GradNorm:   jsonFloat(r.GradNorm),
+		BatchSize:  r.BatchSize,
+		DataTime:   jsonFloat(r.DataTime),
+		StepTime:   jsonFloat(r.StepTime),
+		Throughput: jsonFloat(r.Throughput),
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface.
+func (r *StepRecord) UnmarshalJSON(data []byte) error {
+	var v stepRecordJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	var lrs []float64
+	if v.LRs != nil {
+		lrs = make([]float64, len(v.LRs))
+		for i, lr := range v.LRs {
+			lrs[i] = float64(lr)
+		}
+	}
+	*r = StepRecord{
+		Epoch:      v.Epoch,
+		Step:       v.Step,
+		Loss:       float64(v.Loss),
+		LRs:        lrs,
+		GradNorm:   float64(v.GradNorm),
+		BatchSize:  v.BatchSize,
+		DataTime:   float64(v.DataTime),
+		StepTime:   float64(v.StepTime),
+		Throughput: float64(v.Throughput),
+	}
+	return nil
+}
+
+// jsonFloat is a float64 encoded as JSON number, or as string if not finite as JSON numbers
+// can't be NaN or infinite.
+type jsonFloat float64
+
+// MarshalJSON implements json.Marshaler interface.
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return []byte(strconv.Quote(strconv.FormatFloat(v, 'g', -1, 64))), nil
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface.
+func (f *jsonFloat) UnmarshalJSON(data []byte) error {
+	var v float64
+	if len(data) > 0 && data[0] == '"' {
+		s, err := strconv.Unquote(string(data))
+		if err != nil {
+			return err
+		}
+		v, err = strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+	} else if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*f = jsonFloat(v)
+	return nil
+}
+
+// StepLogger keeps track of per-step training metrics and streams them
+// to a JSON lines file if specified.
+type StepLogger struct {
+	Records []StepRecord
+	file    *os.File
+	writer  *bufio.Writer
+}
+
+// NewStepLogger creates a StepLogger. If filePath is empty, records are only kept in memory.
+func NewStepLogger(filePath string) (*StepLogger, error) {
+	sl := &StepLogger{
+		Records: make([]StepRecord, 0),
+	}
+	if filePath == "" {
+		return sl, nil
+	}
+
+	f, err := os.Create(filePath)
+	if err != nil {
+		err = fmt.Errorf("NewStepLogger - Create step log file failed: %w\n", err)
+		return nil, err
+	}
+	sl.file = f
+	sl.writer = bufio.NewWriter(f)
+
+	return sl, nil
+}
+
+// Log adds a step record and writes it to log file if any.
+func (sl *StepLogger) Log(r StepRecord) error {
+	sl.Records = append(sl.Records, r)
+	if sl.writer == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = sl.writer.Write(append(line, '\n'))
+	return err
+}
+
+// Flush writes buffered records to log file.
+func (sl *StepLogger) Flush() error {
+	if sl.writer == nil {
+		return nil
+	}
+	return sl.writer.Flush()
+}
+
+// Close flushes buffered records and closes log file.
+func (sl *StepLogger) Close() error {
+	if sl.file == nil {
+		return nil
+	}
+	err := sl.Flush()
+	if err != nil {
+		return err
+	}
+	err = sl.file.Close()
+	sl.file = nil
+	sl.writer = nil
+
+	return err
+}
+
+// Last returns the latest step record.
+func (sl *StepLogger) Last() (StepRecord, bool) {
+	if len(sl.Records) == 0 {
+		return StepRecord{}, false
+	}
+	return sl.Records[len(sl.Records)-1], true
+}
+
+// LRHistory returns learning rates of a param group over steps.
+func (sl *StepLogger) LRHistory(group int) []float64 {
+	lrs := make([]float64, 0, len(sl.Records))
+	for _, r := range sl.Records {
+		if group < len(r.LRs) {
+			lrs = append(lrs, r.LRs[group])
+		}
+	}
+	return lrs
+}
+
+// SaveCSV saves step records to a CSV file with one learning rate column per param group.
+func (sl *StepLogger) SaveCSV(filePath string) error {
+	return SaveStepRecordsToCSV(sl.Records, filePath)
+}
+
+// SaveStepRecordsToCSV saves step records to a CSV file.
+func SaveStepRecordsToCSV(records []StepRecord, filePath string) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		err := fmt.Errorf("Create step log csv file failed: %w\n", err)
+		return err
+	}
+	defer file.Close()
+
+	ngroups := 0
+	for _, r := range records {
+		if len(r.LRs) > ngroups {
+			ngroups = len(r.LRs)
+		}
+	}
+
+	headers := []string{"epoch", "step", "loss"}
+	for i := 0; i < ngroups; i++ {
+		headers = append(headers, fmt.Sprintf("lr_%d", i))
+	}
+	headers = append(headers, "grad_norm", "batch_size", "data_time", "step_time", "throughput")
+	_, err = file.WriteString(strings.Join(headers, ",") + "\n")
+	if err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		fields := []string{fmt.Sprint(r.Epoch), fmt.Sprint(r.Step), fmt.Sprint(r.Loss)}
+		for i := 0; i < ngroups; i++ {
+			if i < len(r.LRs) {
+				fields = append(fields, fmt.Sprint(r.LRs[i]))
+			} else {
+				fields = append(fields, "")
+			}
+		}
+		fields = append(fields, fmt.Sprint(r.GradNorm), fmt.Sprint(r.BatchSize), fmt.Sprint(r.DataTime), fmt.Sprint(r.StepTime), fmt.Sprint(r.Throughput))
+		_, err := file.WriteString(strings.Join(fields, ",") + "\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LoadStepLog loads step records from a JSON lines file written by StepLogger.
+func LoadStepLog(filePath string) ([]StepRecord, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		err = fmt.Errorf("LoadStepLog - Open file failed: %w\n", err)
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []StepRecord
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var r StepRecord
+		err := json.Unmarshal([]byte(line), &r)
+		if err != nil {
+			err = fmt.Errorf("LoadStepLog - Parse line %d failed: %w\n", lineNum, err)
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
@@ -0,0 +1,92 @@
+package lab
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStepLogger(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "steps.jsonl")
+	sl, err := NewStepLogger(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []StepRecord{
+		{Epoch: 0, Step: 0, Loss: 1.5, LRs: []float64{0.001, 0.0001}, GradNorm: 2.1, BatchSize: 8, DataTime: 0.1, StepTime: 0.3, Throughput: 20},
+		{Epoch: 0, Step: 1, Loss: 1.2, LRs: []float64{0.002, 0.0002}, GradNorm: 1.8, BatchSize: 8, DataTime: 0.1, StepTime: 0.3, Throughput: 20},
+	}
+	for _, r := range want {
+		if err := sl.Log(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadStepLog(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Want: %+v\n", want)
+		t.Errorf("Got: %+v\n", got)
+	}
+
+	lrs := sl.LRHistory(1)
+	if !reflect.DeepEqual([]float64{0.0001, 0.0002}, lrs) {
+		t.Errorf("Unexpected LR history of group 1: %v\n", lrs)
+	}
+
+	csvFile := filepath.Join(dir, "steps.csv")
+	if err := sl.SaveCSV(csvFile); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(csvFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := strings.Split(string(buf), "\n")[0]
+	wantHeader := "epoch,step,loss,lr_0,lr_1,grad_norm,batch_size,data_time,step_time,throughput"
+	if header != wantHeader {
+		t.Errorf("Want header: %q\n", wantHeader)
+		t.Errorf("Got header: %q\n", header)
+	}
+}
+
+func TestStepLogger_NonFinite(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "steps.jsonl")
+	sl, err := NewStepLogger(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := StepRecord{Step: 3, Loss: math.NaN(), LRs: []float64{0.1}, GradNorm: math.Inf(1), BatchSize: 8}
+	if err := sl.Log(r); err != nil {
+		t.Fatalf("Want NaN loss and infinite grad norm logged, got %v\n", err)
+	}
+	if err := sl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(buf); !strings.Contains(s, `"loss":"NaN"`) || !strings.Contains(s, `"grad_norm":"+Inf"`) {
+		t.Errorf("Want non-finite values encoded as strings, got %s\n", s)
+	}
+
+	got, err := LoadStepLog(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !math.IsNaN(got[0].Loss) || !math.IsInf(got[0].GradNorm, 1) || got[0].LRs[0] != 0.1 {
+		t.Errorf("Want record loaded with NaN loss and +Inf grad norm, got %+v\n", got)
+	}
+}
@@ -3,14 +3,16 @@ package lab
 import (
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/sugarme/gotch"
-	"github.com/sugarme/gotch/dutil"
 	"github.com/sugarme/gotch/nn"
 	"github.com/sugarme/gotch/ts"
+
+	lib "github.com/sugarme/lab/model"
 )
 
 type TimeTracker struct {
@@ -176,9 +178,9 @@ func (lt *LossTracker) SaveValidLossesToCSV(filePath string) error {
 
 // Trainer holds data and methods to train model.
 type Trainer struct {
-	Loader    *dutil.DataLoader
+	Loader    Loader
 	Model     *Model
-	Optimizer *nn.Optimizer
+	Optimizer *Optimizer
 	Scheduler *Scheduler
 	Criterion func(logits, labels *ts.Tensor) *ts.Tensor // loss function
 	Evaluator *Evaluator
@@ -188,29 +190,56 @@ type Trainer struct {
 	// Step
 	GradientAccumulation float64
 	Epochs               int
-	StepsPerEpoch        int // = samples/batch-size
+	StepsPerEpoch        int // = number of batches (see StepsPerEpoch)
 	ValidateInterval     int
 	TotalSteps           int
 	Steps                int // number of steps have been trained upto this point of time.
 	Verbosity            int
 	CUDA                 bool
 	AMP                  bool
+	UnfreezeSchedule     []UnfreezeConfig // stages to unfreeze at given epochs
+	BatchAugment         *BatchAugment    // MixUp/CutMix applied to collated batches. Nil if not specified.
+	Pruner               *lib.Pruner      // pruning masks re-applied after each optimizer step. Nil if not pruning.
+	Distiller            *Distiller       // soft targets of teachers combined with criterion. Nil if not distilling.
 
 	CurrentEpoch int
 	OffsetEpochs int
 	TimeTracker  *TimeTracker
 	LossTracker  *LossTracker
+	StepLogger   *StepLogger // per-step loss, learning rates, gradient norm and timing.
+	ConfigHash   string      // saved in last-epoch checkpoint metadata
+}
+
+// StepsPerEpoch returns number of training steps per epoch. It is number of batches
+// of data loader unless specified in `TrainConfig.Params.StepsPerEpoch`: samples of loader
+// divided by `train.batch_size`, rounded down if sampler drops the last incomplete batch
+// (`drop_last`, default true), otherwise rounded up.
+func StepsPerEpoch(cfg *Config, loader Loader) int {
+	if cfg.Train.Params.StepsPerEpoch > 0 {
+		return cfg.Train.Params.StepsPerEpoch
+	}
+
+	n := loader.Len()
+	batchSize := int(cfg.Train.BatchSize)
+	if batchSize <= 0 {
+		return n
+	}
+	// Invalid drop_last falls back to default, it is reported by Builder.BuildSampler.
+	dropLast := newParamReader(cfg.Train.Params.Sampler.Params).bool("drop_last", true)
+	steps := n / batchSize
+	if !dropLast && n%batchSize != 0 {
+		steps += 1
+	}
+
+	return steps
 }
 
-func NewTrainer(cfg *Config, loader *dutil.DataLoader, model *Model, optimizer *nn.Optimizer, scheduler *Scheduler, criterion LossFunc, evaluator *Evaluator, logger *Logger) *Trainer {
+// NewTrainer creates a Trainer of cfg. Distiller is nil if not distilling (see
+// Builder.BuildDistiller). It returns an error if batch augment or prune config is invalid.
+func NewTrainer(cfg *Config, loader Loader, model *Model, optimizer *Optimizer, scheduler *Scheduler, criterion LossFunc, evaluator *Evaluator, distiller *Distiller, logger *Logger) (*Trainer, error) {
 	// Init
 	gradientAccum := cfg.Train.Params.GradientAcc
-	var stepsPerEpoch int
-	if cfg.Train.Params.StepsPerEpoch == 0 {
-		stepsPerEpoch = loader.Len()
-	} else {
-		stepsPerEpoch = cfg.Train.Params.StepsPerEpoch
-	}
+	stepsPerEpoch := StepsPerEpoch(cfg, loader)
 	cuda := cfg.Train.Params.CUDA
 	currEpoch := cfg.Train.StartEpoch
 	epochs := cfg.Train.Params.Epochs
@@ -220,8 +249,34 @@ func NewTrainer(cfg *Config, loader *dutil.DataLoader, model *Model, optimizer *
 	steps := 0
 	amp := cfg.Train.Params.Amp
 	verbosity := cfg.Train.Params.Verbosity
+	unfreezeSchedule := cfg.Train.Params.UnfreezeSchedule
+	batchAugment, err := MakeBatchAugment(cfg.Transform.Train.BatchAugment, cfg.Model.Params.NumClasses, cfg.Seed)
+	if err != nil {
+		err = fmt.Errorf("NewTrainer failed: %w\n", err)
+		return nil, err
+	}
+	var pruner *lib.Pruner
+	if cfg.Train.Params.Prune.Sparsity > 0 {
+		pruner, err = model.Prune(cfg.Train.Params.Prune)
+		if err != nil {
+			err = fmt.Errorf("NewTrainer failed: %w\n", err)
+			return nil, err
+		}
+	}
+	configHash, err := cfg.Hash()
+	if err != nil {
+		err = fmt.Errorf("NewTrainer failed: %w\n", err)
+		return nil, err
+	}
 	lossTracker := NewLossTracker()
 	timeTracker := NewTimeTracker()
+	stepLogFile := fmt.Sprintf("%s/train-steps-%d.jsonl", cfg.Evaluation.Params.SaveCheckpointDir, cfg.Train.TrainCount)
+	stepLogger, err := NewStepLogger(stepLogFile)
+	if err != nil {
+		// Keep step records in memory only.
+		log.Printf("WARNING: NewTrainer - step log disabled: %v\n", err)
+		stepLogger, _ = NewStepLogger("")
+	}
 
 	return &Trainer{
 		Loader:    loader,
@@ -243,15 +298,28 @@ func NewTrainer(cfg *Config, loader *dutil.DataLoader, model *Model, optimizer *
 		Verbosity:            verbosity,
 		CUDA:                 cuda,
 		AMP:                  amp,
+		UnfreezeSchedule:     unfreezeSchedule,
+		BatchAugment:         batchAugment,
+		Pruner:               pruner,
+		Distiller:            distiller,
 
 		CurrentEpoch: currEpoch,
 		OffsetEpochs: offsetEpochs,
 		TimeTracker:  timeTracker,
 		LossTracker:  lossTracker,
-	}
+		StepLogger:   stepLogger,
+		ConfigHash:   configHash,
+	}, nil
 }
 
 func (t *Trainer) Train() {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Logger.Notify(EventCrash, fmt.Sprintf("Training crashed at epoch %d, step %d: %v", t.CurrentEpoch+1, t.Steps, r))
+			t.Logger.Close()
+			panic(r)
+		}
+	}()
 
 	// Log configuration
 	t.Logger.Printf("DATE: %v\n", time.Now())
@@ -262,57 +330,149 @@ func (t *Trainer) Train() {
 	t.Logger.Printf("----------\n\n")
 	epochMsg := fmt.Sprintf("Sample size: %d - Steps per epoch: %v - Epochs: %v - Total steps: %v\n", t.Loader.Len(), t.StepsPerEpoch, t.Epochs, t.TotalSteps)
 	t.Logger.Printf(epochMsg)
+	for _, w := range ValidateTransformConfig(t.Config.Transform.Train) {
+		t.Logger.Printf("WARNING: train transform - %s\n", w)
+	}
+	for _, w := range ValidateTransformConfig(t.Config.Transform.Valid) {
+		t.Logger.Printf("WARNING: valid transform - %s\n", w)
+	}
+	if epoch, ok := t.Model.Previous["epoch"]; ok {
+		t.Logger.Printf("Loaded previous weights %q of epoch %s\n", t.Config.Train.LoadPrevious, epoch)
+	}
 
-	t.Logger.SendSlack("CONFIGURATION:")
-	t.Logger.SendSlack(cfgMsg)
-	t.Logger.SendSlack(epochMsg)
+	t.Logger.Printf(t.Model.ParamReport())
+	if t.Pruner != nil {
+		t.Logger.Printf(t.Pruner.String())
+	}
+	if t.Distiller != nil {
+		t.Logger.Printf(t.Distiller.String())
+	}
+
+	t.Logger.Notify(EventStart, fmt.Sprintf("CONFIGURATION:\n%s%s", cfgMsg, epochMsg))
+
+	t.Logger.LogParams(ConfigParams(t.Config))
 
 	// Start training
 	for epoch := 0; epoch < t.Epochs; epoch++ {
+		// Progressive unfreezing
+		if stages := StagesToUnfreeze(t.UnfreezeSchedule, t.CurrentEpoch); len(stages) > 0 {
+			frozen := len(t.Model.FrozenStages())
+			err := t.Model.UnfreezeStages(stages...)
+			if err != nil {
+				err = fmt.Errorf("Trainer.Train - Unfreeze stages failed: %w\n", err)
+				t.fatal(err)
+			}
+			// Report only when stages were still frozen, e.g. not at every epoch after the schedule.
+			if len(t.Model.FrozenStages()) < frozen {
+				t.Logger.Printf("Epoch %2d/%d\t\tUnfroze stages %v\n", t.CurrentEpoch+1, t.Epochs+t.OffsetEpochs, stages)
+				t.Logger.Printf(t.Model.ParamReport())
+			}
+		}
 
 		var epochLosses []float64
+		nanNotified := false // notify NaN loss once per epoch
 		for t.Loader.HasNext() {
 			// Train one step
 			dataStart := time.Now()
 			dataItem, err := t.Loader.Next()
 			if err != nil {
 				err = fmt.Errorf("fetch data failed: %w\n", err)
-				log.Fatal(err)
+				t.fatal(err)
 			}
 
 			var (
-				batch  []ts.Tensor
-				labels []ts.Tensor
+				batch   []ts.Tensor
+				labels  []ts.Tensor
+				teacher []ts.Tensor // saved teacher logits
 			)
 			batchSize := len(dataItem.([][]ts.Tensor))
 			for i := 0; i < batchSize; i++ {
 				batch = append(batch, dataItem.([][]ts.Tensor)[i][0])
 				labels = append(labels, dataItem.([][]ts.Tensor)[i][1])
+				if len(dataItem.([][]ts.Tensor)[i]) > 2 {
+					teacher = append(teacher, dataItem.([][]ts.Tensor)[i][2])
+				}
 			}
 			batchTs := ts.MustStack(batch, 0)
 			labelTs := ts.MustStack(labels, 0).MustSqueeze(true)
+			var teacherTs *ts.Tensor
+			if t.Distiller != nil && len(teacher) > 0 {
+				teacherTs = ts.MustStack(teacher, 0)
+			}
 			for i := 0; i < len(batch); i++ {
 				batch[i].MustDrop()
 				labels[i].MustDrop()
 			}
+			for i := 0; i < len(teacher); i++ {
+				teacher[i].MustDrop()
+			}
 
 			device := gotch.CudaIfAvailable()
 			// device := gotch.CPU
 			input := batchTs.MustDetach(true).MustTo(device, true)
 			target := labelTs.MustDetach(true).MustTo(device, true)
+			if t.BatchAugment != nil {
+				// MixUp/CutMix with soft targets
+				mixedInput, softTarget := t.BatchAugment.Apply(input, target, t.CurrentEpoch)
+				input.MustDrop()
+				target.MustDrop()
+				input, target = mixedInput, softTarget
+			}
+			var teacherLogits *ts.Tensor
+			switch {
+			case teacherTs != nil && t.BatchAugment != nil:
+				// Saved logits are of unmixed images.
+				err = fmt.Errorf("Trainer.Train - Distillation failed: saved teacher logits do not support batch augment\n")
+				t.fatal(err)
+			case teacherTs != nil:
+				teacherLogits = teacherTs.MustTo(device, true)
+			case t.Distiller != nil && len(t.Distiller.Teachers) == 0:
+				err = fmt.Errorf("Trainer.Train - Distillation failed: no teacher logits in data items. See ImageDataset.SetTeacherLogits\n")
+				t.fatal(err)
+			}
 
 			dataTime := time.Since(dataStart)
 
 			stepStart := time.Now()
+			if t.Distiller != nil && len(t.Distiller.Teachers) > 0 {
+				// Teacher forward is part of step time.
+				teacherLogits = t.Distiller.TeacherLogits(input)
+			}
+			lrs := t.Optimizer.GetLRs() // learning rates used for this step
 			logits := t.Model.Module.ForwardT(input, true)
-			loss := t.Criterion(logits, target)
+			loss := t.loss(logits, target, teacherLogits)
 			if !loss.MustRequiresGrad() {
 				fmt.Printf("Reset loss required grad... done.\n")
 				loss.MustRequiresGrad_(true)
 			}
-			t.Optimizer.BackwardStep(loss)
+			if t.Optimizer.IsTwoPass() {
+				// Sharpness-Aware Minimization recomputes loss at perturbed weights.
+				closure := func() *ts.Tensor {
+					logits := t.Model.Module.ForwardT(input, true)
+					loss := t.loss(logits, target, teacherLogits)
+					logits.MustDrop()
+					return loss
+				}
+				err = t.Optimizer.BackwardStepTwoPass(loss, closure)
+			} else {
+				err = t.Optimizer.BackwardStep(loss)
+			}
+			if err != nil {
+				err = fmt.Errorf("Trainer.Train - Optimizer step failed: %w\n", err)
+				t.fatal(err)
+			}
+			if t.Pruner != nil {
+				// Keep pruned weights zero.
+				t.Pruner.Apply()
+			}
 			lossVals := loss.Float64Values()
 			// NOTE. take first element. Loss tensor has always 1 value, hasn't it?
+			if math.IsNaN(lossVals[0]) || math.IsInf(lossVals[0], 0) {
+				if !nanNotified {
+					t.Logger.Notify(EventNaNLoss, fmt.Sprintf("Epoch %d, step %d: loss is %v", t.CurrentEpoch+1, t.Steps, lossVals[0]))
+					nanNotified = true
+				}
+			}
 			t.LossTracker.SetLoss(lossVals[0], t.Steps, t.CurrentEpoch)
 			epochLosses = append(epochLosses, lossVals[0])
 
@@ -321,6 +481,9 @@ func (t *Trainer) Train() {
 			target.MustDrop()
 			logits.MustDrop()
 			loss.MustDrop()
+			if teacherLogits != nil {
+				teacherLogits.MustDrop()
+			}
 
 			// TODO. delete this. Just for test validating
 			/*
@@ -330,6 +493,7 @@ func (t *Trainer) Train() {
 						t.Logger.Println("VALIDATING...")
 						validStartTime := time.Now()
 						t.Model.Eval()
+						t.Evaluator.Step = t.Steps
 						validMetric, validLoss, err := t.Evaluator.Validate(t.Model, t.Criterion, t.CurrentEpoch)
 						if err != nil{
 							err = fmt.Errorf("Evaluator - Validate failed: %w\n", err)
@@ -345,62 +509,80 @@ func (t *Trainer) Train() {
 				}
 			*/
 
-			t.Steps += 1
+			gradNorm := t.Optimizer.GradNorm()
 
 			stepTime := time.Since(stepStart)
 			t.TimeTracker.SetTime(dataTime, stepTime)
+			record := StepRecord{
+				Epoch:      t.CurrentEpoch,
+				Step:       t.Steps,
+				Loss:       lossVals[0],
+				LRs:        lrs,
+				GradNorm:   gradNorm,
+				BatchSize:  batchSize,
+				DataTime:   dataTime.Seconds(),
+				StepTime:   stepTime.Seconds(),
+				Throughput: float64(batchSize) / (dataTime + stepTime).Seconds(),
+			}
+			err = t.StepLogger.Log(record)
+			if err != nil {
+				t.Logger.Printf("Trainer.Train - Log step failed: %v\n", err)
+			}
+			t.Logger.LogMetrics(t.Steps, record.Metrics())
+
+			t.Steps += 1
 
 			// Print progression
 			if t.Steps%t.Verbosity == 0 && t.Steps > 0 {
 				t.PrintProgress()
 			}
-			if t.Scheduler.Update == "on_batch" && t.Scheduler.LRScheduler != nil {
-				t.Scheduler.Step()
-			}
+			t.Scheduler.StepOn("on_batch")
 
 		} // for loop step
 
 		// Log average epoch loss
 		epochLoss := Mean(epochLosses)
 		t.Logger.Printf("Epoch %2d/%d\t\tAvg. Loss %3.4f\n", t.CurrentEpoch+1, t.Epochs+t.OffsetEpochs, epochLoss)
+		// Epoch metrics share the global step axis of per-step metrics. Epoch is logged as a metric.
+		t.Logger.LogMetrics(t.Steps, map[string]float64{"train/epoch_loss": epochLoss, "train/epoch": float64(t.CurrentEpoch)})
 
 		// Validation
 		if (t.CurrentEpoch+1)%t.ValidateInterval == 0 {
 			t.Logger.Println("VALIDATING...")
 			validStartTime := time.Now()
 			t.Model.Eval()
+			t.Evaluator.Step = t.Steps
 			validMetric, validLoss, err := t.Evaluator.Validate(t.Model, t.Criterion, t.CurrentEpoch)
 			if err != nil {
 				err = fmt.Errorf("Evaluator - Validate failed: %w\n", err)
-				log.Fatal(err)
+				t.fatal(err)
 			}
 			t.Model.Train()
 			t.LossTracker.SetValidLoss(validLoss, t.Steps, t.CurrentEpoch)
-			if t.Scheduler.Update == "on_valid" && t.Scheduler.LRScheduler != nil {
-				t.Scheduler.Step(nn.WithLoss(validMetric))
-			}
+			t.Scheduler.StepOn("on_valid", nn.WithLoss(validMetric))
 			t.Logger.Printf("Validation took %0.2f mins\n", time.Since(validStartTime).Minutes())
 
 			// Early stopping
 			if t.Evaluator.CheckStopping() {
-				t.Logger.Printf("Training has not improved for %d consecutive epochs. Early stopping now....\n", t.Evaluator.EarlyStopping)
+				stopMsg := fmt.Sprintf("Training has not improved for %d consecutive epochs. Early stopping now....\n", t.Evaluator.EarlyStopping)
+				t.Logger.Printf(stopMsg)
+				t.Logger.Notify(EventEarlyStop, stopMsg)
 				break
 			}
 		}
 
-		t.Loader.Reset()
+		t.StepLogger.Flush()
+		t.Loader.Reset(true) // redraw sampler indexes
 		t.Logger.Printf("Completed epoch %d. Taken time: %0.2f mins. Reset data loader...\n", t.CurrentEpoch+1, time.Since(t.TimeTracker.LastCheck).Minutes())
 		t.TimeTracker.LastCheck = time.Now()
 
 		// Update learning rate
-		if t.Scheduler.Update == "on_epoch" && t.Scheduler.LRScheduler != nil {
-			t.Scheduler.Step()
-		}
+		t.Scheduler.StepOn("on_epoch")
 
 		t.CurrentEpoch += 1
 
 		// Reset best model if using cosine-annealing-warm-restarts
-		if t.Scheduler.Name == "CosineAnnealingWarmRestarts" && t.Scheduler.LRScheduler != nil {
+		if t.Scheduler != nil && t.Scheduler.Name == "CosineAnnealingWarmRestarts" && t.Scheduler.LRScheduler != nil {
 			// TODO. How to do this.
 			// if t.CurrentEpoch%t.Scheduler.T0 == 0 {
 			// t.Evaluator.ResetBest()
@@ -408,31 +590,54 @@ func (t *Trainer) Train() {
 		}
 	} // for loop epoch
 
+	// Stop loader workers and drop prefetched batches.
+	closeLoader(t.Loader)
+	if t.Evaluator != nil {
+		closeLoader(t.Evaluator.Loader)
+	}
+
 	// save last-epoch weights for continuing training purpose
-	lastFile := fmt.Sprintf("%s/last-epoch.bin", t.Config.Evaluation.Params.SaveCheckpointDir)
-	err := t.Model.Weights.Save(lastFile)
+	lastFile := fmt.Sprintf("%s/last-epoch%s", t.Config.Evaluation.Params.SaveCheckpointDir, checkpointExt(t.Config))
+	metadata := checkpointMetadata(t.ConfigHash, t.Model.Name, t.CurrentEpoch-1, nil)
+	err := lib.SaveWeights(t.Model.Weights, lastFile, metadata)
 	if err != nil {
-		err = fmt.Errorf("Trainer.Train - Save last model failed: %w\n", err)
-		fmt.Print(err)
+		t.Logger.Printf("WARNING: Trainer.Train - Save last model failed: %v\n", err)
+	}
+
+	err = t.StepLogger.Close()
+	if err != nil {
+		t.Logger.Printf("WARNING: Trainer.Train - Close step log failed: %v\n", err)
+	}
+	stepsFile := fmt.Sprintf("%s/train-steps-%d.csv", t.Config.Evaluation.Params.SaveCheckpointDir, t.Config.Train.TrainCount)
+	err = t.StepLogger.SaveCSV(stepsFile)
+	if err != nil {
+		t.Logger.Printf("WARNING: Trainer.Train - Save step log failed: %v\n", err)
 	}
 
 	t.Logger.Println("TRAINING: END")
 	endMsg := fmt.Sprintf("Training took: %0.2fmins\n", time.Since(t.TimeTracker.StartTime).Minutes())
 	t.Logger.Printf(endMsg)
-	t.Logger.SendSlack(endMsg)
-	t.Logger.Close()
+	t.Logger.Notify(EventEnd, endMsg)
 
 	// Save losses to csv
 	tlossFile := fmt.Sprintf("%s/train-loss-%d.csv", t.Config.Evaluation.Params.SaveCheckpointDir, t.Config.Train.TrainCount)
 	err = t.LossTracker.SaveLossesToCSV(tlossFile)
 	if err != nil {
-		fmt.Print(err)
+		t.Logger.Printf("WARNING: Trainer.Train - Save train losses failed: %v\n", err)
 	}
 	vlossFile := fmt.Sprintf("%s/valid-loss-%d.csv", t.Config.Evaluation.Params.SaveCheckpointDir, t.Config.Train.TrainCount)
-	t.LossTracker.SaveValidLossesToCSV(vlossFile)
+	err = t.LossTracker.SaveValidLossesToCSV(vlossFile)
 	if err != nil {
-		fmt.Print(err)
+		t.Logger.Printf("WARNING: Trainer.Train - Save valid losses failed: %v\n", err)
+	}
+
+	// Send csv files to trackers and end tracking runs.
+	for _, f := range []string{stepsFile, tlossFile, vlossFile} {
+		if _, err := os.Stat(f); err == nil {
+			t.Logger.LogArtifact(f)
+		}
 	}
+	t.Logger.Close()
 
 	// // Plot train and valid losses and save to a png file.
 	// gFile := fmt.Sprintf("%s/loss-%d.png", t.Config.Evaluation.Params.SaveCheckpointDir, t.Config.Train.TrainCount)
@@ -442,6 +647,15 @@ func (t *Trainer) Train() {
 	// }
 }
 
+// loss computes criterion of logits and target, averaged over tasks of a multi-task head (see
+// TaskLoss), combined with distillation loss if teacher logits are given.
+func (t *Trainer) loss(logits, target, teacherLogits *ts.Tensor) *ts.Tensor {
+	if teacherLogits == nil {
+		return TaskLoss(t.Model.Head, t.Criterion)(logits, target)
+	}
+	return t.Distiller.Loss(logits, target, teacherLogits, t.Criterion)
+}
+
 func (t *Trainer) SchedulerStep() {
 	switch {
 	case t.Scheduler.Name == "CosineAnnealingWarmRestarts":
@@ -471,10 +685,25 @@ func (t *Trainer) PrintProgress() {
 	avgLoss := loss / float64(t.Verbosity)
 	loadTime, stepTime := t.TimeTracker.GetTime("seconds")
 
-	var lr float64 = t.Optimizer.GetLRs()[0] // For now, assuming there ONE param group!
-	msg := fmt.Sprintf("Epoch %2d/%d\t\tStep %5d/%d(avg. data time: %0.4fs/step, step time: %0.4fs/step)\t\t Loss %0.4f (lr %.1e)\n", t.CurrentEpoch+1, t.Epochs+t.OffsetEpochs, t.Steps, t.TotalSteps, loadTime, stepTime, avgLoss, lr)
+	var lrMsgs []string
+	for _, lr := range t.Optimizer.GetLRs() {
+		lrMsgs = append(lrMsgs, fmt.Sprintf("%.1e", lr))
+	}
+	var gradNorm, throughput float64
+	if r, ok := t.StepLogger.Last(); ok {
+		gradNorm = r.GradNorm
+		throughput = r.Throughput
+	}
+	msg := fmt.Sprintf("Epoch %2d/%d\t\tStep %5d/%d(avg. data time: %0.4fs/step, step time: %0.4fs/step, %0.1f samples/s)\t\t Loss %0.4f (lr %s, grad norm %0.4f)\n", t.CurrentEpoch+1, t.Epochs+t.OffsetEpochs, t.Steps, t.TotalSteps, loadTime, stepTime, throughput, avgLoss, strings.Join(lrMsgs, "/"), gradNorm)
 	t.Logger.Print(msg)
-	t.Logger.SendSlack(msg)
+	t.Logger.Notify(EventProgress, msg)
+}
+
+// fatal notifies crash, flushes notifications and trackers then exits.
+func (t *Trainer) fatal(err error) {
+	t.Logger.Notify(EventCrash, fmt.Sprintf("Training crashed at epoch %d, step %d: %v", t.CurrentEpoch+1, t.Steps, err))
+	t.Logger.Close()
+	log.Fatal(err)
 }
 
 /*
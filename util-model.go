@@ -2,7 +2,7 @@ package lab
 
 import (
 	"fmt"
-	"log"
+	"path/filepath"
 	"sort"
 
 	"github.com/sugarme/gotch"
@@ -26,40 +26,131 @@ func CheckBuildModel(cfg *Config) error {
 	return nil
 }
 
-// ModelSummary prints out model architecture to stdout.
-func ModelSummary(modelName string) {
+// ModelSummary builds a ModelZoo classification backbone with a head of nclasses on CPU and
+// summarizes a forward pass on an input of inputSize x inputSize: output shape, parameters and
+// estimated MACs of each layer, and memory. Stages in freezeStages (see StagePrefixes) are
+// counted as frozen.
+func ModelSummary(modelName string, nclasses, inputSize int64, freezeStages ...string) (*lib.Summary, error) {
+	if _, ok := ModelZoo[modelName]; !ok {
+		err := fmt.Errorf("ModelSummary failed: unsupported model %q", modelName)
+		return nil, err
+	}
+
 	vs := nn.NewVarStore(gotch.CPU)
-	switch modelName {
-	case "densenet121":
-		_ = lib.DenseNet121(vs.Root(), 1000)
-	default:
-		log.Fatalf("Unsupported model %q\n", modelName)
+	features, channels, err := lib.Features(vs.Root(), modelName)
+	if err != nil {
+		err = fmt.Errorf("ModelSummary failed: %w", err)
+		return nil, err
+	}
+	head, err := lib.NewClassifierHead(vs.Root(), modelName, channels, nclasses)
+	if err != nil {
+		err = fmt.Errorf("ModelSummary failed: %w", err)
+		return nil, err
+	}
+	m := &Model{
+		Name:    modelName,
+		Module:  lib.Classifier(features, head),
+		Weights: vs,
+		Head:    head,
+		Layers:  append(features, lib.Layer{Name: "head", Prefixes: head.Prefixes(), Module: head}),
+	}
+	if len(freezeStages) > 0 {
+		if err := m.FreezeStages(freezeStages...); err != nil {
+			err = fmt.Errorf("ModelSummary failed: %w", err)
+			return nil, err
+		}
 	}
 
-	var namedTensors []ts.NamedTensor
-	for name, x := range vs.Variables() {
-		namedTensors = append(namedTensors, ts.NamedTensor{
-			Name:   name,
-			Tensor: &x,
-		})
+	return m.Summary([]int64{1, 3, inputSize, inputSize})
+}
+
+// Summary summarizes a forward pass of model layers on a zero input of inputShape
+// [N, C, H, W]. Parameters not requiring gradient are counted as frozen, so it should be
+// called in training mode.
+func (m *Model) Summary(inputShape []int64) (*lib.Summary, error) {
+	if m.Layers == nil {
+		err := fmt.Errorf("Summary failed: model %q has no named layers", m.Name)
+		return nil, err
 	}
+	return lib.Summarize(m.Weights, m.Layers, inputShape)
+}
 
-	printNamedTensors(namedTensors)
+// DiffWeights compares model variables with tensors of a weight file (gotch or safetensors
+// format) to explain variables skipped by partial loading: missing variables, unexpected
+// tensors and shape mismatches.
+func (m *Model) DiffWeights(file string) (*lib.ConvertReport, error) {
+	return lib.DiffWeights(m.Weights, file)
 }
 
-// PretrainedSummary loads and prints out layers of pretrained model from input file.
-func PretrainedSummary(file string) {
-	vs := nn.NewVarStore(gotch.CPU)
-	err := vs.Load(file)
+// ExportONNX exports classification model to ONNX file in evaluation mode. See lib.ExportONNX
+// for supported backbones.
+func (m *Model) ExportONNX(file string, opts ...lib.ExportOption) error {
+	if m.Head == nil {
+		err := fmt.Errorf("ExportONNX failed: model %q has no classification head", m.Name)
+		return err
+	}
+	return lib.ExportONNX(file, m.Weights, m.Name, m.Head, opts...)
+}
+
+// ExportModel builds classification model of cfg with weights of checkpoint file (gotch or
+// safetensors format) and exports it to ONNX file. Normalization of valid transform, class
+// names and config hash are stored as metadata. Only ResNet, ResNeXt, Wide ResNet, SE-ResNet,
+// EfficientNet and DenseNet backbones can be exported (see lib.ONNXSupported); other backbones
+// fail before the model is built.
+func ExportModel(cfg *Config, checkpoint, file string, classes []string) error {
+	if backbone := cfg.Model.Params.Backbone; !lib.ONNXSupported(backbone) {
+		err := fmt.Errorf("ExportModel failed: ONNX export of backbone %q is not supported. Expected ResNet, ResNeXt, Wide ResNet, SE-ResNet, EfficientNet or DenseNet", backbone)
+		return err
+	}
+	c := *cfg
+	c.Model.Params.Pretrained = false
+	c.Train.LoadPrevious = checkpoint
+	m, err := NewBuilder(&c).BuildModel()
+	if err != nil {
+		err = fmt.Errorf("ExportModel failed: %w", err)
+		return err
+	}
 
-	namedTensors, err := ts.LoadMultiWithDevice(file, vs.Device())
+	configHash, err := cfg.Hash()
 	if err != nil {
-		log.Fatal(err)
+		err = fmt.Errorf("ExportModel failed: %w", err)
+		return err
+	}
+	opts := []lib.ExportOption{
+		lib.WithClassNames(classes),
+		lib.WithExportMetadata(map[string]string{"config_hash": configHash, "checkpoint": filepath.Base(checkpoint)}),
 	}
+	if mean, std, ok := cfg.Transform.Valid.Normalization(); ok {
+		opts = append(opts, lib.WithNormalization(mean, std))
+	}
+	if err := m.ExportONNX(file, opts...); err != nil {
+		err = fmt.Errorf("ExportModel failed: %w", err)
+		return err
+	}
+	return nil
+}
 
-	vs = nil
+// PretrainedSummary loads and prints out layers of pretrained model from input file.
+func PretrainedSummary(file string) error {
+	tensors, err := lib.ReadWeights(file)
+	if err != nil {
+		err = fmt.Errorf("PretrainedSummary failed: %w", err)
+		return err
+	}
 
+	var namedTensors []ts.NamedTensor
+	for name, x := range tensors {
+		namedTensors = append(namedTensors, ts.NamedTensor{
+			Name:   name,
+			Tensor: x,
+		})
+	}
 	printNamedTensors(namedTensors)
+
+	for _, x := range tensors {
+		x.MustDrop()
+	}
+	return nil
 }
 
 // Print named tensors
@@ -0,0 +1,120 @@
+package lab
+
+import (
+	"fmt"
+)
+
+// paramFloat returns a number param decoded from YAML as float64 or int, e.g. `alpha: 1`.
+func paramFloat(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case int:
+		return float64(x), true
+	}
+	return 0, false
+}
+
+// paramInt returns an integer param decoded from YAML as int, or as float64 of integer value.
+func paramInt(v interface{}) (int, bool) {
+	switch x := v.(type) {
+	case int:
+		return x, true
+	case float64:
+		if x == float64(int(x)) {
+			return int(x), true
+		}
+	}
+	return 0, false
+}
+
+// paramReader reads typed values of config params decoded from YAML. Missing params get
+// default values; the first param of invalid type is kept in err.
+type paramReader struct {
+	params map[string]interface{}
+	err    error
+}
+
+func newParamReader(params map[string]interface{}) *paramReader {
+	return &paramReader{params: params}
+}
+
+// value returns param name if set, checking its type with ok.
+func (r *paramReader) value(name string, ok func(v interface{}) bool) (interface{}, bool) {
+	v, found := r.params[name]
+	if !found {
+		return nil, false
+	}
+	if !ok(v) {
+		if r.err == nil {
+			r.err = fmt.Errorf("invalid value %v (%T) of param %q", v, v, name)
+		}
+		return nil, false
+	}
+	return v, true
+}
+
+func (r *paramReader) float(name string, defaultValue float64) float64 {
+	v, ok := r.value(name, func(v interface{}) bool { _, ok := paramFloat(v); return ok })
+	if !ok {
+		return defaultValue
+	}
+	f, _ := paramFloat(v)
+	return f
+}
+
+func (r *paramReader) int(name string, defaultValue int) int {
+	v, ok := r.value(name, func(v interface{}) bool { _, ok := paramInt(v); return ok })
+	if !ok {
+		return defaultValue
+	}
+	i, _ := paramInt(v)
+	return i
+}
+
+func (r *paramReader) bool(name string, defaultValue bool) bool {
+	v, ok := r.value(name, func(v interface{}) bool { _, ok := v.(bool); return ok })
+	if !ok {
+		return defaultValue
+	}
+	return v.(bool)
+}
+
+func (r *paramReader) string(name string, defaultValue string) string {
+	v, ok := r.value(name, func(v interface{}) bool { _, ok := v.(string); return ok })
+	if !ok {
+		return defaultValue
+	}
+	return v.(string)
+}
+
+// floats reads a list of numbers, or a single number as a list of one.
+func (r *paramReader) floats(name string, defaultValue []float64) []float64 {
+	v, ok := r.value(name, func(v interface{}) bool {
+		if _, ok := paramFloat(v); ok {
+			return true
+		}
+		list, ok := v.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, e := range list {
+			if _, ok := paramFloat(e); !ok {
+				return false
+			}
+		}
+		return true
+	})
+	if !ok {
+		return defaultValue
+	}
+	if f, ok := paramFloat(v); ok {
+		return []float64{f}
+	}
+	var values []float64
+	for _, e := range v.([]interface{}) {
+		f, _ := paramFloat(e)
+		values = append(values, f)
+	}
+	return values
+}